@@ -285,6 +285,19 @@ CREATE TABLE subscriptions (
 CREATE INDEX idx_subscriptions_user_id ON subscriptions(user_id);
 CREATE INDEX idx_subscriptions_stripe_customer_id ON subscriptions(stripe_customer_id);
 
+-- ========================================
+-- 11. Connection Events - Agent connect/disconnect history
+-- ========================================
+CREATE TABLE connection_events (
+    id BIGSERIAL PRIMARY KEY,
+    server_id UUID NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    event VARCHAR(20) NOT NULL CHECK (event IN ('connect', 'disconnect')),
+    ip_address INET,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_connection_events_server_id_time ON connection_events(server_id, created_at DESC);
+
 -- ========================================
 -- Functions & Triggers
 -- ========================================
@@ -396,3 +409,4 @@ COMMENT ON TABLE alert_history IS 'Alert trigger history';
 COMMENT ON TABLE api_keys IS 'API keys for programmatic access';
 COMMENT ON TABLE audit_logs IS 'Security audit trail';
 COMMENT ON TABLE subscriptions IS 'Subscription and billing information';
+COMMENT ON TABLE connection_events IS 'Agent connect/disconnect history';
@@ -6,6 +6,9 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+
+	"vstats/internal/common"
 )
 
 const ConfigFilename = "vstats-agent.json"
@@ -18,12 +21,75 @@ type AgentConfig struct {
 	Location     string `json:"location"`
 	Provider     string `json:"provider"`
 	IntervalSecs uint64 `json:"interval_secs"`
+	// Ping round settings: seconds between rounds (default 10) and how many
+	// targets are probed at once (default 8)
+	PingIntervalSecs int `json:"ping_interval_secs,omitempty"`
+	PingConcurrency  int `json:"ping_concurrency,omitempty"`
 	// Offline storage settings
 	EnableOfflineStorage bool   `json:"enable_offline_storage"` // Enable local storage when disconnected (default: true)
 	DataDir              string `json:"data_dir,omitempty"`     // Directory for local data storage
 	MaxOfflineRecords    int    `json:"max_offline_records"`    // Max records to store offline (default: 10000)
 	AggregationSecs      int    `json:"aggregation_secs"`       // Aggregation interval in seconds (default: 60)
 	BatchSize            int    `json:"batch_size"`             // Max metrics per batch when syncing (default: 100)
+	// Wire encoding for messages sent to the dashboard: "json" (default) or "msgpack"
+	Encoding string `json:"encoding,omitempty"`
+	// Report pending package updates and reboot-required (apt/dnf/yum, Linux only)
+	CheckUpdates     bool `json:"check_updates,omitempty"`
+	UpdateCheckHours int  `json:"update_check_hours,omitempty"` // Default 6
+	// Report listening TCP/UDP ports, refreshed every 5 minutes. Process
+	// names are only available when running as root.
+	ReportListeningPorts bool `json:"report_listening_ports,omitempty"`
+	// Proxy for the dashboard connection, e.g. http://proxy:3128 or
+	// socks5://proxy:1080. Defaults to HTTPS_PROXY/HTTP_PROXY/ALL_PROXY.
+	ProxyURL string `json:"proxy_url,omitempty"`
+	// TLS for dashboards behind an internal CA: PEM bundle trusted in
+	// addition to the system roots, or (unsafe) no verification at all
+	CACertFile         string `json:"ca_cert_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+	// Disk collection filters. Mount points match exactly or as a parent
+	// directory; filesystem types accept patterns like "fuse.*". When
+	// ExcludeFSTypes is unset, DefaultExcludeFSTypes is used.
+	IncludeMounts  []string `json:"include_mounts,omitempty"` // Only count these mounts
+	ExcludeMounts  []string `json:"exclude_mounts,omitempty"`
+	IncludeFSTypes []string `json:"include_fs_types,omitempty"` // Only count these filesystem types
+	ExcludeFSTypes []string `json:"exclude_fs_types,omitempty"`
+	// Network interface filters (shell patterns like "veth*"), applied to
+	// the interface list and the traffic totals. When ExcludeInterfaces is
+	// unset, DefaultExcludeInterfaces is used.
+	IncludeInterfaces []string `json:"include_interfaces,omitempty"` // Only count these interfaces
+	ExcludeInterfaces []string `json:"exclude_interfaces,omitempty"`
+	// Commands run every interval that print a JSON object of metric name
+	// to number, reported as custom metrics (e.g. UPS battery level)
+	ExternalCollectors []string `json:"external_collectors,omitempty"`
+	// systemd units whose journal the dashboard may read with "tail_logs";
+	// empty disables log access
+	LogUnits []string `json:"log_units,omitempty"`
+	// Additional dashboards that receive the same metrics, each with its own
+	// server ID and token. The dashboard above stays the primary one: only
+	// it gets offline replay and sets the ping targets.
+	Dashboards []DashboardEndpoint `json:"dashboards,omitempty"`
+	// Serve the latest sample locally as Prometheus text (/metrics) and JSON
+	// (/metrics.json), e.g. ":9101". Disabled when empty; an address without
+	// a host binds to localhost.
+	LocalMetricsAddr string `json:"local_metrics_addr,omitempty"`
+}
+
+// DashboardEndpoint is one dashboard the agent reports to
+type DashboardEndpoint struct {
+	DashboardURL string `json:"dashboard_url"`
+	ServerID     string `json:"server_id"`
+	AgentToken   string `json:"agent_token"`
+}
+
+// Endpoints returns the primary dashboard followed by the additional ones
+func (c *AgentConfig) Endpoints() []DashboardEndpoint {
+	endpoints := make([]DashboardEndpoint, 0, 1+len(c.Dashboards))
+	endpoints = append(endpoints, DashboardEndpoint{
+		DashboardURL: c.DashboardURL,
+		ServerID:     c.ServerID,
+		AgentToken:   c.AgentToken,
+	})
+	return append(endpoints, c.Dashboards...)
 }
 
 func DefaultConfigPath() string {
@@ -73,7 +139,7 @@ func LoadConfigFromEnv() *AgentConfig {
 
 	intervalSecs := uint64(5)
 	if intervalStr := os.Getenv("VSTATS_INTERVAL_SECS"); intervalStr != "" {
-		if parsed, err := strconv.ParseUint(intervalStr, 10, 64); err == nil && parsed > 0 {
+		if parsed, err := strconv.ParseUint(intervalStr, 10, 64); err == nil && parsed > 0 && parsed <= common.MaxIntervalSecs {
 			intervalSecs = parsed
 		}
 	}
@@ -100,10 +166,47 @@ func LoadConfigFromEnv() *AgentConfig {
 	if dir := os.Getenv("VSTATS_DATA_DIR"); dir != "" {
 		config.DataDir = dir
 	}
+	config.Encoding = os.Getenv("VSTATS_ENCODING")
+	config.CheckUpdates = os.Getenv("VSTATS_CHECK_UPDATES") == "true"
+	config.ReportListeningPorts = os.Getenv("VSTATS_REPORT_LISTENING_PORTS") == "true"
+	config.ProxyURL = os.Getenv("VSTATS_PROXY_URL")
+	config.CACertFile = os.Getenv("VSTATS_CA_CERT")
+	config.InsecureSkipVerify = os.Getenv("VSTATS_INSECURE_SKIP_VERIFY") == "true"
+	config.IncludeMounts = envList("VSTATS_INCLUDE_MOUNTS")
+	config.ExcludeMounts = envList("VSTATS_EXCLUDE_MOUNTS")
+	config.IncludeFSTypes = envList("VSTATS_INCLUDE_FS_TYPES")
+	config.ExcludeFSTypes = envList("VSTATS_EXCLUDE_FS_TYPES")
+	config.ExternalCollectors = envList("VSTATS_EXTERNAL_COLLECTORS")
+	config.IncludeInterfaces = envList("VSTATS_INCLUDE_INTERFACES")
+	config.ExcludeInterfaces = envList("VSTATS_EXCLUDE_INTERFACES")
+	config.LogUnits = envList("VSTATS_LOG_UNITS")
+	config.LocalMetricsAddr = os.Getenv("VSTATS_LOCAL_METRICS_ADDR")
+	if n, err := strconv.Atoi(os.Getenv("VSTATS_PING_INTERVAL_SECS")); err == nil && n > 0 {
+		config.PingIntervalSecs = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("VSTATS_PING_CONCURRENCY")); err == nil && n > 0 {
+		config.PingConcurrency = n
+	}
 	
 	return config
 }
 
+// envList splits a comma-separated environment variable. An unset variable
+// returns nil, a set but empty one an empty list.
+func envList(key string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func LoadConfig(path string) (*AgentConfig, error) {
 	// First, try to load from environment variables
 	if envConfig := LoadConfigFromEnv(); envConfig != nil {
@@ -124,6 +227,9 @@ func LoadConfig(path string) (*AgentConfig, error) {
 	if config.IntervalSecs == 0 {
 		config.IntervalSecs = 5
 	}
+	if config.IntervalSecs > common.MaxIntervalSecs {
+		return nil, fmt.Errorf("interval_secs must be between 1 and %d", common.MaxIntervalSecs)
+	}
 
 	// Set defaults for offline storage
 	setConfigDefaults(&config)
@@ -146,6 +252,15 @@ func setConfigDefaults(config *AgentConfig) {
 	if config.BatchSize == 0 {
 		config.BatchSize = 100
 	}
+	if config.UpdateCheckHours <= 0 {
+		config.UpdateCheckHours = 6
+	}
+	if config.PingIntervalSecs <= 0 {
+		config.PingIntervalSecs = DefaultPingIntervalSecs
+	}
+	if config.PingConcurrency <= 0 {
+		config.PingConcurrency = DefaultPingConcurrency
+	}
 	if config.DataDir == "" {
 		config.DataDir = GetDataDir()
 	}
@@ -170,7 +285,12 @@ func SaveConfig(config *AgentConfig, path string) error {
 }
 
 func (c *AgentConfig) WSUrl() string {
-	url := c.DashboardURL
+	return c.WSUrlFor(c.DashboardURL)
+}
+
+// WSUrlFor returns the agent WebSocket URL of the given dashboard
+func (c *AgentConfig) WSUrlFor(dashboardURL string) string {
+	url := dashboardURL
 	if len(url) > 4 && url[:4] == "http" {
 		if url[:5] == "https" {
 			url = "wss" + url[5:]
@@ -178,6 +298,9 @@ func (c *AgentConfig) WSUrl() string {
 			url = "ws" + url[4:]
 		}
 	}
+	if c.Encoding == common.EncodingMsgpack {
+		return fmt.Sprintf("%s/ws/agent?encoding=%s", url, common.EncodingMsgpack)
+	}
 	return fmt.Sprintf("%s/ws/agent", url)
 }
 
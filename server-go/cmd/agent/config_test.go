@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigIntervalBounds(t *testing.T) {
+	t.Setenv("VSTATS_DASHBOARD_URL", "")
+
+	for _, tc := range []struct {
+		interval string
+		want     uint64 // 0: the config is refused
+	}{
+		{"0", 5},
+		{"1", 1},
+		{"3600", 3600},
+		{"3601", 0},
+		{"-5", 0},
+		{"18446744073709551615", 0},
+	} {
+		path := filepath.Join(t.TempDir(), ConfigFilename)
+		data := fmt.Sprintf(`{"dashboard_url":"http://localhost:3001","server_id":"s","agent_token":"t","interval_secs":%s}`, tc.interval)
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := LoadConfig(path)
+		switch {
+		case tc.want == 0 && err == nil:
+			t.Errorf("interval_secs %s: accepted as %d, want an error", tc.interval, config.IntervalSecs)
+		case tc.want != 0 && err != nil:
+			t.Errorf("interval_secs %s: %v", tc.interval, err)
+		case tc.want != 0 && config.IntervalSecs != tc.want:
+			t.Errorf("interval_secs %s: got %d, want %d", tc.interval, config.IntervalSecs, tc.want)
+		}
+	}
+}
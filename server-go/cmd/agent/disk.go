@@ -3,7 +3,7 @@ package main
 import (
 	"bufio"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
@@ -14,8 +14,91 @@ import (
 	"github.com/shirou/gopsutil/v4/disk"
 )
 
+// DefaultExcludeFSTypes are skipped unless exclude_fs_types is configured:
+// network filesystems can hang and virtual ones double-count capacity
+var DefaultExcludeFSTypes = []string{
+	"nfs", "nfs4", "cifs", "smb3", "smbfs", "sshfs", "fuse.*",
+	"overlay", "tmpfs", "devtmpfs", "squashfs", "autofs",
+}
+
+// diskFilter decides which partitions are included in disk metrics
+type diskFilter struct {
+	includeMounts  []string
+	excludeMounts  []string
+	includeFSTypes []string
+	excludeFSTypes []string
+}
+
+func newDiskFilter(cfg *AgentConfig) *diskFilter {
+	excludeFSTypes := cfg.ExcludeFSTypes
+	if excludeFSTypes == nil {
+		excludeFSTypes = DefaultExcludeFSTypes
+	}
+	return &diskFilter{
+		includeMounts:  cfg.IncludeMounts,
+		excludeMounts:  cfg.ExcludeMounts,
+		includeFSTypes: cfg.IncludeFSTypes,
+		excludeFSTypes: excludeFSTypes,
+	}
+}
+
+// skip reports whether a partition should be left out of disk metrics
+func (f *diskFilter) skip(p disk.PartitionStat) bool {
+	if f == nil {
+		return false
+	}
+	if len(f.includeMounts) > 0 && !matchMount(p.Mountpoint, f.includeMounts) {
+		return true
+	}
+	if matchMount(p.Mountpoint, f.excludeMounts) {
+		return true
+	}
+	fsType := strings.ToLower(p.Fstype)
+	if len(f.includeFSTypes) > 0 && !matchFSType(fsType, f.includeFSTypes) {
+		return true
+	}
+	return matchFSType(fsType, f.excludeFSTypes)
+}
+
+// matchMount matches a mount point exactly or as a subdirectory of a pattern
+func matchMount(mount string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimRight(pattern, "/")
+		if pattern == "" {
+			pattern = "/"
+		}
+		if mount == pattern || (pattern != "/" && strings.HasPrefix(mount, pattern+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchFSType matches a filesystem type against shell patterns ("fuse.*")
+func matchFSType(fsType string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), fsType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// worstInodes keeps the inode counts of the disk's fullest filesystem. Any
+// one filesystem running out stops new files on it, so summing with its
+// siblings would hide the problem.
+func worstInodes(d *DiskMetrics, usage *disk.UsageStat) {
+	if usage.InodesTotal == 0 {
+		return
+	}
+	if d.InodesTotal == 0 || float64(usage.InodesUsed)/float64(usage.InodesTotal) > float64(d.InodesUsed)/float64(d.InodesTotal) {
+		d.InodesUsed = usage.InodesUsed
+		d.InodesTotal = usage.InodesTotal
+	}
+}
+
 // collectPhysicalDisks collects physical disk information with IO speed
-func collectPhysicalDisks(currentIO map[string]disk.IOCountersStat, lastIO map[string]disk.IOCountersStat, lastTime time.Time) []DiskMetrics {
+func collectPhysicalDisks(currentIO map[string]disk.IOCountersStat, lastIO map[string]disk.IOCountersStat, lastTime time.Time, filter *diskFilter) []DiskMetrics {
 	var disks []DiskMetrics
 
 	switch runtime.GOOS {
@@ -74,6 +157,12 @@ func collectPhysicalDisks(currentIO map[string]disk.IOCountersStat, lastIO map[s
 			}
 
 			// Map partitions to physical disks
+			type diskPartition struct {
+				disk.PartitionStat
+				metrics *DiskMetrics
+			}
+			var mapped []diskPartition
+			var mounts []string
 			partitions, _ := disk.Partitions(false)
 			for _, p := range partitions {
 				partName := p.Device
@@ -83,6 +172,9 @@ func collectPhysicalDisks(currentIO map[string]disk.IOCountersStat, lastIO map[s
 				if strings.HasPrefix(mountPoint, "/snap") || strings.HasPrefix(mountPoint, "/boot/efi") {
 					continue
 				}
+				if filter.skip(p) {
+					continue
+				}
 
 				// Find base device name
 				baseName := strings.TrimPrefix(partName, "/dev/")
@@ -95,14 +187,29 @@ func collectPhysicalDisks(currentIO map[string]disk.IOCountersStat, lastIO map[s
 				}
 
 				if diskMetrics, ok := physicalDisks[baseName]; ok {
-					if mountPoint != "" && mountPoint != "none" {
-						diskMetrics.MountPoints = append(diskMetrics.MountPoints, mountPoint)
-					}
-					// Update usage from partition
-					if usage, err := disk.Usage(p.Mountpoint); err == nil {
-						partUsed := usage.Total - usage.Free
-						diskMetrics.Used += partUsed
-					}
+					mapped = append(mapped, diskPartition{p, diskMetrics})
+					mounts = append(mounts, mountPoint)
+				}
+			}
+
+			// Query all mounts concurrently so a slow one doesn't hold up the rest
+			usages := diskUsages(mounts)
+			countedDevices := make(map[string]bool)
+			for _, p := range mapped {
+				diskMetrics := p.metrics
+				if p.Mountpoint != "" && p.Mountpoint != "none" {
+					diskMetrics.MountPoints = append(diskMetrics.MountPoints, p.Mountpoint)
+				}
+				// Update usage from partition, once per device so bind
+				// mounts of the same partition aren't counted twice
+				if countedDevices[p.Device] {
+					continue
+				}
+				if usage, ok := usages[p.Mountpoint]; ok {
+					countedDevices[p.Device] = true
+					partUsed := usage.Total - usage.Free
+					diskMetrics.Used += partUsed
+					worstInodes(diskMetrics, usage)
 				}
 			}
 
@@ -112,6 +219,9 @@ func collectPhysicalDisks(currentIO map[string]disk.IOCountersStat, lastIO map[s
 				if d.Total > 0 {
 					d.UsagePercent = float32(float64(d.Used) / float64(d.Total) * 100)
 				}
+				if d.InodesTotal > 0 {
+					d.InodesPercent = float32(float64(d.InodesUsed) / float64(d.InodesTotal) * 100)
+				}
 
 				// Calculate IO speed for this disk
 				// On Linux, /proc/diskstats contains both physical disks (sda, nvme0n1) and partitions (sda1, nvme0n1p1)
@@ -186,8 +296,11 @@ func collectPhysicalDisks(currentIO map[string]disk.IOCountersStat, lastIO map[s
 			if strings.HasPrefix(mount, "/System") || strings.Contains(name, "synthesized") {
 				continue
 			}
+			if filter.skip(p) {
+				continue
+			}
 
-			usage, err := disk.Usage(mount)
+			usage, err := diskUsage(mount)
 			if err != nil {
 				continue
 			}
@@ -195,12 +308,15 @@ func collectPhysicalDisks(currentIO map[string]disk.IOCountersStat, lastIO map[s
 			diskName := strings.TrimPrefix(name, "/dev/")
 			if _, exists := physicalDisks[diskName]; !exists {
 				physicalDisks[diskName] = &DiskMetrics{
-					Name:         diskName,
-					Total:        usage.Total,
-					Used:         usage.Used,
-					UsagePercent: float32(usage.UsedPercent),
-					DiskType:     "SSD", // Most Macs use SSD
-					MountPoints:  []string{mount},
+					Name:          diskName,
+					Total:         usage.Total,
+					Used:          usage.Used,
+					UsagePercent:  float32(usage.UsedPercent),
+					DiskType:      "SSD", // Most Macs use SSD
+					MountPoints:   []string{mount},
+					InodesUsed:    usage.InodesUsed,
+					InodesTotal:   usage.InodesTotal,
+					InodesPercent: float32(usage.InodesUsedPercent),
 				}
 			}
 		}
@@ -238,10 +354,11 @@ func collectPhysicalDisks(currentIO map[string]disk.IOCountersStat, lastIO map[s
 			}
 			disks = append(disks, *d)
 		}
+	case "freebsd":
+		disks = collectFreeBSDDisks(currentIO, lastIO, lastTime, filter)
 	case "windows":
 		// Use WMIC to get physical disks
-		cmd := exec.Command("wmic", "diskdrive", "get", "DeviceID,Model,SerialNumber,Size,MediaType", "/format:csv")
-		output, err := cmd.Output()
+		output, err := commandOutput("wmic", "diskdrive", "get", "DeviceID,Model,SerialNumber,Size,MediaType", "/format:csv")
 		if err == nil {
 			scanner := bufio.NewScanner(strings.NewReader(string(output)))
 			firstLine := true
@@ -286,8 +403,8 @@ func collectPhysicalDisks(currentIO map[string]disk.IOCountersStat, lastIO map[s
 			partitions, _ := disk.Partitions(false)
 			for _, p := range partitions {
 				mount := p.Mountpoint
-				if mount != "" {
-					if usage, err := disk.Usage(mount); err == nil {
+				if mount != "" && !filter.skip(p) {
+					if usage, err := diskUsage(mount); err == nil {
 						// On Windows, report partition usage directly if no physical disks found
 						if len(physicalDisks) == 0 {
 							disks = append(disks, DiskMetrics{
@@ -406,6 +523,15 @@ func detectDiskType(diskName string) string {
 		// For now, return "SSD" as fallback (most Macs use SSD)
 		return "SSD"
 
+	case "freebsd":
+		// nvd/nda are NVMe namespaces, vtbd are virtio disks
+		if strings.HasPrefix(diskName, "nvd") || strings.HasPrefix(diskName, "nda") {
+			return "NVMe"
+		}
+		if strings.HasPrefix(diskName, "vtbd") {
+			return "SSD"
+		}
+
 	case "windows":
 		// Windows detection is handled in collectPhysicalDisks using WMIC
 		// This function is not typically called for Windows in the current implementation
@@ -414,3 +540,191 @@ func detectDiskType(diskName string) string {
 
 	return ""
 }
+
+// ============================================================================
+// FreeBSD
+// ============================================================================
+
+// freebsdDiskName matches the disk part of a FreeBSD device: ada0p2 -> ada0,
+// da1s1a -> da1, nvd0p3 -> nvd0
+var freebsdDiskName = regexp.MustCompile(`^[a-z]+[0-9]+`)
+
+// geomDisks parses `geom disk list` into physical disks (name, size, model,
+// serial and type). Returns nil if geom isn't available.
+func geomDisks() map[string]*DiskMetrics {
+	output, err := commandOutput("geom", "disk", "list")
+	if err != nil {
+		return nil
+	}
+
+	physicalDisks := make(map[string]*DiskMetrics)
+	var current *DiskMetrics
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Geom name":
+			current = &DiskMetrics{Name: value, DiskType: detectDiskType(value), MountPoints: []string{}}
+			physicalDisks[value] = current
+		case "Mediasize":
+			// "500107862016 (466G)"
+			if current != nil {
+				current.Total, _ = strconv.ParseUint(strings.Fields(value + " 0")[0], 10, 64)
+			}
+		case "descr":
+			if current != nil && value != "(null)" {
+				current.Model = value
+			}
+		case "ident":
+			if current != nil && value != "(null)" {
+				current.Serial = value
+			}
+		case "rotationrate":
+			if current != nil && current.DiskType == "" {
+				if value == "0" {
+					current.DiskType = "SSD"
+				} else if _, err := strconv.Atoi(value); err == nil {
+					current.DiskType = "HDD"
+				}
+			}
+		}
+	}
+	return physicalDisks
+}
+
+// zpoolUsage is the size and allocated space of a ZFS pool
+type zpoolUsage struct {
+	size, alloc uint64
+}
+
+// zpoolUsages returns the usage of every imported ZFS pool, or nil if zpool
+// isn't available
+func zpoolUsages() map[string]zpoolUsage {
+	output, err := commandOutput("zpool", "list", "-Hp", "-o", "name,size,alloc")
+	if err != nil {
+		return nil
+	}
+	return parseZpoolList(output)
+}
+
+// parseZpoolList parses `zpool list -Hp -o name,size,alloc`: one pool per
+// line, tab separated, sizes in bytes
+func parseZpoolList(output []byte) map[string]zpoolUsage {
+	pools := make(map[string]zpoolUsage)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		size, err1 := strconv.ParseUint(fields[1], 10, 64)
+		alloc, err2 := strconv.ParseUint(fields[2], 10, 64)
+		if err1 != nil || err2 != nil || size == 0 {
+			continue
+		}
+		pools[fields[0]] = zpoolUsage{size: size, alloc: alloc}
+	}
+	return pools
+}
+
+// collectFreeBSDDisks reports the disks from geom with the usage of the
+// partitions on them. Filesystems that don't map to a disk (ZFS datasets,
+// or every filesystem if geom is unavailable) are reported per device like
+// on macOS, with ZFS datasets grouped by pool. A pool's usage is its size
+// and allocation from zpool: statfs on a dataset only sees that dataset's
+// share of the pool.
+func collectFreeBSDDisks(currentIO map[string]disk.IOCountersStat, lastIO map[string]disk.IOCountersStat, lastTime time.Time, filter *diskFilter) []DiskMetrics {
+	physicalDisks := geomDisks()
+	if physicalDisks == nil {
+		physicalDisks = make(map[string]*DiskMetrics)
+	}
+	mappedDisks := make(map[string]bool, len(physicalDisks))
+	for name := range physicalDisks {
+		mappedDisks[name] = true
+	}
+
+	partitions, _ := disk.Partitions(false)
+	var mounts []string
+	for _, p := range partitions {
+		if p.Mountpoint != "" && !filter.skip(p) {
+			mounts = append(mounts, p.Mountpoint)
+		}
+	}
+	usages := diskUsages(mounts)
+	pools := zpoolUsages()
+
+	countedDevices := make(map[string]bool)
+	for _, p := range partitions {
+		if p.Mountpoint == "" || filter.skip(p) {
+			continue
+		}
+
+		device := strings.TrimPrefix(p.Device, "/dev/")
+		name := device
+		if p.Fstype == "zfs" {
+			// pool/dataset: datasets share the pool's space
+			name, _, _ = strings.Cut(device, "/")
+		} else if base := freebsdDiskName.FindString(device); mappedDisks[base] {
+			name = base
+		}
+
+		d, ok := physicalDisks[name]
+		if !ok {
+			d = &DiskMetrics{Name: name, DiskType: detectDiskType(name), MountPoints: []string{}}
+			physicalDisks[name] = d
+		}
+		d.MountPoints = append(d.MountPoints, p.Mountpoint)
+
+		// Count each filesystem once, and each ZFS pool once
+		if countedDevices[p.Device] || (p.Fstype == "zfs" && d.Total > 0) {
+			continue
+		}
+		if pool, ok := pools[name]; ok && p.Fstype == "zfs" {
+			d.Total, d.Used = pool.size, pool.alloc
+			continue
+		}
+		usage, ok := usages[p.Mountpoint]
+		if !ok {
+			continue
+		}
+		countedDevices[p.Device] = true
+		if mappedDisks[name] {
+			d.Used += usage.Total - usage.Free
+		} else {
+			d.Total += usage.Total
+			d.Used += usage.Used
+		}
+		worstInodes(d, usage)
+	}
+
+	var disks []DiskMetrics
+	elapsed := time.Since(lastTime).Seconds()
+	for _, d := range physicalDisks {
+		if len(d.MountPoints) == 0 && d.Total == 0 {
+			continue
+		}
+		if d.Total > 0 {
+			d.UsagePercent = float32(float64(d.Used) / float64(d.Total) * 100)
+		}
+		if d.InodesTotal > 0 {
+			d.InodesPercent = float32(float64(d.InodesUsed) / float64(d.InodesTotal) * 100)
+		}
+
+		// devstat reports whole disks by name (ada0, nvd0)
+		if io, ok := currentIO[d.Name]; ok && elapsed > 0.1 {
+			if lastIOStat, ok := lastIO[d.Name]; ok {
+				if io.ReadBytes >= lastIOStat.ReadBytes {
+					d.ReadSpeed = uint64(float64(io.ReadBytes-lastIOStat.ReadBytes) / elapsed)
+				}
+				if io.WriteBytes >= lastIOStat.WriteBytes {
+					d.WriteSpeed = uint64(float64(io.WriteBytes-lastIOStat.WriteBytes) / elapsed)
+				}
+			}
+		}
+		disks = append(disks, *d)
+	}
+	return disks
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shirou/gopsutil/v4/disk"
+)
+
+// One exhausted filesystem must not be diluted by a large, mostly empty
+// sibling on the same disk
+func TestWorstInodes(t *testing.T) {
+	d := &DiskMetrics{}
+	worstInodes(d, &disk.UsageStat{InodesUsed: 1000, InodesTotal: 10_000_000})
+	worstInodes(d, &disk.UsageStat{InodesUsed: 65_000, InodesTotal: 65_536})
+	worstInodes(d, &disk.UsageStat{}) // No inode table (btrfs)
+	worstInodes(d, &disk.UsageStat{InodesUsed: 10, InodesTotal: 100})
+
+	if d.InodesUsed != 65_000 || d.InodesTotal != 65_536 {
+		t.Fatalf("got %d/%d inodes, want the full filesystem's 65000/65536", d.InodesUsed, d.InodesTotal)
+	}
+}
+
+func TestParseZpoolList(t *testing.T) {
+	output := "zroot\t498216206336\t123480309760\n" +
+		"tank\t7971459301376\t4398046511104\n" +
+		"broken\t-\t-\n\n"
+	pools := parseZpoolList([]byte(output))
+	if len(pools) != 2 {
+		t.Fatalf("got %d pools, want 2: %v", len(pools), pools)
+	}
+	if got := pools["tank"]; got.size != 7971459301376 || got.alloc != 4398046511104 {
+		t.Errorf("tank = %+v", got)
+	}
+	if got := pools["zroot"]; got.size != 498216206336 || got.alloc != 123480309760 {
+		t.Errorf("zroot = %+v", got)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The local exporter serves the latest sample on local_metrics_addr:
+// GET /metrics in the Prometheus text format and GET /metrics.json as the
+// SystemMetrics JSON sent to the dashboard. It adds no collection of its own.
+
+// localMetricsAddr binds an address without a host to localhost, so the
+// exporter is only reachable from elsewhere when asked for explicitly
+func localMetricsAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// serveLocalMetrics runs the local exporter until it fails
+func (wsc *WebSocketClient) serveLocalMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		metrics := wsc.latest.Load()
+		if metrics == nil {
+			http.Error(w, "no metrics collected yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writePrometheusMetrics(w, metrics)
+	})
+	mux.HandleFunc("GET /metrics.json", func(w http.ResponseWriter, r *http.Request) {
+		metrics := wsc.latest.Load()
+		if metrics == nil {
+			http.Error(w, "no metrics collected yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metrics)
+	})
+
+	addr = localMetricsAddr(addr)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	log.Printf("Serving local metrics on http://%s/metrics", addr)
+	if err := server.ListenAndServe(); err != nil {
+		log.Printf("Local metrics listener failed: %v", err)
+	}
+}
+
+// promWriter writes metric families in the Prometheus text format
+type promWriter struct {
+	w io.Writer
+}
+
+func (p promWriter) family(name, kind, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample; labels alternate name and value
+func (p promWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%s=%q", labels[i], escapeLabelValue(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(p.w, "%s %g\n", b.String(), value)
+}
+
+func (p promWriter) gauge(name, help string, value float64) {
+	p.family(name, "gauge", help)
+	p.sample(name, value)
+}
+
+// escapeLabelValue makes a label value safe to write with %q, which escapes
+// backslashes, quotes and newlines the way Prometheus expects but would also
+// escape other non-printable characters it doesn't understand
+func escapeLabelValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' && r != '\n' {
+			return ' '
+		}
+		return r
+	}, value)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writePrometheusMetrics writes a sample as vstats_* metrics
+func writePrometheusMetrics(w io.Writer, m *SystemMetrics) {
+	p := promWriter{w}
+
+	p.gauge("vstats_collected_timestamp_seconds", "Time the sample was collected.", float64(m.Timestamp.UnixMilli())/1000)
+	p.gauge("vstats_uptime_seconds", "System uptime.", float64(m.Uptime))
+
+	p.gauge("vstats_cpu_cores", "Number of logical CPUs.", float64(m.CPU.Cores))
+	p.gauge("vstats_cpu_usage_percent", "CPU usage, averaged over the reporting interval.", float64(m.CPU.Usage))
+	// Left out rather than reported as 0 when no per-second sample was taken
+	if m.CPU.UsageMin != nil && m.CPU.UsageMax != nil {
+		p.gauge("vstats_cpu_usage_min_percent", "Lowest per-second CPU usage over the reporting interval.", float64(*m.CPU.UsageMin))
+		p.gauge("vstats_cpu_usage_max_percent", "Highest per-second CPU usage over the reporting interval.", float64(*m.CPU.UsageMax))
+	}
+	p.family("vstats_cpu_core_usage_percent", "gauge", "CPU usage per core.")
+	for i, usage := range m.CPU.PerCore {
+		p.sample("vstats_cpu_core_usage_percent", float64(usage), "core", fmt.Sprint(i))
+	}
+	p.gauge("vstats_load1", "1 minute load average.", m.LoadAverage.One)
+	p.gauge("vstats_load5", "5 minute load average.", m.LoadAverage.Five)
+	p.gauge("vstats_load15", "15 minute load average.", m.LoadAverage.Fifteen)
+
+	p.gauge("vstats_memory_total_bytes", "Total memory.", float64(m.Memory.Total))
+	p.gauge("vstats_memory_used_bytes", "Used memory.", float64(m.Memory.Used))
+	p.gauge("vstats_memory_available_bytes", "Available memory.", float64(m.Memory.Available))
+	p.gauge("vstats_swap_total_bytes", "Total swap.", float64(m.Memory.SwapTotal))
+	p.gauge("vstats_swap_used_bytes", "Used swap.", float64(m.Memory.SwapUsed))
+
+	diskFamilies := []struct {
+		name, help string
+		value      func(DiskMetrics) float64
+	}{
+		{"vstats_disk_total_bytes", "Disk size.", func(d DiskMetrics) float64 { return float64(d.Total) }},
+		{"vstats_disk_used_bytes", "Used disk space.", func(d DiskMetrics) float64 { return float64(d.Used) }},
+		{"vstats_disk_read_bytes_per_second", "Disk read rate.", func(d DiskMetrics) float64 { return float64(d.ReadSpeed) }},
+		{"vstats_disk_write_bytes_per_second", "Disk write rate.", func(d DiskMetrics) float64 { return float64(d.WriteSpeed) }},
+		{"vstats_disk_inodes_used_percent", "Inode usage of the disk's fullest filesystem.", func(d DiskMetrics) float64 { return float64(d.InodesPercent) }},
+	}
+	for _, f := range diskFamilies {
+		p.family(f.name, "gauge", f.help)
+		for _, d := range m.Disks {
+			p.sample(f.name, f.value(d), "disk", d.Name)
+		}
+	}
+	p.gauge("vstats_inodes_max_used_percent", "Inode usage of the fullest filesystem on any disk.", float64(m.MaxInodesPercent()))
+
+	p.family("vstats_network_receive_bytes_total", "counter", "Bytes received on the counted interfaces.")
+	p.sample("vstats_network_receive_bytes_total", float64(m.Network.TotalRx))
+	p.family("vstats_network_transmit_bytes_total", "counter", "Bytes sent on the counted interfaces.")
+	p.sample("vstats_network_transmit_bytes_total", float64(m.Network.TotalTx))
+	p.gauge("vstats_network_receive_bytes_per_second", "Receive rate.", float64(m.Network.RxSpeed))
+	p.gauge("vstats_network_transmit_bytes_per_second", "Transmit rate.", float64(m.Network.TxSpeed))
+	p.family("vstats_interface_receive_bytes_total", "counter", "Bytes received per interface.")
+	for _, iface := range m.Network.Interfaces {
+		p.sample("vstats_interface_receive_bytes_total", float64(iface.RxBytes), "interface", iface.Name)
+	}
+	p.family("vstats_interface_transmit_bytes_total", "counter", "Bytes sent per interface.")
+	for _, iface := range m.Network.Interfaces {
+		p.sample("vstats_interface_transmit_bytes_total", float64(iface.TxBytes), "interface", iface.Name)
+	}
+
+	if m.Ping != nil {
+		p.family("vstats_ping_up", "gauge", "Whether the last probe of the target succeeded.")
+		for _, t := range m.Ping.Targets {
+			p.sample("vstats_ping_up", boolValue(t.Status == "ok"), "target", t.Name, "host", t.Host, "type", t.Type)
+		}
+		p.family("vstats_ping_latency_ms", "gauge", "Probe latency, absent when the probe failed.")
+		for _, t := range m.Ping.Targets {
+			if t.LatencyMs != nil {
+				p.sample("vstats_ping_latency_ms", *t.LatencyMs, "target", t.Name, "host", t.Host, "type", t.Type)
+			}
+		}
+		p.family("vstats_ping_packet_loss_percent", "gauge", "Probe packet loss.")
+		for _, t := range m.Ping.Targets {
+			p.sample("vstats_ping_packet_loss_percent", t.PacketLoss, "target", t.Name, "host", t.Host, "type", t.Type)
+		}
+	}
+
+	p.gauge("vstats_updates_pending", "Pending package updates, with check_updates.", float64(m.UpdatesPending))
+	p.gauge("vstats_security_updates_pending", "Pending security updates, with check_updates.", float64(m.SecurityUpdates))
+	p.gauge("vstats_reboot_required", "Whether a reboot is required, with check_updates.", boolValue(m.RebootRequired))
+
+	if len(m.Custom) > 0 {
+		names := make([]string, 0, len(m.Custom))
+		for name := range m.Custom {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		p.family("vstats_custom", "gauge", "Values from external_collectors.")
+		for _, name := range names {
+			p.sample("vstats_custom", m.Custom[name], "name", name)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"strings"
+	"sync"
+)
+
+// ============================================================================
+// External Collectors
+// ============================================================================
+
+// SetExternalCollectors sets the commands run on every collection. Each entry
+// is split on whitespace into the program and its arguments; no shell is
+// involved, so anything fancier belongs in a script.
+func (mc *MetricsCollector) SetExternalCollectors(commands []string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.externalCmds = commands
+}
+
+// collectExternal runs the external collectors concurrently and merges their
+// output. Each must print a JSON object of metric name to number; a command
+// that fails, times out (CommandTimeout) or prints anything else contributes
+// nothing. Later commands win on duplicate keys.
+func (mc *MetricsCollector) collectExternal() map[string]float64 {
+	mc.mu.RLock()
+	commands := mc.externalCmds
+	mc.mu.RUnlock()
+	if len(commands) == 0 {
+		return nil
+	}
+
+	results := make([]map[string]float64, len(commands))
+	var wg sync.WaitGroup
+	for i, command := range commands {
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, fields []string) {
+			defer wg.Done()
+			output, err := commandOutput(fields[0], fields[1:]...)
+			if err != nil {
+				log.Printf("External collector %q failed: %v", fields[0], err)
+				return
+			}
+			var values map[string]float64
+			if err := json.Unmarshal(output, &values); err != nil {
+				log.Printf("External collector %q printed invalid output: %v", fields[0], err)
+				return
+			}
+			results[i] = values
+		}(i, fields)
+	}
+	wg.Wait()
+
+	custom := make(map[string]float64)
+	for _, values := range results {
+		for key, value := range values {
+			if key == "" || math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			custom[key] = value
+		}
+	}
+	if len(custom) == 0 {
+		return nil
+	}
+	return custom
+}
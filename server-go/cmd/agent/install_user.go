@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// ============================================================================
+// Unprivileged Service User
+// ============================================================================
+
+// ensureServiceUser creates a system account for the agent if it doesn't
+// exist yet and returns its uid and gid
+func ensureServiceUser(name string) (int, int, error) {
+	if _, err := user.Lookup(name); err != nil {
+		shell := "/usr/sbin/nologin"
+		if _, err := os.Stat(shell); err != nil {
+			shell = "/sbin/nologin"
+		}
+		out, err := exec.Command("useradd", "--system", "--no-create-home", "--user-group", "--shell", shell, name).CombinedOutput()
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to create user %s: %v: %s", name, err, out)
+		}
+		log.Printf("Created system user %s", name)
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	return uid, gid, nil
+}
+
+// prepareServiceUser gives the service user access to what the agent writes
+// at runtime: the config file (token rotation), the daily traffic file next
+// to it, and the offline storage directory
+func prepareServiceUser(name, configPath string) error {
+	uid, gid, err := ensureServiceUser(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chown(configPath, uid, gid); err != nil {
+		return fmt.Errorf("failed to chown %s: %w", configPath, err)
+	}
+	// Only take over the directory if it is the agent's own
+	configDir := filepath.Dir(configPath)
+	if filepath.Base(configDir) == "vstats-agent" {
+		if err := os.Chown(configDir, uid, gid); err != nil {
+			return fmt.Errorf("failed to chown %s: %w", configDir, err)
+		}
+	} else {
+		log.Printf("Warning: %s is not writable by %s; daily traffic totals won't persist across restarts", configDir, name)
+	}
+
+	dataDir := GetDataDir()
+	if config, err := LoadConfig(configPath); err == nil && config.DataDir != "" {
+		dataDir = config.DataDir
+	}
+	if err := os.MkdirAll(dataDir, 0750); err != nil {
+		return fmt.Errorf("failed to create data directory %s: %w", dataDir, err)
+	}
+	return filepath.WalkDir(dataDir, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chown(path, uid, gid)
+	})
+}
+
+var privilegeNoticeOnce sync.Once
+
+// runningAsRoot reports whether the agent has the privileges needed for
+// hardware details. Only meaningful on Linux and FreeBSD; other platforms
+// report true.
+func runningAsRoot() bool {
+	return (runtime.GOOS != "linux" && runtime.GOOS != "freebsd") || os.Geteuid() == 0
+}
+
+// logPrivilegeNotice explains once which metrics are unavailable when the
+// agent runs as an unprivileged user
+func logPrivilegeNotice() {
+	privilegeNoticeOnce.Do(func() {
+		if !runningAsRoot() {
+			log.Println("Running as a non-root user: memory module details (dmidecode), SMART disk health and some disk serial numbers are unavailable")
+		}
+	})
+}
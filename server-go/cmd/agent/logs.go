@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"slices"
+	"strconv"
+
+	"vstats/internal/common"
+)
+
+// ============================================================================
+// Journal Tail ("tail_logs" command)
+// ============================================================================
+
+const (
+	DefaultLogLines = 100
+	MaxLogLines     = 1000
+)
+
+// handleTailLogs returns the last lines of a systemd unit's journal. Only
+// units listed in log_units may be read, so the command can't be used to run
+// anything else.
+func (wsc *WebSocketClient) handleTailLogs(requestID, unit string, lines int) *LogsResultMessage {
+	result := &LogsResultMessage{Type: "logs_result", RequestID: requestID, Unit: unit}
+
+	wsc.configMu.RLock()
+	allowed := slices.Contains(wsc.config.LogUnits, unit)
+	wsc.configMu.RUnlock()
+	if !allowed || unit == "" {
+		result.Error = fmt.Sprintf("unit %q is not in log_units", unit)
+		log.Printf("Rejected tail_logs for %q: not in log_units", unit)
+		return result
+	}
+	if runtime.GOOS != "linux" {
+		result.Error = "journal logs are only available on Linux"
+		return result
+	}
+
+	if lines <= 0 {
+		lines = DefaultLogLines
+	}
+	if lines > MaxLogLines {
+		lines = MaxLogLines
+	}
+
+	output, err := commandOutput("journalctl", "-u", unit, "-n", strconv.Itoa(lines), "--no-pager")
+	if err != nil {
+		result.Error = fmt.Sprintf("journalctl failed: %v", err)
+		return result
+	}
+	// Keep the newest lines if the output is too large
+	if len(output) > common.MaxLogsOutput {
+		output = output[len(output)-common.MaxLogsOutput:]
+		result.Truncated = true
+	}
+	result.Output = string(output)
+	return result
+}
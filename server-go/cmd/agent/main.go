@@ -12,6 +12,8 @@ import (
 	"runtime"
 	"time"
 
+	"vstats/internal/common"
+
 	"github.com/shirou/gopsutil/v4/host"
 )
 
@@ -24,6 +26,8 @@ func init() {
 }
 
 func main() {
+	common.SetupLogging(common.LogFormatFromArgs(os.Args[1:]))
+
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
 		case "version", "--version", "-v":
@@ -31,7 +35,7 @@ func main() {
 			os.Exit(0)
 		case "register":
 			if len(os.Args) < 5 {
-				fmt.Println("Usage: vstats-agent register --server <server_url> --token <admin_token> [--name <server_name>]")
+				fmt.Println("Usage: vstats-agent register --server <server_url> --token <admin_token> [--name <server_name>] [--proxy <proxy_url>] [--ca-cert <file>] [--insecure]")
 				os.Exit(1)
 			}
 			handleRegister()
@@ -74,14 +78,19 @@ func runAgent() {
 	log.Println("Starting vStats agent")
 	log.Printf("  Server ID: %s", config.ServerID)
 	log.Printf("  Dashboard: %s", config.DashboardURL)
+	for _, endpoint := range config.Dashboards {
+		log.Printf("  Dashboard: %s (server ID %s)", endpoint.DashboardURL, endpoint.ServerID)
+	}
 	log.Printf("  Interval: %ds", config.IntervalSecs)
 
-	client := NewWebSocketClient(config)
+	client := NewWebSocketClient(config, configPath)
+	setupReloadHandler(client)
 	client.Run()
 }
 
 func handleRegister() {
-	var serverURL, token, name string
+	var serverURL, token, name, proxyURL, caCertFile string
+	var insecure bool
 
 	for i := 2; i < len(os.Args); i++ {
 		switch os.Args[i] {
@@ -100,6 +109,18 @@ func handleRegister() {
 				name = os.Args[i+1]
 				i++
 			}
+		case "--proxy":
+			if i+1 < len(os.Args) {
+				proxyURL = os.Args[i+1]
+				i++
+			}
+		case "--ca-cert":
+			if i+1 < len(os.Args) {
+				caCertFile = os.Args[i+1]
+				i++
+			}
+		case "--insecure":
+			insecure = true
 		}
 	}
 
@@ -133,7 +154,11 @@ func handleRegister() {
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
+	// Same proxy and TLS settings as the agent connection
+	client, err := newHTTPClient(&AgentConfig{ProxyURL: proxyURL, CACertFile: caCertFile, InsecureSkipVerify: insecure})
+	if err != nil {
+		log.Fatalf("Failed to set up HTTP client: %v", err)
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Fatalf("Failed to send registration request: %v", err)
@@ -162,6 +187,10 @@ func handleRegister() {
 		Location:     "",
 		Provider:     "",
 		IntervalSecs: 5,
+		ProxyURL:     proxyURL,
+		// Saved so the agent connection uses the same trust settings
+		CACertFile:         caCertFile,
+		InsecureSkipVerify: insecure,
 	}
 
 	configPath := DefaultConfigPath()
@@ -182,12 +211,15 @@ func handleRegister() {
 
 func handleInstall() {
 	configPath := DefaultConfigPath()
+	serviceUser := "root"
 
-	// Check for --config flag
+	// Check for --config and --user flags
 	for i, arg := range os.Args {
 		if arg == "--config" && i+1 < len(os.Args) {
 			configPath = os.Args[i+1]
-			break
+		}
+		if arg == "--user" && i+1 < len(os.Args) {
+			serviceUser = os.Args[i+1]
 		}
 	}
 
@@ -198,8 +230,12 @@ func handleInstall() {
 
 	exe, _ := os.Executable()
 
+	if serviceUser != "root" && runtime.GOOS != "linux" {
+		log.Printf("Warning: --user is only supported with systemd, installing as the default account")
+	}
+
 	if runtime.GOOS == "linux" {
-		installSystemd(exe, configPath)
+		installSystemd(exe, configPath, serviceUser)
 	} else if runtime.GOOS == "darwin" {
 		installLaunchd(exe, configPath)
 	} else if runtime.GOOS == "windows" {
@@ -241,25 +277,41 @@ func handleShowConfig() {
 	fmt.Printf("  Location:       %s\n", config.Location)
 	fmt.Printf("  Provider:       %s\n", config.Provider)
 	fmt.Printf("  Interval:       %ds\n", config.IntervalSecs)
+	for i, endpoint := range config.Dashboards {
+		fmt.Println()
+		fmt.Printf("  Additional dashboard %d:\n", i+1)
+		fmt.Printf("    Dashboard URL:  %s\n", endpoint.DashboardURL)
+		fmt.Printf("    WebSocket URL:  %s\n", config.WSUrlFor(endpoint.DashboardURL))
+		fmt.Printf("    Server ID:      %s\n", endpoint.ServerID)
+	}
 }
 
-func installSystemd(exe, configPath string) {
+func installSystemd(exe, configPath, serviceUser string) {
+	if serviceUser != "root" {
+		if err := prepareServiceUser(serviceUser, configPath); err != nil {
+			log.Fatalf("Failed to set up service user: %v. Try running with sudo.", err)
+		}
+	}
+
 	serviceContent := fmt.Sprintf(`[Unit]
 Description=vStats Monitoring Agent
 After=network-online.target
 Wants=network-online.target
 
 [Service]
-Type=simple
-User=root
+Type=notify
+NotifyAccess=main
+WatchdogSec=120
+User=%s
 ExecStart=%s run --config %s
+ExecReload=/bin/kill -HUP $MAINPID
 Restart=always
 RestartSec=10
 Environment=RUST_LOG=info
 
 [Install]
 WantedBy=multi-user.target
-`, exe, configPath)
+`, serviceUser, exe, configPath)
 
 	servicePath := "/etc/systemd/system/vstats-agent.service"
 	if err := os.WriteFile(servicePath, []byte(serviceContent), 0644); err != nil {
@@ -3,7 +3,6 @@ package main
 import (
 	"bufio"
 	"encoding/json"
-	"os/exec"
 	"runtime"
 	"strconv"
 	"strings"
@@ -16,10 +15,15 @@ func collectMemoryModules() []MemoryModule {
 	var modules []MemoryModule
 
 	switch runtime.GOOS {
-	case "linux":
-		// Use dmidecode (requires root)
-		cmd := exec.Command("dmidecode", "-t", "memory")
-		output, err := cmd.Output()
+	case "linux", "freebsd":
+		// Use dmidecode (requires root, and on FreeBSD the
+		// sysutils/dmidecode package); without it module details are
+		// simply unavailable
+		if !runningAsRoot() {
+			logPrivilegeNotice()
+			break
+		}
+		output, err := commandOutput("dmidecode", "-t", "memory")
 		if err == nil {
 			scanner := bufio.NewScanner(strings.NewReader(string(output)))
 			var currentModule *MemoryModule
@@ -82,8 +86,7 @@ func collectMemoryModules() []MemoryModule {
 		}
 	case "darwin":
 		// Use system_profiler
-		cmd := exec.Command("system_profiler", "SPMemoryDataType", "-json")
-		output, err := cmd.Output()
+		output, err := commandOutput("system_profiler", "SPMemoryDataType", "-json")
 		if err == nil {
 			var data map[string]interface{}
 			if json.Unmarshal(output, &data) == nil {
@@ -135,8 +138,7 @@ func collectMemoryModules() []MemoryModule {
 		}
 	case "windows":
 		// Use WMIC
-		cmd := exec.Command("wmic", "memorychip", "get", "Capacity,Speed,MemoryType,Manufacturer,DeviceLocator", "/format:csv")
-		output, err := cmd.Output()
+		output, err := commandOutput("wmic", "memorychip", "get", "Capacity,Speed,MemoryType,Manufacturer,DeviceLocator", "/format:csv")
 		if err == nil {
 			scanner := bufio.NewScanner(strings.NewReader(string(output)))
 			firstLine := true
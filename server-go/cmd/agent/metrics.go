@@ -2,8 +2,10 @@ package main
 
 import (
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/shirou/gopsutil/v4/cpu"
@@ -20,15 +22,31 @@ type MetricsCollector struct {
 	lastNetworkRx     uint64
 	lastNetworkTx     uint64
 	lastNetworkTime   time.Time
+	lastCPUTimes      *cpu.TimesStat                 // Aggregate CPU time counters from the previous sample
+	cpuWindow         cpuUsageWindow                 // Per-second usage since the previous sample, guarded by mu
 	lastDiskIO        map[string]disk.IOCountersStat // Map disk name to last IO stats
 	lastDiskIOTime    time.Time
 	pingResults       *PingMetrics
 	pingResultsMu     sync.RWMutex
 	customPingTargets []PingTargetConfig
 	customTargetsMu   sync.RWMutex
+	pingInterval      atomic.Int64  // Time between ping rounds, as a Duration
+	pingConcurrency   atomic.Int32  // Targets probed at once
+	pingScheduleCh    chan struct{} // Wakes pingLoop when the interval changes
 	gatewayIP         string
 	ipAddresses       []string
+	virtualization    *VirtualizationInfo
+	packageUpdates    *PackageUpdateStatus // Last update check, nil until one has completed
+	packageUpdatesMu  sync.RWMutex
+	diskFilter        *diskFilter  // Guarded by mu
+	collectStarted    atomic.Int64 // UnixNano when the running Collect began, 0 when idle
+	externalCmds      []string     // external_collectors, guarded by mu
+	smartResults      map[string]diskHealth
+	smartResultsMu    sync.RWMutex
+	interfaceFilter   *interfaceFilter // Guarded by mu
 	dailyTrafficStats *DailyTrafficStats
+	listeningPorts    []PortInfo
+	listeningPortsMu  sync.RWMutex
 }
 
 // NewMetricsCollector creates a new metrics collector
@@ -37,26 +55,26 @@ func NewMetricsCollector() *MetricsCollector {
 		lastNetworkTime:   time.Now(),
 		lastDiskIO:        make(map[string]disk.IOCountersStat),
 		lastDiskIOTime:    time.Now(),
+		interfaceFilter:   newInterfaceFilter(&AgentConfig{}),
 		pingResults:       nil, // Will be set when ping targets are configured
+		pingScheduleCh:    make(chan struct{}, 1),
 		dailyTrafficStats: loadDailyTrafficStats(),
 	}
 
 	// Get initial network totals
 	netIO, _ := gopsutilnet.IOCounters(true)
-	var totalRx, totalTx uint64
-	for _, io := range netIO {
-		name := strings.ToLower(io.Name)
-		if !isVirtualInterface(name) {
-			totalRx += io.BytesRecv
-			totalTx += io.BytesSent
-		}
-	}
+	totalRx, totalTx := interfaceTotals(netIO, mc.interfaceFilter)
 	mc.lastNetworkRx = totalRx
 	mc.lastNetworkTx = totalTx
 
 	// Initialize daily traffic stats with current totals
 	mc.dailyTrafficStats.updateDailyTraffic(totalRx, totalTx)
 
+	// Baseline for the CPU time breakdown
+	if times, err := cpu.Times(false); err == nil && len(times) > 0 {
+		mc.lastCPUTimes = &times[0]
+	}
+
 	// Get initial disk IO stats
 	diskIO, _ := disk.IOCounters()
 	for name, io := range diskIO {
@@ -69,9 +87,21 @@ func NewMetricsCollector() *MetricsCollector {
 	// Collect IP addresses
 	mc.ipAddresses = collectIPAddresses()
 
+	// Detect VM/container environment (doesn't change while running)
+	mc.virtualization = detectVirtualization()
+
+	mc.pingInterval.Store(int64(DefaultPingIntervalSecs * time.Second))
+	mc.pingConcurrency.Store(DefaultPingConcurrency)
+
+	// Sample CPU usage between reports
+	go mc.cpuSampleLoop()
+
 	// Start background ping thread
 	go mc.pingLoop()
 
+	// Start hourly SMART health checks
+	go mc.smartLoop()
+
 	return mc
 }
 
@@ -82,8 +112,68 @@ func (mc *MetricsCollector) SetPingTargets(targets []PingTargetConfig) {
 	mc.customPingTargets = targets
 }
 
+// SetPingSchedule sets the seconds between ping rounds and how many targets
+// are probed at once. Values <= 0 keep the current setting.
+func (mc *MetricsCollector) SetPingSchedule(intervalSecs, concurrency int) {
+	if concurrency > 0 {
+		mc.pingConcurrency.Store(int32(concurrency))
+	}
+	if intervalSecs <= 0 {
+		return
+	}
+	interval := int64(time.Duration(intervalSecs) * time.Second)
+	if mc.pingInterval.Swap(interval) != interval {
+		select {
+		case mc.pingScheduleCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SetDiskFilter sets which mounts and filesystem types count towards disk usage
+func (mc *MetricsCollector) SetDiskFilter(filter *diskFilter) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.diskFilter = filter
+}
+
+// CollectionRunningFor returns how long the current Collect call has been
+// running, or 0 if none is in progress
+func (mc *MetricsCollector) CollectionRunningFor() time.Duration {
+	started := mc.collectStarted.Load()
+	if started == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, started))
+}
+
+// SetInterfaceFilter sets which interfaces count towards network metrics. The
+// speed and daily traffic baselines are moved to the new set of interfaces so
+// the change doesn't show up as a traffic spike.
+func (mc *MetricsCollector) SetInterfaceFilter(filter *interfaceFilter) {
+	netIO, _ := gopsutilnet.IOCounters(true)
+	totalRx, totalTx := interfaceTotals(netIO, filter)
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.interfaceFilter = filter
+	mc.lastNetworkRx = totalRx
+	mc.lastNetworkTx = totalTx
+	mc.lastNetworkTime = time.Now()
+	mc.dailyTrafficStats.rebase(totalRx, totalTx)
+}
+
 // Collect collects all system metrics
 func (mc *MetricsCollector) Collect() SystemMetrics {
+	mc.collectStarted.Store(time.Now().UnixNano())
+	defer mc.collectStarted.Store(0)
+
+	// External collectors run alongside the built-in ones
+	customCh := make(chan map[string]float64, 1)
+	go func() {
+		customCh <- mc.collectExternal()
+	}()
+
 	// CPU metrics
 	cpuPercent, _ := cpu.Percent(200*time.Millisecond, true)
 	cpuInfo, _ := cpu.Info()
@@ -94,6 +184,19 @@ func (mc *MetricsCollector) Collect() SystemMetrics {
 		cpuBrand = cpuInfo[0].ModelName
 		cpuFreq = uint64(cpuInfo[0].Mhz)
 	}
+	if runtime.GOOS == "freebsd" {
+		// gopsutil parses the boot messages, which may have been rotated away
+		if cpuBrand == "" {
+			if output, err := commandOutput("sysctl", "-n", "hw.model"); err == nil {
+				cpuBrand = strings.TrimSpace(string(output))
+			}
+		}
+		if cpuFreq == 0 {
+			if output, err := commandOutput("sysctl", "-n", "dev.cpu.0.freq"); err == nil {
+				cpuFreq, _ = strconv.ParseUint(strings.TrimSpace(string(output)), 10, 64)
+			}
+		}
+	}
 
 	var totalCPU float32
 	perCore := make([]float32, len(cpuPercent))
@@ -104,6 +207,28 @@ func (mc *MetricsCollector) Collect() SystemMetrics {
 	if len(cpuPercent) > 0 {
 		totalCPU /= float32(len(cpuPercent))
 	}
+	// Report the interval's average and extremes instead of the reading
+	// above when the sampler has seen at least one second of it
+	var usageMin, usageMax *float32
+	mc.mu.Lock()
+	window := mc.cpuWindow
+	mc.cpuWindow = cpuUsageWindow{}
+	mc.mu.Unlock()
+	if window.count > 0 {
+		totalCPU = window.sum / float32(window.count)
+		usageMin, usageMax = &window.min, &window.max
+	}
+
+	// CPU time breakdown (user/system/idle/iowait/steal) since the last sample
+	var cpuTimes CpuMetrics
+	if times, err := cpu.Times(false); err == nil && len(times) > 0 {
+		mc.mu.Lock()
+		if mc.lastCPUTimes != nil {
+			cpuTimes = cpuTimeBreakdown(*mc.lastCPUTimes, times[0])
+		}
+		mc.lastCPUTimes = &times[0]
+		mc.mu.Unlock()
+	}
 
 	// Memory metrics
 	memInfo, _ := mem.VirtualMemory()
@@ -113,10 +238,11 @@ func (mc *MetricsCollector) Collect() SystemMetrics {
 	// Disk metrics - collect physical disks with IO speed
 	mc.mu.Lock()
 	diskIO, _ := disk.IOCounters()
-	diskMetrics := collectPhysicalDisks(diskIO, mc.lastDiskIO, mc.lastDiskIOTime)
+	diskMetrics := collectPhysicalDisks(diskIO, mc.lastDiskIO, mc.lastDiskIOTime, mc.diskFilter)
 	mc.lastDiskIO = diskIO
 	mc.lastDiskIOTime = time.Now()
 	mc.mu.Unlock()
+	mc.applyDiskHealth(diskMetrics)
 
 	// Network metrics
 	netIO, _ := gopsutilnet.IOCounters(true)
@@ -127,6 +253,7 @@ func (mc *MetricsCollector) Collect() SystemMetrics {
 		mc.lastNetworkTx,
 		mc.lastNetworkTime,
 		mc.dailyTrafficStats,
+		mc.interfaceFilter,
 	)
 	mc.lastNetworkRx = totalRx
 	mc.lastNetworkTx = totalTx
@@ -163,10 +290,11 @@ func (mc *MetricsCollector) Collect() SystemMetrics {
 		Timestamp: time.Now().UTC(),
 		Hostname:  hostInfo.Hostname,
 		OS: OsInfo{
-			Name:    hostInfo.Platform,
-			Version: hostInfo.PlatformVersion,
-			Kernel:  hostInfo.KernelVersion,
-			Arch:    runtime.GOARCH,
+			Name:           hostInfo.Platform,
+			Version:        hostInfo.PlatformVersion,
+			Kernel:         hostInfo.KernelVersion,
+			Arch:           runtime.GOARCH,
+			Virtualization: mc.virtualization,
 		},
 		CPU: CpuMetrics{
 			Brand:     cpuBrand,
@@ -174,6 +302,13 @@ func (mc *MetricsCollector) Collect() SystemMetrics {
 			Usage:     totalCPU,
 			Frequency: cpuFreq,
 			PerCore:   perCore,
+			User:      cpuTimes.User,
+			System:    cpuTimes.System,
+			Idle:      cpuTimes.Idle,
+			IOWait:    cpuTimes.IOWait,
+			Steal:     cpuTimes.Steal,
+			UsageMin:  usageMin,
+			UsageMax:  usageMax,
 		},
 		Memory: MemoryMetrics{
 			Total:        memInfo.Total,
@@ -204,23 +339,124 @@ func (mc *MetricsCollector) Collect() SystemMetrics {
 		metrics.IPAddresses = mc.ipAddresses
 	}
 
+	mc.packageUpdatesMu.RLock()
+	if updates := mc.packageUpdates; updates != nil {
+		metrics.UpdatesPending = updates.Pending
+		metrics.SecurityUpdates = updates.Security
+		metrics.RebootRequired = updates.RebootRequired
+	}
+	mc.packageUpdatesMu.RUnlock()
+
+	mc.listeningPortsMu.RLock()
+	metrics.ListeningPorts = mc.listeningPorts
+	mc.listeningPortsMu.RUnlock()
+
+	metrics.Custom = <-customCh
+
 	return metrics
 }
 
 // pingLoop runs in the background to periodically collect ping metrics
 func (mc *MetricsCollector) pingLoop() {
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(time.Duration(mc.pingInterval.Load()))
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-mc.pingScheduleCh:
+			ticker.Reset(time.Duration(mc.pingInterval.Load()))
+			continue
+		}
+
 		mc.customTargetsMu.RLock()
 		customTargets := mc.customPingTargets
 		mc.customTargetsMu.RUnlock()
 
-		results := collectPingMetrics(mc.gatewayIP, customTargets)
+		results := collectPingMetrics(mc.gatewayIP, customTargets, int(mc.pingConcurrency.Load()))
 
 		mc.pingResultsMu.Lock()
 		mc.pingResults = results
 		mc.pingResultsMu.Unlock()
 	}
 }
+
+// CPUSampleInterval is how often CPU usage is sampled between reports, so a
+// short spike shows up in the reported maximum
+const CPUSampleInterval = time.Second
+
+// cpuUsageWindow accumulates the per-second usage samples of one report
+type cpuUsageWindow struct {
+	min, max, sum float32
+	count         int
+}
+
+func (w *cpuUsageWindow) add(usage float32) {
+	if w.count == 0 || usage < w.min {
+		w.min = usage
+	}
+	if w.count == 0 || usage > w.max {
+		w.max = usage
+	}
+	w.sum += usage
+	w.count++
+}
+
+// cpuSampleLoop adds the overall CPU usage of every CPUSampleInterval to the
+// window Collect reports
+func (mc *MetricsCollector) cpuSampleLoop() {
+	ticker := time.NewTicker(CPUSampleInterval)
+	defer ticker.Stop()
+
+	var prev *cpu.TimesStat
+	for range ticker.C {
+		times, err := cpu.Times(false)
+		if err != nil || len(times) == 0 {
+			continue
+		}
+		if prev != nil {
+			// Busy is whatever isn't idle, as in cpu.Percent. An empty
+			// breakdown means the counters went backwards.
+			if b := cpuTimeBreakdown(*prev, times[0]); b.User+b.System+b.Idle+b.IOWait+b.Steal > 0 {
+				usage := min(max(100-b.Idle-b.IOWait, 0), 100)
+				mc.mu.Lock()
+				mc.cpuWindow.add(usage)
+				mc.mu.Unlock()
+			}
+		}
+		prev = &times[0]
+	}
+}
+
+// cpuTimeBreakdown turns the change in CPU time counters between two samples
+// into percentages. If any counter went backwards (wrapped, or reset after a
+// suspend/CPU hotplug) the interval is unusable and an empty result is
+// returned; the caller re-baselines on the current sample either way.
+func cpuTimeBreakdown(prev, cur cpu.TimesStat) CpuMetrics {
+	deltas := []struct{ cur, prev float64 }{
+		{cur.User, prev.User}, {cur.System, prev.System}, {cur.Idle, prev.Idle},
+		{cur.Nice, prev.Nice}, {cur.Iowait, prev.Iowait}, {cur.Irq, prev.Irq},
+		{cur.Softirq, prev.Softirq}, {cur.Steal, prev.Steal},
+	}
+	var total float64
+	for _, d := range deltas {
+		if d.cur < d.prev {
+			return CpuMetrics{}
+		}
+		total += d.cur - d.prev
+	}
+	if total <= 0 {
+		return CpuMetrics{}
+	}
+
+	percent := func(c, p float64) float32 {
+		return float32((c - p) / total * 100)
+	}
+	return CpuMetrics{
+		User:   percent(cur.User+cur.Nice, prev.User+prev.Nice),
+		System: percent(cur.System+cur.Irq+cur.Softirq, prev.System+prev.Irq+prev.Softirq),
+		Idle:   percent(cur.Idle, prev.Idle),
+		IOWait: percent(cur.Iowait, prev.Iowait),
+		Steal:  percent(cur.Steal, prev.Steal),
+	}
+}
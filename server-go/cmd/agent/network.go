@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -130,6 +130,23 @@ func (dts *DailyTrafficStats) updateDailyTraffic(totalRx, totalTx uint64) (daily
 	return dts.DailyRx, dts.DailyTx
 }
 
+// rebase keeps today's totals when the set of counted interfaces changes:
+// the day start moves so that the new totals continue from today's traffic
+func (dts *DailyTrafficStats) rebase(totalRx, totalTx uint64) {
+	dts.mu.Lock()
+	defer dts.mu.Unlock()
+	if totalRx >= dts.DailyRx {
+		dts.DayStartRx = totalRx - dts.DailyRx
+	} else {
+		dts.DayStartRx = totalRx
+	}
+	if totalTx >= dts.DailyTx {
+		dts.DayStartTx = totalTx - dts.DailyTx
+	} else {
+		dts.DayStartTx = totalTx
+	}
+}
+
 // getDailyTraffic returns current daily traffic without updating
 func (dts *DailyTrafficStats) getDailyTraffic() (dailyRx, dailyTx uint64) {
 	dts.mu.RLock()
@@ -142,8 +159,7 @@ func detectGateway() string {
 	switch runtime.GOOS {
 	case "linux":
 		// Use 'ip route show default'
-		cmd := exec.Command("ip", "route", "show", "default")
-		output, err := cmd.Output()
+		output, err := commandOutput("ip", "route", "show", "default")
 		if err == nil {
 			outputStr := string(output)
 			// Parse: default via 192.168.1.1 dev eth0
@@ -159,8 +175,7 @@ func detectGateway() string {
 		}
 	case "darwin":
 		// Use 'route -n get default'
-		cmd := exec.Command("route", "-n", "get", "default")
-		output, err := cmd.Output()
+		output, err := commandOutput("route", "-n", "get", "default")
 		if err == nil {
 			scanner := bufio.NewScanner(strings.NewReader(string(output)))
 			for scanner.Scan() {
@@ -175,8 +190,7 @@ func detectGateway() string {
 		}
 	case "windows":
 		// Use PowerShell to get default gateway
-		cmd := exec.Command("powershell", "-Command", "(Get-NetRoute -DestinationPrefix '0.0.0.0/0' | Select-Object -First 1).NextHop")
-		output, err := cmd.Output()
+		output, err := commandOutput("powershell", "-Command", "(Get-NetRoute -DestinationPrefix '0.0.0.0/0' | Select-Object -First 1).NextHop")
 		if err == nil {
 			gateway := strings.TrimSpace(string(output))
 			if gateway != "" && strings.Contains(gateway, ".") {
@@ -184,8 +198,7 @@ func detectGateway() string {
 			}
 		}
 		// Fallback: use 'route print'
-		cmd = exec.Command("cmd", "/C", "route", "print", "0.0.0.0")
-		output, err = cmd.Output()
+		output, err = commandOutput("cmd", "/C", "route", "print", "0.0.0.0")
 		if err == nil {
 			scanner := bufio.NewScanner(strings.NewReader(string(output)))
 			for scanner.Scan() {
@@ -210,8 +223,7 @@ func collectIPAddresses() []string {
 	switch runtime.GOOS {
 	case "linux":
 		// Try 'hostname -I' first
-		cmd := exec.Command("hostname", "-I")
-		output, err := cmd.Output()
+		output, err := commandOutput("hostname", "-I")
 		if err == nil {
 			fields := strings.Fields(string(output))
 			for _, ip := range fields {
@@ -222,8 +234,7 @@ func collectIPAddresses() []string {
 		}
 		// Fallback: use 'ip addr show'
 		if len(ips) == 0 {
-			cmd = exec.Command("ip", "addr", "show")
-			output, err := cmd.Output()
+			output, err := commandOutput("ip", "addr", "show")
 			if err == nil {
 				scanner := bufio.NewScanner(strings.NewReader(string(output)))
 				for scanner.Scan() {
@@ -242,8 +253,7 @@ func collectIPAddresses() []string {
 		}
 	case "darwin":
 		// Use 'ifconfig'
-		cmd := exec.Command("ifconfig")
-		output, err := cmd.Output()
+		output, err := commandOutput("ifconfig")
 		if err == nil {
 			scanner := bufio.NewScanner(strings.NewReader(string(output)))
 			for scanner.Scan() {
@@ -261,8 +271,7 @@ func collectIPAddresses() []string {
 		}
 	case "windows":
 		// Use PowerShell
-		cmd := exec.Command("powershell", "-Command", "(Get-NetIPAddress -AddressFamily IPv4 | Where-Object { $_.IPAddress -ne '127.0.0.1' }).IPAddress")
-		output, err := cmd.Output()
+		output, err := commandOutput("powershell", "-Command", "(Get-NetIPAddress -AddressFamily IPv4 | Where-Object { $_.IPAddress -ne '127.0.0.1' }).IPAddress")
 		if err == nil {
 			scanner := bufio.NewScanner(strings.NewReader(string(output)))
 			for scanner.Scan() {
@@ -274,8 +283,7 @@ func collectIPAddresses() []string {
 		}
 		// Fallback: use 'ipconfig'
 		if len(ips) == 0 {
-			cmd = exec.Command("ipconfig")
-			output, err := cmd.Output()
+			output, err := commandOutput("ipconfig")
 			if err == nil {
 				scanner := bufio.NewScanner(strings.NewReader(string(output)))
 				for scanner.Scan() {
@@ -297,16 +305,55 @@ func collectIPAddresses() []string {
 	return ips
 }
 
-// isVirtualInterface checks if a network interface is virtual
-func isVirtualInterface(name string) bool {
-	return name == "lo" || name == "lo0" ||
-		strings.HasPrefix(name, "veth") ||
-		strings.HasPrefix(name, "docker") ||
-		strings.HasPrefix(name, "br-") ||
-		strings.HasPrefix(name, "virbr") ||
-		strings.HasPrefix(name, "utun") ||
-		strings.HasPrefix(name, "awdl") ||
-		strings.HasPrefix(name, "llw")
+// DefaultExcludeInterfaces are skipped unless exclude_interfaces is
+// configured: loopback, container and VM bridges, and macOS tunnels
+var DefaultExcludeInterfaces = []string{
+	"lo", "lo0", "veth*", "docker*", "br-*", "virbr*", "utun*", "awdl*", "llw*",
+}
+
+// interfaceFilter decides which interfaces count towards network metrics
+type interfaceFilter struct {
+	include []string
+	exclude []string
+}
+
+func newInterfaceFilter(cfg *AgentConfig) *interfaceFilter {
+	exclude := cfg.ExcludeInterfaces
+	if exclude == nil {
+		exclude = DefaultExcludeInterfaces
+	}
+	return &interfaceFilter{include: cfg.IncludeInterfaces, exclude: exclude}
+}
+
+// skip reports whether an interface should be left out of network metrics.
+// Names and patterns are compared case-insensitively.
+func (f *interfaceFilter) skip(name string) bool {
+	name = strings.ToLower(name)
+	if len(f.include) > 0 && !matchInterface(name, f.include) {
+		return true
+	}
+	return matchInterface(name, f.exclude)
+}
+
+// matchInterface matches an interface name against shell patterns ("veth*")
+func matchInterface(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// interfaceTotals sums the counters of the interfaces the filter keeps
+func interfaceTotals(netIO []gopsutilnet.IOCountersStat, filter *interfaceFilter) (totalRx, totalTx uint64) {
+	for _, io := range netIO {
+		if !filter.skip(io.Name) {
+			totalRx += io.BytesRecv
+			totalTx += io.BytesSent
+		}
+	}
+	return totalRx, totalTx
 }
 
 // getInterfaceDetails gets MAC address and link speed for a network interface
@@ -333,8 +380,7 @@ func getInterfaceDetails(name string) (string, uint32) {
 		}
 	case "darwin":
 		// Use ifconfig to get MAC
-		cmd := exec.Command("ifconfig", name)
-		output, err := cmd.Output()
+		output, err := commandOutput("ifconfig", name)
 		if err == nil {
 			scanner := bufio.NewScanner(strings.NewReader(string(output)))
 			for scanner.Scan() {
@@ -348,8 +394,7 @@ func getInterfaceDetails(name string) (string, uint32) {
 			}
 		}
 		// Use networksetup for speed
-		cmd = exec.Command("networksetup", "-getMedia", name)
-		output, err = cmd.Output()
+		output, err = commandOutput("networksetup", "-getMedia", name)
 		if err == nil {
 			outputStr := strings.ToLower(string(output))
 			if strings.Contains(outputStr, "1000") {
@@ -362,8 +407,7 @@ func getInterfaceDetails(name string) (string, uint32) {
 		}
 	case "windows":
 		// Use PowerShell
-		cmd := exec.Command("powershell", "-Command", fmt.Sprintf("Get-NetAdapter -Name '%s' | Select-Object -Property MacAddress,LinkSpeed | ConvertTo-Json", name))
-		output, err := cmd.Output()
+		output, err := commandOutput("powershell", "-Command", fmt.Sprintf("Get-NetAdapter -Name '%s' | Select-Object -Property MacAddress,LinkSpeed | ConvertTo-Json", name))
 		if err == nil {
 			var data map[string]interface{}
 			if json.Unmarshal(output, &data) == nil {
@@ -390,15 +434,25 @@ func getInterfaceDetails(name string) (string, uint32) {
 	return mac, speed
 }
 
+// counterRate returns the per-second rate between two samples of a byte
+// counter. A counter that went backwards was reset (reboot, interface flap,
+// an interface disappearing from the totals): that tick reports 0 and the
+// caller's new baseline is the current value.
+func counterRate(current, last uint64, elapsed float64) uint64 {
+	if current < last || elapsed <= 0 {
+		return 0
+	}
+	return uint64(float64(current-last) / elapsed)
+}
+
 // collectNetworkMetrics collects network interface metrics
-func collectNetworkMetrics(netIO []gopsutilnet.IOCountersStat, lastRx, lastTx uint64, lastTime time.Time, dailyStats *DailyTrafficStats) ([]NetworkInterface, uint64, uint64, uint64, uint64, uint64, uint64, time.Time) {
+func collectNetworkMetrics(netIO []gopsutilnet.IOCountersStat, lastRx, lastTx uint64, lastTime time.Time, dailyStats *DailyTrafficStats, filter *interfaceFilter) ([]NetworkInterface, uint64, uint64, uint64, uint64, uint64, uint64, time.Time) {
 	var interfaces []NetworkInterface
 	var totalRx, totalTx uint64
 
 	for _, io := range netIO {
-		// Filter out virtual interfaces
-		name := strings.ToLower(io.Name)
-		if isVirtualInterface(name) {
+		// Filter out virtual and excluded interfaces
+		if filter.skip(io.Name) {
 			continue
 		}
 
@@ -423,14 +477,8 @@ func collectNetworkMetrics(netIO []gopsutilnet.IOCountersStat, lastRx, lastTx ui
 	elapsed := now.Sub(lastTime).Seconds()
 	var rxSpeed, txSpeed uint64
 	if elapsed > 0.1 {
-		rxDiff := totalRx - lastRx
-		txDiff := totalTx - lastTx
-		if totalRx >= lastRx {
-			rxSpeed = uint64(float64(rxDiff) / elapsed)
-		}
-		if totalTx >= lastTx {
-			txSpeed = uint64(float64(txDiff) / elapsed)
-		}
+		rxSpeed = counterRate(totalRx, lastRx, elapsed)
+		txSpeed = counterRate(totalTx, lastTx, elapsed)
 	}
 
 	// Update daily traffic statistics
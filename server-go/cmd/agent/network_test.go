@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	gopsutilnet "github.com/shirou/gopsutil/v4/net"
+)
+
+func TestCounterRate(t *testing.T) {
+	tests := []struct {
+		name          string
+		current, last uint64
+		elapsed       float64
+		want          uint64
+	}{
+		{"normal", 3000, 1000, 2, 1000},
+		{"equal counters", 5000, 5000, 1, 0},
+		{"counter reset", 100, 1 << 40, 1, 0},
+		{"no time elapsed", 3000, 1000, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := counterRate(tt.current, tt.last, tt.elapsed); got != tt.want {
+				t.Fatalf("counterRate(%d, %d, %v) = %d, want %d", tt.current, tt.last, tt.elapsed, got, tt.want)
+			}
+		})
+	}
+}
+
+// A decreasing counter must not show up as a multi-exabyte spike; the next
+// sample is measured from the new, lower baseline
+func TestCollectNetworkMetricsCounterReset(t *testing.T) {
+	filter := &interfaceFilter{}
+	start := time.Now().Add(-time.Second)
+
+	netIO := []gopsutilnet.IOCountersStat{{Name: "test0", BytesRecv: 500, BytesSent: 200}}
+	_, rx, tx, rxSpeed, txSpeed, _, _, now := collectNetworkMetrics(netIO, 1<<40, 1<<40, start, nil, filter)
+	if rxSpeed != 0 || txSpeed != 0 {
+		t.Fatalf("after reset got rx %d tx %d B/s, want 0", rxSpeed, txSpeed)
+	}
+	if rx != 500 || tx != 200 {
+		t.Fatalf("baseline = %d/%d, want 500/200", rx, tx)
+	}
+
+	netIO[0].BytesRecv, netIO[0].BytesSent = 500+10000, 200+5000
+	_, _, _, rxSpeed, txSpeed, _, _, _ = collectNetworkMetrics(netIO, rx, tx, now.Add(-time.Second), nil, filter)
+	if rxSpeed < 9000 || rxSpeed > 10000 || txSpeed < 4500 || txSpeed > 5000 {
+		t.Fatalf("after reset baseline got rx %d tx %d B/s, want about 10000/5000", rxSpeed, txSpeed)
+	}
+}
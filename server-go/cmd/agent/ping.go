@@ -2,78 +2,156 @@ package main
 
 import (
 	"context"
+	"io"
 	"net"
+	"net/http"
 	"os/exec"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-// collectPingMetrics collects ping metrics for configured targets
-func collectPingMetrics(gatewayIP string, customTargets []PingTargetConfig) *PingMetrics {
+// Defaults for ping_interval_secs and ping_concurrency
+const (
+	DefaultPingIntervalSecs = 10
+	DefaultPingConcurrency  = 8
+)
+
+// pingProbe is a configured target with its type, host and port resolved
+type pingProbe struct {
+	config     PingTargetConfig
+	targetType string
+	host       string
+	port       int
+}
+
+// collectPingMetrics collects ping metrics for configured targets, probing up
+// to concurrency targets at once. Results keep the configured order.
+func collectPingMetrics(gatewayIP string, customTargets []PingTargetConfig, concurrency int) *PingMetrics {
 	// If no custom targets configured, return nil (no ping)
 	if len(customTargets) == 0 {
 		return nil
 	}
 
-	var targets []PingTarget
+	var probes []pingProbe
 	pingedHosts := make(map[string]bool)
 
 	// Only ping custom targets from dashboard configuration
 	for _, ct := range customTargets {
-		if ct.Host == "" || pingedHosts[ct.Host] {
+		if ct.Host == "" && ct.URL == "" {
 			continue
 		}
 
-		// Determine type (default to icmp)
+		host, port := ct.Host, ct.Port
 		targetType := ct.Type
-		if targetType == "" {
-			targetType = "icmp"
+		if targetType == "" && ct.URL != "" {
+			targetType = "http"
 		}
 
-		var latency *float64
-		var packetLoss float64
-		var status string
-
-		if targetType == "tcp" {
-			// Use TCP connection test
-			port := ct.Port
-			if port == 0 {
-				port = 80 // Default to HTTP port
+		if targetType == "http" {
+			// HTTP probes are reported and stored by URL
+			host = httpProbeURL(ct)
+		} else if h, p, err := net.SplitHostPort(ct.Host); err == nil {
+			// Accept "host:port" shorthand; a port implies a TCP probe
+			if n, err := strconv.Atoi(p); err == nil && n > 0 {
+				host = h
+				if port == 0 {
+					port = n
+				}
 			}
-			latency, status = testTCPConnection(ct.Host, port)
-			if status == "ok" {
-				packetLoss = 0.0
+		}
+
+		// Determine type (default to icmp, or tcp when a port is given)
+		if targetType == "" {
+			if port > 0 {
+				targetType = "tcp"
 			} else {
-				packetLoss = 100.0
+				targetType = "icmp"
 			}
-		} else {
-			// Use ICMP ping
-			latency, packetLoss, status = pingHost(ct.Host)
 		}
 
-		targets = append(targets, PingTarget{
-			Name:       ct.Name,
-			Host:       ct.Host,
-			Type:       targetType,
-			Port:       ct.Port,
-			LatencyMs:  latency,
-			PacketLoss: packetLoss,
-			Status:     status,
-		})
-		pingedHosts[ct.Host] = true
+		key := targetType + "|" + net.JoinHostPort(host, strconv.Itoa(port))
+		if pingedHosts[key] {
+			continue
+		}
+		pingedHosts[key] = true
+		probes = append(probes, pingProbe{config: ct, targetType: targetType, host: host, port: port})
 	}
 
 	// Return nil if no valid targets after filtering
-	if len(targets) == 0 {
+	if len(probes) == 0 {
 		return nil
 	}
 
+	if concurrency <= 0 {
+		concurrency = DefaultPingConcurrency
+	}
+	targets := make([]PingTarget, len(probes))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(concurrency, len(probes)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				targets[i] = runPingProbe(probes[i])
+			}
+		}()
+	}
+	for i := range probes {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
 	return &PingMetrics{Targets: targets}
 }
 
+// runPingProbe probes a single target
+func runPingProbe(probe pingProbe) PingTarget {
+	host, port := probe.host, probe.port
+	var latency *float64
+	var packetLoss float64
+	var status string
+
+	if probe.targetType == "tcp" {
+		// Use TCP connection test
+		if port == 0 {
+			port = 80 // Default to HTTP port
+		}
+		latency, status = testTCPConnection(host, port)
+		if status == "ok" {
+			packetLoss = 0.0
+		} else {
+			packetLoss = 100.0
+		}
+	} else if probe.targetType == "http" {
+		// Use timed HTTP GET with status/body assertions
+		latency, status = testHTTPEndpoint(host, probe.config)
+		if status == "ok" {
+			packetLoss = 0.0
+		} else {
+			packetLoss = 100.0
+		}
+	} else {
+		// Use ICMP ping
+		latency, packetLoss, status = pingHost(host)
+	}
+
+	return PingTarget{
+		Name:       probe.config.Name,
+		Host:       host,
+		Type:       probe.targetType,
+		Port:       port,
+		LatencyMs:  latency,
+		PacketLoss: packetLoss,
+		Status:     status,
+	}
+}
+
 // testTCPConnection tests TCP connection latency
 func testTCPConnection(host string, port int) (*float64, string) {
 	address := net.JoinHostPort(host, strconv.Itoa(port))
@@ -89,6 +167,64 @@ func testTCPConnection(host string, port int) (*float64, string) {
 	return &latency, "ok"
 }
 
+// httpProbeURL returns the URL an HTTP probe should request
+func httpProbeURL(ct PingTargetConfig) string {
+	if ct.URL != "" {
+		return ct.URL
+	}
+	if strings.Contains(ct.Host, "://") {
+		return ct.Host
+	}
+	return "http://" + ct.Host
+}
+
+// testHTTPEndpoint performs a timed GET and checks the status code and body
+func testHTTPEndpoint(url string, ct PingTargetConfig) (*float64, string) {
+	timeout := time.Duration(ct.TimeoutSecs) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	client := &http.Client{Timeout: timeout}
+	if ct.FollowRedirects != nil && !*ct.FollowRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "error"
+	}
+	req.Header.Set("User-Agent", "vstats-agent")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "error"
+	}
+	defer resp.Body.Close()
+
+	latency := float64(time.Since(start).Nanoseconds()) / 1000000.0 // Convert to milliseconds
+
+	if ct.ExpectedStatus > 0 {
+		if resp.StatusCode != ct.ExpectedStatus {
+			return &latency, "error"
+		}
+	} else if resp.StatusCode >= 400 {
+		return &latency, "error"
+	}
+
+	if ct.BodyContains != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil || !strings.Contains(string(body), ct.BodyContains) {
+			return &latency, "error"
+		}
+	}
+
+	return &latency, "ok"
+}
+
 // pingHost performs ICMP ping to a host
 func pingHost(host string) (*float64, float64, string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
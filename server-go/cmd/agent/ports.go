@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"syscall"
+	"time"
+
+	gopsutilnet "github.com/shirou/gopsutil/v4/net"
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// ============================================================================
+// Listening Ports
+// ============================================================================
+
+// ListeningPortsInterval is how often listening sockets are enumerated;
+// walking every socket (and its process) is too costly for each sample
+const ListeningPortsInterval = 5 * time.Minute
+
+// listeningPortsLoop refreshes the listening sockets at startup and then
+// every ListeningPortsInterval, caching them for Collect
+func (mc *MetricsCollector) listeningPortsLoop() {
+	for {
+		ports, err := collectListeningPorts()
+		if err != nil {
+			log.Printf("Listing listening ports failed: %v", err)
+		} else {
+			mc.listeningPortsMu.Lock()
+			mc.listeningPorts = ports
+			mc.listeningPortsMu.Unlock()
+		}
+		time.Sleep(ListeningPortsInterval)
+	}
+}
+
+// collectListeningPorts returns TCP sockets in LISTEN state and bound,
+// unconnected UDP sockets. Process names need root (or the same user) on
+// most platforms and are left empty when they can't be read.
+func collectListeningPorts() ([]PortInfo, error) {
+	conns, err := gopsutilnet.Connections("inet")
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[int32]string)
+	processName := func(pid int32) string {
+		if pid <= 0 {
+			return ""
+		}
+		if name, ok := names[pid]; ok {
+			return name
+		}
+		name := ""
+		if p, err := process.NewProcess(pid); err == nil {
+			name, _ = p.Name()
+		}
+		names[pid] = name
+		return name
+	}
+
+	seen := make(map[string]bool)
+	var ports []PortInfo
+	for _, conn := range conns {
+		var proto string
+		switch {
+		case conn.Type == syscall.SOCK_STREAM && conn.Status == "LISTEN":
+			proto = "tcp"
+		case conn.Type == syscall.SOCK_DGRAM && conn.Raddr.Port == 0:
+			proto = "udp"
+		default:
+			continue
+		}
+		if conn.Family == syscall.AF_INET6 {
+			proto += "6"
+		}
+
+		key := fmt.Sprintf("%s/%s/%d", proto, conn.Laddr.IP, conn.Laddr.Port)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ports = append(ports, PortInfo{
+			Proto:   proto,
+			Address: conn.Laddr.IP,
+			Port:    conn.Laddr.Port,
+			Process: processName(conn.Pid),
+		})
+	}
+
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Port != ports[j].Port {
+			return ports[i].Port < ports[j].Port
+		}
+		if ports[i].Proto != ports[j].Proto {
+			return ports[i].Proto < ports[j].Proto
+		}
+		return ports[i].Address < ports[j].Address
+	})
+	return ports, nil
+}
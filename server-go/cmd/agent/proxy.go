@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// proxyFunc returns the proxy selector for outgoing connections. ProxyURL
+// from the config wins, then HTTPS_PROXY/HTTP_PROXY, then ALL_PROXY; NO_PROXY
+// is honoured in every case.
+func proxyFunc(proxyURL string) func(*url.URL) (*url.URL, error) {
+	cfg := httpproxy.FromEnvironment()
+	if all := getEnvAny("ALL_PROXY", "all_proxy"); all != "" {
+		if cfg.HTTPProxy == "" {
+			cfg.HTTPProxy = all
+		}
+		if cfg.HTTPSProxy == "" {
+			cfg.HTTPSProxy = all
+		}
+	}
+	if proxyURL != "" {
+		cfg.HTTPProxy = proxyURL
+		cfg.HTTPSProxy = proxyURL
+	}
+	return cfg.ProxyFunc()
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// newHTTPClient returns an HTTP client that goes through the configured proxy
+// and trusts the configured CA. net/http dials socks5:// proxies itself.
+func newHTTPClient(cfg *AgentConfig) (*http.Client, error) {
+	tlsConfig, err := agentTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	selectProxy := proxyFunc(cfg.ProxyURL)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return selectProxy(req.URL)
+	}
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+// newWebSocketDialer returns a dialer for the dashboard that goes through the
+// configured proxy (HTTP proxies via CONNECT, SOCKS5 via x/net/proxy) and
+// trusts the configured CA
+func newWebSocketDialer(cfg *AgentConfig, wsURL string) (*websocket.Dialer, error) {
+	tlsConfig, err := agentTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+
+	// Proxy selection (and NO_PROXY) works on http(s) URLs
+	target, err := url.Parse(strings.Replace(wsURL, "ws", "http", 1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid dashboard URL: %w", err)
+	}
+	proxyTarget, err := proxyFunc(cfg.ProxyURL)(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if proxyTarget == nil {
+		dialer.Proxy = nil
+		return &dialer, nil
+	}
+
+	switch proxyTarget.Scheme {
+	case "socks5", "socks5h":
+		socks, err := proxy.FromURL(proxyTarget, proxy.Direct)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOCKS proxy: %w", err)
+		}
+		dialer.Proxy = nil
+		if contextDialer, ok := socks.(proxy.ContextDialer); ok {
+			dialer.NetDialContext = contextDialer.DialContext
+		} else {
+			dialer.NetDial = socks.Dial
+		}
+	case "http", "https":
+		dialer.Proxy = http.ProxyURL(proxyTarget)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyTarget.Scheme)
+	}
+	return &dialer, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// systemd Notification (sd_notify)
+// ============================================================================
+
+// sdNotify sends a state string ("READY=1", "WATCHDOG=1", ...) to systemd
+// over the datagram socket in $NOTIFY_SOCKET. It is a no-op when the agent
+// isn't running under a Type=notify unit.
+func sdNotify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+	// A leading @ means a socket in the abstract namespace
+	if socketPath[0] == '@' {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogTimeout returns the WatchdogSec= of the unit, or 0 if the watchdog
+// is disabled or meant for another process
+func watchdogTimeout() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// watchdogLoop pings the systemd watchdog at half its timeout. Pings stop
+// while a metrics collection has been running for longer than that, so
+// systemd restarts an agent whose collectors are stuck (e.g. on a hung
+// mount) while reconnect backoff alone never trips it.
+func (wsc *WebSocketClient) watchdogLoop() {
+	timeout := watchdogTimeout()
+	if timeout == 0 {
+		return
+	}
+	interval := timeout / 2
+	log.Printf("systemd watchdog enabled (timeout %v)", timeout)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if running := wsc.collector.CollectionRunningFor(); running > interval {
+			log.Printf("Metrics collection running for %v, withholding watchdog ping", running.Round(time.Second))
+			continue
+		}
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Printf("Failed to notify systemd watchdog: %v", err)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// setupReloadHandler reloads the agent config on SIGHUP, e.g. from
+// `systemctl reload vstats-agent`
+func setupReloadHandler(wsc *WebSocketClient) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	go func() {
+		for range sigs {
+			log.Println("Received SIGHUP, reloading config...")
+			wsc.ReloadConfig()
+		}
+	}()
+}
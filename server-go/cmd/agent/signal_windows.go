@@ -0,0 +1,8 @@
+//go:build windows
+// +build windows
+
+package main
+
+// setupReloadHandler is a no-op on Windows, which has no SIGHUP; restart the
+// service to apply config changes
+func setupReloadHandler(wsc *WebSocketClient) {}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"vstats/internal/common"
+)
+
+// ============================================================================
+// SMART Disk Health
+// ============================================================================
+
+// SmartCheckInterval is how often smartctl is run; it wakes sleeping disks
+// and can take a while on busy controllers, so it isn't done per sample
+const SmartCheckInterval = time.Hour
+
+// diskHealth is the cached SMART summary of one disk
+type diskHealth struct {
+	status             string
+	reallocatedSectors uint64
+	mediaErrors        uint64
+}
+
+// smartLoop refreshes the SMART health of the physical disks every
+// SmartCheckInterval. Linux only.
+func (mc *MetricsCollector) smartLoop() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if !commandExists("smartctl") {
+		log.Println("smartctl not found, disk health is reported as unknown (install smartmontools to enable it)")
+	} else if !runningAsRoot() {
+		logPrivilegeNotice()
+	}
+
+	for {
+		results := make(map[string]diskHealth)
+		for _, name := range smartDiskNames() {
+			results[name] = checkSmart(name)
+		}
+		mc.smartResultsMu.Lock()
+		mc.smartResults = results
+		mc.smartResultsMu.Unlock()
+
+		time.Sleep(SmartCheckInterval)
+	}
+}
+
+// smartDiskNames lists the physical disks in /sys/block, skipping the same
+// virtual devices as collectPhysicalDisks
+func smartDiskNames() []string {
+	entries, err := os.ReadDir("/sys/block")
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") ||
+			strings.HasPrefix(name, "dm-") || strings.HasPrefix(name, "sr") ||
+			strings.HasPrefix(name, "fd") || strings.HasPrefix(name, "zram") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// smartctlOutput is the part of `smartctl --json` output used here
+type smartctlOutput struct {
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	ATASmartAttributes struct {
+		Table []struct {
+			ID  int `json:"id"`
+			Raw struct {
+				Value uint64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NVMeHealth *struct {
+		CriticalWarning uint64 `json:"critical_warning"`
+		MediaErrors     uint64 `json:"media_errors"`
+	} `json:"nvme_smart_health_information_log"`
+}
+
+// checkSmart runs smartctl on one disk. smartctl encodes findings in its exit
+// status, so the JSON is parsed whatever the exit code.
+func checkSmart(name string) diskHealth {
+	output, _ := commandOutput("smartctl", "--json", "-H", "-A", "/dev/"+name)
+	var out smartctlOutput
+	if len(output) == 0 || json.Unmarshal(output, &out) != nil || out.SmartStatus == nil {
+		return diskHealth{status: common.DiskHealthUnknown}
+	}
+
+	health := diskHealth{status: common.DiskHealthOK}
+	for _, attr := range out.ATASmartAttributes.Table {
+		// 5 Reallocated_Sector_Ct, 197 Current_Pending_Sector
+		if attr.ID == 5 || attr.ID == 197 {
+			health.reallocatedSectors += attr.Raw.Value
+		}
+	}
+	if out.NVMeHealth != nil {
+		health.mediaErrors = out.NVMeHealth.MediaErrors
+	}
+
+	switch {
+	case !out.SmartStatus.Passed:
+		health.status = common.DiskHealthFailing
+	case health.reallocatedSectors > 0 || health.mediaErrors > 0 ||
+		(out.NVMeHealth != nil && out.NVMeHealth.CriticalWarning != 0):
+		health.status = common.DiskHealthWarning
+	}
+	return health
+}
+
+// applyDiskHealth copies the cached SMART results onto the collected disks.
+// Disks not checked yet are left without a health value.
+func (mc *MetricsCollector) applyDiskHealth(disks []DiskMetrics) {
+	mc.smartResultsMu.RLock()
+	defer mc.smartResultsMu.RUnlock()
+	for i := range disks {
+		if health, ok := mc.smartResults[disks[i].Name]; ok {
+			disks[i].Health = health.status
+			disks[i].ReallocatedSectors = health.reallocatedSectors
+			disks[i].MediaErrors = health.mediaErrors
+		}
+	}
+}
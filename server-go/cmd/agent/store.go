@@ -84,8 +84,9 @@ type AggregatedMetrics struct {
 	LastMetrics *SystemMetrics `json:"last_metrics,omitempty"`
 }
 
-// NewLocalStore creates a new local storage instance
-func NewLocalStore(dataDir string) (*LocalStore, error) {
+// NewLocalStore creates a new local storage instance. maxRecords caps the
+// number of pending raw metrics kept while offline (0 uses the default).
+func NewLocalStore(dataDir string, maxRecords int) (*LocalStore, error) {
 	// Create data directory if it doesn't exist
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		return nil, err
@@ -132,7 +133,10 @@ func NewLocalStore(dataDir string) (*LocalStore, error) {
 			net_tx INTEGER NOT NULL DEFAULT 0,
 			ping_sum REAL NOT NULL DEFAULT 0,
 			ping_count INTEGER NOT NULL DEFAULT 0,
-			sample_count INTEGER NOT NULL DEFAULT 0
+			sample_count INTEGER NOT NULL DEFAULT 0,
+			iowait_sum REAL NOT NULL DEFAULT 0,
+			steal_sum REAL NOT NULL DEFAULT 0,
+			cpu_times_count INTEGER NOT NULL DEFAULT 0
 		) WITHOUT ROWID;
 
 		-- 2-minute aggregated metrics (for 24H view, keep 26 hours)
@@ -147,7 +151,10 @@ func NewLocalStore(dataDir string) (*LocalStore, error) {
 			net_tx INTEGER NOT NULL DEFAULT 0,
 			ping_sum REAL NOT NULL DEFAULT 0,
 			ping_count INTEGER NOT NULL DEFAULT 0,
-			sample_count INTEGER NOT NULL DEFAULT 0
+			sample_count INTEGER NOT NULL DEFAULT 0,
+			iowait_sum REAL NOT NULL DEFAULT 0,
+			steal_sum REAL NOT NULL DEFAULT 0,
+			cpu_times_count INTEGER NOT NULL DEFAULT 0
 		) WITHOUT ROWID;
 
 		-- 15-minute aggregated metrics (for 7D view, keep 8 days)
@@ -162,7 +169,10 @@ func NewLocalStore(dataDir string) (*LocalStore, error) {
 			net_tx INTEGER NOT NULL DEFAULT 0,
 			ping_sum REAL NOT NULL DEFAULT 0,
 			ping_count INTEGER NOT NULL DEFAULT 0,
-			sample_count INTEGER NOT NULL DEFAULT 0
+			sample_count INTEGER NOT NULL DEFAULT 0,
+			iowait_sum REAL NOT NULL DEFAULT 0,
+			steal_sum REAL NOT NULL DEFAULT 0,
+			cpu_times_count INTEGER NOT NULL DEFAULT 0
 		) WITHOUT ROWID;
 
 		-- Hourly aggregated metrics (for 30D view, keep 32 days)
@@ -177,7 +187,10 @@ func NewLocalStore(dataDir string) (*LocalStore, error) {
 			net_tx INTEGER NOT NULL DEFAULT 0,
 			ping_sum REAL NOT NULL DEFAULT 0,
 			ping_count INTEGER NOT NULL DEFAULT 0,
-			sample_count INTEGER NOT NULL DEFAULT 0
+			sample_count INTEGER NOT NULL DEFAULT 0,
+			iowait_sum REAL NOT NULL DEFAULT 0,
+			steal_sum REAL NOT NULL DEFAULT 0,
+			cpu_times_count INTEGER NOT NULL DEFAULT 0
 		) WITHOUT ROWID;
 
 		-- Daily aggregated metrics (for 1Y view, keep 400 days)
@@ -192,7 +205,10 @@ func NewLocalStore(dataDir string) (*LocalStore, error) {
 			net_tx INTEGER NOT NULL DEFAULT 0,
 			ping_sum REAL NOT NULL DEFAULT 0,
 			ping_count INTEGER NOT NULL DEFAULT 0,
-			sample_count INTEGER NOT NULL DEFAULT 0
+			sample_count INTEGER NOT NULL DEFAULT 0,
+			iowait_sum REAL NOT NULL DEFAULT 0,
+			steal_sum REAL NOT NULL DEFAULT 0,
+			cpu_times_count INTEGER NOT NULL DEFAULT 0
 		) WITHOUT ROWID;
 
 		-- 5-second ping aggregation
@@ -265,10 +281,22 @@ func NewLocalStore(dataDir string) (*LocalStore, error) {
 		return nil, err
 	}
 
+	// Migration: iowait/steal sums, counted only for samples with a CPU time
+	// breakdown. Fails harmlessly once the columns exist.
+	for _, table := range []string{"metrics_5sec", "metrics_2min", "metrics_15min", "metrics_hourly", "metrics_daily"} {
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN iowait_sum REAL NOT NULL DEFAULT 0")
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN steal_sum REAL NOT NULL DEFAULT 0")
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN cpu_times_count INTEGER NOT NULL DEFAULT 0")
+	}
+
+	if maxRecords <= 0 {
+		maxRecords = 10000
+	}
+
 	store := &LocalStore{
 		db:          db,
 		maxAge:      24 * time.Hour,
-		maxRecords:  10000,
+		maxRecords:  maxRecords,
 		aggregation: 1 * time.Minute,
 	}
 
@@ -278,7 +306,8 @@ func NewLocalStore(dataDir string) (*LocalStore, error) {
 	return store, nil
 }
 
-// Store saves metrics to local storage
+// Store saves metrics to local storage. The pending buffer behaves as a ring:
+// once it holds maxRecords entries the oldest ones are dropped first.
 func (s *LocalStore) Store(metrics *SystemMetrics) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -294,6 +323,14 @@ func (s *LocalStore) Store(metrics *SystemMetrics) error {
 		metrics.Timestamp.Format(time.RFC3339Nano),
 		string(data),
 	)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`
+		DELETE FROM pending_metrics WHERE id IN (
+			SELECT id FROM pending_metrics ORDER BY timestamp DESC LIMIT -1 OFFSET ?
+		)`, s.maxRecords)
 	return err
 }
 
@@ -331,6 +368,13 @@ func (s *LocalStore) StoreWithAggregation(metrics *SystemMetrics) error {
 	cpuUsage := float64(metrics.CPU.Usage)
 	memUsage := float64(metrics.Memory.UsagePercent)
 
+	// iowait/steal only count for samples that carry a CPU time breakdown
+	var iowait, steal float64
+	var timesCount int
+	if metrics.CPU.HasTimes() {
+		iowait, steal, timesCount = float64(metrics.CPU.IOWait), float64(metrics.CPU.Steal), 1
+	}
+
 	// Update all granularity buckets
 	buckets := []struct {
 		table    string
@@ -346,8 +390,8 @@ func (s *LocalStore) StoreWithAggregation(metrics *SystemMetrics) error {
 	for _, b := range buckets {
 		bucket := ts / b.interval
 		s.db.Exec(`
-			INSERT INTO `+b.table+` (bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
+			INSERT INTO `+b.table+` (bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count, iowait_sum, steal_sum, cpu_times_count)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?, ?)
 			ON CONFLICT(bucket) DO UPDATE SET
 				cpu_sum = cpu_sum + excluded.cpu_sum,
 				cpu_max = MAX(cpu_max, excluded.cpu_max),
@@ -358,13 +402,17 @@ func (s *LocalStore) StoreWithAggregation(metrics *SystemMetrics) error {
 				net_tx = MAX(net_tx, excluded.net_tx),
 				ping_sum = ping_sum + excluded.ping_sum,
 				ping_count = ping_count + excluded.ping_count,
-				sample_count = sample_count + 1`,
+				sample_count = sample_count + 1,
+				iowait_sum = iowait_sum + excluded.iowait_sum,
+				steal_sum = steal_sum + excluded.steal_sum,
+				cpu_times_count = cpu_times_count + excluded.cpu_times_count`,
 			bucket,
-			cpuUsage, cpuUsage,
+			cpuUsage, float64(metrics.CPU.PeakUsage()),
 			memUsage, memUsage,
 			diskUsage,
 			metrics.Network.TotalRx, metrics.Network.TotalTx,
 			pingVal, pingCnt,
+			iowait, steal, timesCount,
 		)
 	}
 
@@ -455,7 +503,7 @@ func (s *LocalStore) GetAggregatedData(granularity string, sinceBucket int64) (*
 
 	// Query metrics
 	rows, err := s.db.Query(`
-		SELECT bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count
+		SELECT bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count, iowait_sum, steal_sum, cpu_times_count
 		FROM `+table+`
 		WHERE bucket >= ?
 		ORDER BY bucket ASC`, sinceBucket)
@@ -467,7 +515,8 @@ func (s *LocalStore) GetAggregatedData(granularity string, sinceBucket int64) (*
 	for rows.Next() {
 		var bd common.BucketData
 		if err := rows.Scan(&bd.Bucket, &bd.CPUSum, &bd.CPUMax, &bd.MemorySum, &bd.MemoryMax,
-			&bd.DiskSum, &bd.NetRx, &bd.NetTx, &bd.PingSum, &bd.PingCount, &bd.SampleCount); err != nil {
+			&bd.DiskSum, &bd.NetRx, &bd.NetTx, &bd.PingSum, &bd.PingCount, &bd.SampleCount,
+			&bd.IOWaitSum, &bd.StealSum, &bd.CPUTimesCount); err != nil {
 			continue
 		}
 		data.Metrics = append(data.Metrics, bd)
@@ -801,8 +850,8 @@ func aggregateMetricsList(metrics []*SystemMetrics) *AggregatedMetrics {
 	for _, m := range metrics {
 		// CPU
 		cpuSum += m.CPU.Usage
-		if m.CPU.Usage > agg.CPUMax {
-			agg.CPUMax = m.CPU.Usage
+		if peak := m.CPU.PeakUsage(); peak > agg.CPUMax {
+			agg.CPUMax = peak
 		}
 
 		// Memory
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/disk"
+)
+
+// ============================================================================
+// Collector Timeouts
+// ============================================================================
+
+const (
+	CommandTimeout   = 10 * time.Second // External tools (dmidecode, wmic, ip, ...)
+	DiskUsageTimeout = 3 * time.Second  // statfs per mount point
+	DiskUsageWorkers = 8                // Concurrent statfs calls per collection
+)
+
+// commandOutput runs an external command for metrics collection and kills it
+// if it runs longer than CommandTimeout, so a slow tool only costs the data
+// it would have provided
+func commandOutput(name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), CommandTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, name, args...).Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("%s timed out after %v, skipping", name, CommandTimeout)
+		return nil, ctx.Err()
+	}
+	return output, err
+}
+
+// stuckMounts holds mount points whose statfs call hasn't returned yet
+var stuckMounts sync.Map
+
+// queryDiskUsage is the statfs call behind diskUsage; tests replace it to
+// simulate a hanging mount
+var queryDiskUsage = disk.Usage
+
+// diskUsage wraps disk.Usage with DiskUsageTimeout. statfs can't be
+// cancelled, so a call that hangs (typically a dead NFS server) is left
+// running in the background and the mount is skipped until it returns,
+// instead of piling up another blocked call every interval.
+func diskUsage(path string) (*disk.UsageStat, error) {
+	if _, stuck := stuckMounts.Load(path); stuck {
+		return nil, fmt.Errorf("%s: previous usage query still pending", path)
+	}
+
+	type result struct {
+		usage *disk.UsageStat
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		usage, err := queryDiskUsage(path)
+		done <- result{usage, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.usage, r.err
+	case <-time.After(DiskUsageTimeout):
+		log.Printf("Disk usage for %s timed out after %v, skipping mount", path, DiskUsageTimeout)
+		stuckMounts.Store(path, struct{}{})
+		go func() {
+			<-done
+			stuckMounts.Delete(path)
+		}()
+		return nil, fmt.Errorf("%s: usage query timed out", path)
+	}
+}
+
+// diskUsages queries the usage of many mount points with up to
+// DiskUsageWorkers concurrent diskUsage calls, so one slow mount only delays
+// its own result. Mounts whose query failed are missing from the result.
+func diskUsages(mounts []string) map[string]*disk.UsageStat {
+	usages := make(map[string]*disk.UsageStat, len(mounts))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, DiskUsageWorkers)
+
+	seen := make(map[string]bool, len(mounts))
+	for _, mount := range mounts {
+		if seen[mount] {
+			continue
+		}
+		seen[mount] = true
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(mount string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if usage, err := diskUsage(mount); err == nil {
+				mu.Lock()
+				usages[mount] = usage
+				mu.Unlock()
+			}
+		}(mount)
+	}
+	wg.Wait()
+	return usages
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/disk"
+)
+
+// fakeDiskUsage answers instantly for every mount except slow, which blocks
+// until release is closed
+func fakeDiskUsage(t testing.TB, slow string) (release chan struct{}) {
+	release = make(chan struct{})
+	orig := queryDiskUsage
+	queryDiskUsage = func(path string) (*disk.UsageStat, error) {
+		if path == slow {
+			<-release
+		}
+		return &disk.UsageStat{Path: path, Total: 100, Used: 50}, nil
+	}
+	t.Cleanup(func() {
+		close(release)
+		queryDiskUsage = orig
+		// Let the background waiter clear the stuck mount for the next test
+		for i := 0; i < 100; i++ {
+			if _, stuck := stuckMounts.Load(slow); !stuck {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	return release
+}
+
+func testMounts(n int) []string {
+	mounts := make([]string, n)
+	for i := range mounts {
+		mounts[i] = fmt.Sprintf("/mnt/disk%d", i)
+	}
+	return mounts
+}
+
+func TestDiskUsagesSlowMount(t *testing.T) {
+	mounts := append(testMounts(20), "/mnt/nfs")
+	fakeDiskUsage(t, "/mnt/nfs")
+
+	start := time.Now()
+	usages := diskUsages(mounts)
+	if elapsed := time.Since(start); elapsed > DiskUsageTimeout+time.Second {
+		t.Fatalf("diskUsages took %v with one hanging mount, want about %v", elapsed, DiskUsageTimeout)
+	}
+	if _, ok := usages["/mnt/nfs"]; ok {
+		t.Fatal("hanging mount has a result")
+	}
+	if len(usages) != len(mounts)-1 {
+		t.Fatalf("got %d results, want %d", len(usages), len(mounts)-1)
+	}
+
+	// While the call is still hanging, the mount is skipped without waiting
+	start = time.Now()
+	usages = diskUsages(mounts)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("second collection took %v, want the stuck mount skipped", elapsed)
+	}
+	if len(usages) != len(mounts)-1 {
+		t.Fatalf("second collection got %d results, want %d", len(usages), len(mounts)-1)
+	}
+}
+
+// Slow mounts that still answer within DiskUsageTimeout are queried side by
+// side, so a collection with several of them takes about one slow call, not
+// their sum, and keeps all their results
+func TestDiskUsagesSeveralSlowMounts(t *testing.T) {
+	const slowCount, delay = DiskUsageWorkers - 2, DiskUsageTimeout / 2
+	slow := make(map[string]bool, slowCount)
+	for i := 0; i < slowCount; i++ {
+		slow[fmt.Sprintf("/mnt/slow%d", i)] = true
+	}
+	orig := queryDiskUsage
+	queryDiskUsage = func(path string) (*disk.UsageStat, error) {
+		if slow[path] {
+			time.Sleep(delay)
+		}
+		return &disk.UsageStat{Path: path, Total: 100, Used: 50}, nil
+	}
+	t.Cleanup(func() { queryDiskUsage = orig })
+
+	mounts := testMounts(10)
+	for mount := range slow {
+		mounts = append(mounts, mount)
+	}
+	start := time.Now()
+	usages := diskUsages(mounts)
+	if elapsed := time.Since(start); elapsed >= DiskUsageTimeout {
+		t.Fatalf("diskUsages took %v with %d mounts taking %v each, want about %v", elapsed, slowCount, delay, delay)
+	}
+	if len(usages) != len(mounts) {
+		t.Fatalf("got %d results, want %d", len(usages), len(mounts))
+	}
+}
+
+// BenchmarkDiskUsagesStuckMount measures collections while one mount's
+// statfs call is stuck; after the first timeout it costs nothing
+func BenchmarkDiskUsagesStuckMount(b *testing.B) {
+	mounts := append(testMounts(20), "/mnt/nfs")
+	fakeDiskUsage(b, "/mnt/nfs")
+	diskUsages(mounts) // Times out once and marks the mount stuck
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if got := diskUsages(mounts); len(got) != len(mounts)-1 {
+			b.Fatalf("got %d results, want %d", len(got), len(mounts)-1)
+		}
+	}
+}
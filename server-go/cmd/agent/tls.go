@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// Only warn once per process, the dialer is rebuilt on every reconnect
+var insecureWarning sync.Once
+
+// agentTLSConfig returns the TLS settings for connections to the dashboard, or
+// nil to use the defaults. CACertFile is added to the system roots so the
+// dashboard can use a certificate from an internal CA.
+func agentTLSConfig(cfg *AgentConfig) (*tls.Config, error) {
+	if cfg.CACertFile == "" && !cfg.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CACertFile != "" {
+		pem, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", cfg.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.InsecureSkipVerify {
+		insecureWarning.Do(func() {
+			log.Println("⚠️  WARNING: TLS certificate verification is DISABLED (insecure_skip_verify).")
+			log.Println("⚠️  The dashboard connection and agent token can be intercepted. Use ca_cert_file instead.")
+		})
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
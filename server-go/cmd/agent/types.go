@@ -7,6 +7,7 @@ import (
 // Re-export common types for convenience
 type SystemMetrics = common.SystemMetrics
 type OsInfo = common.OsInfo
+type VirtualizationInfo = common.VirtualizationInfo
 type CpuMetrics = common.CpuMetrics
 type MemoryMetrics = common.MemoryMetrics
 type MemoryModule = common.MemoryModule
@@ -17,9 +18,14 @@ type LoadAverage = common.LoadAverage
 type PingMetrics = common.PingMetrics
 type PingTarget = common.PingTarget
 type PingTargetConfig = common.PingTargetConfig
+type PortInfo = common.PortInfo
 type AuthMessage = common.AuthMessage
 type MetricsMessage = common.MetricsMessage
+type HeartbeatMessage = common.HeartbeatMessage
 type ServerResponse = common.ServerResponse
+type UpdateResultMessage = common.UpdateResultMessage
+type LogsResultMessage = common.LogsResultMessage
+type CommandAckMessage = common.CommandAckMessage
 type RegisterRequest = common.RegisterRequest
 type RegisterResponse = common.RegisterResponse
 
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Package managers can take minutes (dnf refreshes its metadata first)
+const packageCheckTimeout = 5 * time.Minute
+
+// PackageUpdateStatus is the result of one pending-updates check
+type PackageUpdateStatus struct {
+	Pending        int
+	Security       int
+	RebootRequired bool
+}
+
+// packageUpdatesLoop checks for pending package updates at startup and then
+// every interval, caching the result for Collect
+func (mc *MetricsCollector) packageUpdatesLoop(interval time.Duration) {
+	if runtime.GOOS != "linux" {
+		log.Printf("Package update checks are only supported on Linux")
+		return
+	}
+
+	for {
+		status, err := checkPackageUpdates()
+		if err != nil {
+			log.Printf("Package update check failed: %v", err)
+		} else {
+			mc.packageUpdatesMu.Lock()
+			mc.packageUpdates = status
+			mc.packageUpdatesMu.Unlock()
+		}
+		time.Sleep(interval)
+	}
+}
+
+// checkPackageUpdates counts pending updates with whichever package manager
+// is installed and checks whether a reboot is required
+func checkPackageUpdates() (*PackageUpdateStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), packageCheckTimeout)
+	defer cancel()
+
+	var status *PackageUpdateStatus
+	var err error
+	switch {
+	case commandExists("apt-get"):
+		status, err = checkAptUpdates(ctx)
+	case commandExists("dnf"):
+		status, err = checkDnfUpdates(ctx, "dnf")
+	case commandExists("yum"):
+		status, err = checkDnfUpdates(ctx, "yum")
+	default:
+		return nil, errors.New("no supported package manager (apt-get, dnf, yum) found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status.RebootRequired = rebootRequired(ctx)
+	return status, nil
+}
+
+func commandExists(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+var (
+	// "12 updates can be applied immediately." / "12 packages can be updated."
+	notifierPendingRe = regexp.MustCompile(`(\d+) (?:updates?|packages?) can be (?:applied|updated)`)
+	// "5 of these updates are standard security updates." / "5 updates are security updates."
+	notifierSecurityRe = regexp.MustCompile(`(\d+) (?:of these )?updates? (?:are|is an?) (?:standard )?security updates?`)
+)
+
+// checkAptUpdates prefers update-notifier's cached summary (Ubuntu) and falls
+// back to simulating an upgrade
+func checkAptUpdates(ctx context.Context) (*PackageUpdateStatus, error) {
+	if data, err := os.ReadFile("/var/lib/update-notifier/updates-available"); err == nil {
+		if m := notifierPendingRe.FindSubmatch(data); m != nil {
+			status := &PackageUpdateStatus{}
+			status.Pending, _ = strconv.Atoi(string(m[1]))
+			if m := notifierSecurityRe.FindSubmatch(data); m != nil {
+				status.Security, _ = strconv.Atoi(string(m[1]))
+			}
+			return status, nil
+		}
+		// An empty file means nothing is pending, but be sure via apt-get
+	}
+
+	out, err := exec.CommandContext(ctx, "apt-get", "-s", "-o", "Debug::NoLocking=true", "upgrade").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	// Simulated installs look like:
+	// Inst openssl [3.0.2-0ubuntu1.10] (3.0.2-0ubuntu1.12 Ubuntu:22.04/jammy-security [amd64])
+	status := &PackageUpdateStatus{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Inst ") {
+			continue
+		}
+		status.Pending++
+		if strings.Contains(line, "-security") {
+			status.Security++
+		}
+	}
+	return status, nil
+}
+
+// checkDnfUpdates uses check-update (exit code 100 means updates are
+// available) and updateinfo for the security subset
+func checkDnfUpdates(ctx context.Context, tool string) (*PackageUpdateStatus, error) {
+	out, err := exec.CommandContext(ctx, tool, "-q", "check-update").Output()
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 100) {
+		return nil, err
+	}
+
+	status := &PackageUpdateStatus{}
+	status.Pending = countPackageLines(out)
+
+	if out, err := exec.CommandContext(ctx, tool, "-q", "updateinfo", "list", "--security").Output(); err == nil {
+		status.Security = countPackageLines(out)
+	}
+	return status, nil
+}
+
+// countPackageLines counts the package rows in dnf/yum list output, stopping
+// at the "Obsoleting Packages" section that repeats packages
+func countPackageLines(out []byte) int {
+	count := 0
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Obsoleting") {
+			break
+		}
+		if len(strings.Fields(line)) >= 3 {
+			count++
+		}
+	}
+	return count
+}
+
+// rebootRequired checks the Debian/Ubuntu marker file, then asks
+// needs-restarting on RHEL-family systems (exit code 1 means reboot needed)
+func rebootRequired(ctx context.Context) bool {
+	if _, err := os.Stat("/var/run/reboot-required"); err == nil {
+		return true
+	}
+	if commandExists("needs-restarting") {
+		err := exec.CommandContext(ctx, "needs-restarting", "-r").Run()
+		var exitErr *exec.ExitError
+		return errors.As(err, &exitErr) && exitErr.ExitCode() == 1
+	}
+	return false
+}
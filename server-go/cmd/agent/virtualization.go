@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/shirou/gopsutil/v4/host"
+)
+
+// Virtualization systems reported by gopsutil that are containers, not VMs
+var containerSystems = map[string]bool{
+	"docker":        true,
+	"lxc":           true,
+	"openvz":        true,
+	"rkt":           true,
+	"linux-vserver": true,
+}
+
+// detectVirtualization works out whether the agent runs on bare metal, in a
+// VM or in a container. Container runtimes gopsutil doesn't know about
+// (Kubernetes, containerd, Podman) are picked up from /proc/1/cgroup and the
+// marker files the runtimes leave behind.
+func detectVirtualization() *VirtualizationInfo {
+	info := &VirtualizationInfo{Type: "bare-metal"}
+
+	system, role, err := host.Virtualization()
+	if err == nil && system != "" {
+		info.System = system
+		info.Role = role
+		if role == "guest" {
+			info.Type = "vm"
+			if containerSystems[system] {
+				info.Type = "container"
+			}
+		}
+	}
+
+	if runtime.GOOS == "linux" {
+		if container := detectContainerRuntime(); container != "" {
+			info.Type = "container"
+			info.System = container
+			info.Role = "guest"
+		}
+	}
+
+	return info
+}
+
+// detectContainerRuntime returns the container runtime the agent runs under,
+// or "" if it doesn't look containerized
+func detectContainerRuntime() string {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return "kubernetes"
+	}
+
+	if data, err := os.ReadFile("/proc/1/cgroup"); err == nil {
+		cgroup := string(data)
+		switch {
+		case strings.Contains(cgroup, "kubepods"):
+			return "kubernetes"
+		case strings.Contains(cgroup, "libpod"):
+			return "podman"
+		case strings.Contains(cgroup, "docker"):
+			return "docker"
+		case strings.Contains(cgroup, "containerd"):
+			return "containerd"
+		case strings.Contains(cgroup, "/lxc"):
+			return "lxc"
+		}
+	}
+
+	// cgroup v2 hides the container path (/proc/1/cgroup is just "0::/"),
+	// so fall back to the runtimes' marker files
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		return "podman"
+	}
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "docker"
+	}
+
+	return ""
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,10 +13,14 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"vstats/internal/common"
+
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
@@ -22,28 +30,71 @@ const (
 	MaxReconnectDelay      = 60 * time.Second
 	AuthTimeout            = 10 * time.Second
 	PingInterval           = 30 * time.Second
+	HeartbeatInterval      = 10 * time.Second // Application-level liveness, independent of collection
 	BatchSyncInterval      = 30 * time.Second  // How often to sync offline data
 	AggregationSyncInterval = 60 * time.Second // How often to sync aggregated data
+	CollectNowInterval     = time.Second      // Minimum gap between on-demand collections
 )
 
+// errReconnect makes Run reconnect immediately, without the backoff delay
+var errReconnect = errors.New("reconnect requested")
+
 type WebSocketClient struct {
-	config       *AgentConfig
-	collector    *MetricsCollector
-	store        *LocalStore
-	connected    bool
-	connectedMu  sync.RWMutex
-	lastSentTime time.Time
+	config     *AgentConfig
+	configMu   sync.RWMutex // Guards config against SIGHUP reloads
+	configPath string
+	intervalCh chan struct{} // Signals a reload to the collection loop
+	collectNow chan struct{} // Signals a dashboard's request for an immediate sample
+	collector  *MetricsCollector
+	store      *LocalStore
+	conns      []*dashboardConn              // One per AgentConfig.Endpoints(), primary first
+	latest     atomic.Pointer[SystemMetrics] // Last sample, for the local exporter
+}
+
+// dashboardConn is the connection to one dashboard. Each one reconnects on
+// its own; collected metrics are handed to all that are connected.
+type dashboardConn struct {
+	index     int                // Position in AgentConfig.Endpoints(); 0 is the primary
+	metricsCh chan SystemMetrics // Latest sample to send
+	reloadCh  chan bool          // Signals a config reload; true if the connection must be re-established
+	connected atomic.Bool
+}
+
+func (dc *dashboardConn) primary() bool {
+	return dc.index == 0
 }
 
-func NewWebSocketClient(config *AgentConfig) *WebSocketClient {
+func NewWebSocketClient(config *AgentConfig, configPath string) *WebSocketClient {
 	wsc := &WebSocketClient{
-		config:    config,
-		collector: NewMetricsCollector(),
+		config:     config,
+		configPath: configPath,
+		collector:  NewMetricsCollector(),
+		intervalCh: make(chan struct{}, 1),
+		collectNow: make(chan struct{}, 1),
+	}
+	for i := range config.Endpoints() {
+		wsc.conns = append(wsc.conns, &dashboardConn{
+			index:     i,
+			metricsCh: make(chan SystemMetrics, 1),
+			reloadCh:  make(chan bool, 1),
+		})
+	}
+
+	wsc.collector.SetDiskFilter(newDiskFilter(config))
+	wsc.collector.SetExternalCollectors(config.ExternalCollectors)
+	wsc.collector.SetInterfaceFilter(newInterfaceFilter(config))
+	wsc.collector.SetPingSchedule(config.PingIntervalSecs, config.PingConcurrency)
+
+	if config.CheckUpdates {
+		go wsc.collector.packageUpdatesLoop(time.Duration(config.UpdateCheckHours) * time.Hour)
+	}
+	if config.ReportListeningPorts {
+		go wsc.collector.listeningPortsLoop()
 	}
 
 	// Initialize local storage if enabled
 	if config.EnableOfflineStorage {
-		store, err := NewLocalStore(config.DataDir)
+		store, err := NewLocalStore(config.DataDir, config.MaxOfflineRecords)
 		if err != nil {
 			log.Printf("Warning: Failed to initialize offline storage: %v", err)
 		} else {
@@ -55,38 +106,166 @@ func NewWebSocketClient(config *AgentConfig) *WebSocketClient {
 	return wsc
 }
 
+// encodeMessage serializes an outgoing message with the configured encoding and
+// returns the matching WebSocket frame type
+func (wsc *WebSocketClient) encodeMessage(v interface{}) (int, []byte, error) {
+	if wsc.currentConfig().Encoding == common.EncodingMsgpack {
+		data, err := common.EncodeMessage(common.EncodingMsgpack, v)
+		return websocket.BinaryMessage, data, err
+	}
+	data, err := json.Marshal(v)
+	return websocket.TextMessage, data, err
+}
+
+// currentConfig returns a snapshot of the config, which may be replaced by a reload
+func (wsc *WebSocketClient) currentConfig() AgentConfig {
+	wsc.configMu.RLock()
+	defer wsc.configMu.RUnlock()
+	return *wsc.config
+}
+
+func (wsc *WebSocketClient) interval() time.Duration {
+	return time.Duration(wsc.currentConfig().IntervalSecs) * time.Second
+}
+
+// endpoint returns the current settings of a dashboard connection
+func (wsc *WebSocketClient) endpoint(dc *dashboardConn) DashboardEndpoint {
+	cfg := wsc.currentConfig()
+	return cfg.Endpoints()[dc.index]
+}
+
+// ReloadConfig re-reads the config file and applies it to the running client.
+// Connection settings (dashboard URL, server ID, token, encoding) take effect
+// by reconnecting the affected dashboards, a new interval by resetting the
+// metrics ticker. Adding or removing dashboards needs a restart.
+func (wsc *WebSocketClient) ReloadConfig() {
+	newConfig, err := LoadConfig(wsc.configPath)
+	if err != nil {
+		log.Printf("Config reload failed, keeping current config: %v", err)
+		return
+	}
+
+	wsc.configMu.Lock()
+	old := *wsc.config
+	reconnectAll := newConfig.Encoding != old.Encoding || newConfig.ProxyURL != old.ProxyURL ||
+		newConfig.CACertFile != old.CACertFile || newConfig.InsecureSkipVerify != old.InsecureSkipVerify
+	oldEndpoints, newEndpoints := old.Endpoints(), newConfig.Endpoints()
+	dashboardsChanged := len(newEndpoints) != len(oldEndpoints)
+	wsc.config.DashboardURL = newConfig.DashboardURL
+	wsc.config.ServerID = newConfig.ServerID
+	wsc.config.AgentToken = newConfig.AgentToken
+	if !dashboardsChanged {
+		wsc.config.Dashboards = newConfig.Dashboards
+	}
+	wsc.config.ServerName = newConfig.ServerName
+	wsc.config.Location = newConfig.Location
+	wsc.config.Provider = newConfig.Provider
+	wsc.config.IntervalSecs = newConfig.IntervalSecs
+	wsc.config.Encoding = newConfig.Encoding
+	wsc.config.ProxyURL = newConfig.ProxyURL
+	wsc.config.CACertFile = newConfig.CACertFile
+	wsc.config.InsecureSkipVerify = newConfig.InsecureSkipVerify
+	wsc.config.IncludeMounts = newConfig.IncludeMounts
+	wsc.config.ExcludeMounts = newConfig.ExcludeMounts
+	wsc.config.IncludeFSTypes = newConfig.IncludeFSTypes
+	wsc.config.ExcludeFSTypes = newConfig.ExcludeFSTypes
+	wsc.config.ExternalCollectors = newConfig.ExternalCollectors
+	wsc.config.IncludeInterfaces = newConfig.IncludeInterfaces
+	wsc.config.ExcludeInterfaces = newConfig.ExcludeInterfaces
+	wsc.config.LogUnits = newConfig.LogUnits
+	wsc.config.PingIntervalSecs = newConfig.PingIntervalSecs
+	wsc.config.PingConcurrency = newConfig.PingConcurrency
+	wsc.configMu.Unlock()
+	wsc.collector.SetDiskFilter(newDiskFilter(newConfig))
+	wsc.collector.SetExternalCollectors(newConfig.ExternalCollectors)
+	wsc.collector.SetPingSchedule(newConfig.PingIntervalSecs, newConfig.PingConcurrency)
+	if !slices.Equal(newConfig.IncludeInterfaces, old.IncludeInterfaces) || !slices.Equal(newConfig.ExcludeInterfaces, old.ExcludeInterfaces) {
+		wsc.collector.SetInterfaceFilter(newInterfaceFilter(newConfig))
+	}
+
+	if newConfig.EnableOfflineStorage != old.EnableOfflineStorage || newConfig.DataDir != old.DataDir ||
+		newConfig.CheckUpdates != old.CheckUpdates || newConfig.UpdateCheckHours != old.UpdateCheckHours ||
+		newConfig.ReportListeningPorts != old.ReportListeningPorts || newConfig.LocalMetricsAddr != old.LocalMetricsAddr {
+		log.Println("Offline storage, update check, listening port and local metrics settings take effect after a restart")
+	}
+	if dashboardsChanged {
+		log.Println("Adding or removing dashboards takes effect after a restart")
+	}
+	log.Printf("Config reloaded (dashboard: %s, interval: %ds)", newConfig.DashboardURL, newConfig.IntervalSecs)
+
+	select {
+	case wsc.intervalCh <- struct{}{}:
+	default:
+	}
+	for _, dc := range wsc.conns {
+		reconnect := reconnectAll || (!dashboardsChanged && newEndpoints[dc.index] != oldEndpoints[dc.index])
+		// Merge with a reload that hasn't been picked up yet so a pending
+		// reconnect isn't lost
+		select {
+		case pending := <-dc.reloadCh:
+			reconnect = reconnect || pending
+		default:
+		}
+		dc.reloadCh <- reconnect
+	}
+}
+
+// isConnected reports whether the primary dashboard is connected; while it
+// isn't, samples go to the offline store
 func (wsc *WebSocketClient) isConnected() bool {
-	wsc.connectedMu.RLock()
-	defer wsc.connectedMu.RUnlock()
-	return wsc.connected
+	return wsc.conns[0].connected.Load()
 }
 
-func (wsc *WebSocketClient) setConnected(connected bool) {
-	wsc.connectedMu.Lock()
-	defer wsc.connectedMu.Unlock()
-	wsc.connected = connected
+func (wsc *WebSocketClient) anyConnected() bool {
+	for _, dc := range wsc.conns {
+		if dc.connected.Load() {
+			return true
+		}
+	}
+	return false
 }
 
 func (wsc *WebSocketClient) Run() {
-	reconnectDelay := InitialReconnectDelay
+	go wsc.collectLoop()
+	if addr := wsc.config.LocalMetricsAddr; addr != "" {
+		go wsc.serveLocalMetrics(addr)
+	}
+
+	// Tell systemd (Type=notify) that startup is done; connecting may take a
+	// while if the dashboard is down
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+	go wsc.watchdogLoop()
+
+	for _, dc := range wsc.conns[1:] {
+		go wsc.runDashboard(dc)
+	}
+	wsc.runDashboard(wsc.conns[0])
+}
 
-	// Start offline metrics collection goroutine
-	offlineMetricsCh := make(chan *SystemMetrics, 100)
-	go wsc.offlineCollector(offlineMetricsCh)
+// runDashboard keeps one dashboard connected, with its own reconnect backoff
+func (wsc *WebSocketClient) runDashboard(dc *dashboardConn) {
+	reconnectDelay := InitialReconnectDelay
 
 	for {
-		log.Printf("Connecting to %s...", wsc.config.WSUrl())
+		cfg := wsc.currentConfig()
+		wsURL := cfg.WSUrlFor(wsc.endpoint(dc).DashboardURL)
+		log.Printf("Connecting to %s...", wsURL)
 
-		if err := wsc.connectAndRun(offlineMetricsCh); err != nil {
-			log.Printf("Connection error: %v", err)
-			wsc.setConnected(false)
+		err := wsc.connectAndRun(dc)
+		if errors.Is(err, errReconnect) {
+			reconnectDelay = InitialReconnectDelay
+			continue
+		}
+		if err != nil {
+			log.Printf("Connection error (%s): %v", wsURL, err)
 		} else {
-			log.Println("Connection closed normally")
-			wsc.setConnected(false)
+			log.Printf("Connection to %s closed normally", wsURL)
 			reconnectDelay = InitialReconnectDelay
 		}
 
-		log.Printf("Reconnecting in %v...", reconnectDelay)
+		log.Printf("Reconnecting to %s in %v...", wsURL, reconnectDelay)
 		time.Sleep(reconnectDelay)
 
 		// Exponential backoff
@@ -97,52 +276,136 @@ func (wsc *WebSocketClient) Run() {
 	}
 }
 
-// offlineCollector collects metrics and stores them locally when disconnected
-func (wsc *WebSocketClient) offlineCollector(metricsCh chan<- *SystemMetrics) {
-	ticker := time.NewTicker(time.Duration(wsc.config.IntervalSecs) * time.Second)
+// collectLoop collects metrics every interval and hands each sample to all
+// connected dashboards. Collection runs off the loop so a hung collector
+// (e.g. ping or dmidecode) only skips intervals. While the primary dashboard
+// is disconnected, samples are kept in the offline store for replay.
+func (wsc *WebSocketClient) collectLoop() {
+	interval := wsc.interval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		if !wsc.isConnected() && wsc.store != nil {
-			// Collect metrics while offline and store with aggregation
-			metrics := wsc.collector.Collect()
-			if err := wsc.store.StoreWithAggregation(&metrics); err != nil {
-				log.Printf("Failed to store offline metrics: %v", err)
-			} else {
-				pending := wsc.store.GetPendingCount()
-				if pending%10 == 0 { // Log every 10 metrics
-					log.Printf("Stored offline metrics (pending: %d)", pending)
+	collectedCh := make(chan SystemMetrics, 1)
+	collecting := false
+	var lastCollect time.Time
+
+	collect := func() {
+		collecting = true
+		lastCollect = time.Now()
+		go func() {
+			collectedCh <- wsc.collector.Collect()
+		}()
+	}
+
+	for {
+		select {
+		case <-wsc.intervalCh:
+			if current := wsc.interval(); current != interval {
+				interval = current
+				ticker.Reset(interval)
+			}
+
+		case <-ticker.C:
+			if wsc.store == nil && !wsc.anyConnected() && wsc.config.LocalMetricsAddr == "" {
+				continue // Nowhere to put the sample
+			}
+			if collecting {
+				log.Println("Previous metrics collection still running, skipping this interval")
+				continue
+			}
+			collect()
+
+		case <-wsc.collectNow:
+			// A sample already on its way or just taken answers the request
+			if collecting || time.Since(lastCollect) < CollectNowInterval || !wsc.anyConnected() {
+				continue
+			}
+			collect()
+
+		case metrics := <-collectedCh:
+			collecting = false
+			wsc.latest.Store(&metrics)
+			wsc.storeMetrics(&metrics)
+
+			for _, dc := range wsc.conns {
+				if !dc.connected.Load() {
+					continue
+				}
+				// Replace a sample a slow connection hasn't sent yet
+				select {
+				case <-dc.metricsCh:
+				default:
+				}
+				select {
+				case dc.metricsCh <- metrics:
+				default:
 				}
 			}
 		}
 	}
 }
 
-func (wsc *WebSocketClient) connectAndRun(offlineMetricsCh chan<- *SystemMetrics) error {
-	wsURL := wsc.config.WSUrl()
+// storeMetrics updates the local aggregation buckets and, while the primary
+// dashboard is disconnected, keeps the raw sample for replay on reconnect
+func (wsc *WebSocketClient) storeMetrics(metrics *SystemMetrics) {
+	if wsc.store == nil {
+		return
+	}
+	if err := wsc.store.StoreWithAggregation(metrics); err != nil {
+		log.Printf("Failed to aggregate metrics: %v", err)
+	}
+	if wsc.isConnected() {
+		return
+	}
+	if err := wsc.store.Store(metrics); err != nil {
+		log.Printf("Failed to store offline metrics: %v", err)
+	} else {
+		pending := wsc.store.GetPendingCount()
+		if pending%10 == 0 { // Log every 10 metrics
+			log.Printf("Stored offline metrics (pending: %d)", pending)
+		}
+	}
+}
+
+func (wsc *WebSocketClient) connectAndRun(dc *dashboardConn) error {
+	// Any pending reload is already reflected in the config used below
+	select {
+	case <-dc.reloadCh:
+	default:
+	}
+
+	cfg := wsc.currentConfig()
+	endpoint := cfg.Endpoints()[dc.index]
+	wsURL := cfg.WSUrlFor(endpoint.DashboardURL)
 
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	dialer, err := newWebSocketDialer(&cfg, wsURL)
+	if err != nil {
+		return err
+	}
+
+	conn, _, err := dialer.Dial(wsURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 	defer conn.Close()
 
-	log.Println("Connected to WebSocket server")
+	log.Printf("Connected to WebSocket server %s", endpoint.DashboardURL)
 
 	// Send authentication message
 	authMsg := AuthMessage{
-		Type:     "auth",
-		ServerID: wsc.config.ServerID,
-		Token:    wsc.config.AgentToken,
-		Version:  AgentVersion,
+		Type:         "auth",
+		ServerID:     endpoint.ServerID,
+		Token:        endpoint.AgentToken,
+		Version:      AgentVersion,
+		IntervalSecs: cfg.IntervalSecs,
 	}
 
-	authData, err := json.Marshal(authMsg)
+	msgType, authData, err := wsc.encodeMessage(authMsg)
 	if err != nil {
 		return fmt.Errorf("failed to serialize auth message: %w", err)
 	}
 
-	if err := conn.WriteMessage(websocket.TextMessage, authData); err != nil {
+	if err := conn.WriteMessage(msgType, authData); err != nil {
 		return fmt.Errorf("failed to send auth message: %w", err)
 	}
 
@@ -164,8 +427,9 @@ func (wsc *WebSocketClient) connectAndRun(offlineMetricsCh chan<- *SystemMetrics
 		return fmt.Errorf("authentication failed: %s", response.Message)
 	}
 
-	// Update ping targets from server config if provided
-	if len(response.PingTargets) > 0 {
+	// Update ping targets from server config if provided; with several
+	// dashboards the primary one decides
+	if len(response.PingTargets) > 0 && dc.primary() {
 		log.Printf("Received %d ping targets from server", len(response.PingTargets))
 		wsc.collector.SetPingTargets(response.PingTargets)
 	}
@@ -188,28 +452,53 @@ func (wsc *WebSocketClient) connectAndRun(offlineMetricsCh chan<- *SystemMetrics
 	conn.SetReadDeadline(time.Time{})
 
 	// Mark as connected
-	wsc.setConnected(true)
-
-	// Sync missing data since last server checkpoint
-	go wsc.syncMissingData(conn, lastBuckets)
-	
-	// Sync offline data if any
-	go wsc.syncOfflineData(conn)
-
-	// Start metrics sending loop
-	metricsTicker := time.NewTicker(time.Duration(wsc.config.IntervalSecs) * time.Second)
-	defer metricsTicker.Stop()
+	dc.connected.Store(true)
+	defer dc.connected.Store(false)
+
+	// Offline data and local aggregates are synced to the primary dashboard
+	// only, since replayed samples are deleted once sent
+	var aggSyncC <-chan time.Time
+	if dc.primary() {
+		// Sync missing data since last server checkpoint
+		go wsc.syncMissingData(conn, lastBuckets)
+
+		// Sync offline data if any
+		go wsc.syncOfflineData(conn)
+
+		// Aggregation sync ticker (send aggregated data periodically)
+		aggSyncTicker := time.NewTicker(AggregationSyncInterval)
+		defer aggSyncTicker.Stop()
+		aggSyncC = aggSyncTicker.C
+	}
 
 	pingTicker := time.NewTicker(PingInterval)
 	defer pingTicker.Stop()
 
-	// Aggregation sync ticker (send aggregated data periodically)
-	aggSyncTicker := time.NewTicker(AggregationSyncInterval)
-	defer aggSyncTicker.Stop()
+	heartbeatTicker := time.NewTicker(HeartbeatInterval)
+	defer heartbeatTicker.Stop()
 
 	// Handle incoming messages
 	done := make(chan error, 1)
 	batchAckCh := make(chan *ServerResponse, 10)
+	// Update results are written by the main loop, which owns the connection
+	updateResultCh := make(chan updateOutcome, 1)
+	logsResultCh := make(chan *LogsResultMessage, 4)
+	ackCh := make(chan *CommandAckMessage, 4)
+	// ack acknowledges a command the server attached a request ID to
+	ack := func(response ServerResponse, err error) {
+		if response.RequestID == "" {
+			return
+		}
+		msg := &CommandAckMessage{Type: "command_ack", RequestID: response.RequestID, Command: response.Command, Success: err == nil}
+		if err != nil {
+			msg.Error = err.Error()
+		}
+		select {
+		case ackCh <- msg:
+		default:
+			log.Printf("Dropping acknowledgement of %s, send queue full", response.Command)
+		}
+	}
 
 	go func() {
 		for {
@@ -240,10 +529,40 @@ func (wsc *WebSocketClient) connectAndRun(offlineMetricsCh chan<- *SystemMetrics
 					} else {
 						log.Println("Received update command from server")
 					}
-					wsc.handleUpdateCommand(response.DownloadURL, response.Force)
+					result, restart := wsc.handleUpdateCommand(response.DownloadURL, response.SHA256, response.Force)
+					result.RequestID = response.RequestID
+					select {
+					case updateResultCh <- updateOutcome{result: result, restart: restart}:
+					default:
+						// A previous result is still queued; don't block the
+						// read loop, but never skip a pending restart
+						if restart {
+							restartAgent()
+						}
+					}
+				} else if response.Command == "collect_now" {
+					select {
+					case wsc.collectNow <- struct{}{}:
+					default:
+						// A collection is already pending
+					}
+					ack(response, nil)
+				} else if response.Command == "rotate_token" {
+					ack(response, wsc.handleRotateToken(dc, response.Token))
+				} else if response.Command == "tail_logs" {
+					go func(response ServerResponse) {
+						select {
+						case logsResultCh <- wsc.handleTailLogs(response.RequestID, response.Unit, response.Lines):
+						case <-time.After(CommandTimeout):
+							log.Printf("Dropping logs result for %s, send queue full", response.Unit)
+						}
+					}(response)
 				}
 			case "config":
 				// Handle runtime config update (e.g., ping targets)
+				if !dc.primary() {
+					continue
+				}
 				if len(response.PingTargets) > 0 {
 					log.Printf("Received updated ping targets from server: %d targets", len(response.PingTargets))
 					wsc.collector.SetPingTargets(response.PingTargets)
@@ -257,31 +576,29 @@ func (wsc *WebSocketClient) connectAndRun(offlineMetricsCh chan<- *SystemMetrics
 
 	for {
 		select {
-		case <-metricsTicker.C:
-			metrics := wsc.collector.Collect()
-			
-			// Store metrics with aggregation locally
-			if wsc.store != nil {
-				wsc.store.StoreWithAggregation(&metrics)
-			}
-			
+		case metrics := <-dc.metricsCh:
 			msg := MetricsMessage{
 				Type:    "metrics",
 				Metrics: metrics,
 			}
 
-			data, err := json.Marshal(msg)
+			msgType, data, err := wsc.encodeMessage(msg)
 			if err != nil {
 				log.Printf("Failed to serialize metrics: %v", err)
 				continue
 			}
 
-			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			if err := conn.WriteMessage(msgType, data); err != nil {
 				return fmt.Errorf("failed to send metrics: %w", err)
 			}
-			wsc.lastSentTime = time.Now()
 
-		case <-aggSyncTicker.C:
+		case reconnect := <-dc.reloadCh:
+			if reconnect {
+				log.Printf("Connection settings of %s changed, reconnecting", endpoint.DashboardURL)
+				return errReconnect
+			}
+
+		case <-aggSyncC:
 			// Periodically send aggregated data to server
 			wsc.sendAggregatedData(conn)
 
@@ -290,6 +607,39 @@ func (wsc *WebSocketClient) connectAndRun(offlineMetricsCh chan<- *SystemMetrics
 				return fmt.Errorf("failed to send ping: %w", err)
 			}
 
+		case <-heartbeatTicker.C:
+			msgType, data, err := wsc.encodeMessage(HeartbeatMessage{Type: "ping"})
+			if err != nil {
+				continue
+			}
+			if err := conn.WriteMessage(msgType, data); err != nil {
+				return fmt.Errorf("failed to send heartbeat: %w", err)
+			}
+
+		case outcome := <-updateResultCh:
+			if msgType, data, err := wsc.encodeMessage(outcome.result); err == nil {
+				if err := conn.WriteMessage(msgType, data); err != nil {
+					log.Printf("Failed to report update result: %v", err)
+				}
+			}
+			if outcome.restart {
+				restartAgent()
+			}
+
+		case result := <-logsResultCh:
+			if msgType, data, err := wsc.encodeMessage(result); err == nil {
+				if err := conn.WriteMessage(msgType, data); err != nil {
+					return fmt.Errorf("failed to send logs: %w", err)
+				}
+			}
+
+		case msg := <-ackCh:
+			if msgType, data, err := wsc.encodeMessage(msg); err == nil {
+				if err := conn.WriteMessage(msgType, data); err != nil {
+					return fmt.Errorf("failed to send acknowledgement: %w", err)
+				}
+			}
+
 		case err := <-done:
 			return err
 		}
@@ -312,13 +662,13 @@ func (wsc *WebSocketClient) sendAggregatedData(conn *websocket.Conn) {
 		return
 	}
 
-	data, err := json.Marshal(aggData)
+	msgType, data, err := wsc.encodeMessage(aggData)
 	if err != nil {
 		log.Printf("Failed to serialize aggregated data: %v", err)
 		return
 	}
 
-	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+	if err := conn.WriteMessage(msgType, data); err != nil {
 		log.Printf("Failed to send aggregated data: %v", err)
 	}
 }
@@ -363,13 +713,13 @@ func (wsc *WebSocketClient) syncMissingData(conn *websocket.Conn, lastBuckets ma
 	
 	log.Printf("Syncing %d missing buckets across %d granularities...", totalBuckets, len(result.Granularities))
 	
-	data, err := json.Marshal(result)
+	msgType, data, err := wsc.encodeMessage(result)
 	if err != nil {
 		log.Printf("Failed to serialize missing data: %v", err)
 		return
 	}
 	
-	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+	if err := conn.WriteMessage(msgType, data); err != nil {
 		log.Printf("Failed to send missing data: %v", err)
 		return
 	}
@@ -460,13 +810,13 @@ func (wsc *WebSocketClient) syncOfflineData(conn *websocket.Conn) {
 		}
 
 		// Send batch
-		data, err := json.Marshal(batch)
+		msgType, data, err := wsc.encodeMessage(batch)
 		if err != nil {
 			log.Printf("Failed to serialize batch: %v", err)
 			break
 		}
 
-		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		if err := conn.WriteMessage(msgType, data); err != nil {
 			log.Printf("Failed to send batch: %v", err)
 			break
 		}
@@ -493,7 +843,52 @@ func (wsc *WebSocketClient) syncOfflineData(conn *websocket.Conn) {
 	}
 }
 
-func (wsc *WebSocketClient) handleUpdateCommand(downloadURL string, force bool) {
+// handleRotateToken switches a dashboard to a new agent token and persists it
+func (wsc *WebSocketClient) handleRotateToken(dc *dashboardConn, token string) error {
+	wsc.configMu.Lock()
+	current := &wsc.config.AgentToken
+	if !dc.primary() {
+		// Copy, since config snapshots share the slice
+		wsc.config.Dashboards = slices.Clone(wsc.config.Dashboards)
+		current = &wsc.config.Dashboards[dc.index-1].AgentToken
+	}
+	if token == "" {
+		wsc.configMu.Unlock()
+		return fmt.Errorf("empty token")
+	}
+	if token == *current {
+		wsc.configMu.Unlock()
+		return nil
+	}
+	*current = token
+	cfg := *wsc.config
+	wsc.configMu.Unlock()
+
+	if err := SaveConfig(&cfg, wsc.configPath); err != nil {
+		log.Printf("Failed to save rotated token: %v", err)
+		return fmt.Errorf("failed to save rotated token: %w", err)
+	}
+	log.Println("Agent token rotated and saved to config")
+	return nil
+}
+
+// updateOutcome carries an update result from the read loop to the write loop
+type updateOutcome struct {
+	result  *UpdateResultMessage
+	restart bool
+}
+
+// handleUpdateCommand downloads a new agent binary, verifies it (checksum when
+// the server sent one, and that it runs) and swaps it in, keeping the old
+// binary as .bak. It returns the result to report to the server and whether
+// the agent must restart to run the new binary.
+func (wsc *WebSocketClient) handleUpdateCommand(downloadURL, expectedSHA256 string, force bool) (*UpdateResultMessage, bool) {
+	fail := func(format string, args ...interface{}) (*UpdateResultMessage, bool) {
+		msg := fmt.Sprintf(format, args...)
+		log.Print(msg)
+		return &UpdateResultMessage{Type: "update_result", Success: false, Version: AgentVersion, Error: msg}, false
+	}
+
 	if force {
 		log.Println("Starting FORCE self-update process (will update regardless of version)...")
 	} else {
@@ -503,8 +898,7 @@ func (wsc *WebSocketClient) handleUpdateCommand(downloadURL string, force bool)
 	// Get the current executable path
 	currentExe, err := os.Executable()
 	if err != nil {
-		log.Printf("Failed to get current executable path: %v", err)
-		return
+		return fail("Failed to get current executable path: %v", err)
 	}
 
 	// Determine download URL and check version
@@ -532,7 +926,11 @@ func (wsc *WebSocketClient) handleUpdateCommand(downloadURL string, force bool)
 		
 		// Try to get latest version from GitHub API
 		latestVersion = "latest"
-		if latest, err := fetchLatestGitHubVersion("zsai001", "vstats"); err == nil && latest != nil {
+		client, err := wsc.httpClient(10 * time.Second)
+		if err != nil {
+			return fail("Failed to set up HTTP client: %v", err)
+		}
+		if latest, err := fetchLatestGitHubVersion(client, "zsai001", "vstats"); err == nil && latest != nil {
 			latestVersion = *latest
 			
 			// Skip update if already on latest version (unless force is true)
@@ -541,7 +939,7 @@ func (wsc *WebSocketClient) handleUpdateCommand(downloadURL string, force bool)
 			currentVersionClean := strings.TrimPrefix(AgentVersion, "v")
 			if !force && latestVersionClean == currentVersionClean {
 				log.Printf("Already on latest version %s, skipping update", AgentVersion)
-				return
+				return &UpdateResultMessage{Type: "update_result", Success: true, Version: AgentVersion}, false
 			}
 			log.Printf("Update available: current=%s, latest=%s", AgentVersion, latestVersion)
 		}
@@ -559,12 +957,28 @@ func (wsc *WebSocketClient) handleUpdateCommand(downloadURL string, force bool)
 
 	log.Printf("Downloading update from: %s", url)
 
-	// Download to a temporary file
+	// Download to a temporary file, through the configured proxy and CA
 	tempPath := currentExe + ".new"
 
-	if err := downloadFile(url, tempPath); err != nil {
-		log.Printf("Failed to download update: %v", err)
-		return
+	client, err := wsc.httpClient(UpdateDownloadTimeout)
+	if err != nil {
+		return fail("Failed to set up HTTP client: %v", err)
+	}
+	if err := downloadFile(client, url, tempPath); err != nil {
+		return fail("Failed to download update: %v", err)
+	}
+
+	if expectedSHA256 != "" {
+		actual, err := fileSHA256(tempPath)
+		if err != nil {
+			os.Remove(tempPath)
+			return fail("Failed to hash update: %v", err)
+		}
+		if !strings.EqualFold(actual, expectedSHA256) {
+			os.Remove(tempPath)
+			return fail("Update checksum mismatch (expected %s, got %s), aborting", expectedSHA256, actual)
+		}
+		log.Println("Update checksum verified")
 	}
 
 	log.Println("Download complete, applying update...")
@@ -572,33 +986,39 @@ func (wsc *WebSocketClient) handleUpdateCommand(downloadURL string, force bool)
 	// On Unix, set execute permissions
 	if runtime.GOOS != "windows" {
 		if err := os.Chmod(tempPath, 0755); err != nil {
-			log.Printf("Failed to set permissions: %v", err)
 			os.Remove(tempPath)
-			return
+			return fail("Failed to set permissions: %v", err)
 		}
 	}
 
-	// Backup current executable
-	backupPath := currentExe + ".backup"
+	// Make sure the new binary actually runs before replacing the current one
+	newVersion, err := verifyAgentBinary(tempPath)
+	if err != nil {
+		os.Remove(tempPath)
+		return fail("New binary failed verification, aborting update: %v", err)
+	}
+
+	// Keep the current executable as .bak for manual rollback
+	backupPath := currentExe + ".bak"
+	os.Remove(backupPath)
 	if err := os.Rename(currentExe, backupPath); err != nil {
-		log.Printf("Failed to backup current executable: %v", err)
 		os.Remove(tempPath)
-		return
+		return fail("Failed to backup current executable: %v", err)
 	}
 
 	// Move new executable to current path
 	if err := os.Rename(tempPath, currentExe); err != nil {
-		log.Printf("Failed to install new executable: %v", err)
 		// Try to restore backup
 		os.Rename(backupPath, currentExe)
-		return
+		return fail("Failed to install new executable: %v", err)
 	}
 
-	// Remove backup
-	os.Remove(backupPath)
-
-	log.Println("Update installed successfully! Restarting...")
+	log.Printf("Update installed successfully (previous binary kept at %s)! Restarting...", backupPath)
+	return &UpdateResultMessage{Type: "update_result", Success: true, Version: newVersion}, true
+}
 
+// restartAgent restarts the agent service so the newly installed binary runs
+func restartAgent() {
 	// Restart the agent using systemd-run to avoid being killed by cgroup
 	if runtime.GOOS == "linux" {
 		// Use systemd-run --no-block to run restart in an independent transient unit
@@ -624,9 +1044,25 @@ func (wsc *WebSocketClient) handleUpdateCommand(downloadURL string, force bool)
 	os.Exit(0)
 }
 
+// UpdateDownloadTimeout bounds the download of a new agent binary
+const UpdateDownloadTimeout = 10 * time.Minute
+
+// httpClient returns a client with the agent's proxy and CA settings, for
+// requests made outside the dashboard connection
+func (wsc *WebSocketClient) httpClient(timeout time.Duration) (*http.Client, error) {
+	wsc.configMu.RLock()
+	defer wsc.configMu.RUnlock()
+	client, err := newHTTPClient(wsc.config)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = timeout
+	return client, nil
+}
+
 // downloadFile downloads a file from URL to path
-func downloadFile(url, path string) error {
-	resp, err := http.Get(url)
+func downloadFile(client *http.Client, url, path string) error {
+	resp, err := client.Get(url)
 	if err != nil {
 		return fmt.Errorf("HTTP request failed: %w", err)
 	}
@@ -651,11 +1087,44 @@ func downloadFile(url, path string) error {
 	return nil
 }
 
+// fileSHA256 returns the hex SHA-256 digest of a file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyAgentBinary runs "<path> --version", checks that it identifies itself
+// as the agent (catching truncated or wrong-platform downloads) and returns
+// the version it reports
+func verifyAgentBinary(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("--version failed: %w", err)
+	}
+	output := strings.TrimSpace(string(out))
+	version, ok := strings.CutPrefix(output, "vstats-agent version ")
+	if !ok {
+		return "", fmt.Errorf("unexpected --version output: %q", output)
+	}
+	return version, nil
+}
+
 // fetchLatestGitHubVersion fetches the latest release version from GitHub
-func fetchLatestGitHubVersion(owner, repo string) (*string, error) {
+func fetchLatestGitHubVersion(client *http.Client, owner, repo string) (*string, error) {
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/latest", owner, repo)
 
-	client := &http.Client{Timeout: 10 * time.Second}
 	req, _ := http.NewRequest("GET", url, nil)
 	req.Header.Set("User-Agent", "vstats-agent")
 
@@ -124,6 +124,7 @@ func main() {
 		auth.DELETE("/servers/:id", handlers.DeleteServer)
 		auth.POST("/servers/:id/regenerate-key", handlers.RegenerateAgentKey)
 		auth.GET("/servers/:id/install-command", handlers.GetInstallCommand)
+		auth.GET("/servers/:id/connections", handlers.GetServerConnections)
 
 		// Metrics
 		auth.GET("/servers/:id/metrics", handlers.GetServerMetrics)
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// Agent Requests
+// ============================================================================
+
+// Commands carry a request_id that the agent copies into its reply
+// ("logs_result", "update_result" or "command_ack"), so a reply can be
+// matched to the command that caused it. Agents older than this server
+// ignore the ID and send no acknowledgement.
+
+var (
+	errAgentQueueFull    = errors.New("agent send queue is full")
+	errAgentTimeout      = errors.New("agent did not answer in time")
+	errAgentDisconnected = errors.New("agent disconnected before answering")
+)
+
+// pendingAgentRequest waits for the reply to one command
+type pendingAgentRequest struct {
+	serverID string
+	reply    chan *AgentMessage
+}
+
+// pendingAgentRequests maps request IDs to the callers waiting for them
+var pendingAgentRequests sync.Map
+
+// sendAgentCommand queues a command without waiting for a reply. It is
+// given a request ID so the agent's acknowledgement can be logged.
+func sendAgentCommand(conn *AgentConnection, cmd AgentCommand) error {
+	if cmd.RequestID == "" {
+		cmd.RequestID = uuid.New().String()
+	}
+	data, _ := json.Marshal(cmd)
+	select {
+	case conn.SendChan <- data:
+		return nil
+	default:
+		return errAgentQueueFull
+	}
+}
+
+// requestAgent sends a command and waits up to timeout for the agent's
+// reply. It fails early if the agent disconnects or ctx is done.
+func requestAgent(ctx context.Context, serverID string, conn *AgentConnection, cmd AgentCommand, timeout time.Duration) (*AgentMessage, error) {
+	cmd.RequestID = uuid.New().String()
+	pending := &pendingAgentRequest{serverID: serverID, reply: make(chan *AgentMessage, 1)}
+	pendingAgentRequests.Store(cmd.RequestID, pending)
+	defer pendingAgentRequests.Delete(cmd.RequestID)
+
+	if err := sendAgentCommand(conn, cmd); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case msg := <-pending.reply:
+		return msg, nil
+	case <-timer.C:
+		return nil, errAgentTimeout
+	case <-conn.Done:
+		return nil, errAgentDisconnected
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// deliverAgentReply hands a reply to the request waiting for it and reports
+// whether there was one. Replies sent by a different server than the one
+// asked are dropped.
+func deliverAgentReply(serverID string, msg *AgentMessage) bool {
+	if msg.RequestID == "" {
+		return false
+	}
+	v, ok := pendingAgentRequests.Load(msg.RequestID)
+	if !ok {
+		return false
+	}
+	pending := v.(*pendingAgentRequest)
+	if pending.serverID != serverID {
+		return false
+	}
+	select {
+	case pending.reply <- msg:
+	default:
+	}
+	return true
+}
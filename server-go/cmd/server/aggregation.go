@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// Server-side Aggregation
+// ============================================================================
+
+// Agents with offline storage send their own rollups (the *_agg tables).
+// For the others the server rolls metrics_raw up into metrics_15min, then
+// metrics_hourly and metrics_daily, which back the 7d/30d/1y ranges once
+// the raw data is gone.
+
+// aggregationDelay lets samples of a just-finished window arrive before it
+// is rolled up
+const aggregationDelay = time.Minute
+
+// aggregationRuns holds the outcome of the last run per rollup table
+var (
+	aggregationRuns   = make(map[string]AggregationRun)
+	aggregationRunsMu sync.Mutex
+)
+
+func recordAggregationRun(table string, started time.Time, err error) {
+	run := AggregationRun{
+		LastRun:    started.UTC().Format(time.RFC3339),
+		DurationMs: time.Since(started).Milliseconds(),
+	}
+	if err != nil {
+		run.LastError = err.Error()
+		slog.Error("Aggregation failed", "table", table, "error", err)
+	}
+	aggregationRunsMu.Lock()
+	aggregationRuns[table] = run
+	aggregationRunsMu.Unlock()
+}
+
+// aggregationLoop runs shortly after every 15-minute boundary. Each run
+// rolls up the finished 15-minute window; the first run of an hour also
+// rolls up the previous hour, and the first run of a day the previous day.
+// It starts by catching up on the raw data already in the database.
+func aggregationLoop(db *sql.DB) {
+	catchUpAggregation(db)
+
+	for {
+		now := time.Now().UTC()
+		next := now.Truncate(15 * time.Minute).Add(15 * time.Minute).Add(aggregationDelay)
+		if next.Sub(now) > 15*time.Minute {
+			next = next.Add(-15 * time.Minute)
+		}
+		time.Sleep(time.Until(next))
+		runAggregation(db, time.Now().UTC())
+	}
+}
+
+func runAggregation(db *sql.DB, now time.Time) {
+	started := time.Now()
+	recordAggregationRun("metrics_15min", started, Aggregate15Min(db))
+
+	if now.Minute() >= 15 {
+		return
+	}
+	started = time.Now()
+	recordAggregationRun("metrics_hourly", started, AggregateHourly(db))
+
+	if now.Hour() != 0 {
+		return
+	}
+	started = time.Now()
+	recordAggregationRun("metrics_daily", started, AggregateDaily(db))
+}
+
+// catchUpAggregation rolls up all raw data present at startup, up to the
+// last finished 15-minute window, so 7d/30d history covers the time before
+// the first scheduled run and any windows missed while the server was down.
+// Every window is a separate write so agent samples aren't held up behind
+// the whole catch-up.
+func catchUpAggregation(db *sql.DB) {
+	var oldest sql.NullString
+	if err := db.QueryRow("SELECT MIN(timestamp) FROM metrics_raw").Scan(&oldest); err != nil || !oldest.Valid {
+		return
+	}
+	from, err := time.Parse(time.RFC3339, oldest.String)
+	if err != nil {
+		return
+	}
+	from = from.UTC()
+	to := time.Now().UTC().Truncate(15 * time.Minute)
+	if !from.Before(to) {
+		return
+	}
+
+	write := func(fn func(db *sql.DB) error) error {
+		if dbWriter != nil {
+			return dbWriter.WriteSync(fn)
+		}
+		return fn(db)
+	}
+
+	// Each level is built from the one below, so finish them in order
+	started := time.Now()
+	var firstErr error
+	for t := from.Truncate(15 * time.Minute); t.Before(to) && firstErr == nil; t = t.Add(15 * time.Minute) {
+		window := t
+		firstErr = write(func(db *sql.DB) error { return aggregate15MinWindow(db, window) })
+	}
+	recordAggregationRun("metrics_15min", started, firstErr)
+
+	started, firstErr = time.Now(), nil
+	for t := from.Truncate(time.Hour); t.Before(to) && firstErr == nil; t = t.Add(time.Hour) {
+		window := t
+		firstErr = write(func(db *sql.DB) error { return aggregateHourlyWindow(db, window) })
+	}
+	recordAggregationRun("metrics_hourly", started, firstErr)
+
+	started, firstErr = time.Now(), nil
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC); day.Before(to) && firstErr == nil; day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		firstErr = write(func(db *sql.DB) error { return aggregateDailyWindow(db, date) })
+	}
+	recordAggregationRun("metrics_daily", started, firstErr)
+
+	slog.Info("Caught up on raw metrics aggregation", "since", from.Format(time.RFC3339))
+}
+
+// GetAggregationStatus reports, per rollup table, when the server last
+// aggregated into it, how many rows it holds and its newest bucket
+func (s *AppState) GetAggregationStatus(c *gin.Context) {
+	tables, err := GetAggregationTableStats(s.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read aggregation tables"})
+		return
+	}
+
+	aggregationRunsMu.Lock()
+	for i := range tables {
+		if run, ok := aggregationRuns[tables[i].Table]; ok {
+			tables[i].AggregationRun = &run
+		}
+	}
+	aggregationRunsMu.Unlock()
+
+	c.JSON(http.StatusOK, AggregationStatusResponse{Tables: tables})
+}
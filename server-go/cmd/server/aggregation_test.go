@@ -0,0 +1,151 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"vstats/internal/common"
+)
+
+// Raw samples older than 24h are rolled up at startup, so 7d history still
+// covers them once the raw table has been purged
+func TestCatchUpAggregationServes7d(t *testing.T) {
+	db, err := openDatabase("file:catchup?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+	for _, age := range []time.Duration{30 * time.Hour, 29 * time.Hour, 26 * time.Hour, time.Hour} {
+		ts := now.Add(-age)
+		if _, err := db.Exec(`
+			INSERT INTO metrics_raw (server_id, timestamp, cpu_usage, memory_usage, disk_usage, net_rx, net_tx, load_1, load_5, load_15)
+			VALUES ('srv', ?, 40, 50, 60, 0, 0, 0, 0, 0)`, ts.Format(time.RFC3339)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	catchUpAggregation(db)
+
+	var hourly int
+	db.QueryRow("SELECT COUNT(*) FROM metrics_hourly WHERE server_id = 'srv'").Scan(&hourly)
+	if hourly == 0 {
+		t.Fatal("catch-up built no hourly rollups")
+	}
+
+	// What the retention cleanup leaves behind a day later
+	if _, err := db.Exec("DELETE FROM metrics_raw"); err != nil {
+		t.Fatal(err)
+	}
+
+	points, err := GetHistory(db, "srv", "7d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dayAgo := now.Add(-24 * time.Hour)
+	var old int
+	for _, p := range points {
+		ts, err := time.Parse(time.RFC3339, p.Timestamp)
+		if err != nil {
+			t.Fatalf("bad timestamp %q: %v", p.Timestamp, err)
+		}
+		if ts.Before(dayAgo) {
+			old++
+			if p.CPU != 40 {
+				t.Fatalf("point at %s has cpu %v, want 40", p.Timestamp, p.CPU)
+			}
+		}
+	}
+	if old < 3 {
+		t.Fatalf("7d history has %d points older than 24h, want 3 (got %d points)", old, len(points))
+	}
+}
+
+// The 15min rollup's cpu_max keeps the per-second peak the agent reported,
+// not just the highest interval average
+func TestAggregate15MinKeepsCPUPeak(t *testing.T) {
+	db, err := openDatabase("file:cpupeak?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	window := time.Now().UTC().Add(-time.Hour).Truncate(15 * time.Minute)
+	peak := float32(95)
+	for i, m := range []*SystemMetrics{
+		{CPU: CpuMetrics{Usage: 20, UsageMax: &peak}},
+		{CPU: CpuMetrics{Usage: 30}},
+	} {
+		m.Timestamp = window.Add(time.Duration(i) * time.Minute)
+		if err := storeMetricsInternal(db, "srv", m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := aggregate15MinWindow(db, window); err != nil {
+		t.Fatal(err)
+	}
+	var cpuMax float64
+	if err := db.QueryRow("SELECT cpu_max FROM metrics_15min WHERE server_id = 'srv'").Scan(&cpuMax); err != nil {
+		t.Fatal(err)
+	}
+	if cpuMax != 95 {
+		t.Errorf("cpu_max = %v, want the reported peak 95", cpuMax)
+	}
+}
+
+// iowait/steal survive the server rollups and the agent rollups, so 7d and
+// longer ranges can chart them
+func TestRollupsKeepIOWaitAndSteal(t *testing.T) {
+	db, err := openDatabase("file:iowaitrollup?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	hour := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Hour)
+	for i, m := range []*SystemMetrics{
+		{CPU: CpuMetrics{Usage: 50, User: 40, Idle: 40, IOWait: 10, Steal: 2}},
+		{CPU: CpuMetrics{Usage: 50, User: 40, Idle: 30, IOWait: 20, Steal: 4}},
+		{CPU: CpuMetrics{Usage: 50}}, // No CPU time breakdown
+	} {
+		m.Timestamp = hour.Add(time.Duration(i) * time.Minute)
+		if err := storeMetricsInternal(db, "srv", m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for q := 0; q < 4; q++ {
+		if err := aggregate15MinWindow(db, hour.Add(time.Duration(q)*15*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aggregateHourlyWindow(db, hour); err != nil {
+		t.Fatal(err)
+	}
+	var iowait, steal float64
+	if err := db.QueryRow("SELECT iowait_avg, steal_avg FROM metrics_hourly WHERE server_id = 'srv'").Scan(&iowait, &steal); err != nil {
+		t.Fatal(err)
+	}
+	if iowait != 15 || steal != 3 {
+		t.Errorf("hourly iowait/steal = %v/%v, want 15/3", iowait, steal)
+	}
+
+	bucket := hour.Unix() / 900
+	if err := storeMultiGranularityMetricsInternal(db, "agent", []common.GranularityData{{
+		Granularity: "15min",
+		Metrics: []common.BucketData{{
+			Bucket: bucket, CPUSum: 100, SampleCount: 2,
+			IOWaitSum: 30, StealSum: 6, CPUTimesCount: 2,
+		}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	points, err := GetHistory(db, "agent", "7d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].IOWait == nil || *points[0].IOWait != 15 || points[0].Steal == nil || *points[0].Steal != 3 {
+		t.Fatalf("7d history from agent rollups = %+v, want one point with iowait 15 and steal 3", points)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ============================================================================
+// Alerts
+// ============================================================================
+
+// Alert kinds
+const (
+	AlertTrafficQuota = "traffic_quota"
+	AlertDiskHealth   = "disk_health"
+	AlertRenewal      = "renewal"
+)
+
+// alertWebhookTimeout bounds one webhook delivery
+const alertWebhookTimeout = 10 * time.Second
+
+// Alert is a condition an operator should hear about. It is logged and, when
+// alert_webhook_url is set, POSTed there as JSON.
+type Alert struct {
+	Kind       string                 `json:"kind"`
+	ServerID   string                 `json:"server_id"`
+	ServerName string                 `json:"server_name"`
+	Message    string                 `json:"message"`
+	Detail     map[string]interface{} `json:"detail,omitempty"`
+	Time       time.Time              `json:"time"`
+}
+
+// raiseAlert logs an alert and delivers it to the configured webhook in the
+// background. Callers dedupe; every call is delivered.
+func (s *AppState) raiseAlert(alert Alert) {
+	if alert.Time.IsZero() {
+		alert.Time = time.Now().UTC()
+	}
+	slog.Warn(alert.Message, "kind", alert.Kind, "server_id", alert.ServerID, "server", alert.ServerName)
+
+	s.ConfigMu.RLock()
+	url := s.Config.AlertWebhookURL
+	s.ConfigMu.RUnlock()
+	if url == "" {
+		return
+	}
+	go func() {
+		if err := postAlert(url, alert); err != nil {
+			slog.Error("Alert webhook failed", "kind", alert.Kind, "server_id", alert.ServerID, "error", err)
+		}
+	}()
+}
+
+func postAlert(url string, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	client := newOutboundClient(alertWebhookTimeout)
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
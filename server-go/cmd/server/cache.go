@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"sync"
 	"time"
 )
@@ -204,6 +205,18 @@ func (c *HistoryCache) Invalidate(serverID, rangeStr string) {
 	delete(c.entries, cacheKey(serverID, rangeStr))
 }
 
+// InvalidateServer removes all cache entries of a server, after its stored
+// history was changed
+func (c *HistoryCache) InvalidateServer(serverID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, serverID+":") {
+			delete(c.entries, key)
+		}
+	}
+}
+
 // cleanup periodically removes expired entries
 func (c *HistoryCache) cleanup() {
 	ticker := time.NewTicker(time.Minute)
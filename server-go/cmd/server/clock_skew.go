@@ -0,0 +1,146 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vstats/internal/common"
+)
+
+// ============================================================================
+// Agent Clock Skew
+// ============================================================================
+
+const (
+	DefaultMaxClockSkew   = 5 * time.Minute
+	clockSkewWarnInterval = time.Hour
+	// Replayed offline samples older than this fall outside every history
+	// range and would only be deleted again by the retention cleanup
+	maxBatchSampleAge = 30 * 24 * time.Hour
+)
+
+// maxClockSkew mirrors AppConfig.MaxClockSkewSecs for the write path
+// (nanoseconds, 0 = check disabled)
+var maxClockSkew atomic.Int64
+
+// clockSkewWarned holds when each server's skew was last logged
+var clockSkewWarned sync.Map
+
+func init() {
+	maxClockSkew.Store(int64(DefaultMaxClockSkew))
+}
+
+// setMaxClockSkew applies max_clock_skew_secs: 0 uses the default, a
+// negative value disables the check
+func setMaxClockSkew(secs int) {
+	switch {
+	case secs < 0:
+		maxClockSkew.Store(0)
+	case secs == 0:
+		maxClockSkew.Store(int64(DefaultMaxClockSkew))
+	default:
+		maxClockSkew.Store(int64(time.Duration(secs) * time.Second))
+	}
+}
+
+// warnClockSkew logs an agent's clock offset at most once an hour per server
+func warnClockSkew(serverID string, skew time.Duration, action string) {
+	now := time.Now()
+	if last, ok := clockSkewWarned.Load(serverID); ok && now.Sub(last.(time.Time)) < clockSkewWarnInterval {
+		return
+	}
+	clockSkewWarned.Store(serverID, now)
+
+	direction := "ahead of"
+	if skew < 0 {
+		direction, skew = "behind", -skew
+	}
+	slog.Warn("Agent clock is off, check NTP on the agent", "server_id", serverID,
+		"skew", skew.Round(time.Second).String(), "direction", direction, "action", action)
+}
+
+// correctLiveTimestamp replaces the timestamp of a live sample with the
+// server's receive time when the agent clock is off by more than
+// max_clock_skew_secs, so it lands in the right history buckets
+func correctLiveTimestamp(serverID string, metrics *SystemMetrics) {
+	limit := time.Duration(maxClockSkew.Load())
+	if limit == 0 {
+		return
+	}
+	now := time.Now()
+	if skew := metrics.Timestamp.Sub(now); metrics.Timestamp.IsZero() || skew > limit || skew < -limit {
+		if !metrics.Timestamp.IsZero() {
+			warnClockSkew(serverID, skew, "using server time for its samples")
+		}
+		metrics.Timestamp = now.UTC()
+	}
+}
+
+// batchTimestampValid reports whether a replayed offline sample can be
+// stored. Samples may be old, since the agent buffered them, but not from
+// the future (beyond max_clock_skew_secs) or older than any history range.
+func batchTimestampValid(serverID string, ts time.Time) bool {
+	now := time.Now()
+	if limit := time.Duration(maxClockSkew.Load()); limit > 0 && ts.Sub(now) > limit {
+		warnClockSkew(serverID, ts.Sub(now), "rejecting replayed samples from the future")
+		return false
+	}
+	return now.Sub(ts) <= maxBatchSampleAge
+}
+
+// granularitySecs is the bucket size of each granularity an agent aggregates
+// into. Buckets are numbered by Unix time / size.
+var granularitySecs = map[string]int64{
+	"5sec":   5,
+	"2min":   120,
+	"15min":  900,
+	"hourly": 3600,
+	"daily":  86400,
+}
+
+// dropFutureBuckets removes aggregated buckets that start further in the
+// future than max_clock_skew_secs. The agent numbers them by its own clock,
+// and unlike a live sample a bucket can't be moved to the receive time.
+func dropFutureBuckets(serverID string, granularities []common.GranularityData) []common.GranularityData {
+	limit := time.Duration(maxClockSkew.Load())
+	if limit == 0 {
+		return granularities
+	}
+	now := time.Now()
+	future := func(granularity string, bucket int64) bool {
+		secs, ok := granularitySecs[granularity]
+		if !ok {
+			return false
+		}
+		skew := time.Unix(bucket*secs, 0).Sub(now)
+		if skew > limit {
+			warnClockSkew(serverID, skew, "rejecting aggregated buckets from the future")
+			return true
+		}
+		return false
+	}
+
+	kept := make([]common.GranularityData, 0, len(granularities))
+	for _, g := range granularities {
+		metrics := make([]common.BucketData, 0, len(g.Metrics))
+		for _, m := range g.Metrics {
+			if !future(g.Granularity, m.Bucket) {
+				metrics = append(metrics, m)
+			}
+		}
+		ping := make([]common.PingBucketData, 0, len(g.Ping))
+		for _, p := range g.Ping {
+			if !future(g.Granularity, p.Bucket) {
+				ping = append(ping, p)
+			}
+		}
+		if len(metrics) == 0 && len(ping) == 0 {
+			continue
+		}
+		g.Metrics, g.Ping = metrics, ping
+		kept = append(kept, g)
+	}
+	return kept
+}
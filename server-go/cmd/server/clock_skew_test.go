@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"vstats/internal/common"
+)
+
+// Aggregated buckets numbered from a clock running ahead are dropped; current
+// ones and ones from a granularity the server doesn't know are kept
+func TestDropFutureBuckets(t *testing.T) {
+	now := time.Now().Unix()
+	in := []common.GranularityData{
+		{Granularity: "2min", Metrics: []common.BucketData{
+			{Bucket: now / 120},
+			{Bucket: (now + 3600) / 120},
+		}},
+		{Granularity: "hourly", Ping: []common.PingBucketData{
+			{Bucket: (now + 86400) / 3600},
+		}},
+		{Granularity: "weekly", Metrics: []common.BucketData{{Bucket: now}}},
+	}
+
+	out := dropFutureBuckets("srv", in)
+	if len(out) != 2 {
+		t.Fatalf("got %d granularities, want 2: %+v", len(out), out)
+	}
+	if out[0].Granularity != "2min" || len(out[0].Metrics) != 1 || out[0].Metrics[0].Bucket != now/120 {
+		t.Errorf("2min: got %+v, want only the current bucket", out[0].Metrics)
+	}
+	if out[1].Granularity != "weekly" {
+		t.Errorf("got %s, want the unknown granularity passed through", out[1].Granularity)
+	}
+}
@@ -3,8 +3,9 @@ package main
 import (
 	"bufio"
 	"context"
-	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"os/exec"
 	"regexp"
 	"runtime"
@@ -107,14 +108,41 @@ func collectLocalPingMetrics(targets []common.PingTargetConfig) *PingMetrics {
 	pingedHosts := make(map[string]bool)
 
 	for _, ct := range targets {
-		if ct.Host == "" || pingedHosts[ct.Host] {
+		if ct.Host == "" && ct.URL == "" {
 			continue
 		}
 
-		// Determine type (default to icmp)
+		host, port := ct.Host, ct.Port
 		targetType := ct.Type
+		if targetType == "" && ct.URL != "" {
+			targetType = "http"
+		}
+
+		if targetType == "http" {
+			// HTTP probes are reported and stored by URL
+			host = httpProbeURL(ct)
+		} else if h, p, err := net.SplitHostPort(ct.Host); err == nil {
+			// Accept "host:port" shorthand; a port implies a TCP probe
+			if n, err := strconv.Atoi(p); err == nil && n > 0 {
+				host = h
+				if port == 0 {
+					port = n
+				}
+			}
+		}
+
+		// Determine type (default to icmp, or tcp when a port is given)
 		if targetType == "" {
-			targetType = "icmp"
+			if port > 0 {
+				targetType = "tcp"
+			} else {
+				targetType = "icmp"
+			}
+		}
+
+		key := targetType + "|" + net.JoinHostPort(host, strconv.Itoa(port))
+		if pingedHosts[key] {
+			continue
 		}
 
 		var latency *float64
@@ -123,11 +151,18 @@ func collectLocalPingMetrics(targets []common.PingTargetConfig) *PingMetrics {
 
 		if targetType == "tcp" {
 			// Use TCP connection test
-			port := ct.Port
 			if port == 0 {
 				port = 80 // Default to HTTP port
 			}
-			latency, status = testTCPConnection(ct.Host, port)
+			latency, status = testTCPConnection(host, port)
+			if status == "ok" {
+				packetLoss = 0.0
+			} else {
+				packetLoss = 100.0
+			}
+		} else if targetType == "http" {
+			// Use timed HTTP GET with status/body assertions
+			latency, status = testHTTPEndpoint(host, ct)
 			if status == "ok" {
 				packetLoss = 0.0
 			} else {
@@ -135,19 +170,19 @@ func collectLocalPingMetrics(targets []common.PingTargetConfig) *PingMetrics {
 			}
 		} else {
 			// Use ICMP ping
-			latency, packetLoss, status = pingHost(ct.Host)
+			latency, packetLoss, status = pingHost(host)
 		}
 
 		pingTargets = append(pingTargets, PingTarget{
 			Name:       ct.Name,
-			Host:       ct.Host,
+			Host:       host,
 			Type:       targetType,
-			Port:       ct.Port,
+			Port:       port,
 			LatencyMs:  latency,
 			PacketLoss: packetLoss,
 			Status:     status,
 		})
-		pingedHosts[ct.Host] = true
+		pingedHosts[key] = true
 	}
 
 	if len(pingTargets) == 0 {
@@ -159,7 +194,7 @@ func collectLocalPingMetrics(targets []common.PingTargetConfig) *PingMetrics {
 
 // testTCPConnection tests TCP connection latency
 func testTCPConnection(host string, port int) (*float64, string) {
-	address := fmt.Sprintf("%s:%d", host, port)
+	address := net.JoinHostPort(host, strconv.Itoa(port))
 	start := time.Now()
 
 	conn, err := net.DialTimeout("tcp", address, 3*time.Second)
@@ -172,6 +207,64 @@ func testTCPConnection(host string, port int) (*float64, string) {
 	return &latency, "ok"
 }
 
+// httpProbeURL returns the URL an HTTP probe should request
+func httpProbeURL(ct common.PingTargetConfig) string {
+	if ct.URL != "" {
+		return ct.URL
+	}
+	if strings.Contains(ct.Host, "://") {
+		return ct.Host
+	}
+	return "http://" + ct.Host
+}
+
+// testHTTPEndpoint performs a timed GET and checks the status code and body
+func testHTTPEndpoint(url string, ct common.PingTargetConfig) (*float64, string) {
+	timeout := time.Duration(ct.TimeoutSecs) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	client := &http.Client{Timeout: timeout}
+	if ct.FollowRedirects != nil && !*ct.FollowRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "error"
+	}
+	req.Header.Set("User-Agent", "vstats-server")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "error"
+	}
+	defer resp.Body.Close()
+
+	latency := float64(time.Since(start).Nanoseconds()) / 1000000.0 // Convert to milliseconds
+
+	if ct.ExpectedStatus > 0 {
+		if resp.StatusCode != ct.ExpectedStatus {
+			return &latency, "error"
+		}
+	} else if resp.StatusCode >= 400 {
+		return &latency, "error"
+	}
+
+	if ct.BodyContains != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil || !strings.Contains(string(body), ct.BodyContains) {
+			return &latency, "error"
+		}
+	}
+
+	return &latency, "ok"
+}
+
 // pingHost executes a ping test to the specified host
 func pingHost(host string) (*float64, float64, string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -434,13 +527,13 @@ func CollectMetrics() SystemMetrics {
 	elapsed := now.Sub(lc.lastNetworkTime).Seconds()
 	var rxSpeed, txSpeed uint64
 	if elapsed > 0.1 {
-		rxDiff := totalRx - lc.lastNetworkRx
-		txDiff := totalTx - lc.lastNetworkTx
+		// Counters that went backwards were reset: report 0 for this tick
+		// and start over from the new values
 		if totalRx >= lc.lastNetworkRx {
-			rxSpeed = uint64(float64(rxDiff) / elapsed)
+			rxSpeed = uint64(float64(totalRx-lc.lastNetworkRx) / elapsed)
 		}
 		if totalTx >= lc.lastNetworkTx {
-			txSpeed = uint64(float64(txDiff) / elapsed)
+			txSpeed = uint64(float64(totalTx-lc.lastNetworkTx) / elapsed)
 		}
 		lc.lastNetworkRx = totalRx
 		lc.lastNetworkTx = totalTx
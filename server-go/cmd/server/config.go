@@ -7,7 +7,9 @@ import (
 	"math/big"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"vstats/internal/common"
 	"golang.org/x/crypto/bcrypt"
@@ -34,6 +36,7 @@ type LocalNodeConfig struct {
 	PricePeriod  string            `json:"price_period,omitempty"`
 	PurchaseDate string            `json:"purchase_date,omitempty"`
 	TipBadge     string            `json:"tip_badge,omitempty"`
+	SortOrder    int               `json:"sort_order"`
 }
 
 // BackgroundConfig represents background settings for the site theme
@@ -67,6 +70,25 @@ type SocialLink struct {
 
 type ProbeSettings struct {
 	PingTargets []common.PingTargetConfig `json:"ping_targets"`
+	// Seconds without a report before a server is shown offline (0 = automatic)
+	OfflineThresholdSecs int `json:"offline_threshold_secs,omitempty"`
+}
+
+// DefaultOfflineThresholdSecs is used when no threshold is configured
+const DefaultOfflineThresholdSecs = 30
+
+// OfflineThreshold returns how long an agent reporting every intervalSecs may
+// stay silent before it is considered offline. An explicit setting wins;
+// otherwise the default is stretched to cover three missed reports.
+func (p *ProbeSettings) OfflineThreshold(intervalSecs uint64) time.Duration {
+	if p.OfflineThresholdSecs > 0 {
+		return time.Duration(p.OfflineThresholdSecs) * time.Second
+	}
+	secs := uint64(DefaultOfflineThresholdSecs)
+	if 3*intervalSecs > secs {
+		secs = 3 * intervalSecs
+	}
+	return time.Duration(secs) * time.Second
 }
 
 // OAuth 2.0 Configuration
@@ -74,7 +96,8 @@ type OAuthProvider struct {
 	Enabled      bool     `json:"enabled"`
 	ClientID     string   `json:"client_id"`
 	ClientSecret string   `json:"client_secret"`
-	AllowedUsers []string `json:"allowed_users,omitempty"` // GitHub usernames or Google emails
+	AllowedUsers []string `json:"allowed_users,omitempty"` // GitHub usernames or Google emails; supports "*@domain.com", "*" and "!user" denies
+	ViewerUsers  []string `json:"viewer_users,omitempty"`  // Same syntax; these log in with the read-only viewer role
 }
 
 type OAuthConfig struct {
@@ -84,12 +107,96 @@ type OAuthConfig struct {
 
 	// Allowed users for centralized OAuth (GitHub usernames or Google emails)
 	AllowedUsers []string `json:"allowed_users,omitempty"`
+	// Users that log in through centralized OAuth with the viewer role
+	ViewerUsers []string `json:"viewer_users,omitempty"`
 
 	// Self-hosted OAuth configuration (optional, for advanced users)
 	GitHub *OAuthProvider `json:"github,omitempty"`
 	Google *OAuthProvider `json:"google,omitempty"`
 }
 
+// LoginRateLimitConfig controls brute-force protection on the login endpoint.
+// Zero values fall back to the defaults (5 attempts per 60s, 1h max backoff).
+type LoginRateLimitConfig struct {
+	MaxAttempts    int `json:"max_attempts,omitempty"`     // Failed attempts allowed per window
+	WindowSecs     int `json:"window_secs,omitempty"`      // Window length, also the first lockout duration
+	MaxBackoffSecs int `json:"max_backoff_secs,omitempty"` // Upper bound for the exponential lockout
+}
+
+// DBWriteQueueConfig tunes the queue in front of the single database writer.
+// In "drop" mode (default) asynchronous writes are discarded when the queue is
+// full, so ingestion never stalls but data can be lost under overload. In
+// "block" mode the writer waits up to BlockTimeoutMs for room, which protects
+// data but slows agent ingestion while the database catches up.
+type DBWriteQueueConfig struct {
+	BufferSize     int    `json:"buffer_size,omitempty"`      // Queued jobs (default 1024)
+	Mode           string `json:"mode,omitempty"`             // "drop" or "block"
+	BlockTimeoutMs int    `json:"block_timeout_ms,omitempty"` // Block mode only (default 5000)
+}
+
+// GeoIPConfig enables filling in the location of servers that have none
+// from their public IP. DatabasePath (a MaxMind GeoLite2 City or Country
+// .mmdb) is used if set and works offline; otherwise LookupURL is queried,
+// with {ip} replaced by the address.
+type GeoIPConfig struct {
+	DatabasePath string `json:"database_path,omitempty"`
+	LookupURL    string `json:"lookup_url,omitempty"` // e.g. "https://ipapi.co/{ip}/json/"
+}
+
+const (
+	defaultDBWriteQueueSize      = 1024
+	defaultDBWriteBlockTimeoutMs = 5000
+)
+
+func (c *DBWriteQueueConfig) bufferSize() int {
+	if c != nil && c.BufferSize > 0 {
+		return c.BufferSize
+	}
+	return defaultDBWriteQueueSize
+}
+
+func (c *DBWriteQueueConfig) blocking() bool {
+	return c != nil && c.Mode == "block"
+}
+
+func (c *DBWriteQueueConfig) blockTimeout() time.Duration {
+	if c != nil && c.BlockTimeoutMs > 0 {
+		return time.Duration(c.BlockTimeoutMs) * time.Millisecond
+	}
+	return defaultDBWriteBlockTimeoutMs * time.Millisecond
+}
+
+// DeltaThresholdsConfig sets how much a metric must move before dashboards
+// are sent a delta for it. Zero values fall back to the defaults (1% for
+// CPU, memory and disk, 1 KiB/s for network speeds).
+type DeltaThresholdsConfig struct {
+	CPUPercent         uint8  `json:"cpu_percent,omitempty"`
+	MemoryPercent      uint8  `json:"memory_percent,omitempty"`
+	DiskPercent        uint8  `json:"disk_percent,omitempty"`
+	NetworkBytesPerSec uint64 `json:"network_bytes_per_sec,omitempty"` // Applies to rx and tx separately
+}
+
+// effective returns the thresholds with defaults filled in; safe on nil
+func (t *DeltaThresholdsConfig) effective() DeltaThresholdsConfig {
+	th := DeltaThresholdsConfig{CPUPercent: 1, MemoryPercent: 1, DiskPercent: 1, NetworkBytesPerSec: 1024}
+	if t == nil {
+		return th
+	}
+	if t.CPUPercent > 0 {
+		th.CPUPercent = t.CPUPercent
+	}
+	if t.MemoryPercent > 0 {
+		th.MemoryPercent = t.MemoryPercent
+	}
+	if t.DiskPercent > 0 {
+		th.DiskPercent = t.DiskPercent
+	}
+	if t.NetworkBytesPerSec > 0 {
+		th.NetworkBytesPerSec = t.NetworkBytesPerSec
+	}
+	return th
+}
+
 // GroupDimension represents a grouping dimension (e.g., Region, Purpose)
 type GroupDimension struct {
 	ID        string        `json:"id"`
@@ -115,34 +222,151 @@ type ServerGroup struct {
 }
 
 type RemoteServer struct {
-	ID           string            `json:"id"`
-	Name         string            `json:"name"`
-	URL          string            `json:"url"`
-	Location     string            `json:"location"`
-	Provider     string            `json:"provider"`
-	Tag          string            `json:"tag"`
-	Token        string            `json:"token"`
-	Version      string            `json:"version"`
-	IP           string            `json:"ip"`
-	GroupID      string            `json:"group_id,omitempty"`     // Deprecated, for backward compatibility
-	GroupValues  map[string]string `json:"group_values,omitempty"` // dimension_id -> option_id
-	PriceAmount  string            `json:"price_amount,omitempty"`
-	PricePeriod  string            `json:"price_period,omitempty"`
-	PurchaseDate string            `json:"purchase_date,omitempty"`
-	TipBadge     string            `json:"tip_badge,omitempty"`
+	ID                   string            `json:"id"`
+	Name                 string            `json:"name"`
+	URL                  string            `json:"url"`
+	Location             string            `json:"location"`
+	Provider             string            `json:"provider"`
+	Tag                  string            `json:"tag"`
+	Token                string            `json:"token"`
+	Version              string            `json:"version"`
+	IP                   string            `json:"ip"`                     // First address the agent reports, else PublicIP
+	PublicIP             string            `json:"public_ip,omitempty"`    // Address the agent connects from; differs from IP behind NAT
+	GroupID              string            `json:"group_id,omitempty"`     // Deprecated, for backward compatibility
+	GroupValues          map[string]string `json:"group_values,omitempty"` // dimension_id -> option_id
+	PriceAmount          string            `json:"price_amount,omitempty"`
+	PricePeriod          string            `json:"price_period,omitempty"`
+	PurchaseDate         string            `json:"purchase_date,omitempty"`
+	TipBadge             string            `json:"tip_badge,omitempty"`
+	PreviousToken        string            `json:"previous_token,omitempty"`         // Rotated-out token, accepted during the grace period
+	PreviousTokenExpires int64             `json:"previous_token_expires,omitempty"` // Unix seconds
+	SortOrder            int               `json:"sort_order"`
+	MonthlyQuotaBytes    int64             `json:"monthly_quota_bytes,omitempty"` // Egress (tx) quota per calendar month, 0 = none
+	MaintenanceUntil     *time.Time        `json:"maintenance_until,omitempty"`   // Outages and alerts are suppressed until then
+	ExpiryDate           string            `json:"expiry_date,omitempty"`         // YYYY-MM-DD; overrides the renewal computed from purchase_date
+	// Filled from the public IP by GeoIP, see GeoIPConfig
+	CountryCode string   `json:"country_code,omitempty"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+}
+
+// sortServers orders servers by SortOrder, keeping insertion order for ties
+// (configs written before sort_order existed are all 0)
+func sortServers(servers []RemoteServer) {
+	sort.SliceStable(servers, func(i, j int) bool {
+		return servers[i].SortOrder < servers[j].SortOrder
+	})
+}
+
+// nextServerSortOrder returns the sort order that appends a server to the end
+func (c *AppConfig) nextServerSortOrder() int {
+	next := c.LocalNode.SortOrder + 1
+	for _, server := range c.Servers {
+		if server.SortOrder >= next {
+			next = server.SortOrder + 1
+		}
+	}
+	return next
+}
+
+// AgentTokenGracePeriod is how long a rotated-out agent token is still
+// accepted, unless the agent connects with the new token first
+const AgentTokenGracePeriod = 7 * 24 * time.Hour
+
+// CheckToken reports whether token authenticates this server's agent, and
+// whether it was the previous (rotated-out) token
+func (rs *RemoteServer) CheckToken(token string) (ok bool, previous bool) {
+	if token == rs.Token {
+		return true, false
+	}
+	if rs.PreviousToken != "" && token == rs.PreviousToken && time.Now().Unix() < rs.PreviousTokenExpires {
+		return true, true
+	}
+	return false, false
+}
+
+// clearPreviousToken retires the rotated-out token
+func (rs *RemoteServer) clearPreviousToken() {
+	rs.PreviousToken = ""
+	rs.PreviousTokenExpires = 0
 }
 
 type AppConfig struct {
-	AdminPasswordHash string           `json:"admin_password_hash"`
-	JWTSecret         string           `json:"jwt_secret"`
-	Port              string           `json:"port,omitempty"`
-	Servers           []RemoteServer   `json:"servers"`
-	Groups            []ServerGroup    `json:"groups,omitempty"` // Deprecated, for backward compatibility
-	GroupDimensions   []GroupDimension `json:"group_dimensions,omitempty"`
-	SiteSettings      SiteSettings     `json:"site_settings"`
-	LocalNode         LocalNodeConfig  `json:"local_node"`
-	ProbeSettings     ProbeSettings    `json:"probe_settings"`
-	OAuth             *OAuthConfig     `json:"oauth,omitempty"`
+	AdminPasswordHash string                `json:"admin_password_hash"`
+	JWTSecret         string                `json:"jwt_secret"`
+	Port              string                `json:"port,omitempty"`
+	Servers           []RemoteServer        `json:"servers"`
+	Groups            []ServerGroup         `json:"groups,omitempty"` // Deprecated, for backward compatibility
+	GroupDimensions   []GroupDimension      `json:"group_dimensions,omitempty"`
+	SiteSettings      SiteSettings          `json:"site_settings"`
+	LocalNode         LocalNodeConfig       `json:"local_node"`
+	ProbeSettings     ProbeSettings         `json:"probe_settings"`
+	OAuth             *OAuthConfig          `json:"oauth,omitempty"`
+	LoginRateLimit    *LoginRateLimitConfig `json:"login_rate_limit,omitempty"`
+	// Disable permessage-deflate on dashboard WebSockets (some proxies mishandle it)
+	DisableWSCompression bool `json:"disable_ws_compression,omitempty"`
+	// Store per-core CPU usage (cpu_core_raw, kept 24h). Off by default since
+	// it writes one row per core per sample.
+	PerCoreHistory bool `json:"per_core_history,omitempty"`
+	// Custom agent metrics (from external_collectors) to store in
+	// custom_metric_raw, kept 24h. Other custom keys are only shown live.
+	CustomHistoryKeys []string `json:"custom_history_keys,omitempty"`
+	// Most points /api/history returns for ?points= (default 5000)
+	MaxHistoryPoints int `json:"max_history_points,omitempty"`
+	// Minimum change before a metric is included in dashboard deltas
+	DeltaThresholds *DeltaThresholdsConfig `json:"delta_thresholds,omitempty"`
+	// How often deltas are computed and pushed to dashboards (default 5)
+	BroadcastIntervalSecs int `json:"broadcast_interval_secs,omitempty"`
+	// Built-in HTTPS: certificate files (or VSTATS_TLS_CERT / VSTATS_TLS_KEY),
+	// or an ACME certificate obtained automatically for AutocertDomain
+	TLSCertFile      string `json:"tls_cert_file,omitempty"`
+	TLSKeyFile       string `json:"tls_key_file,omitempty"`
+	AutocertDomain   string `json:"autocert_domain,omitempty"`
+	AutocertEmail    string `json:"autocert_email,omitempty"`
+	AutocertCacheDir string `json:"autocert_cache_dir,omitempty"` // Default: autocert/ next to the database
+	AutocertHTTPAddr string `json:"autocert_http_addr,omitempty"` // HTTP-01 and redirect listener, default ":80"; "off" disables
+	// Origins allowed to call the API from a browser; exact origins or
+	// wildcard subdomains like "https://*.example.com". Default ["*"].
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+	// Origins besides the dashboard's own that may open the dashboard
+	// WebSocket, same syntax as AllowedOrigins; ["*"] disables the check
+	WSAllowedOrigins []string `json:"ws_allowed_origins,omitempty"`
+	// Database write queue size and overflow behavior (read at startup)
+	DBWriteQueue *DBWriteQueueConfig `json:"db_write_queue,omitempty"`
+	// What to do when a second agent authenticates as an already connected
+	// server: "last-wins" (default) or "first-wins"
+	DuplicateAgentPolicy string `json:"duplicate_agent_policy,omitempty"`
+	// Agent WebSocket limits: concurrent connections including ones still
+	// authenticating (default 1000, negative disables), and seconds a new
+	// connection has to authenticate (default 10)
+	MaxAgentConnections  int `json:"max_agent_connections,omitempty"`
+	AgentAuthTimeoutSecs int `json:"agent_auth_timeout_secs,omitempty"`
+	// Session length: how long a login stays valid before the user has to
+	// sign in again, e.g. "8h" or "30d" (default "7d", 15m to 90d)
+	TokenTTL string `json:"token_ttl,omitempty"`
+	// Largest accepted difference between an agent's clock and the server's,
+	// in seconds (default 300, negative disables). Live samples beyond it are
+	// stamped with the receive time; replayed ones from the future are dropped.
+	MaxClockSkewSecs int `json:"max_clock_skew_secs,omitempty"`
+	// Locate servers without a location by their public IP
+	GeoIP *GeoIPConfig `json:"geoip,omitempty"`
+	// Days ahead a server's renewal or expiry is reported (default 7,
+	// negative disables)
+	RenewalReminderDays int `json:"renewal_reminder_days,omitempty"`
+	// Local time of day ("HH:MM", default "04:00") free database pages are
+	// returned to the filesystem; "off" disables it
+	VacuumTime string `json:"vacuum_time,omitempty"`
+	// URL alerts (such as a server going over its monthly traffic quota)
+	// are POSTed to as JSON; alerts are only logged when empty
+	AlertWebhookURL string `json:"alert_webhook_url,omitempty"`
+}
+
+// broadcastInterval returns the dashboard delta interval, defaulting to 5s
+func (c *AppConfig) broadcastInterval() time.Duration {
+	if c.BroadcastIntervalSecs > 0 {
+		return time.Duration(c.BroadcastIntervalSecs) * time.Second
+	}
+	return 5 * time.Second
 }
 
 func getExeDir() string {
@@ -309,7 +533,12 @@ func LoadConfig() (*AppConfig, *string) {
 			fmt.Println("✅ Initialized default group dimensions")
 		}
 
+		sortServers(config.Servers)
+
 		InitJWTSecret(config.JWTSecret)
+		if err := InitTokenTTL(config.TokenTTL); err != nil {
+			fmt.Printf("⚠️  Invalid token_ttl %q (%v), using %v\n", config.TokenTTL, err, DefaultTokenTTL)
+		}
 		return &config, nil
 	}
 
@@ -353,6 +582,25 @@ func ResetAdminPassword() string {
 	return password
 }
 
+// backupConfigFile copies the current config file to a timestamped
+// <config>.<time>.bak next to it and returns the backup path. A missing
+// config file is not an error (nothing to back up).
+func backupConfigFile() (string, error) {
+	path := GetConfigPath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	backup := fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102-150405"))
+	if err := os.WriteFile(backup, data, 0600); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
 func SaveConfig(config *AppConfig) {
 	path := GetConfigPath()
 	data, err := json.MarshalIndent(config, "", "  ")
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// Costs
+// ============================================================================
+
+// Prices are free-form strings like "$5", "€4.50" or "10 USD". The number
+// is the first decimal in the string; the currency is whatever precedes it,
+// or else follows it, and defaults to "$" like the dashboard.
+var priceNumber = regexp.MustCompile(`\d[\d,]*(\.\d+)?`)
+
+const defaultCurrency = "$"
+
+// monthsPerPeriod converts a price period to months. An empty period is
+// monthly, the dashboard's default.
+var monthsPerPeriod = map[string]float64{
+	"":        1,
+	"day":     12.0 / 365,
+	"week":    12.0 / 52,
+	"month":   1,
+	"quarter": 3,
+	"year":    12,
+}
+
+// parsePrice splits a price into currency and amount
+func parsePrice(price string) (currency string, amount float64, ok bool) {
+	loc := priceNumber.FindStringIndex(price)
+	if loc == nil {
+		return "", 0, false
+	}
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(price[loc[0]:loc[1]], ",", ""), 64)
+	if err != nil {
+		return "", 0, false
+	}
+	currency = strings.TrimSpace(price[:loc[0]])
+	if currency == "" {
+		currency = strings.TrimSpace(price[loc[1]:])
+	}
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	return currency, amount, true
+}
+
+// monthlyCost normalizes a price to a monthly amount
+func monthlyCost(amount string, period string) (currency string, monthly float64, ok bool) {
+	months, known := monthsPerPeriod[strings.ToLower(strings.TrimSpace(period))]
+	if !known {
+		return "", 0, false
+	}
+	currency, value, ok := parsePrice(amount)
+	if !ok {
+		return "", 0, false
+	}
+	return currency, value / months, true
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// costTotals sums monthly costs per currency, since different currencies
+// can't be added up
+type costTotals map[string]*CostTotal
+
+func (t costTotals) add(currency string, monthly float64) {
+	total := t[currency]
+	if total == nil {
+		total = &CostTotal{Currency: currency}
+		t[currency] = total
+	}
+	total.Monthly += monthly
+	total.Servers++
+}
+
+func (t costTotals) list() []CostTotal {
+	list := make([]CostTotal, 0, len(t))
+	for _, total := range t {
+		list = append(list, CostTotal{
+			Currency: total.Currency,
+			Monthly:  roundCents(total.Monthly),
+			Yearly:   roundCents(total.Monthly * 12),
+			Servers:  total.Servers,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Currency < list[j].Currency })
+	return list
+}
+
+// GetCosts rolls the servers' prices up into monthly costs: totals per
+// currency across the fleet, per option of each group dimension (or only
+// ?dimension=), and per server. Servers without a parseable price are
+// counted in unpriced.
+func (s *AppState) GetCosts(c *gin.Context) {
+	dimensionFilter := c.Query("dimension")
+
+	s.ConfigMu.RLock()
+	type pricedServer struct {
+		id, name, amount, period string
+		groupValues              map[string]string
+	}
+	servers := make([]pricedServer, 0, len(s.Config.Servers)+1)
+	local := s.Config.LocalNode
+	if local.PriceAmount != "" {
+		name := local.Name
+		if name == "" {
+			name = "local"
+		}
+		servers = append(servers, pricedServer{"local", name, local.PriceAmount, local.PricePeriod, local.GroupValues})
+	}
+	for _, server := range s.Config.Servers {
+		servers = append(servers, pricedServer{server.ID, server.Name, server.PriceAmount, server.PricePeriod, server.GroupValues})
+	}
+	dimensions := make([]GroupDimension, 0, len(s.Config.GroupDimensions))
+	for _, dim := range s.Config.GroupDimensions {
+		if dimensionFilter == "" || dim.ID == dimensionFilter {
+			dimensions = append(dimensions, dim)
+		}
+	}
+	s.ConfigMu.RUnlock()
+
+	if dimensionFilter != "" && len(dimensions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dimension not found"})
+		return
+	}
+
+	totals := costTotals{}
+	// dimension ID -> option ID ("" for servers without one) -> totals
+	byOption := make(map[string]map[string]costTotals, len(dimensions))
+	for _, dim := range dimensions {
+		byOption[dim.ID] = make(map[string]costTotals)
+	}
+
+	resp := CostsResponse{Servers: []ServerCost{}}
+	for _, server := range servers {
+		currency, monthly, ok := monthlyCost(server.amount, server.period)
+		if !ok {
+			resp.Unpriced++
+			continue
+		}
+		totals.add(currency, monthly)
+		for _, dim := range dimensions {
+			optionID := server.groupValues[dim.ID]
+			if !dim.hasOption(optionID) {
+				optionID = "" // Unset, or an option that was deleted
+			}
+			if byOption[dim.ID][optionID] == nil {
+				byOption[dim.ID][optionID] = costTotals{}
+			}
+			byOption[dim.ID][optionID].add(currency, monthly)
+		}
+		resp.Servers = append(resp.Servers, ServerCost{
+			ServerID:    server.id,
+			ServerName:  server.name,
+			PriceAmount: server.amount,
+			PricePeriod: server.period,
+			Currency:    currency,
+			Monthly:     roundCents(monthly),
+			GroupValues: server.groupValues,
+		})
+	}
+	sort.Slice(resp.Servers, func(i, j int) bool { return resp.Servers[i].Monthly > resp.Servers[j].Monthly })
+	resp.Totals = totals.list()
+
+	for _, dim := range dimensions {
+		group := DimensionCosts{DimensionID: dim.ID, DimensionName: dim.Name, Options: []OptionCosts{}}
+		for _, option := range dim.Options {
+			if t := byOption[dim.ID][option.ID]; t != nil {
+				group.Options = append(group.Options, OptionCosts{OptionID: option.ID, OptionName: option.Name, Totals: t.list()})
+			}
+		}
+		if t := byOption[dim.ID][""]; t != nil {
+			group.Options = append(group.Options, OptionCosts{Totals: t.list()}) // Servers without an option
+		}
+		resp.Dimensions = append(resp.Dimensions, group)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (dim *GroupDimension) hasOption(optionID string) bool {
+	for _, option := range dim.Options {
+		if option.ID == optionID {
+			return true
+		}
+	}
+	return false
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"vstats/internal/common"
@@ -18,6 +23,26 @@ type DBWriter struct {
 	writeCh  chan writeJob
 	done     chan struct{}
 	wg       sync.WaitGroup
+
+	// When blocking is set, WriteAsync waits up to blockTimeout for room
+	// instead of dropping immediately
+	blocking     bool
+	blockTimeout time.Duration
+
+	queued    atomic.Uint64 // Jobs accepted into the queue
+	processed atomic.Uint64 // Jobs executed (successfully or not)
+	failed    atomic.Uint64 // Jobs whose write returned an error
+	dropped   atomic.Uint64 // Async jobs discarded because the queue was full
+}
+
+// DBWriterStats is a snapshot of the DBWriter counters
+type DBWriterStats struct {
+	Queued        uint64 `json:"queued"`
+	Processed     uint64 `json:"processed"`
+	Failed        uint64 `json:"failed"`
+	Dropped       uint64 `json:"dropped"`
+	QueueDepth    int    `json:"queue_depth"`
+	QueueCapacity int    `json:"queue_capacity"`
 }
 
 type writeJob struct {
@@ -54,6 +79,7 @@ type AggBuffer struct {
 	ping        map[PingBufferKey]*common.PingBucketData
 	flushTicker *time.Ticker
 	done        chan struct{}
+	stopped     chan struct{} // Closed once the final flush has been queued
 }
 
 // Global aggregation buffer
@@ -75,6 +101,7 @@ type MetricsBuffer struct {
 	items       []MetricsBufferItem
 	flushTicker *time.Ticker
 	done        chan struct{}
+	stopped     chan struct{} // Closed once the final flush has been queued
 	maxSize     int
 }
 
@@ -87,6 +114,7 @@ func NewMetricsBuffer(flushInterval time.Duration, maxSize int) *MetricsBuffer {
 		items:       make([]MetricsBufferItem, 0, maxSize),
 		flushTicker: time.NewTicker(flushInterval),
 		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
 		maxSize:     maxSize,
 	}
 	go mb.flushLoop()
@@ -124,6 +152,7 @@ func (mb *MetricsBuffer) flushLoop() {
 			mb.Flush()
 		case <-mb.done:
 			mb.Flush()
+			close(mb.stopped)
 			return
 		}
 	}
@@ -155,10 +184,12 @@ func (mb *MetricsBuffer) flushItems(items []MetricsBufferItem) {
 	})
 }
 
-// Close stops the buffer
+// Close stops the buffer and waits until the remaining items are queued
+// on the DBWriter
 func (mb *MetricsBuffer) Close() {
 	mb.flushTicker.Stop()
 	close(mb.done)
+	<-mb.stopped
 }
 
 // GetLastMetricsTime returns the last metrics timestamp for a server
@@ -250,16 +281,16 @@ func batchStoreMetrics(db *sql.DB, items []MetricsBufferItem) error {
 	
 	// Prepare statements for batch insert
 	rawStmt, err := tx.Prepare(`
-		INSERT INTO metrics_raw (server_id, timestamp, cpu_usage, memory_usage, disk_usage, net_rx, net_tx, load_1, load_5, load_15, ping_ms, bucket_5min, bucket_5sec)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+		INSERT INTO metrics_raw (server_id, timestamp, cpu_usage, memory_usage, disk_usage, net_rx, net_tx, load_1, load_5, load_15, ping_ms, bucket_5min, bucket_5sec, iowait, steal, cpu_peak)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
 	defer rawStmt.Close()
 	
 	stmt5sec, err := tx.Prepare(`
-		INSERT INTO metrics_5sec (server_id, bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
+		INSERT INTO metrics_5sec (server_id, bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count, load1_sum, load5_sum, load15_sum, load_count, iowait_sum, steal_sum, cpu_times_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?, ?, 1, ?, ?, ?)
 		ON CONFLICT(server_id, bucket) DO UPDATE SET
 			cpu_sum = cpu_sum + excluded.cpu_sum,
 			cpu_max = MAX(cpu_max, excluded.cpu_max),
@@ -270,15 +301,22 @@ func batchStoreMetrics(db *sql.DB, items []MetricsBufferItem) error {
 			net_tx = MAX(net_tx, excluded.net_tx),
 			ping_sum = ping_sum + excluded.ping_sum,
 			ping_count = ping_count + excluded.ping_count,
-			sample_count = sample_count + 1`)
+			sample_count = sample_count + 1,
+			load1_sum = load1_sum + excluded.load1_sum,
+			load5_sum = load5_sum + excluded.load5_sum,
+			load15_sum = load15_sum + excluded.load15_sum,
+			load_count = load_count + excluded.load_count,
+			iowait_sum = iowait_sum + excluded.iowait_sum,
+			steal_sum = steal_sum + excluded.steal_sum,
+			cpu_times_count = cpu_times_count + excluded.cpu_times_count`)
 	if err != nil {
 		return err
 	}
 	defer stmt5sec.Close()
 	
 	stmt2min, err := tx.Prepare(`
-		INSERT INTO metrics_2min (server_id, bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
+		INSERT INTO metrics_2min (server_id, bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count, load1_sum, load5_sum, load15_sum, load_count, iowait_sum, steal_sum, cpu_times_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?, ?, 1, ?, ?, ?)
 		ON CONFLICT(server_id, bucket) DO UPDATE SET
 			cpu_sum = cpu_sum + excluded.cpu_sum,
 			cpu_max = MAX(cpu_max, excluded.cpu_max),
@@ -289,7 +327,14 @@ func batchStoreMetrics(db *sql.DB, items []MetricsBufferItem) error {
 			net_tx = MAX(net_tx, excluded.net_tx),
 			ping_sum = ping_sum + excluded.ping_sum,
 			ping_count = ping_count + excluded.ping_count,
-			sample_count = sample_count + 1`)
+			sample_count = sample_count + 1,
+			load1_sum = load1_sum + excluded.load1_sum,
+			load5_sum = load5_sum + excluded.load5_sum,
+			load15_sum = load15_sum + excluded.load15_sum,
+			load_count = load_count + excluded.load_count,
+			iowait_sum = iowait_sum + excluded.iowait_sum,
+			steal_sum = steal_sum + excluded.steal_sum,
+			cpu_times_count = cpu_times_count + excluded.cpu_times_count`)
 	if err != nil {
 		return err
 	}
@@ -329,6 +374,8 @@ func batchStoreMetrics(db *sql.DB, items []MetricsBufferItem) error {
 			}
 		}
 		
+		iowait, steal, timesCount := cpuStallTimes(metrics)
+
 		// Insert raw
 		rawStmt.Exec(
 			serverID, timestamp,
@@ -336,27 +383,42 @@ func batchStoreMetrics(db *sql.DB, items []MetricsBufferItem) error {
 			metrics.Network.TotalRx, metrics.Network.TotalTx,
 			metrics.LoadAverage.One, metrics.LoadAverage.Five, metrics.LoadAverage.Fifteen,
 			pingMs, bucket5min, bucket5sec,
+			iowait, steal, metrics.CPU.PeakUsage(),
 		)
 		
 		// Insert to 5sec aggregation
 		stmt5sec.Exec(
 			serverID, bucket5sec,
-			float64(metrics.CPU.Usage), float64(metrics.CPU.Usage),
+			float64(metrics.CPU.Usage), float64(metrics.CPU.PeakUsage()),
 			float64(metrics.Memory.UsagePercent), float64(metrics.Memory.UsagePercent),
 			float64(diskUsage),
 			metrics.Network.TotalRx, metrics.Network.TotalTx,
 			pingVal, pingCnt,
+			metrics.LoadAverage.One, metrics.LoadAverage.Five, metrics.LoadAverage.Fifteen,
+			iowait.Float64, steal.Float64, timesCount,
 		)
 		
 		// Insert to 2min aggregation
 		stmt2min.Exec(
 			serverID, bucket5min,
-			float64(metrics.CPU.Usage), float64(metrics.CPU.Usage),
+			float64(metrics.CPU.Usage), float64(metrics.CPU.PeakUsage()),
 			float64(metrics.Memory.UsagePercent), float64(metrics.Memory.UsagePercent),
 			float64(diskUsage),
 			metrics.Network.TotalRx, metrics.Network.TotalTx,
 			pingVal, pingCnt,
+			metrics.LoadAverage.One, metrics.LoadAverage.Five, metrics.LoadAverage.Fifteen,
+			iowait.Float64, steal.Float64, timesCount,
 		)
+
+		if err := storeCoreUsage(tx, serverID, timestamp, metrics.CPU.PerCore); err != nil {
+			return err
+		}
+		if err := storeCustomMetrics(tx, serverID, timestamp, metrics.Custom); err != nil {
+			return err
+		}
+		if err := storeMetricRecords(tx, serverID, metrics); err != nil {
+			return err
+		}
 	}
 	
 	return tx.Commit()
@@ -369,6 +431,7 @@ func NewAggBuffer(flushInterval time.Duration) *AggBuffer {
 		ping:        make(map[PingBufferKey]*common.PingBucketData),
 		flushTicker: time.NewTicker(flushInterval),
 		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
 	}
 	go ab.flushLoop()
 	return ab
@@ -450,6 +513,7 @@ func (ab *AggBuffer) flushLoop() {
 			ab.Flush()
 		case <-ab.done:
 			ab.Flush() // Final flush
+			close(ab.stopped)
 			return
 		}
 	}
@@ -478,7 +542,7 @@ func (ab *AggBuffer) Flush() {
 		dbWriter.WriteAsync(func(db *sql.DB) error {
 			err := flushAggBufferToDB(db, metrics, ping)
 			if err != nil {
-				fmt.Printf("⚠️ Aggregation buffer flush error: %v\n", err)
+				slog.Error("Aggregation buffer flush failed", "error", err)
 			}
 			return err
 		})
@@ -496,6 +560,7 @@ func (ab *AggBuffer) Stats() (metricsCount, pingCount int) {
 func (ab *AggBuffer) Close() {
 	ab.flushTicker.Stop()
 	close(ab.done)
+	<-ab.stopped
 }
 
 // flushAggBufferToDB writes buffered data to database using batch inserts
@@ -534,7 +599,7 @@ func flushAggBufferToDB(db *sql.DB, metrics map[AggBufferKey]*common.BucketData,
 		if len(items) > 0 {
 			err := batchUpsertMetrics(tx, table, items)
 			if err != nil {
-				fmt.Printf("Error batch inserting to %s: %v\n", table, err)
+				slog.Error("Batch insert failed", "table", table, "error", err)
 			}
 		}
 	}
@@ -562,7 +627,7 @@ func flushAggBufferToDB(db *sql.DB, metrics map[AggBufferKey]*common.BucketData,
 		if len(items) > 0 {
 			err := batchUpsertPing(tx, table, items)
 			if err != nil {
-				fmt.Printf("Error batch inserting to %s: %v\n", table, err)
+				slog.Error("Batch insert failed", "table", table, "error", err)
 			}
 		}
 	}
@@ -733,18 +798,18 @@ func (w *DBWriter) processWrites() {
 	for {
 		select {
 		case job := <-w.writeCh:
-			err := job.fn(w.db)
+			err := w.run(job)
 			if job.result != nil {
 				job.result <- err
 			} else if err != nil {
-				fmt.Printf("Database write error: %v\n", err)
+				slog.Error("Database write failed", "error", err)
 			}
 		case <-w.done:
 			// Drain remaining jobs before exiting
 			for {
 				select {
 				case job := <-w.writeCh:
-					err := job.fn(w.db)
+					err := w.run(job)
 					if job.result != nil {
 						job.result <- err
 					}
@@ -756,22 +821,68 @@ func (w *DBWriter) processWrites() {
 	}
 }
 
+// run executes a single job and updates the counters
+func (w *DBWriter) run(job writeJob) error {
+	err := job.fn(w.db)
+	w.processed.Add(1)
+	if err != nil {
+		w.failed.Add(1)
+	}
+	return err
+}
+
+// SetBlocking switches WriteAsync between dropping (default) and waiting up
+// to timeout when the queue is full. Call before the writer is shared.
+func (w *DBWriter) SetBlocking(blocking bool, timeout time.Duration) {
+	w.blocking = blocking
+	w.blockTimeout = timeout
+}
+
 // WriteAsync queues a write operation (fire-and-forget)
 func (w *DBWriter) WriteAsync(fn func(*sql.DB) error) {
+	job := writeJob{fn: fn, result: nil}
 	select {
-	case w.writeCh <- writeJob{fn: fn, result: nil}:
+	case w.writeCh <- job:
+		w.queued.Add(1)
+		return
 	default:
-		fmt.Println("Warning: write queue full, dropping write")
 	}
+
+	if w.blocking {
+		timer := time.NewTimer(w.blockTimeout)
+		defer timer.Stop()
+		select {
+		case w.writeCh <- job:
+			w.queued.Add(1)
+			return
+		case <-timer.C:
+		}
+	}
+
+	dropped := w.dropped.Add(1)
+	slog.Warn("Write queue full, dropping write", "dropped_total", dropped, "queue_capacity", cap(w.writeCh))
 }
 
 // WriteSync queues a write operation and waits for result
 func (w *DBWriter) WriteSync(fn func(*sql.DB) error) error {
 	result := make(chan error, 1)
+	w.queued.Add(1)
 	w.writeCh <- writeJob{fn: fn, result: result}
 	return <-result
 }
 
+// Stats returns a snapshot of the writer's counters and queue usage
+func (w *DBWriter) Stats() DBWriterStats {
+	return DBWriterStats{
+		Queued:        w.queued.Load(),
+		Processed:     w.processed.Load(),
+		Failed:        w.failed.Load(),
+		Dropped:       w.dropped.Load(),
+		QueueDepth:    len(w.writeCh),
+		QueueCapacity: cap(w.writeCh),
+	}
+}
+
 // Close stops the writer and waits for pending writes
 func (w *DBWriter) Close() {
 	close(w.done)
@@ -785,11 +896,23 @@ func (w *DBWriter) GetDB() *sql.DB {
 
 func InitDatabase() (*sql.DB, error) {
 	// Open database with busy_timeout as fallback
-	db, err := sql.Open("sqlite", GetDBPath()+"?_busy_timeout=5000")
+	return openDatabase(GetDBPath() + "?_busy_timeout=5000")
+}
+
+// openDatabase opens the database at dsn and creates or migrates its schema
+func openDatabase(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, err
 	}
 
+	// Let the daily vacuum release free pages incrementally. This only takes
+	// effect on a new database; existing ones are converted by their first
+	// vacuum.
+	if _, err := db.Exec("PRAGMA auto_vacuum=INCREMENTAL"); err != nil {
+		slog.Warn("Failed to set auto_vacuum", "error", err)
+	}
+
 	// Enable WAL mode for better concurrent read access
 	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
 		fmt.Printf("Warning: Failed to enable WAL mode: %v\n", err)
@@ -817,6 +940,9 @@ func InitDatabase() (*sql.DB, error) {
 			load_5 REAL NOT NULL,
 			load_15 REAL NOT NULL,
 			ping_ms REAL,
+			iowait REAL,
+			steal REAL,
+			cpu_peak REAL,
 			created_at TEXT DEFAULT CURRENT_TIMESTAMP
 		);
 		
@@ -834,6 +960,8 @@ func InitDatabase() (*sql.DB, error) {
 			net_tx_total INTEGER NOT NULL,
 			ping_avg REAL,
 			sample_count INTEGER NOT NULL,
+			iowait_avg REAL,
+			steal_avg REAL,
 			UNIQUE(server_id, bucket_start)
 		);
 		
@@ -853,6 +981,8 @@ func InitDatabase() (*sql.DB, error) {
 			net_tx_total INTEGER NOT NULL,
 			ping_avg REAL,
 			sample_count INTEGER NOT NULL,
+			iowait_avg REAL,
+			steal_avg REAL,
 			UNIQUE(server_id, hour_start)
 		);
 		
@@ -871,6 +1001,8 @@ func InitDatabase() (*sql.DB, error) {
 			uptime_percent REAL NOT NULL,
 			ping_avg REAL,
 			sample_count INTEGER NOT NULL,
+			iowait_avg REAL,
+			steal_avg REAL,
 			UNIQUE(server_id, date)
 		);
 		
@@ -1018,6 +1150,13 @@ func InitDatabase() (*sql.DB, error) {
 			ping_sum REAL NOT NULL DEFAULT 0,
 			ping_count INTEGER NOT NULL DEFAULT 0,
 			sample_count INTEGER NOT NULL DEFAULT 0,
+			load1_sum REAL NOT NULL DEFAULT 0,
+			load5_sum REAL NOT NULL DEFAULT 0,
+			load15_sum REAL NOT NULL DEFAULT 0,
+			load_count INTEGER NOT NULL DEFAULT 0,
+			iowait_sum REAL NOT NULL DEFAULT 0,
+			steal_sum REAL NOT NULL DEFAULT 0,
+			cpu_times_count INTEGER NOT NULL DEFAULT 0,
 			PRIMARY KEY (server_id, bucket)
 		) WITHOUT ROWID
 	`)
@@ -1037,10 +1176,43 @@ func InitDatabase() (*sql.DB, error) {
 			ping_sum REAL NOT NULL DEFAULT 0,
 			ping_count INTEGER NOT NULL DEFAULT 0,
 			sample_count INTEGER NOT NULL DEFAULT 0,
+			load1_sum REAL NOT NULL DEFAULT 0,
+			load5_sum REAL NOT NULL DEFAULT 0,
+			load15_sum REAL NOT NULL DEFAULT 0,
+			load_count INTEGER NOT NULL DEFAULT 0,
+			iowait_sum REAL NOT NULL DEFAULT 0,
+			steal_sum REAL NOT NULL DEFAULT 0,
+			cpu_times_count INTEGER NOT NULL DEFAULT 0,
 			PRIMARY KEY (server_id, bucket)
 		) WITHOUT ROWID
 	`)
 
+	// Migration: Add load average columns to the real-time aggregation tables.
+	// Both server samples and agent buckets fill them in; load_count is kept
+	// apart from sample_count so rows written before the migration, which
+	// have samples but no load sums, don't drag the averages towards zero.
+	for _, table := range []string{"metrics_5sec", "metrics_2min"} {
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN load1_sum REAL NOT NULL DEFAULT 0")
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN load5_sum REAL NOT NULL DEFAULT 0")
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN load15_sum REAL NOT NULL DEFAULT 0")
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN load_count INTEGER NOT NULL DEFAULT 0")
+	}
+
+	// Migration: CPU iowait/steal history. Only samples from agents that report
+	// a CPU time breakdown count towards cpu_times_count; metrics_raw keeps
+	// NULL for the others.
+	for _, table := range []string{"metrics_5sec", "metrics_2min"} {
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN iowait_sum REAL NOT NULL DEFAULT 0")
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN steal_sum REAL NOT NULL DEFAULT 0")
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN cpu_times_count INTEGER NOT NULL DEFAULT 0")
+	}
+	db.Exec("ALTER TABLE metrics_raw ADD COLUMN iowait REAL")
+	db.Exec("ALTER TABLE metrics_raw ADD COLUMN steal REAL")
+	// The highest CPU reading within the agent's interval, so the 15min
+	// rollup's cpu_max keeps the peaks the live tables record. NULL for rows
+	// written before it existed.
+	db.Exec("ALTER TABLE metrics_raw ADD COLUMN cpu_peak REAL")
+
 	// New aggregation tables for agent-side aggregation (15min, hourly, daily)
 	db.Exec(`
 		-- 15-minute aggregated metrics (for 7d queries, from agent)
@@ -1057,6 +1229,9 @@ func InitDatabase() (*sql.DB, error) {
 			ping_sum REAL NOT NULL DEFAULT 0,
 			ping_count INTEGER NOT NULL DEFAULT 0,
 			sample_count INTEGER NOT NULL DEFAULT 0,
+			iowait_sum REAL NOT NULL DEFAULT 0,
+			steal_sum REAL NOT NULL DEFAULT 0,
+			cpu_times_count INTEGER NOT NULL DEFAULT 0,
 			PRIMARY KEY (server_id, bucket)
 		) WITHOUT ROWID
 	`)
@@ -1076,6 +1251,9 @@ func InitDatabase() (*sql.DB, error) {
 			ping_sum REAL NOT NULL DEFAULT 0,
 			ping_count INTEGER NOT NULL DEFAULT 0,
 			sample_count INTEGER NOT NULL DEFAULT 0,
+			iowait_sum REAL NOT NULL DEFAULT 0,
+			steal_sum REAL NOT NULL DEFAULT 0,
+			cpu_times_count INTEGER NOT NULL DEFAULT 0,
 			PRIMARY KEY (server_id, bucket)
 		) WITHOUT ROWID
 	`)
@@ -1095,10 +1273,30 @@ func InitDatabase() (*sql.DB, error) {
 			ping_sum REAL NOT NULL DEFAULT 0,
 			ping_count INTEGER NOT NULL DEFAULT 0,
 			sample_count INTEGER NOT NULL DEFAULT 0,
+			iowait_sum REAL NOT NULL DEFAULT 0,
+			steal_sum REAL NOT NULL DEFAULT 0,
+			cpu_times_count INTEGER NOT NULL DEFAULT 0,
 			PRIMARY KEY (server_id, bucket)
 		) WITHOUT ROWID
 	`)
 
+	// Migration: iowait/steal in the agent rollups, summed like the live
+	// tables. Buckets from agents that don't send them keep cpu_times_count 0.
+	for _, table := range []string{"metrics_15min_agg", "metrics_hourly_agg", "metrics_daily_agg"} {
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN iowait_sum REAL NOT NULL DEFAULT 0")
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN steal_sum REAL NOT NULL DEFAULT 0")
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN cpu_times_count INTEGER NOT NULL DEFAULT 0")
+	}
+
+	// Migration: iowait/steal averages in the server rollups, NULL where no
+	// sample had a CPU time breakdown
+	db.Exec("ALTER TABLE metrics_15min ADD COLUMN iowait_avg REAL")
+	db.Exec("ALTER TABLE metrics_15min ADD COLUMN steal_avg REAL")
+	db.Exec("ALTER TABLE metrics_hourly ADD COLUMN iowait_avg REAL")
+	db.Exec("ALTER TABLE metrics_hourly ADD COLUMN steal_avg REAL")
+	db.Exec("ALTER TABLE metrics_daily ADD COLUMN iowait_avg REAL")
+	db.Exec("ALTER TABLE metrics_daily ADD COLUMN steal_avg REAL")
+
 	db.Exec(`
 		-- 5-second aggregated ping metrics (for 1h queries)
 		CREATE TABLE IF NOT EXISTS ping_5sec (
@@ -1180,6 +1378,156 @@ func InitDatabase() (*sql.DB, error) {
 		) WITHOUT ROWID
 	`)
 
+	db.Exec(`
+		-- Outage windows recorded when a server flips offline (for SLA reporting)
+		CREATE TABLE IF NOT EXISTS outages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			server_id TEXT NOT NULL,
+			start_time TEXT NOT NULL,
+			end_time TEXT
+		)
+	`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_outages_server_start ON outages(server_id, start_time)")
+
+	db.Exec(`
+		-- Refresh tokens (SHA-256 hashes), used to renew short-lived access tokens
+		CREATE TABLE IF NOT EXISTS refresh_tokens (
+			token_hash TEXT NOT NULL PRIMARY KEY,
+			sub TEXT NOT NULL,
+			provider TEXT NOT NULL,
+			expires_at INTEGER NOT NULL
+		) WITHOUT ROWID
+	`)
+
+	db.Exec(`
+		-- Revoked JWT IDs (logout), kept until the token would have expired
+		CREATE TABLE IF NOT EXISTS revoked_tokens (
+			jti TEXT NOT NULL PRIMARY KEY,
+			expires_at INTEGER NOT NULL
+		) WITHOUT ROWID
+	`)
+
+	db.Exec(`
+		-- Renewal date last reported per server, so restarts don't repeat it
+		CREATE TABLE IF NOT EXISTS renewal_reminders (
+			server_id TEXT NOT NULL PRIMARY KEY,
+			renewal_date TEXT NOT NULL
+		) WITHOUT ROWID
+	`)
+
+	db.Exec(`
+		-- Administrative actions (who changed what)
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp TEXT NOT NULL,
+			actor TEXT NOT NULL,
+			ip TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			detail TEXT
+		)
+	`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_audit_log_timestamp ON audit_log(timestamp)")
+
+	db.Exec(`
+		-- Per-core CPU usage (only written when per_core_history is enabled, keep for 24 hours)
+		CREATE TABLE IF NOT EXISTS cpu_core_raw (
+			server_id TEXT NOT NULL,
+			timestamp TEXT NOT NULL,
+			core_index INTEGER NOT NULL,
+			usage REAL NOT NULL,
+			PRIMARY KEY (server_id, timestamp, core_index)
+		) WITHOUT ROWID
+	`)
+
+	db.Exec(`
+		-- Custom agent metrics (only keys listed in custom_history_keys, keep for 24 hours)
+		CREATE TABLE IF NOT EXISTS custom_metric_raw (
+			server_id TEXT NOT NULL,
+			timestamp TEXT NOT NULL,
+			key TEXT NOT NULL,
+			value REAL NOT NULL,
+			PRIMARY KEY (server_id, key, timestamp)
+		) WITHOUT ROWID
+	`)
+
+	db.Exec(`
+		-- Peak value of each metric per server, all-time (period 'all') and per
+		-- month (period 'YYYY-MM'), with the sample time it was reached
+		CREATE TABLE IF NOT EXISTS metrics_records (
+			server_id TEXT NOT NULL,
+			period TEXT NOT NULL,
+			metric TEXT NOT NULL,
+			value REAL NOT NULL,
+			timestamp TEXT NOT NULL,
+			PRIMARY KEY (server_id, period, metric)
+		) WITHOUT ROWID
+	`)
+
+	db.Exec(`
+		-- Listening sockets reported by agents with report_listening_ports.
+		-- closed_at is NULL while the port is still open.
+		CREATE TABLE IF NOT EXISTS listening_ports (
+			server_id TEXT NOT NULL,
+			proto TEXT NOT NULL,
+			address TEXT NOT NULL,
+			port INTEGER NOT NULL,
+			process TEXT NOT NULL DEFAULT '',
+			first_seen TEXT NOT NULL,
+			closed_at TEXT,
+			PRIMARY KEY (server_id, proto, address, port)
+		) WITHOUT ROWID
+	`)
+
+	db.Exec(`
+		-- Monthly network traffic per server (reset-aware, from hourly counters)
+		CREATE TABLE IF NOT EXISTS traffic_monthly (
+			server_id TEXT NOT NULL,
+			month TEXT NOT NULL,
+			rx_bytes INTEGER NOT NULL DEFAULT 0,
+			tx_bytes INTEGER NOT NULL DEFAULT 0,
+			updated_at TEXT NOT NULL,
+			PRIMARY KEY (server_id, month)
+		) WITHOUT ROWID
+	`)
+
+	db.Exec(`
+		-- API keys for programmatic access (SHA-256 hashes only)
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id TEXT NOT NULL PRIMARY KEY,
+			key_hash TEXT NOT NULL UNIQUE,
+			label TEXT NOT NULL,
+			scope TEXT NOT NULL,
+			created_at TEXT NOT NULL
+		)
+	`)
+
+	db.Exec(`
+		-- Named logins besides the built-in admin password (bcrypt hashes).
+		-- github/google map an OAuth identity to the user.
+		CREATE TABLE IF NOT EXISTS users (
+			id TEXT NOT NULL PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			password_hash TEXT NOT NULL DEFAULT '',
+			role TEXT NOT NULL,
+			github TEXT NOT NULL DEFAULT '',
+			google TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL
+		)
+	`)
+
+	db.Exec(`
+		-- Agent connect/disconnect history (keep for 30 days)
+		CREATE TABLE IF NOT EXISTS connection_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			server_id TEXT NOT NULL,
+			event TEXT NOT NULL,
+			timestamp TEXT NOT NULL,
+			remote_ip TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_connection_events_server_time ON connection_events(server_id, timestamp)")
+
 	// Run ANALYZE in background to avoid slow startup
 	go func() {
 		time.Sleep(10 * time.Second) // Wait for server to fully start
@@ -1189,6 +1537,30 @@ func InitDatabase() (*sql.DB, error) {
 	return db, nil
 }
 
+// ReadPoolSize is how many read-only connections serve history queries
+const ReadPoolSize = 4
+
+// OpenReadDB opens a small pool of read-only connections to the database
+// for the history queries. With WAL they read the last committed state
+// without waiting for the writer, and a burst of dashboard queries can't
+// take connections the write path needs. Call it after InitDatabase.
+func OpenReadDB() (*sql.DB, error) {
+	// modernc.org/sqlite only honours mode=ro on file: URIs, and sets the
+	// busy timeout through _pragma
+	dsn := "file:" + (&url.URL{Path: GetDBPath()}).EscapedPath() + "?mode=ro&_pragma=busy_timeout(5000)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(ReadPoolSize)
+	db.SetMaxIdleConns(ReadPoolSize)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
 // StoreMetricsAsync queues metrics storage (fire-and-forget)
 func StoreMetricsAsync(serverID string, metrics *SystemMetrics) {
 	if dbWriter == nil {
@@ -1260,6 +1632,7 @@ func StoreAggregatedMetrics(serverID string, agg *common.AggregatedMetrics) bool
 // StoreMultiGranularityMetrics stores pre-aggregated metrics at multiple granularities from agent
 // Uses buffered writes for better performance
 func StoreMultiGranularityMetrics(serverID string, granularities []common.GranularityData) bool {
+	granularities = dropFutureBuckets(serverID, granularities)
 	if len(granularities) == 0 {
 		return false
 	}
@@ -1310,8 +1683,8 @@ func storeMultiGranularityMetricsInternal(db *sql.DB, serverID string, granulari
 		// Store metrics buckets
 		for _, m := range g.Metrics {
 			db.Exec(`
-				INSERT INTO `+metricsTable+` (server_id, bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count)
-				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				INSERT INTO `+metricsTable+` (server_id, bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count, iowait_sum, steal_sum, cpu_times_count)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 				ON CONFLICT(server_id, bucket) DO UPDATE SET
 					cpu_sum = excluded.cpu_sum,
 					cpu_max = MAX(cpu_max, excluded.cpu_max),
@@ -1322,7 +1695,10 @@ func storeMultiGranularityMetricsInternal(db *sql.DB, serverID string, granulari
 					net_tx = MAX(net_tx, excluded.net_tx),
 					ping_sum = excluded.ping_sum,
 					ping_count = excluded.ping_count,
-					sample_count = excluded.sample_count`,
+					sample_count = excluded.sample_count,
+					iowait_sum = excluded.iowait_sum,
+					steal_sum = excluded.steal_sum,
+					cpu_times_count = excluded.cpu_times_count`,
 				serverID, m.Bucket,
 				m.CPUSum, m.CPUMax,
 				m.MemorySum, m.MemoryMax,
@@ -1330,6 +1706,7 @@ func storeMultiGranularityMetricsInternal(db *sql.DB, serverID string, granulari
 				m.NetRx, m.NetTx,
 				m.PingSum, m.PingCount,
 				m.SampleCount,
+				m.IOWaitSum, m.StealSum, m.CPUTimesCount,
 			)
 		}
 
@@ -1423,8 +1800,8 @@ func storeAggregatedMetricsInternal(db *sql.DB, serverID string, agg *common.Agg
 	
 	// Store in 2-minute aggregation table
 	_, err = db.Exec(`
-		INSERT INTO metrics_2min (server_id, bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO metrics_2min (server_id, bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count, load1_sum, load5_sum, load15_sum, load_count, iowait_sum, steal_sum, cpu_times_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(server_id, bucket) DO UPDATE SET
 			cpu_sum = cpu_sum + excluded.cpu_sum,
 			cpu_max = MAX(cpu_max, excluded.cpu_max),
@@ -1435,7 +1812,14 @@ func storeAggregatedMetricsInternal(db *sql.DB, serverID string, agg *common.Agg
 			net_tx = MAX(net_tx, excluded.net_tx),
 			ping_sum = ping_sum + excluded.ping_sum,
 			ping_count = ping_count + excluded.ping_count,
-			sample_count = sample_count + excluded.sample_count`,
+			sample_count = sample_count + excluded.sample_count,
+			load1_sum = load1_sum + excluded.load1_sum,
+			load5_sum = load5_sum + excluded.load5_sum,
+			load15_sum = load15_sum + excluded.load15_sum,
+			load_count = load_count + excluded.load_count,
+			iowait_sum = iowait_sum + excluded.iowait_sum,
+			steal_sum = steal_sum + excluded.steal_sum,
+			cpu_times_count = cpu_times_count + excluded.cpu_times_count`,
 		serverID, bucket2min,
 		float64(agg.CPUAvg)*float64(agg.SampleCount), float64(agg.CPUMax),
 		float64(agg.MemoryAvg)*float64(agg.SampleCount), float64(agg.MemoryMax),
@@ -1443,6 +1827,9 @@ func storeAggregatedMetricsInternal(db *sql.DB, serverID string, agg *common.Agg
 		agg.NetRxMax, agg.NetTxMax,
 		0.0, 0, // ping values (if available)
 		agg.SampleCount,
+		agg.LoadOneAvg*float64(agg.SampleCount), agg.LoadFiveAvg*float64(agg.SampleCount), agg.LoadFifteenAvg*float64(agg.SampleCount),
+		agg.SampleCount,
+		0.0, 0.0, 0, // Agent buckets carry no CPU time breakdown
 	)
 	if err != nil {
 		return err
@@ -1469,7 +1856,30 @@ func StoreMetrics(db *sql.DB, serverID string, metrics *SystemMetrics) error {
 	return storeMetricsInternal(db, serverID, metrics)
 }
 
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// storeMetricsInternal stores one sample in a single transaction: the raw row,
+// both bucket upserts and the ping rows share a single commit (and WAL sync)
+// instead of one each
 func storeMetricsInternal(db *sql.DB, serverID string, metrics *SystemMetrics) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := storeMetricsWith(tx, serverID, metrics); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// storeMetricsWith writes one sample's rows through tx, which may also be a
+// plain *sql.DB (one commit per statement)
+func storeMetricsWith(tx sqlExecer, serverID string, metrics *SystemMetrics) error {
 	var diskUsage float32 = 0
 	if len(metrics.Disks) > 0 {
 		diskUsage = metrics.Disks[0].UsagePercent
@@ -1498,10 +1908,12 @@ func storeMetricsInternal(db *sql.DB, serverID string, metrics *SystemMetrics) e
 		}
 	}
 
+	iowait, steal, timesCount := cpuStallTimes(metrics)
+
 	// Insert raw data (for debugging and fallback)
-	_, err := db.Exec(`
-		INSERT INTO metrics_raw (server_id, timestamp, cpu_usage, memory_usage, disk_usage, net_rx, net_tx, load_1, load_5, load_15, ping_ms, bucket_5min, bucket_5sec)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+	_, err := tx.Exec(`
+		INSERT INTO metrics_raw (server_id, timestamp, cpu_usage, memory_usage, disk_usage, net_rx, net_tx, load_1, load_5, load_15, ping_ms, bucket_5min, bucket_5sec, iowait, steal, cpu_peak)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		serverID,
 		timestamp,
 		metrics.CPU.Usage,
@@ -1515,6 +1927,9 @@ func storeMetricsInternal(db *sql.DB, serverID string, metrics *SystemMetrics) e
 		pingMs,
 		bucket5min,
 		bucket5sec,
+		iowait,
+		steal,
+		metrics.CPU.PeakUsage(),
 	)
 	if err != nil {
 		return err
@@ -1527,9 +1942,9 @@ func storeMetricsInternal(db *sql.DB, serverID string, metrics *SystemMetrics) e
 		pingVal = *pingMs
 		pingCnt = 1
 	}
-	db.Exec(`
-		INSERT INTO metrics_5sec (server_id, bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
+	tx.Exec(`
+		INSERT INTO metrics_5sec (server_id, bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count, load1_sum, load5_sum, load15_sum, load_count, iowait_sum, steal_sum, cpu_times_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?, ?, 1, ?, ?, ?)
 		ON CONFLICT(server_id, bucket) DO UPDATE SET
 			cpu_sum = cpu_sum + excluded.cpu_sum,
 			cpu_max = MAX(cpu_max, excluded.cpu_max),
@@ -1540,19 +1955,28 @@ func storeMetricsInternal(db *sql.DB, serverID string, metrics *SystemMetrics) e
 			net_tx = MAX(net_tx, excluded.net_tx),
 			ping_sum = ping_sum + excluded.ping_sum,
 			ping_count = ping_count + excluded.ping_count,
-			sample_count = sample_count + 1`,
+			sample_count = sample_count + 1,
+			load1_sum = load1_sum + excluded.load1_sum,
+			load5_sum = load5_sum + excluded.load5_sum,
+			load15_sum = load15_sum + excluded.load15_sum,
+			load_count = load_count + excluded.load_count,
+			iowait_sum = iowait_sum + excluded.iowait_sum,
+			steal_sum = steal_sum + excluded.steal_sum,
+			cpu_times_count = cpu_times_count + excluded.cpu_times_count`,
 		serverID, bucket5sec,
-		float64(metrics.CPU.Usage), float64(metrics.CPU.Usage),
+		float64(metrics.CPU.Usage), float64(metrics.CPU.PeakUsage()),
 		float64(metrics.Memory.UsagePercent), float64(metrics.Memory.UsagePercent),
 		float64(diskUsage),
 		metrics.Network.TotalRx, metrics.Network.TotalTx,
 		pingVal, pingCnt,
+		metrics.LoadAverage.One, metrics.LoadAverage.Five, metrics.LoadAverage.Fifteen,
+		iowait.Float64, steal.Float64, timesCount,
 	)
 
 	// UPSERT to 2-minute aggregation table (for 24h queries)
-	db.Exec(`
-		INSERT INTO metrics_2min (server_id, bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
+	tx.Exec(`
+		INSERT INTO metrics_2min (server_id, bucket, cpu_sum, cpu_max, memory_sum, memory_max, disk_sum, net_rx, net_tx, ping_sum, ping_count, sample_count, load1_sum, load5_sum, load15_sum, load_count, iowait_sum, steal_sum, cpu_times_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?, ?, 1, ?, ?, ?)
 		ON CONFLICT(server_id, bucket) DO UPDATE SET
 			cpu_sum = cpu_sum + excluded.cpu_sum,
 			cpu_max = MAX(cpu_max, excluded.cpu_max),
@@ -1563,20 +1987,39 @@ func storeMetricsInternal(db *sql.DB, serverID string, metrics *SystemMetrics) e
 			net_tx = MAX(net_tx, excluded.net_tx),
 			ping_sum = ping_sum + excluded.ping_sum,
 			ping_count = ping_count + excluded.ping_count,
-			sample_count = sample_count + 1`,
+			sample_count = sample_count + 1,
+			load1_sum = load1_sum + excluded.load1_sum,
+			load5_sum = load5_sum + excluded.load5_sum,
+			load15_sum = load15_sum + excluded.load15_sum,
+			load_count = load_count + excluded.load_count,
+			iowait_sum = iowait_sum + excluded.iowait_sum,
+			steal_sum = steal_sum + excluded.steal_sum,
+			cpu_times_count = cpu_times_count + excluded.cpu_times_count`,
 		serverID, bucket5min,
-		float64(metrics.CPU.Usage), float64(metrics.CPU.Usage),
+		float64(metrics.CPU.Usage), float64(metrics.CPU.PeakUsage()),
 		float64(metrics.Memory.UsagePercent), float64(metrics.Memory.UsagePercent),
 		float64(diskUsage),
 		metrics.Network.TotalRx, metrics.Network.TotalTx,
 		pingVal, pingCnt,
+		metrics.LoadAverage.One, metrics.LoadAverage.Five, metrics.LoadAverage.Fifteen,
+		iowait.Float64, steal.Float64, timesCount,
 	)
 
+	if err := storeCoreUsage(tx, serverID, timestamp, metrics.CPU.PerCore); err != nil {
+		return err
+	}
+	if err := storeCustomMetrics(tx, serverID, timestamp, metrics.Custom); err != nil {
+		return err
+	}
+	if err := storeMetricRecords(tx, serverID, metrics); err != nil {
+		return err
+	}
+
 	// Store individual ping targets
 	if metrics.Ping != nil {
 		for _, target := range metrics.Ping.Targets {
 			// Insert raw ping data
-			db.Exec(`
+			tx.Exec(`
 				INSERT INTO ping_raw (server_id, timestamp, target_name, target_host, latency_ms, packet_loss, status, bucket_5min, bucket_5sec)
 				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 				serverID, timestamp, target.Name, target.Host,
@@ -1602,7 +2045,7 @@ func storeMetricsInternal(db *sql.DB, serverID string, metrics *SystemMetrics) e
 			}
 
 			// UPSERT to ping_5sec (for 1h queries)
-			db.Exec(`
+			tx.Exec(`
 				INSERT INTO ping_5sec (server_id, bucket, target_name, target_host, latency_sum, latency_max, latency_count, ok_count, fail_count)
 				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 				ON CONFLICT(server_id, target_name, bucket) DO UPDATE SET
@@ -1617,7 +2060,7 @@ func storeMetricsInternal(db *sql.DB, serverID string, metrics *SystemMetrics) e
 			)
 
 			// UPSERT to ping_2min (for 24h queries)
-			db.Exec(`
+			tx.Exec(`
 				INSERT INTO ping_2min (server_id, bucket, target_name, target_host, latency_sum, latency_max, latency_count, ok_count, fail_count)
 				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 				ON CONFLICT(server_id, target_name, bucket) DO UPDATE SET
@@ -1650,22 +2093,29 @@ func aggregate15MinInternal(db *sql.DB) error {
 	// Round down to the previous 15-minute boundary
 	minuteOffset := now.Minute() % 15
 	bucketEnd := now.Add(-time.Duration(minuteOffset) * time.Minute).Truncate(time.Minute)
-	bucketStart := bucketEnd.Add(-15 * time.Minute)
+	return aggregate15MinWindow(db, bucketEnd.Add(-15*time.Minute))
+}
+
+// aggregate15MinWindow (re)computes the 15-minute bucket starting at bucketStart
+func aggregate15MinWindow(db *sql.DB, bucketStart time.Time) error {
+	bucketEnd := bucketStart.Add(15 * time.Minute)
 
 	_, err := db.Exec(`
-		INSERT OR REPLACE INTO metrics_15min (server_id, bucket_start, cpu_avg, cpu_max, memory_avg, memory_max, disk_avg, net_rx_total, net_tx_total, ping_avg, sample_count)
+		INSERT OR REPLACE INTO metrics_15min (server_id, bucket_start, cpu_avg, cpu_max, memory_avg, memory_max, disk_avg, net_rx_total, net_tx_total, ping_avg, sample_count, iowait_avg, steal_avg)
 		SELECT 
 			server_id,
 			? as bucket_start,
 			AVG(cpu_usage),
-			MAX(cpu_usage),
+			MAX(COALESCE(cpu_peak, cpu_usage)),
 			AVG(memory_usage),
 			MAX(memory_usage),
 			AVG(disk_usage),
 			MAX(net_rx) - MIN(net_rx),
 			MAX(net_tx) - MIN(net_tx),
 			AVG(ping_ms),
-			COUNT(*)
+			COUNT(*),
+			AVG(iowait),
+			AVG(steal)
 		FROM metrics_raw
 		WHERE timestamp >= ? AND timestamp < ?
 		GROUP BY server_id`,
@@ -1707,11 +2157,17 @@ func AggregateHourly(db *sql.DB) error {
 }
 
 func aggregateHourlyInternal(db *sql.DB) error {
-	hourAgo := time.Now().UTC().Add(-time.Hour)
-	hourStart := hourAgo.Format("2006-01-02T15:00:00Z")
+	return aggregateHourlyWindow(db, time.Now().UTC().Add(-time.Hour).Truncate(time.Hour))
+}
+
+// aggregateHourlyWindow (re)computes the hourly bucket starting at start from
+// the 15-minute buckets
+func aggregateHourlyWindow(db *sql.DB, start time.Time) error {
+	hourStart := start.Format(time.RFC3339)
+	hourEnd := start.Add(time.Hour).Format(time.RFC3339)
 
 	_, err := db.Exec(`
-		INSERT OR REPLACE INTO metrics_hourly (server_id, hour_start, cpu_avg, cpu_max, memory_avg, memory_max, disk_avg, net_rx_total, net_tx_total, ping_avg, sample_count)
+		INSERT OR REPLACE INTO metrics_hourly (server_id, hour_start, cpu_avg, cpu_max, memory_avg, memory_max, disk_avg, net_rx_total, net_tx_total, ping_avg, sample_count, iowait_avg, steal_avg)
 		SELECT 
 			server_id,
 			strftime('%Y-%m-%dT%H:00:00Z', bucket_start) as hour,
@@ -1723,10 +2179,12 @@ func aggregateHourlyInternal(db *sql.DB) error {
 			SUM(net_rx_total),
 			SUM(net_tx_total),
 			AVG(ping_avg),
-			SUM(sample_count)
+			SUM(sample_count),
+			AVG(iowait_avg),
+			AVG(steal_avg)
 		FROM metrics_15min
-		WHERE bucket_start >= ? AND bucket_start < datetime(?, '+1 hour')
-		GROUP BY server_id, hour`, hourStart, hourStart)
+		WHERE bucket_start >= ? AND bucket_start < ?
+		GROUP BY server_id, hour`, hourStart, hourEnd)
 	if err != nil {
 		return err
 	}
@@ -1746,8 +2204,8 @@ func aggregateHourlyInternal(db *sql.DB) error {
 			SUM(fail_count),
 			SUM(sample_count)
 		FROM ping_15min
-		WHERE bucket_start >= ? AND bucket_start < datetime(?, '+1 hour')
-		GROUP BY server_id, target_name, target_host, hour`, hourStart, hourStart)
+		WHERE bucket_start >= ? AND bucket_start < ?
+		GROUP BY server_id, target_name, target_host, hour`, hourStart, hourEnd)
 	return err
 }
 
@@ -1759,10 +2217,14 @@ func AggregateDaily(db *sql.DB) error {
 }
 
 func aggregateDailyInternal(db *sql.DB) error {
-	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	return aggregateDailyWindow(db, time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02"))
+}
 
+// aggregateDailyWindow (re)computes the daily bucket for day (YYYY-MM-DD) from
+// the hourly buckets
+func aggregateDailyWindow(db *sql.DB, day string) error {
 	_, err := db.Exec(`
-		INSERT OR REPLACE INTO metrics_daily (server_id, date, cpu_avg, cpu_max, memory_avg, memory_max, disk_avg, net_rx_total, net_tx_total, uptime_percent, sample_count)
+		INSERT OR REPLACE INTO metrics_daily (server_id, date, cpu_avg, cpu_max, memory_avg, memory_max, disk_avg, net_rx_total, net_tx_total, uptime_percent, sample_count, iowait_avg, steal_avg)
 		SELECT 
 			server_id,
 			date(hour_start) as day,
@@ -1774,10 +2236,12 @@ func aggregateDailyInternal(db *sql.DB) error {
 			SUM(net_rx_total),
 			SUM(net_tx_total),
 			(COUNT(*) * 100.0 / 24.0),
-			SUM(sample_count)
+			SUM(sample_count),
+			AVG(iowait_avg),
+			AVG(steal_avg)
 		FROM metrics_hourly
 		WHERE date(hour_start) = ?
-		GROUP BY server_id, day`, yesterday)
+		GROUP BY server_id, day`, day)
 	if err != nil {
 		return err
 	}
@@ -1797,10 +2261,94 @@ func aggregateDailyInternal(db *sql.DB) error {
 			SUM(sample_count)
 		FROM ping_hourly
 		WHERE date(hour_start) = ?
-		GROUP BY server_id, target_name, target_host, day`, yesterday)
+		GROUP BY server_id, target_name, target_host, day`, day)
 	return err
 }
 
+// AggregateRange recomputes the 15-minute, hourly and daily buckets covering
+// [from, to). The periodic aggregation only looks at the most recent window, so
+// this is used to pick up metrics that arrive late, e.g. replayed batches from
+// an agent that was offline, or imported history. Every UTC day is a separate
+// write, so agent samples queue behind one day's rebuild at most.
+func AggregateRange(db *sql.DB, from, to time.Time) error {
+	from, to = from.UTC(), to.UTC()
+	for start := from; start.Before(to); {
+		end := time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, time.UTC)
+		if end.After(to) {
+			end = to
+		}
+		chunkFrom, chunkTo := start, end
+		var err error
+		if dbWriter != nil {
+			err = dbWriter.WriteSync(func(db *sql.DB) error {
+				return aggregateRangeInternal(db, chunkFrom, chunkTo)
+			})
+		} else {
+			err = aggregateRangeInternal(db, chunkFrom, chunkTo)
+		}
+		if err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+func aggregateRangeInternal(db *sql.DB, from, to time.Time) error {
+	from = from.UTC()
+	to = to.UTC()
+
+	// Each level is built from the one below, so finish them in order
+	for t := from.Truncate(15 * time.Minute); t.Before(to); t = t.Add(15 * time.Minute) {
+		if err := aggregate15MinWindow(db, t); err != nil {
+			return err
+		}
+	}
+	for t := from.Truncate(time.Hour); t.Before(to); t = t.Add(time.Hour) {
+		if err := aggregateHourlyWindow(db, t); err != nil {
+			return err
+		}
+	}
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if err := aggregateDailyWindow(db, day.Format("2006-01-02")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aggregationTables lists the rollup tables with an SQL expression for their
+// newest bucket as an RFC3339 timestamp
+var aggregationTables = []struct {
+	table, newest, source string
+}{
+	{"metrics_15min", "MAX(bucket_start)", "server"},
+	{"metrics_hourly", "MAX(hour_start)", "server"},
+	{"metrics_daily", "MAX(date) || 'T00:00:00Z'", "server"},
+	{"metrics_15min_agg", "strftime('%Y-%m-%dT%H:%M:%SZ', MAX(bucket) * 900, 'unixepoch')", "agent"},
+	{"metrics_hourly_agg", "strftime('%Y-%m-%dT%H:%M:%SZ', MAX(bucket) * 3600, 'unixepoch')", "agent"},
+	{"metrics_daily_agg", "strftime('%Y-%m-%dT%H:%M:%SZ', MAX(bucket) * 86400, 'unixepoch')", "agent"},
+}
+
+// GetAggregationTableStats returns the row count and newest bucket of each
+// rollup table
+func GetAggregationTableStats(db *sql.DB) ([]AggregationTableStatus, error) {
+	stats := make([]AggregationTableStatus, 0, len(aggregationTables))
+	for _, t := range aggregationTables {
+		status := AggregationTableStatus{Table: t.table, Source: t.source}
+		var newest sql.NullString
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*), %s FROM %s", t.newest, t.table)).Scan(&status.Rows, &newest); err != nil {
+			return nil, err
+		}
+		if newest.Valid {
+			status.NewestBucket = newest.String
+		}
+		stats = append(stats, status)
+	}
+	return stats, nil
+}
+
 func CleanupOldData(db *sql.DB) error {
 	if dbWriter != nil {
 		return dbWriter.WriteSync(cleanupOldDataInternal)
@@ -1855,88 +2403,893 @@ func cleanupOldDataInternal(db *sql.DB) error {
 	db.Exec("DELETE FROM metrics_hourly WHERE hour_start < ?", cutoffHourly)
 	db.Exec("DELETE FROM ping_hourly WHERE hour_start < ?", cutoffHourly)
 
+	// Delete per-core CPU samples older than 24 hours
+	db.Exec("DELETE FROM cpu_core_raw WHERE timestamp < ?", cutoffRaw)
+
+	// Delete custom metric samples older than 24 hours
+	db.Exec("DELETE FROM custom_metric_raw WHERE timestamp < ?", cutoffRaw)
+
+	// Delete agent connection events older than 30 days
+	cutoffConnections := time.Now().UTC().AddDate(0, 0, -30).Format(time.RFC3339)
+	db.Exec("DELETE FROM connection_events WHERE timestamp < ?", cutoffConnections)
+	db.Exec("DELETE FROM listening_ports WHERE closed_at < ?", cutoffConnections)
+
+	// Delete closed outage windows older than 400 days
+	cutoffOutages := time.Now().UTC().Add(-400 * 24 * time.Hour).Format(time.RFC3339)
+	db.Exec("DELETE FROM outages WHERE end_time IS NOT NULL AND end_time < ?", cutoffOutages)
+
+	// Delete audit entries past their retention
+	cutoffAudit := time.Now().UTC().Add(-AuditLogRetention).Format(time.RFC3339)
+	db.Exec("DELETE FROM audit_log WHERE timestamp < ?", cutoffAudit)
+
+	// Delete revoked token entries whose tokens have expired anyway
+	db.Exec("DELETE FROM revoked_tokens WHERE expires_at < ?", time.Now().Unix())
+	db.Exec("DELETE FROM refresh_tokens WHERE expires_at < ?", time.Now().Unix())
+
 	// Update query planner statistics after cleanup
 	db.Exec("ANALYZE")
 
 	return nil
 }
 
-func GetHistory(db *sql.DB, serverID, rangeStr string) ([]HistoryPoint, error) {
-	return GetHistorySince(db, serverID, rangeStr, 0)
+// ============================================================================
+// Token Revocation
+// ============================================================================
+
+// StoreRevokedToken persists a revoked JWT ID until its expiry
+func StoreRevokedToken(jti string, expiresAt time.Time) {
+	if dbWriter == nil {
+		return
+	}
+	dbWriter.WriteAsync(func(db *sql.DB) error {
+		_, err := db.Exec("INSERT OR REPLACE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)", jti, expiresAt.Unix())
+		return err
+	})
 }
 
-// GetHistorySince returns history data since a specific bucket (for incremental queries)
-func GetHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int64) ([]HistoryPoint, error) {
-	var data []HistoryPoint
-	var rows *sql.Rows
-	var err error
+// LoadRevokedTokens returns all unexpired revoked JWT IDs with their expiry
+func LoadRevokedTokens(db *sql.DB) (map[string]time.Time, error) {
+	rows, err := db.Query("SELECT jti, expires_at FROM revoked_tokens WHERE expires_at >= ?", time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	switch rangeStr {
-	case "1h":
-		// Read directly from pre-aggregated 5-second table (no GROUP BY needed!)
-		cutoffBucket := time.Now().UTC().Add(-time.Hour).Unix() / 5
-		if sinceBucket > cutoffBucket {
-			cutoffBucket = sinceBucket
+	tokens := make(map[string]time.Time)
+	for rows.Next() {
+		var jti string
+		var expiresAt int64
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			continue
 		}
-		rows, err = db.Query(`
-			SELECT 
-				strftime('%Y-%m-%dT%H:%M:%SZ', bucket * 5, 'unixepoch') as timestamp,
-				CASE WHEN sample_count > 0 THEN cpu_sum / sample_count ELSE 0 END as cpu_usage,
-				CASE WHEN sample_count > 0 THEN memory_sum / sample_count ELSE 0 END as memory_usage,
-				CASE WHEN sample_count > 0 THEN disk_sum / sample_count ELSE 0 END as disk_usage,
-				net_rx,
-				net_tx,
-				CASE WHEN ping_count > 0 THEN ping_sum / ping_count ELSE NULL END as ping_ms,
-				bucket
-			FROM metrics_5sec 
-			WHERE server_id = ? AND bucket >= ?
-			ORDER BY bucket ASC
-			LIMIT 720`, serverID, cutoffBucket)
+		tokens[jti] = time.Unix(expiresAt, 0)
+	}
+	return tokens, nil
+}
 
-	case "24h":
-		// Read directly from pre-aggregated 2-minute table (no GROUP BY needed!)
-		cutoffBucket := time.Now().UTC().Add(-24*time.Hour).Unix() / 120
-		if sinceBucket > cutoffBucket {
-			cutoffBucket = sinceBucket
-		}
-		rows, err = db.Query(`
-			SELECT 
-				strftime('%Y-%m-%dT%H:%M:%SZ', bucket * 120, 'unixepoch') as timestamp,
-				CASE WHEN sample_count > 0 THEN cpu_sum / sample_count ELSE 0 END as cpu_usage,
-				CASE WHEN sample_count > 0 THEN memory_sum / sample_count ELSE 0 END as memory_usage,
-				CASE WHEN sample_count > 0 THEN disk_sum / sample_count ELSE 0 END as disk_usage,
-				net_rx,
-				net_tx,
-				CASE WHEN ping_count > 0 THEN ping_sum / ping_count ELSE NULL END as ping_ms,
-				bucket
-			FROM metrics_2min 
-			WHERE server_id = ? AND bucket >= ?
-			ORDER BY bucket ASC
-			LIMIT 720`, serverID, cutoffBucket)
+// StoreRenewalReminder records that a server's renewal date was reported
+func StoreRenewalReminder(serverID, renewalDate string) {
+	if dbWriter == nil {
+		return
+	}
+	dbWriter.WriteAsync(func(db *sql.DB) error {
+		_, err := db.Exec("INSERT OR REPLACE INTO renewal_reminders (server_id, renewal_date) VALUES (?, ?)", serverID, renewalDate)
+		return err
+	})
+}
 
-	case "7d":
-		// 7d with 15-min buckets (672 points max) - try agent-aggregated data first
-		cutoffBucket := time.Now().UTC().Add(-7*24*time.Hour).Unix() / 900
-		var count int
-		db.QueryRow(`SELECT COUNT(*) FROM metrics_15min_agg WHERE server_id = ? AND bucket >= ?`,
-			serverID, cutoffBucket).Scan(&count)
+// LoadRenewalReminders returns the renewal date last reported per server
+func LoadRenewalReminders(db *sql.DB) (map[string]string, error) {
+	rows, err := db.Query("SELECT server_id, renewal_date FROM renewal_reminders")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		if count > 0 {
-			// Use agent-aggregated 15-min data
-			rows, err = db.Query(`
-				SELECT 
-					strftime('%Y-%m-%dT%H:%M:%SZ', bucket * 900, 'unixepoch') as timestamp,
-					CASE WHEN sample_count > 0 THEN cpu_sum / sample_count ELSE 0 END as cpu_usage,
-					CASE WHEN sample_count > 0 THEN memory_sum / sample_count ELSE 0 END as memory_usage,
-					CASE WHEN sample_count > 0 THEN disk_sum / sample_count ELSE 0 END as disk_usage,
-					net_rx,
-					net_tx,
-					CASE WHEN ping_count > 0 THEN ping_sum / ping_count ELSE NULL END as ping_ms
-				FROM metrics_15min_agg 
-				WHERE server_id = ? AND bucket >= ?
-				ORDER BY bucket ASC
-				LIMIT 720`, serverID, cutoffBucket)
-		} else {
+	reminded := make(map[string]string)
+	for rows.Next() {
+		var serverID, renewalDate string
+		if err := rows.Scan(&serverID, &renewalDate); err != nil {
+			continue
+		}
+		reminded[serverID] = renewalDate
+	}
+	return reminded, rows.Err()
+}
+
+// StoreRefreshToken persists a refresh token hash
+func StoreRefreshToken(tokenHash, sub, provider string, expiresAt time.Time) error {
+	store := func(db *sql.DB) error {
+		_, err := db.Exec("INSERT INTO refresh_tokens (token_hash, sub, provider, expires_at) VALUES (?, ?, ?, ?)",
+			tokenHash, sub, provider, expiresAt.Unix())
+		return err
+	}
+	if dbWriter != nil {
+		return dbWriter.WriteSync(store)
+	}
+	return fmt.Errorf("database not initialized")
+}
+
+// GetRefreshToken looks up the subject, provider and expiry of a refresh token hash
+func GetRefreshToken(db *sql.DB, tokenHash string) (string, string, time.Time, error) {
+	var sub, provider string
+	var expiresAt int64
+	err := db.QueryRow("SELECT sub, provider, expires_at FROM refresh_tokens WHERE token_hash = ?", tokenHash).
+		Scan(&sub, &provider, &expiresAt)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return sub, provider, time.Unix(expiresAt, 0), nil
+}
+
+// errRefreshTokenUsed means a refresh token was already exchanged
+var errRefreshTokenUsed = errors.New("refresh token already used")
+
+// RotateRefreshToken replaces a refresh token hash with a new one for the
+// same session. Only one caller can swap out a given hash; the others get
+// errRefreshTokenUsed.
+func RotateRefreshToken(oldHash, newHash, sub, provider string, expiresAt time.Time) error {
+	if dbWriter == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbWriter.WriteSync(func(db *sql.DB) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec("DELETE FROM refresh_tokens WHERE token_hash = ?", oldHash)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errRefreshTokenUsed
+		}
+		if _, err := tx.Exec("INSERT INTO refresh_tokens (token_hash, sub, provider, expires_at) VALUES (?, ?, ?, ?)",
+			newHash, sub, provider, expiresAt.Unix()); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// DeleteRefreshTokensForSub ends every session of a subject. An empty
+// provider matches sessions from any login method.
+func DeleteRefreshTokensForSub(sub, provider string) error {
+	remove := func(db *sql.DB) error {
+		_, err := db.Exec("DELETE FROM refresh_tokens WHERE sub = ? AND (? = '' OR provider = ?)", sub, provider, provider)
+		return err
+	}
+	if dbWriter != nil {
+		return dbWriter.WriteSync(remove)
+	}
+	return fmt.Errorf("database not initialized")
+}
+
+// DeleteRefreshToken removes a refresh token hash
+func DeleteRefreshToken(tokenHash string) {
+	if dbWriter == nil {
+		return
+	}
+	dbWriter.WriteAsync(func(db *sql.DB) error {
+		_, err := db.Exec("DELETE FROM refresh_tokens WHERE token_hash = ?", tokenHash)
+		return err
+	})
+}
+
+// ============================================================================
+// Audit Log
+// ============================================================================
+
+// StoreAuditEntry appends an administrative action to the audit log
+func StoreAuditEntry(entry AuditEntry) {
+	if dbWriter == nil {
+		return
+	}
+	dbWriter.WriteAsync(func(db *sql.DB) error {
+		var detail interface{}
+		if len(entry.Detail) > 0 {
+			detail = string(entry.Detail)
+		}
+		_, err := db.Exec(`
+			INSERT INTO audit_log (timestamp, actor, ip, action, target, detail)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			entry.Timestamp, entry.Actor, entry.IP, entry.Action, entry.Target, detail)
+		return err
+	})
+}
+
+// GetAuditLog returns audit entries newest first, along with the total count
+func GetAuditLog(db *sql.DB, limit, offset int) ([]AuditEntry, int, error) {
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM audit_log").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, timestamp, actor, ip, action, target, detail
+		FROM audit_log
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var detail sql.NullString
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.Actor, &e.IP, &e.Action, &e.Target, &detail); err != nil {
+			continue
+		}
+		if detail.Valid && detail.String != "" {
+			e.Detail = json.RawMessage(detail.String)
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, nil
+}
+
+// ============================================================================
+// Connection Events
+// ============================================================================
+
+// RecordConnectionEvent logs an agent connecting or disconnecting
+func RecordConnectionEvent(serverID, event, remoteIP string) {
+	if dbWriter == nil {
+		return
+	}
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	dbWriter.WriteAsync(func(db *sql.DB) error {
+		_, err := db.Exec("INSERT INTO connection_events (server_id, event, timestamp, remote_ip) VALUES (?, ?, ?, ?)",
+			serverID, event, timestamp, remoteIP)
+		return err
+	})
+}
+
+// GetConnectionEvents returns a server's connection events since a time, oldest first
+func GetConnectionEvents(db *sql.DB, serverID string, since time.Time) ([]ConnectionEvent, error) {
+	rows, err := db.Query(`
+		SELECT event, timestamp, remote_ip FROM connection_events
+		WHERE server_id = ? AND timestamp >= ?
+		ORDER BY id ASC`, serverID, since.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []ConnectionEvent{}
+	for rows.Next() {
+		var e ConnectionEvent
+		if err := rows.Scan(&e.Event, &e.Timestamp, &e.RemoteIP); err != nil {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// GetRawMetrics returns a server's raw samples between from and to, oldest
+// first, at most limit of them
+func GetRawMetrics(db *sql.DB, serverID string, from, to time.Time, limit int) ([]RawMetricsSample, error) {
+	rows, err := db.Query(`
+		SELECT timestamp, cpu_usage, memory_usage, disk_usage, net_rx, net_tx, load_1, load_5, load_15, ping_ms, iowait, steal
+		FROM metrics_raw
+		WHERE server_id = ? AND timestamp >= ? AND timestamp <= ?
+		ORDER BY timestamp ASC
+		LIMIT ?`, serverID, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []RawMetricsSample{}
+	for rows.Next() {
+		var m RawMetricsSample
+		var ping, iowait, steal sql.NullFloat64
+		if err := rows.Scan(&m.Timestamp, &m.CPU, &m.Memory, &m.Disk, &m.NetRx, &m.NetTx,
+			&m.Load1, &m.Load5, &m.Load15, &ping, &iowait, &steal); err != nil {
+			continue
+		}
+		if ping.Valid {
+			m.PingMs = &ping.Float64
+		}
+		if iowait.Valid {
+			m.IOWait = &iowait.Float64
+		}
+		if steal.Valid {
+			m.Steal = &steal.Float64
+		}
+		samples = append(samples, m)
+	}
+	return samples, rows.Err()
+}
+
+// ============================================================================
+// API Keys
+// ============================================================================
+
+// StoreAPIKey persists a new API key
+func StoreAPIKey(key APIKey, keyHash string) error {
+	store := func(db *sql.DB) error {
+		_, err := db.Exec("INSERT INTO api_keys (id, key_hash, label, scope, created_at) VALUES (?, ?, ?, ?, ?)",
+			key.ID, keyHash, key.Label, key.Scope, key.CreatedAt)
+		return err
+	}
+	if dbWriter != nil {
+		return dbWriter.WriteSync(store)
+	}
+	return fmt.Errorf("database not initialized")
+}
+
+// DeleteAPIKey removes an API key by ID
+func DeleteAPIKey(id string) error {
+	remove := func(db *sql.DB) error {
+		_, err := db.Exec("DELETE FROM api_keys WHERE id = ?", id)
+		return err
+	}
+	if dbWriter != nil {
+		return dbWriter.WriteSync(remove)
+	}
+	return fmt.Errorf("database not initialized")
+}
+
+// LoadAPIKeys returns all API keys indexed by key hash
+func LoadAPIKeys(db *sql.DB) (map[string]APIKey, error) {
+	rows, err := db.Query("SELECT id, key_hash, label, scope, created_at FROM api_keys")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]APIKey)
+	for rows.Next() {
+		var key APIKey
+		var keyHash string
+		if err := rows.Scan(&key.ID, &keyHash, &key.Label, &key.Scope, &key.CreatedAt); err != nil {
+			continue
+		}
+		keys[keyHash] = key
+	}
+	return keys, nil
+}
+
+// ============================================================================
+// Users
+// ============================================================================
+
+// StoreUser inserts or updates a user
+func StoreUser(user User) error {
+	store := func(db *sql.DB) error {
+		_, err := db.Exec(`
+			INSERT INTO users (id, username, password_hash, role, github, google, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(id) DO UPDATE SET
+				password_hash = excluded.password_hash,
+				role = excluded.role,
+				github = excluded.github,
+				google = excluded.google`,
+			user.ID, user.Username, user.PasswordHash, user.Role, user.GitHub, user.Google, user.CreatedAt)
+		return err
+	}
+	if dbWriter != nil {
+		return dbWriter.WriteSync(store)
+	}
+	return fmt.Errorf("database not initialized")
+}
+
+// DeleteUser removes a user and ends their sessions
+func DeleteUser(user User) error {
+	remove := func(db *sql.DB) error {
+		if _, err := db.Exec("DELETE FROM users WHERE id = ?", user.ID); err != nil {
+			return err
+		}
+		_, err := db.Exec(`DELETE FROM refresh_tokens WHERE (provider = 'password' AND sub = ?)
+			OR (provider = 'github' AND sub = ? COLLATE NOCASE)
+			OR (provider = 'google' AND sub = ? COLLATE NOCASE)`,
+			user.Username, user.GitHub, user.Google)
+		return err
+	}
+	if dbWriter != nil {
+		return dbWriter.WriteSync(remove)
+	}
+	return fmt.Errorf("database not initialized")
+}
+
+// LoadUsers returns all users
+func LoadUsers(db *sql.DB) ([]User, error) {
+	rows, err := db.Query("SELECT id, username, password_hash, role, github, google, created_at FROM users")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.GitHub, &user.Google, &user.CreatedAt); err != nil {
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// ============================================================================
+// Outage Tracking
+// ============================================================================
+
+// RecordOutageStart opens an outage window for a server unless one is already open
+func RecordOutageStart(serverID string, start time.Time) {
+	if dbWriter == nil {
+		return
+	}
+	startStr := start.UTC().Format(time.RFC3339)
+	dbWriter.WriteAsync(func(db *sql.DB) error {
+		_, err := db.Exec(`
+			INSERT INTO outages (server_id, start_time)
+			SELECT ?, ?
+			WHERE NOT EXISTS (SELECT 1 FROM outages WHERE server_id = ? AND end_time IS NULL)
+		`, serverID, startStr, serverID)
+		return err
+	})
+}
+
+// RecordOutageEnd closes any open outage window for a server
+func RecordOutageEnd(serverID string, end time.Time) {
+	if dbWriter == nil {
+		return
+	}
+	endStr := end.UTC().Format(time.RFC3339)
+	dbWriter.WriteAsync(func(db *sql.DB) error {
+		_, err := db.Exec("UPDATE outages SET end_time = ? WHERE server_id = ? AND end_time IS NULL", endStr, serverID)
+		return err
+	})
+}
+
+// rangeDuration converts a history range string to a duration (default 24h)
+func rangeDuration(rangeStr string) time.Duration {
+	switch rangeStr {
+	case "1h":
+		return time.Hour
+	case "7d":
+		return 7 * 24 * time.Hour
+	case "30d":
+		return 30 * 24 * time.Hour
+	case "1y":
+		return 365 * 24 * time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+// GetOutages returns outage windows overlapping the range and the total downtime
+// within it. Windows that started before the range are clipped to its start.
+func GetOutages(db *sql.DB, serverID, rangeStr string) ([]OutageWindow, int64, error) {
+	now := time.Now().UTC()
+	since := now.Add(-rangeDuration(rangeStr))
+
+	rows, err := db.Query(`
+		SELECT start_time, end_time FROM outages
+		WHERE server_id = ? AND (end_time IS NULL OR end_time >= ?)
+		ORDER BY start_time ASC
+	`, serverID, since.Format(time.RFC3339))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	outages := []OutageWindow{}
+	var total int64
+	for rows.Next() {
+		var startStr string
+		var endStr *string
+		if err := rows.Scan(&startStr, &endStr); err != nil {
+			continue
+		}
+
+		start, err := time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			continue
+		}
+		end := now
+		if endStr != nil {
+			if t, err := time.Parse(time.RFC3339, *endStr); err == nil {
+				end = t
+			}
+		}
+
+		duration := int64(end.Sub(start).Seconds())
+		if duration < 0 {
+			duration = 0
+		}
+		outages = append(outages, OutageWindow{
+			Start:           startStr,
+			End:             endStr,
+			DurationSeconds: duration,
+		})
+
+		if start.Before(since) {
+			start = since
+		}
+		if end.After(start) {
+			total += int64(end.Sub(start).Seconds())
+		}
+	}
+
+	return outages, total, nil
+}
+
+// GetUptime computes per-day and overall uptime for the last `days` days.
+// Days with a legacy metrics_daily row use its uptime_percent weighted by
+// sample_count (hourly samples); other days, including the current one, are
+// derived from the outages table and weighted by the hours they cover. Days
+// with neither metrics nor an outage are unknown (nil) and left out of the
+// overall figure, which is nil when no day has data.
+func GetUptime(db *sql.DB, serverID string, days int) (*float64, []UptimeDay, error) {
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	rangeStart := today.AddDate(0, 0, -(days - 1))
+
+	// Legacy daily aggregates
+	legacy := make(map[string]struct {
+		uptime  float64
+		samples int
+	})
+	rows, err := db.Query(`
+		SELECT date, uptime_percent, sample_count FROM metrics_daily
+		WHERE server_id = ? AND date >= ?
+	`, serverID, rangeStart.Format("2006-01-02"))
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var date string
+		var uptime float64
+		var samples int
+		if err := rows.Scan(&date, &uptime, &samples); err != nil {
+			continue
+		}
+		legacy[date] = struct {
+			uptime  float64
+			samples int
+		}{uptime, samples}
+	}
+	rows.Close()
+
+	// Days the server reported anything at all
+	seen := make(map[string]bool)
+	rows, err = db.Query(`
+		SELECT substr(hour_start, 1, 10) FROM metrics_hourly
+		WHERE server_id = ? AND hour_start >= ?
+		UNION
+		SELECT date(bucket * 3600, 'unixepoch') FROM metrics_hourly_agg
+		WHERE server_id = ? AND bucket >= ?
+		UNION
+		SELECT date(bucket * 120, 'unixepoch') FROM metrics_2min
+		WHERE server_id = ? AND bucket >= ?
+	`, serverID, rangeStart.Format("2006-01-02"),
+		serverID, rangeStart.Unix()/3600,
+		serverID, rangeStart.Unix()/120)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err == nil {
+			seen[date] = true
+		}
+	}
+	rows.Close()
+
+	// Outage windows overlapping the range
+	type window struct{ start, end time.Time }
+	var windows []window
+	rows, err = db.Query(`
+		SELECT start_time, end_time FROM outages
+		WHERE server_id = ? AND (end_time IS NULL OR end_time >= ?)
+	`, serverID, rangeStart.Format(time.RFC3339))
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var startStr string
+		var endStr *string
+		if err := rows.Scan(&startStr, &endStr); err != nil {
+			continue
+		}
+		start, err := time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			continue
+		}
+		end := now
+		if endStr != nil {
+			if t, err := time.Parse(time.RFC3339, *endStr); err == nil {
+				end = t
+			}
+		}
+		windows = append(windows, window{start, end})
+	}
+	rows.Close()
+
+	result := make([]UptimeDay, 0, days)
+	var weightedSum, totalWeight float64
+	for day := rangeStart; !day.After(today); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		dayEnd := day.Add(24 * time.Hour)
+		if dayEnd.After(now) {
+			dayEnd = now
+		}
+
+		var downtime int64
+		outage := false
+		for _, w := range windows {
+			start, end := w.start, w.end
+			if start.Before(day) {
+				start = day
+			}
+			if end.After(dayEnd) {
+				end = dayEnd
+			}
+			if end.After(start) {
+				downtime += int64(end.Sub(start).Seconds())
+				outage = true
+			}
+		}
+
+		var uptime, weight float64
+		if l, ok := legacy[date]; ok {
+			uptime = l.uptime
+			weight = float64(l.samples)
+		} else if seen[date] || outage {
+			covered := dayEnd.Sub(day).Seconds()
+			uptime = 100
+			if covered > 0 {
+				uptime = 100 - float64(downtime)*100/covered
+			}
+			weight = covered / 3600
+		} else {
+			result = append(result, UptimeDay{Date: date})
+			continue
+		}
+		if uptime < 0 {
+			uptime = 0
+		}
+
+		result = append(result, UptimeDay{
+			Date:            date,
+			UptimePercent:   &uptime,
+			DowntimeSeconds: downtime,
+		})
+		weightedSum += uptime * weight
+		totalWeight += weight
+	}
+
+	var overall *float64
+	if totalWeight > 0 {
+		v := weightedSum / totalWeight
+		overall = &v
+	}
+
+	return overall, result, nil
+}
+
+func GetHistory(db *sql.DB, serverID, rangeStr string) ([]HistoryPoint, error) {
+	return GetHistorySince(db, serverID, rangeStr, 0)
+}
+
+// GetHistorySince returns history data since a specific bucket (for incremental queries)
+func GetHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int64) ([]HistoryPoint, error) {
+	return GetHistoryPoints(db, serverID, rangeStr, sinceBucket, DefaultHistoryPoints)
+}
+
+// History resolution: ranges return about DefaultHistoryPoints points unless
+// ?points= asks for more or fewer, up to max_history_points
+const (
+	DefaultHistoryPoints    = 720
+	MinHistoryPoints        = 10
+	DefaultMaxHistoryPoints = 5000
+)
+
+// maxHistoryPoints mirrors AppConfig.MaxHistoryPoints
+var maxHistoryPoints atomic.Int64
+
+func init() {
+	maxHistoryPoints.Store(DefaultMaxHistoryPoints)
+}
+
+// setMaxHistoryPoints applies max_history_points: 0 uses the default, and
+// the cap never drops below DefaultHistoryPoints
+func setMaxHistoryPoints(n int) {
+	if n <= 0 {
+		n = DefaultMaxHistoryPoints
+	}
+	maxHistoryPoints.Store(int64(max(n, DefaultHistoryPoints)))
+}
+
+// historyPointsLimit is the most points a history query may return
+func historyPointsLimit() int {
+	return int(maxHistoryPoints.Load())
+}
+
+// historySource is a bucketed metrics table history can be read from
+type historySource struct {
+	table      string
+	bucketSecs int64
+	retention  time.Duration
+	load       bool // Has the load columns
+	cpuTimes   bool // Has the iowait/steal columns
+}
+
+// The live tables, written for every sample, back 1h and 24h; the agent
+// rollups back 7d, 30d and 1y for agents that send them. Finest first.
+var (
+	liveHistorySources = []historySource{
+		{"metrics_5sec", 5, 2 * time.Hour, true, true},
+		{"metrics_2min", 120, 26 * time.Hour, true, true},
+	}
+	aggHistorySources = []historySource{
+		{"metrics_15min_agg", 900, 8 * 24 * time.Hour, false, true},
+		{"metrics_hourly_agg", 3600, 32 * 24 * time.Hour, false, true},
+		{"metrics_daily_agg", 86400, 400 * 24 * time.Hour, false, true},
+	}
+)
+
+// pickHistorySource chooses the table and the bucket size its rows are
+// grouped into to cover span in about the given number of points: the
+// coarsest table still kept for the whole span whose buckets are no larger
+// than needed, or the finest kept one when more points are asked for than
+// any of them has
+func pickHistorySource(sources []historySource, span time.Duration, points int) (historySource, int64) {
+	want := max(int64(span.Seconds())/int64(points), 1)
+	var src *historySource
+	for i := range sources {
+		if sources[i].retention < span {
+			continue
+		}
+		if src == nil || sources[i].bucketSecs <= want {
+			src = &sources[i]
+		}
+	}
+	if src == nil {
+		src = &sources[len(sources)-1]
+	}
+	// Round up, so the span never needs more than the requested points
+	groups := max((want+src.bucketSecs-1)/src.bucketSecs, 1)
+	return *src, groups * src.bucketSecs
+}
+
+// queryHistorySource reads the history of a bucketed table from cutoffBucket
+// (in the table's buckets) on, grouping its buckets into groupSecs. Columns
+// are those of HistoryPoint followed by the first bucket of each group.
+func queryHistorySource(db *sql.DB, serverID string, src historySource, groupSecs, cutoffBucket int64, limit int) (*sql.Rows, error) {
+	if groupSecs > src.bucketSecs {
+		// Start at a group boundary so the first group isn't a partial one
+		cutoffBucket = (cutoffBucket*src.bucketSecs + groupSecs - 1) / groupSecs * groupSecs / src.bucketSecs
+	}
+	load := `NULL, NULL, NULL`
+	if src.load {
+		load = `
+			CASE WHEN SUM(load_count) > 0 THEN SUM(load1_sum) / SUM(load_count) ELSE NULL END,
+			CASE WHEN SUM(load_count) > 0 THEN SUM(load5_sum) / SUM(load_count) ELSE NULL END,
+			CASE WHEN SUM(load_count) > 0 THEN SUM(load15_sum) / SUM(load_count) ELSE NULL END`
+	}
+	cpuTimes := `NULL, NULL`
+	if src.cpuTimes {
+		cpuTimes = `
+			CASE WHEN SUM(cpu_times_count) > 0 THEN SUM(iowait_sum) / SUM(cpu_times_count) ELSE NULL END,
+			CASE WHEN SUM(cpu_times_count) > 0 THEN SUM(steal_sum) / SUM(cpu_times_count) ELSE NULL END`
+	}
+	// net_rx/net_tx are cumulative counters, so a group keeps the highest
+	group := fmt.Sprintf("bucket * %d / %d", src.bucketSecs, groupSecs)
+	return db.Query(fmt.Sprintf(`
+		SELECT
+			strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', (%[1]s) * %[2]d, 'unixepoch') as timestamp,
+			CASE WHEN SUM(sample_count) > 0 THEN SUM(cpu_sum) / SUM(sample_count) ELSE 0 END as cpu_usage,
+			CASE WHEN SUM(sample_count) > 0 THEN SUM(memory_sum) / SUM(sample_count) ELSE 0 END as memory_usage,
+			CASE WHEN SUM(sample_count) > 0 THEN SUM(disk_sum) / SUM(sample_count) ELSE 0 END as disk_usage,
+			MAX(net_rx),
+			MAX(net_tx),
+			CASE WHEN SUM(ping_count) > 0 THEN SUM(ping_sum) / SUM(ping_count) ELSE NULL END as ping_ms,
+			%[3]s,
+			%[4]s,
+			MIN(bucket)
+		FROM %[5]s
+		WHERE server_id = ? AND bucket >= ?
+		GROUP BY %[1]s
+		ORDER BY MIN(bucket) ASC
+		LIMIT ?`, group, groupSecs, load, cpuTimes, src.table), serverID, cutoffBucket, limit)
+}
+
+// downsampleHistory merges runs of consecutive points so that at most n
+// remain, for tables that can't be grouped in SQL. Gauges are averaged and
+// network totals, which these tables store per bucket, are added up.
+func downsampleHistory(data []HistoryPoint, n int) []HistoryPoint {
+	if len(data) <= n {
+		return data
+	}
+	per := (len(data) + n - 1) / n
+	avg := func(run []HistoryPoint, field func(p *HistoryPoint) *float64) *float64 {
+		var sum float64
+		var count int
+		for i := range run {
+			if v := field(&run[i]); v != nil {
+				sum += *v
+				count++
+			}
+		}
+		if count == 0 {
+			return nil
+		}
+		mean := sum / float64(count)
+		return &mean
+	}
+
+	merged := make([]HistoryPoint, 0, n)
+	for start := 0; start < len(data); start += per {
+		run := data[start:min(start+per, len(data))]
+		point := HistoryPoint{Timestamp: run[0].Timestamp}
+		for _, p := range run {
+			point.CPU += p.CPU / float32(len(run))
+			point.Memory += p.Memory / float32(len(run))
+			point.Disk += p.Disk / float32(len(run))
+			point.NetRx += p.NetRx
+			point.NetTx += p.NetTx
+		}
+		point.PingMs = avg(run, func(p *HistoryPoint) *float64 { return p.PingMs })
+		point.Load1 = avg(run, func(p *HistoryPoint) *float64 { return p.Load1 })
+		point.Load5 = avg(run, func(p *HistoryPoint) *float64 { return p.Load5 })
+		point.Load15 = avg(run, func(p *HistoryPoint) *float64 { return p.Load15 })
+		point.IOWait = avg(run, func(p *HistoryPoint) *float64 { return p.IOWait })
+		point.Steal = avg(run, func(p *HistoryPoint) *float64 { return p.Steal })
+		merged = append(merged, point)
+	}
+	return merged
+}
+
+// GetHistoryPoints returns about the given number of history points for a
+// range. Ranges backed by bucketed tables pick their table and grouping from
+// points; the legacy fallbacks keep their native resolution, merged down when
+// that gives more points than asked for.
+func GetHistoryPoints(db *sql.DB, serverID, rangeStr string, sinceBucket int64, points int) ([]HistoryPoint, error) {
+	var data []HistoryPoint
+	var rows *sql.Rows
+	var err error
+	bucketed := false // Rows come from queryHistorySource, with the bucket column
+	// Legacy fallbacks read their range at its own resolution, merged down
+	// afterwards if fewer points were asked for
+	limit := historyPointsLimit()
+
+	// bucketedHistory reads from the best of sources for the span. Only the
+	// live ranges support incremental queries.
+	bucketedHistory := func(sources []historySource, span time.Duration, incremental bool) {
+		src, groupSecs := pickHistorySource(sources, span, points)
+		cutoffBucket := time.Now().UTC().Add(-span).Unix() / src.bucketSecs
+		if incremental && sinceBucket > cutoffBucket {
+			cutoffBucket = sinceBucket
+		}
+		bucketed = true
+		rows, err = queryHistorySource(db, serverID, src, groupSecs, cutoffBucket, points)
+	}
+
+	switch rangeStr {
+	case "1h":
+		// Read directly from the pre-aggregated live tables (5-second buckets by default)
+		bucketedHistory(liveHistorySources, time.Hour, true)
+
+	case "7d":
+		// 7d with 15-min buckets (672 points max) - try agent-aggregated data first
+		cutoffBucket := time.Now().UTC().Add(-7*24*time.Hour).Unix() / 900
+		var count int
+		db.QueryRow(`SELECT COUNT(*) FROM metrics_15min_agg WHERE server_id = ? AND bucket >= ?`,
+			serverID, cutoffBucket).Scan(&count)
+
+		if count > 0 {
+			// Use agent-aggregated data
+			bucketedHistory(aggHistorySources, 7*24*time.Hour, false)
+		} else {
 			// Fall back to old pre-aggregated 15-min data (for backward compatibility)
 			cutoff := time.Now().UTC().Add(-7 * 24 * time.Hour).Format(time.RFC3339)
 			db.QueryRow(`SELECT COUNT(*) FROM metrics_15min WHERE server_id = ? AND bucket_start >= ?`,
@@ -1944,11 +3297,11 @@ func GetHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int64) (
 			
 			if count > 0 {
 				rows, err = db.Query(`
-					SELECT bucket_start, cpu_avg, memory_avg, disk_avg, net_rx_total, net_tx_total, ping_avg
+					SELECT bucket_start, cpu_avg, memory_avg, disk_avg, net_rx_total, net_tx_total, ping_avg, NULL, NULL, NULL, iowait_avg, steal_avg
 					FROM metrics_15min 
 					WHERE server_id = ? AND bucket_start >= ?
 					ORDER BY bucket_start ASC
-					LIMIT 720`, serverID, cutoff)
+					LIMIT ?`, serverID, cutoff, limit)
 			} else {
 				// Fall back to real-time aggregation from raw data (15-min buckets = 900 seconds)
 				rows, err = db.Query(`
@@ -1959,12 +3312,17 @@ func GetHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int64) (
 						AVG(disk_usage) as disk_avg,
 						MAX(net_rx) - MIN(net_rx) as net_rx_total,
 						MAX(net_tx) - MIN(net_tx) as net_tx_total,
-						AVG(ping_ms) as ping_avg
+						AVG(ping_ms) as ping_avg,
+						AVG(load_1) as load_1,
+						AVG(load_5) as load_5,
+						AVG(load_15) as load_15,
+						AVG(iowait) as iowait,
+						AVG(steal) as steal
 					FROM metrics_raw 
 					WHERE server_id = ? AND timestamp >= ?
 					GROUP BY strftime('%s', timestamp) / 900
 					ORDER BY bucket_start ASC
-					LIMIT 720`, serverID, cutoff)
+					LIMIT ?`, serverID, cutoff, limit)
 			}
 		}
 
@@ -1976,20 +3334,8 @@ func GetHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int64) (
 			serverID, cutoffBucket).Scan(&count)
 
 		if count > 0 {
-			// Use agent-aggregated hourly data
-			rows, err = db.Query(`
-				SELECT 
-					strftime('%Y-%m-%dT%H:00:00Z', bucket * 3600, 'unixepoch') as timestamp,
-					CASE WHEN sample_count > 0 THEN cpu_sum / sample_count ELSE 0 END as cpu_usage,
-					CASE WHEN sample_count > 0 THEN memory_sum / sample_count ELSE 0 END as memory_usage,
-					CASE WHEN sample_count > 0 THEN disk_sum / sample_count ELSE 0 END as disk_usage,
-					net_rx,
-					net_tx,
-					CASE WHEN ping_count > 0 THEN ping_sum / ping_count ELSE NULL END as ping_ms
-				FROM metrics_hourly_agg 
-				WHERE server_id = ? AND bucket >= ?
-				ORDER BY bucket ASC
-				LIMIT 720`, serverID, cutoffBucket)
+			// Use agent-aggregated data
+			bucketedHistory(aggHistorySources, 30*24*time.Hour, false)
 		} else {
 			// Fall back to old pre-aggregated hourly data (for backward compatibility)
 			cutoff := time.Now().UTC().AddDate(0, 0, -30).Format(time.RFC3339)
@@ -1998,10 +3344,10 @@ func GetHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int64) (
 
 			if count > 0 {
 				rows, err = db.Query(`
-					SELECT hour_start, cpu_avg, memory_avg, disk_avg, net_rx_total, net_tx_total, ping_avg
+					SELECT hour_start, cpu_avg, memory_avg, disk_avg, net_rx_total, net_tx_total, ping_avg, NULL, NULL, NULL, iowait_avg, steal_avg
 					FROM metrics_hourly WHERE server_id = ? AND hour_start >= ?
 					ORDER BY hour_start ASC
-					LIMIT 720`, serverID, cutoff)
+					LIMIT ?`, serverID, cutoff, limit)
 			} else {
 				// Try 15-min table
 				var count15 int
@@ -2017,12 +3363,17 @@ func GetHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int64) (
 							AVG(disk_avg) as disk_avg,
 							SUM(net_rx_total) as net_rx_total,
 							SUM(net_tx_total) as net_tx_total,
-							AVG(ping_avg) as ping_avg
+							AVG(ping_avg) as ping_avg,
+							NULL as load_1,
+							NULL as load_5,
+							NULL as load_15,
+							AVG(iowait_avg) as iowait,
+							AVG(steal_avg) as steal
 						FROM metrics_15min 
 						WHERE server_id = ? AND bucket_start >= ?
 						GROUP BY strftime('%Y-%m-%dT%H:00:00Z', bucket_start)
 						ORDER BY hour_start ASC
-						LIMIT 720`, serverID, cutoff)
+						LIMIT ?`, serverID, cutoff, limit)
 				} else {
 					// Fall back to raw data with hourly aggregation
 					rows, err = db.Query(`
@@ -2033,12 +3384,17 @@ func GetHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int64) (
 							AVG(disk_usage) as disk_avg,
 							MAX(net_rx) - MIN(net_rx) as net_rx_total,
 							MAX(net_tx) - MIN(net_tx) as net_tx_total,
-							AVG(ping_ms) as ping_avg
+							AVG(ping_ms) as ping_avg,
+							AVG(load_1) as load_1,
+							AVG(load_5) as load_5,
+							AVG(load_15) as load_15,
+							AVG(iowait) as iowait,
+							AVG(steal) as steal
 						FROM metrics_raw 
 						WHERE server_id = ? AND timestamp >= ?
 						GROUP BY strftime('%Y-%m-%dT%H:00:00Z', timestamp)
 						ORDER BY hour_start ASC
-						LIMIT 720`, serverID, cutoff)
+						LIMIT ?`, serverID, cutoff, limit)
 				}
 			}
 		}
@@ -2051,20 +3407,8 @@ func GetHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int64) (
 			serverID, cutoffBucket).Scan(&count)
 
 		if count > 0 {
-			// Use agent-aggregated daily data
-			rows, err = db.Query(`
-				SELECT 
-					strftime('%Y-%m-%dT00:00:00Z', bucket * 86400, 'unixepoch') as timestamp,
-					CASE WHEN sample_count > 0 THEN cpu_sum / sample_count ELSE 0 END as cpu_usage,
-					CASE WHEN sample_count > 0 THEN memory_sum / sample_count ELSE 0 END as memory_usage,
-					CASE WHEN sample_count > 0 THEN disk_sum / sample_count ELSE 0 END as disk_usage,
-					net_rx,
-					net_tx,
-					CASE WHEN ping_count > 0 THEN ping_sum / ping_count ELSE NULL END as ping_ms
-				FROM metrics_daily_agg 
-				WHERE server_id = ? AND bucket >= ?
-				ORDER BY bucket ASC
-				LIMIT 365`, serverID, cutoffBucket)
+			// Use agent-aggregated data
+			bucketedHistory(aggHistorySources, 365*24*time.Hour, false)
 		} else {
 			// Fall back to old pre-aggregated hourly data (for backward compatibility)
 			cutoff := time.Now().UTC().AddDate(0, 0, -365).Format(time.RFC3339)
@@ -2081,12 +3425,17 @@ func GetHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int64) (
 						AVG(disk_avg) as disk_avg,
 						SUM(net_rx_total) as net_rx_total,
 						SUM(net_tx_total) as net_tx_total,
-						AVG(ping_avg) as ping_avg
+						AVG(ping_avg) as ping_avg,
+						NULL as load_1,
+						NULL as load_5,
+						NULL as load_15,
+						AVG(iowait_avg) as iowait,
+						AVG(steal_avg) as steal
 					FROM metrics_hourly 
 					WHERE server_id = ? AND hour_start >= ?
 					GROUP BY date(hour_start), (CAST(strftime('%H', hour_start) AS INTEGER) / 12)
 					ORDER BY MIN(hour_start) ASC
-					LIMIT 730`, serverID, cutoff)
+					LIMIT ?`, serverID, cutoff, limit)
 			} else {
 				// Fall back to raw data with 12-hour aggregation
 				rows, err = db.Query(`
@@ -2097,35 +3446,23 @@ func GetHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int64) (
 						AVG(disk_usage) as disk_avg,
 						MAX(net_rx) - MIN(net_rx) as net_rx_total,
 						MAX(net_tx) - MIN(net_tx) as net_tx_total,
-						AVG(ping_ms) as ping_avg
+						AVG(ping_ms) as ping_avg,
+						AVG(load_1) as load_1,
+						AVG(load_5) as load_5,
+						AVG(load_15) as load_15,
+						AVG(iowait) as iowait,
+						AVG(steal) as steal
 					FROM metrics_raw 
 					WHERE server_id = ? AND timestamp >= ?
 					GROUP BY date(timestamp), (CAST(strftime('%H', timestamp) AS INTEGER) / 12)
 					ORDER BY MIN(timestamp) ASC
-					LIMIT 730`, serverID, cutoff)
+					LIMIT ?`, serverID, cutoff, limit)
 			}
 		}
 
 	default:
-		// Default to 24h - read from pre-aggregated table
-		cutoffBucket := time.Now().UTC().Add(-24*time.Hour).Unix() / 120
-		if sinceBucket > cutoffBucket {
-			cutoffBucket = sinceBucket
-		}
-		rows, err = db.Query(`
-			SELECT 
-				strftime('%Y-%m-%dT%H:%M:%SZ', bucket * 120, 'unixepoch') as timestamp,
-				CASE WHEN sample_count > 0 THEN cpu_sum / sample_count ELSE 0 END as cpu_usage,
-				CASE WHEN sample_count > 0 THEN memory_sum / sample_count ELSE 0 END as memory_usage,
-				CASE WHEN sample_count > 0 THEN disk_sum / sample_count ELSE 0 END as disk_usage,
-				net_rx,
-				net_tx,
-				CASE WHEN ping_count > 0 THEN ping_sum / ping_count ELSE NULL END as ping_ms,
-				bucket
-			FROM metrics_2min 
-			WHERE server_id = ? AND bucket >= ?
-			ORDER BY bucket ASC
-			LIMIT 720`, serverID, cutoffBucket)
+		// 24h (and the default) - read from the pre-aggregated live tables (2-minute buckets by default)
+		bucketedHistory(liveHistorySources, 24*time.Hour, true)
 	}
 
 	if err != nil {
@@ -2133,17 +3470,14 @@ func GetHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int64) (
 	}
 	defer rows.Close()
 
-	// Check if we're reading from aggregated tables (1h or 24h) which have bucket column
-	useAggregated := rangeStr == "1h" || rangeStr == "24h" || rangeStr == ""
-
 	for rows.Next() {
 		var point HistoryPoint
 		var bucket int64
 		var scanErr error
-		if useAggregated {
-			scanErr = rows.Scan(&point.Timestamp, &point.CPU, &point.Memory, &point.Disk, &point.NetRx, &point.NetTx, &point.PingMs, &bucket)
+		if bucketed {
+			scanErr = rows.Scan(&point.Timestamp, &point.CPU, &point.Memory, &point.Disk, &point.NetRx, &point.NetTx, &point.PingMs, &point.Load1, &point.Load5, &point.Load15, &point.IOWait, &point.Steal, &bucket)
 		} else {
-			scanErr = rows.Scan(&point.Timestamp, &point.CPU, &point.Memory, &point.Disk, &point.NetRx, &point.NetTx, &point.PingMs)
+			scanErr = rows.Scan(&point.Timestamp, &point.CPU, &point.Memory, &point.Disk, &point.NetRx, &point.NetTx, &point.PingMs, &point.Load1, &point.Load5, &point.Load15, &point.IOWait, &point.Steal)
 		}
 		if scanErr != nil {
 			continue
@@ -2151,6 +3485,9 @@ func GetHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int64) (
 		data = append(data, point)
 	}
 
+	if !bucketed && points != DefaultHistoryPoints {
+		data = downsampleHistory(data, points)
+	}
 	return data, nil
 }
 
@@ -2430,3 +3767,512 @@ func GetPingHistorySince(db *sql.DB, serverID, rangeStr string, sinceBucket int6
 	return targets, nil
 }
 
+// pingTables are all tables holding per-target ping history
+var pingTables = []string{
+	"ping_raw", "ping_5sec", "ping_2min",
+	"ping_15min", "ping_hourly", "ping_daily",
+	"ping_15min_agg", "ping_hourly_agg", "ping_daily_agg",
+}
+
+// serverHistoryTables are all tables holding per-server history
+var serverHistoryTables = append([]string{
+	"metrics_raw", "metrics_5sec", "metrics_2min",
+	"metrics_15min", "metrics_hourly", "metrics_daily",
+	"metrics_15min_agg", "metrics_hourly_agg", "metrics_daily_agg",
+	"cpu_core_raw", "custom_metric_raw", "metrics_records", "traffic_monthly",
+	"outages", "connection_events", "listening_ports",
+}, pingTables...)
+
+// DeleteServerHistory removes all stored history of a server in a single
+// transaction, so a failure can't leave part of it behind. Returns the
+// number of rows deleted.
+func DeleteServerHistory(serverID string) (int64, error) {
+	var deleted int64
+	remove := func(db *sql.DB) error {
+		deleted = 0
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, table := range serverHistoryTables {
+			result, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE server_id = ?", table), serverID)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			n, _ := result.RowsAffected()
+			deleted += n
+		}
+		return tx.Commit()
+	}
+	if dbWriter != nil {
+		err := dbWriter.WriteSync(remove)
+		return deleted, err
+	}
+	return 0, fmt.Errorf("database not initialized")
+}
+
+// RenamePingTarget moves a server's ping history from one target name to
+// another in a single transaction, so a renamed probe keeps one continuous
+// series. Where both names have a row for the same bucket (the agent already
+// reported under the new name) the new row is kept. Returns the number of
+// rows renamed.
+func RenamePingTarget(serverID, from, to string) (int64, error) {
+	var renamed int64
+	rename := func(db *sql.DB) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, table := range pingTables {
+			result, err := tx.Exec(fmt.Sprintf("UPDATE OR IGNORE %s SET target_name = ? WHERE server_id = ? AND target_name = ?", table), to, serverID, from)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			n, _ := result.RowsAffected()
+			renamed += n
+			if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE server_id = ? AND target_name = ?", table), serverID, from); err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+		}
+		return tx.Commit()
+	}
+	if dbWriter != nil {
+		return renamed, dbWriter.WriteSync(rename)
+	}
+	return 0, fmt.Errorf("database not initialized")
+}
+
+// ============================================================================
+// Traffic Accounting
+// ============================================================================
+
+// AggregateTrafficMonthly recomputes the current month's traffic totals and
+// finalizes the previous month if that hasn't happened since it ended, e.g.
+// because the server was down on the 1st. Its hourly data is kept for 32
+// days, so it can still be finalized during the first days of a month; older
+// months can't be recomputed and are left as they are.
+func AggregateTrafficMonthly(db *sql.DB) error {
+	aggregate := func(db *sql.DB) error {
+		now := time.Now().UTC()
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if err := finalizeTrafficMonth(db, month.AddDate(0, -1, 0)); err != nil {
+			return err
+		}
+		return aggregateTrafficMonth(db, month, false)
+	}
+	if dbWriter != nil {
+		return dbWriter.WriteSync(aggregate)
+	}
+	return aggregate(db)
+}
+
+// finalizeTrafficMonth recomputes a past month unless its totals were already
+// written after it ended. Totals only ever grow within a month, so where the
+// oldest hours have already been purged the larger, earlier total is kept.
+func finalizeTrafficMonth(db *sql.DB, monthStart time.Time) error {
+	var updated sql.NullString
+	db.QueryRow("SELECT MAX(updated_at) FROM traffic_monthly WHERE month = ?",
+		monthStart.Format("2006-01")).Scan(&updated)
+	if updated.Valid && updated.String >= monthStart.AddDate(0, 1, 0).Format(time.RFC3339) {
+		return nil
+	}
+	return aggregateTrafficMonth(db, monthStart, true)
+}
+
+// aggregateTrafficMonth sums traffic for the month starting at monthStart.
+// metrics_hourly_agg holds the highest interface counter seen in each hour, so
+// traffic is the difference between consecutive hours. A counter that went
+// backwards was reset (reboot), in which case everything since the reset -
+// the new counter value - is counted. The hour before the month is included so
+// the first hour has something to diff against. With keepLarger an existing
+// total is only ever raised.
+func aggregateTrafficMonth(db *sql.DB, monthStart time.Time, keepLarger bool) error {
+	startBucket := monthStart.Unix() / 3600
+	endBucket := monthStart.AddDate(0, 1, 0).Unix() / 3600
+
+	update := "rx_bytes = excluded.rx_bytes, tx_bytes = excluded.tx_bytes"
+	if keepLarger {
+		update = "rx_bytes = MAX(rx_bytes, excluded.rx_bytes), tx_bytes = MAX(tx_bytes, excluded.tx_bytes)"
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO traffic_monthly (server_id, month, rx_bytes, tx_bytes, updated_at)
+		SELECT
+			server_id,
+			?,
+			SUM(CASE WHEN prev_rx IS NULL THEN 0 WHEN net_rx >= prev_rx THEN net_rx - prev_rx ELSE net_rx END),
+			SUM(CASE WHEN prev_tx IS NULL THEN 0 WHEN net_tx >= prev_tx THEN net_tx - prev_tx ELSE net_tx END),
+			?
+		FROM (
+			SELECT
+				server_id, bucket, net_rx, net_tx,
+				LAG(net_rx) OVER (PARTITION BY server_id ORDER BY bucket) as prev_rx,
+				LAG(net_tx) OVER (PARTITION BY server_id ORDER BY bucket) as prev_tx
+			FROM metrics_hourly_agg
+			WHERE bucket >= ? AND bucket < ?
+		)
+		WHERE bucket >= ?
+		GROUP BY server_id
+		ON CONFLICT(server_id, month) DO UPDATE SET `+update+`, updated_at = excluded.updated_at`,
+		monthStart.Format("2006-01"), time.Now().UTC().Format(time.RFC3339),
+		startBucket-1, endBucket, startBucket)
+	return err
+}
+
+// GetTrafficMonthly returns up to `months` monthly totals for a server, newest first
+func GetTrafficMonthly(db *sql.DB, serverID string, months int) ([]TrafficMonth, error) {
+	rows, err := db.Query(`
+		SELECT month, rx_bytes, tx_bytes FROM traffic_monthly
+		WHERE server_id = ?
+		ORDER BY month DESC
+		LIMIT ?`, serverID, months)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []TrafficMonth{}
+	for rows.Next() {
+		var m TrafficMonth
+		if err := rows.Scan(&m.Month, &m.RxBytes, &m.TxBytes); err != nil {
+			continue
+		}
+		m.TotalBytes = m.RxBytes + m.TxBytes
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// cpuStallTimes returns a sample's iowait and steal percentages. They are
+// invalid (and count is 0) for agents that don't report a CPU time breakdown,
+// so those samples don't drag the bucket averages towards zero.
+func cpuStallTimes(metrics *SystemMetrics) (iowait, steal sql.NullFloat64, count int) {
+	c := metrics.CPU
+	if !c.HasTimes() {
+		return iowait, steal, 0
+	}
+	return sql.NullFloat64{Float64: float64(c.IOWait), Valid: true},
+		sql.NullFloat64{Float64: float64(c.Steal), Valid: true}, 1
+}
+
+// ============================================================================
+// Per-core CPU History
+// ============================================================================
+
+// perCoreHistoryEnabled mirrors AppConfig.PerCoreHistory for the write path
+var perCoreHistoryEnabled atomic.Bool
+
+// storeCoreUsage writes one cpu_core_raw row per core, if per-core history is enabled
+func storeCoreUsage(tx sqlExecer, serverID, timestamp string, perCore []float32) error {
+	if !perCoreHistoryEnabled.Load() || len(perCore) == 0 {
+		return nil
+	}
+
+	valueStrings := make([]string, 0, len(perCore))
+	valueArgs := make([]interface{}, 0, len(perCore)*4)
+	for i, usage := range perCore {
+		valueStrings = append(valueStrings, "(?, ?, ?, ?)")
+		valueArgs = append(valueArgs, serverID, timestamp, i, float64(usage))
+	}
+
+	_, err := tx.Exec(`
+		INSERT OR REPLACE INTO cpu_core_raw (server_id, timestamp, core_index, usage)
+		VALUES `+strings.Join(valueStrings, ","), valueArgs...)
+	return err
+}
+
+// GetCoreHistory returns per-core CPU usage for the 1h or 24h range. 1h returns
+// the raw samples, 24h averages them into 2-minute buckets.
+func GetCoreHistory(db *sql.DB, serverID, rangeStr string) ([]CoreHistory, error) {
+	var rows *sql.Rows
+	var err error
+	switch rangeStr {
+	case "1h":
+		cutoff := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+		rows, err = db.Query(`
+			SELECT core_index, timestamp, usage
+			FROM cpu_core_raw
+			WHERE server_id = ? AND timestamp >= ?
+			ORDER BY core_index, timestamp`, serverID, cutoff)
+	case "24h":
+		cutoff := time.Now().UTC().Add(-24 * time.Hour).Format(time.RFC3339)
+		rows, err = db.Query(`
+			SELECT
+				core_index,
+				strftime('%Y-%m-%dT%H:%M:%SZ', (strftime('%s', timestamp) / 120) * 120, 'unixepoch') as bucket_start,
+				AVG(usage)
+			FROM cpu_core_raw
+			WHERE server_id = ? AND timestamp >= ?
+			GROUP BY core_index, strftime('%s', timestamp) / 120
+			ORDER BY core_index, bucket_start`, serverID, cutoff)
+	default:
+		return nil, fmt.Errorf("unsupported range %q", rangeStr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cores := []CoreHistory{}
+	for rows.Next() {
+		var core int
+		var point CoreHistoryPoint
+		if err := rows.Scan(&core, &point.Timestamp, &point.Usage); err != nil {
+			continue
+		}
+		if len(cores) == 0 || cores[len(cores)-1].Core != core {
+			cores = append(cores, CoreHistory{Core: core})
+		}
+		last := &cores[len(cores)-1]
+		last.Data = append(last.Data, point)
+	}
+	return cores, nil
+}
+
+// ============================================================================
+// Metric Records
+// ============================================================================
+
+// MetricRecordPeriodAll is the period of all-time records; monthly records
+// use the month (YYYY-MM, UTC)
+const MetricRecordPeriodAll = "all"
+
+// recordedMetrics returns the values tracked in metrics_records for a sample
+func recordedMetrics(metrics *SystemMetrics) map[string]float64 {
+	values := map[string]float64{
+		"cpu":      float64(metrics.CPU.Usage),
+		"memory":   float64(metrics.Memory.UsagePercent),
+		"rx_speed": float64(metrics.Network.RxSpeed),
+		"tx_speed": float64(metrics.Network.TxSpeed),
+		"load_1":   metrics.LoadAverage.One,
+	}
+	if len(metrics.Disks) > 0 {
+		values["disk"] = float64(metrics.Disks[0].UsagePercent)
+	}
+	return values
+}
+
+// storeMetricRecords raises the all-time and monthly records of a server to
+// this sample's values where they exceed them
+func storeMetricRecords(tx sqlExecer, serverID string, metrics *SystemMetrics) error {
+	timestamp := metrics.Timestamp.UTC().Format(time.RFC3339)
+	month := metrics.Timestamp.UTC().Format("2006-01")
+
+	values := recordedMetrics(metrics)
+	valueStrings := make([]string, 0, len(values)*2)
+	valueArgs := make([]interface{}, 0, len(values)*10)
+	for metric, value := range values {
+		for _, period := range []string{MetricRecordPeriodAll, month} {
+			valueStrings = append(valueStrings, "(?, ?, ?, ?, ?)")
+			valueArgs = append(valueArgs, serverID, period, metric, value, timestamp)
+		}
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO metrics_records (server_id, period, metric, value, timestamp)
+		VALUES `+strings.Join(valueStrings, ",")+`
+		ON CONFLICT(server_id, period, metric) DO UPDATE SET
+			value = excluded.value,
+			timestamp = excluded.timestamp
+		WHERE excluded.value > metrics_records.value`, valueArgs...)
+	return err
+}
+
+// GetMetricRecords returns a server's records for the given periods, grouped
+// by period
+func GetMetricRecords(db *sql.DB, serverID string, periods []string) (map[string][]MetricRecord, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(periods)), ",")
+	args := []interface{}{serverID}
+	for _, period := range periods {
+		args = append(args, period)
+	}
+
+	rows, err := db.Query(`
+		SELECT period, metric, value, timestamp
+		FROM metrics_records
+		WHERE server_id = ? AND period IN (`+placeholders+`)
+		ORDER BY period, metric`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make(map[string][]MetricRecord)
+	for _, period := range periods {
+		records[period] = []MetricRecord{}
+	}
+	for rows.Next() {
+		var period string
+		var record MetricRecord
+		if err := rows.Scan(&period, &record.Metric, &record.Value, &record.Timestamp); err != nil {
+			continue
+		}
+		records[period] = append(records[period], record)
+	}
+	return records, nil
+}
+
+// ============================================================================
+// Custom Metric History
+// ============================================================================
+
+// customHistoryKeys mirrors AppConfig.CustomHistoryKeys for the write path
+var customHistoryKeys atomic.Pointer[map[string]bool]
+
+func setCustomHistoryKeys(keys []string) {
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[key] = true
+	}
+	customHistoryKeys.Store(&set)
+}
+
+// storeCustomMetrics writes one custom_metric_raw row per custom metric listed
+// in custom_history_keys
+func storeCustomMetrics(tx sqlExecer, serverID, timestamp string, custom map[string]float64) error {
+	keys := customHistoryKeys.Load()
+	if keys == nil || len(*keys) == 0 || len(custom) == 0 {
+		return nil
+	}
+
+	var valueStrings []string
+	var valueArgs []interface{}
+	for key, value := range custom {
+		if !(*keys)[key] {
+			continue
+		}
+		valueStrings = append(valueStrings, "(?, ?, ?, ?)")
+		valueArgs = append(valueArgs, serverID, timestamp, key, value)
+	}
+	if len(valueStrings) == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(`
+		INSERT OR REPLACE INTO custom_metric_raw (server_id, timestamp, key, value)
+		VALUES `+strings.Join(valueStrings, ","), valueArgs...)
+	return err
+}
+
+// GetCustomHistory returns custom metric history for the 1h or 24h range,
+// optionally for a single key. 1h returns the raw samples, 24h averages them
+// into 2-minute buckets.
+func GetCustomHistory(db *sql.DB, serverID, rangeStr, key string) ([]CustomHistory, error) {
+	var cutoff string
+	var query string
+	switch rangeStr {
+	case "1h":
+		cutoff = time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+		query = `
+			SELECT key, timestamp, value
+			FROM custom_metric_raw
+			WHERE server_id = ? AND timestamp >= ? AND (? = '' OR key = ?)
+			ORDER BY key, timestamp`
+	case "24h":
+		cutoff = time.Now().UTC().Add(-24 * time.Hour).Format(time.RFC3339)
+		query = `
+			SELECT
+				key,
+				strftime('%Y-%m-%dT%H:%M:%SZ', (strftime('%s', timestamp) / 120) * 120, 'unixepoch') as bucket_start,
+				AVG(value)
+			FROM custom_metric_raw
+			WHERE server_id = ? AND timestamp >= ? AND (? = '' OR key = ?)
+			GROUP BY key, strftime('%s', timestamp) / 120
+			ORDER BY key, bucket_start`
+	default:
+		return nil, fmt.Errorf("unsupported range %q", rangeStr)
+	}
+
+	rows, err := db.Query(query, serverID, cutoff, key, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := []CustomHistory{}
+	for rows.Next() {
+		var metricKey string
+		var point CustomHistoryPoint
+		if err := rows.Scan(&metricKey, &point.Timestamp, &point.Value); err != nil {
+			continue
+		}
+		if len(metrics) == 0 || metrics[len(metrics)-1].Key != metricKey {
+			metrics = append(metrics, CustomHistory{Key: metricKey})
+		}
+		last := &metrics[len(metrics)-1]
+		last.Data = append(last.Data, point)
+	}
+	return metrics, nil
+}
+
+// ============================================================================
+// Listening Ports
+// ============================================================================
+
+// SyncListeningPorts records the ports a server currently listens on: new
+// ports (or ones that reopened) get first_seen set to now, ports no longer
+// reported get closed_at. Only called when the reported set changes.
+func SyncListeningPorts(serverID string, ports []PortInfo) {
+	if dbWriter == nil {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	dbWriter.WriteAsync(func(db *sql.DB) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("UPDATE listening_ports SET closed_at = ? WHERE server_id = ? AND closed_at IS NULL",
+			now, serverID); err != nil {
+			return err
+		}
+		// Ports still open keep their first_seen; reopened ones start over
+		for _, p := range ports {
+			if _, err := tx.Exec(`
+				INSERT INTO listening_ports (server_id, proto, address, port, process, first_seen, closed_at)
+				VALUES (?, ?, ?, ?, ?, ?, NULL)
+				ON CONFLICT(server_id, proto, address, port) DO UPDATE SET
+					process = excluded.process,
+					first_seen = CASE WHEN listening_ports.closed_at = ? THEN listening_ports.first_seen ELSE excluded.first_seen END,
+					closed_at = NULL`,
+				serverID, p.Proto, p.Address, p.Port, p.Process, now, now); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+// GetListeningPorts returns a server's open ports followed by the ones that
+// closed in the last 30 days
+func GetListeningPorts(db *sql.DB, serverID string) ([]ListeningPortRecord, error) {
+	rows, err := db.Query(`
+		SELECT proto, address, port, process, first_seen, closed_at
+		FROM listening_ports
+		WHERE server_id = ?
+		ORDER BY closed_at IS NOT NULL, port, proto, address`, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ports := []ListeningPortRecord{}
+	for rows.Next() {
+		var p ListeningPortRecord
+		var closedAt sql.NullString
+		if err := rows.Scan(&p.Proto, &p.Address, &p.Port, &p.Process, &p.FirstSeen, &closedAt); err != nil {
+			continue
+		}
+		if closedAt.Valid {
+			p.ClosedAt = &closedAt.String
+		}
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BenchmarkStoreMetrics compares writing a sample statement by statement
+// (one commit each) with the single transaction storeMetricsInternal uses.
+// It runs on a file-backed WAL database, like the server's, so commits pay
+// for their WAL writes.
+func BenchmarkStoreMetrics(b *testing.B) {
+	latency := 12.5
+	sample := func(i int) *SystemMetrics {
+		return &SystemMetrics{
+			Timestamp: time.Unix(1700000000+int64(i), 0).UTC(),
+			Ping: &PingMetrics{Targets: []PingTarget{
+				{Name: "a", Host: "1.1.1.1", LatencyMs: &latency, Status: "ok"},
+				{Name: "b", Host: "8.8.8.8", Status: "timeout"},
+			}},
+		}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		store func(db *sql.DB, m *SystemMetrics) error
+	}{
+		{"PerStatement", func(db *sql.DB, m *SystemMetrics) error { return storeMetricsWith(db, "srv", m) }},
+		{"Transaction", func(db *sql.DB, m *SystemMetrics) error { return storeMetricsInternal(db, "srv", m) }},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			db, err := openDatabase(filepath.Join(b.TempDir(), "vstats.db") + "?_busy_timeout=5000")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+			var mode string
+			if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+				b.Fatalf("journal_mode is %q (%v), want wal", mode, err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := tc.store(db, sample(i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWritesUnderHistoryReads measures how long writer batches take
+// while 16 clients keep reading 7d history (aggregated on the fly from raw
+// rows), with the reads on the main pool and on the read-only pool
+// OpenReadDB returns. Each op is one 50-row batch through the writer; p95-ms
+// and max-ms are its latency, reads/op the history queries finished meanwhile.
+func BenchmarkWritesUnderHistoryReads(b *testing.B) {
+	b.Setenv("VSTATS_DB_PATH", filepath.Join(b.TempDir(), "vstats.db"))
+	db, err := InitDatabase()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`
+		WITH RECURSIVE n(i) AS (SELECT 0 UNION ALL SELECT i + 1 FROM n WHERE i < 199999)
+		INSERT INTO metrics_raw (server_id, timestamp, cpu_usage, memory_usage, disk_usage,
+			net_rx, net_tx, load_1, load_5, load_15)
+		SELECT 'srv', strftime('%Y-%m-%dT%H:%M:%SZ', 'now', '-' || (i % 86400) || ' seconds'),
+			i % 100, 50, 40, i * 1000, i * 500, 1, 1, 1
+		FROM n`); err != nil {
+		b.Fatal(err)
+	}
+	readDB, err := OpenReadDB()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer readDB.Close()
+
+	batch := func(db *sql.DB) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		ts := time.Now().UTC().Format(time.RFC3339)
+		for i := 0; i < 50; i++ {
+			if _, err := tx.Exec(`INSERT INTO metrics_raw (server_id, timestamp, cpu_usage, memory_usage,
+				disk_usage, net_rx, net_tx, load_1, load_5, load_15) VALUES ('w', ?, 1, 1, 1, 0, 0, 0, 0, 0)`, ts); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}
+
+	for _, tc := range []struct {
+		name  string
+		reads *sql.DB
+	}{
+		{"MainPool", db},
+		{"ReadPool", readDB},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			writer := NewDBWriter(db, 100)
+			defer writer.Close()
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			var readsMu sync.Mutex
+			reads := 0
+			for i := 0; i < 16; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						if _, err := GetHistory(tc.reads, "srv", "7d"); err == nil {
+							readsMu.Lock()
+							reads++
+							readsMu.Unlock()
+						}
+					}
+				}()
+			}
+
+			latencies := make([]time.Duration, 0, b.N)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				started := time.Now()
+				if err := writer.WriteSync(batch); err != nil {
+					b.Fatal(err)
+				}
+				latencies = append(latencies, time.Since(started))
+			}
+			b.StopTimer()
+			close(stop)
+			wg.Wait()
+
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+			b.ReportMetric(ms(latencies[len(latencies)*95/100]), "p95-ms")
+			b.ReportMetric(ms(latencies[len(latencies)-1]), "max-ms")
+			b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+// An incremental vacuum empties the whole freelist, not just the first page
+func TestIncrementalVacuumFreesAllPages(t *testing.T) {
+	db, err := openDatabase(filepath.Join(t.TempDir(), "vstats.db") + "?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("CREATE TABLE filler (data BLOB)"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := db.Exec("INSERT INTO filler (data) VALUES (zeroblob(8192))"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("DELETE FROM filler"); err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := vacuumDatabaseInternal(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Mode != "incremental" || run.FreedPages < 100 {
+		t.Errorf("got %s vacuum freeing %d pages, want incremental freeing at least 100", run.Mode, run.FreedPages)
+	}
+	var free int64
+	if err := db.QueryRow("PRAGMA freelist_count").Scan(&free); err != nil {
+		t.Fatal(err)
+	}
+	if free != 0 {
+		t.Errorf("freelist_count = %d after vacuum, want 0", free)
+	}
+}
+
+// The daily cleanup drops audit entries older than AuditLogRetention
+func TestCleanupTrimsAuditLog(t *testing.T) {
+	db, err := openDatabase("file:auditcleanup?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+	for _, age := range []time.Duration{AuditLogRetention + time.Hour, AuditLogRetention - time.Hour, time.Minute} {
+		if _, err := db.Exec("INSERT INTO audit_log (timestamp, actor, action) VALUES (?, 'admin', 'test')",
+			now.Add(-age).Format(time.RFC3339)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cleanupOldDataInternal(db); err != nil {
+		t.Fatal(err)
+	}
+	var left int
+	if err := db.QueryRow("SELECT COUNT(*) FROM audit_log").Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 2 {
+		t.Errorf("%d audit entries left, want 2", left)
+	}
+}
+
+func TestPickHistorySource(t *testing.T) {
+	const day = 24 * time.Hour
+	tests := []struct {
+		name      string
+		sources   []historySource
+		span      time.Duration
+		points    int
+		table     string
+		groupSecs int64
+	}{
+		{"1h at default resolution", liveHistorySources, time.Hour, 720, "metrics_5sec", 5},
+		{"1h grouped into minutes", liveHistorySources, time.Hour, 60, "metrics_5sec", 60},
+		{"1h coarse enough for 2min buckets", liveHistorySources, time.Hour, 10, "metrics_2min", 360},
+		{"24h skips 5sec, kept only 2h", liveHistorySources, day, 720, "metrics_2min", 120},
+		{"24h asking for more points than stored", liveHistorySources, day, 5000, "metrics_2min", 120},
+		{"7d", aggHistorySources, 7 * day, 720, "metrics_15min_agg", 900},
+		{"30d skips 15min, kept only 8d", aggHistorySources, 30 * day, 720, "metrics_hourly_agg", 3600},
+		{"30d with few points uses daily", aggHistorySources, 30 * day, 10, "metrics_daily_agg", 3 * 86400},
+		{"1y", aggHistorySources, 365 * day, 720, "metrics_daily_agg", 86400},
+		{"beyond every retention falls back to the coarsest", aggHistorySources, 730 * day, 720, "metrics_daily_agg", 2 * 86400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, groupSecs := pickHistorySource(tt.sources, tt.span, tt.points)
+			if src.table != tt.table || groupSecs != tt.groupSecs {
+				t.Errorf("got %s grouped by %ds, want %s grouped by %ds", src.table, groupSecs, tt.table, tt.groupSecs)
+			}
+			if groupSecs%src.bucketSecs != 0 {
+				t.Errorf("group of %ds isn't a whole number of %ds buckets", groupSecs, src.bucketSecs)
+			}
+		})
+	}
+}
+
+func TestDownsampleHistory(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	points := []HistoryPoint{
+		{Timestamp: "t0", CPU: 10, NetRx: 100, PingMs: f(20), Load1: f(1)},
+		{Timestamp: "t1", CPU: 20, NetRx: 200, PingMs: nil, Load1: f(2)},
+		{Timestamp: "t2", CPU: 30, NetRx: 300, PingMs: f(40), Load1: f(3)},
+		{Timestamp: "t3", CPU: 40, NetRx: 400},
+		{Timestamp: "t4", CPU: 50, NetRx: 500},
+	}
+
+	tests := []struct {
+		name       string
+		n          int
+		timestamps []string
+		cpu        []float32
+		netRx      []int64
+		pingMs     []*float64
+	}{
+		{"fewer points than asked for are kept", 5, []string{"t0", "t1", "t2", "t3", "t4"},
+			[]float32{10, 20, 30, 40, 50}, []int64{100, 200, 300, 400, 500}, []*float64{f(20), nil, f(40), nil, nil}},
+		{"runs of three", 2, []string{"t0", "t3"},
+			[]float32{20, 45}, []int64{600, 900}, []*float64{f(30), nil}},
+		{"everything in one", 1, []string{"t0"},
+			[]float32{30}, []int64{1500}, []*float64{f(30)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := downsampleHistory(points, tt.n)
+			if len(got) != len(tt.timestamps) {
+				t.Fatalf("got %d points, want %d", len(got), len(tt.timestamps))
+			}
+			for i, p := range got {
+				if p.Timestamp != tt.timestamps[i] || p.CPU != tt.cpu[i] || p.NetRx != tt.netRx[i] {
+					t.Errorf("point %d: got %s cpu %v rx %d, want %s cpu %v rx %d",
+						i, p.Timestamp, p.CPU, p.NetRx, tt.timestamps[i], tt.cpu[i], tt.netRx[i])
+				}
+				if (p.PingMs == nil) != (tt.pingMs[i] == nil) || (p.PingMs != nil && *p.PingMs != *tt.pingMs[i]) {
+					t.Errorf("point %d: ping %v, want %v", i, p.PingMs, tt.pingMs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSetMaxHistoryPoints(t *testing.T) {
+	defer setMaxHistoryPoints(0)
+	for _, tt := range []struct{ set, want int }{
+		{0, DefaultMaxHistoryPoints},
+		{-1, DefaultMaxHistoryPoints},
+		{100, DefaultHistoryPoints}, // Never below the default resolution
+		{10000, 10000},
+	} {
+		setMaxHistoryPoints(tt.set)
+		if got := historyPointsLimit(); got != tt.want {
+			t.Errorf("max_history_points %d: limit %d, want %d", tt.set, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"vstats/internal/common"
+)
+
+// ============================================================================
+// Disk Health Alerts
+// ============================================================================
+
+// diskHealthAlerted remembers the last health reported per server disk, so a
+// warning is raised once per change rather than on every sample
+var (
+	diskHealthAlerted   = make(map[string]string) // server_id + "/" + disk name -> health
+	diskHealthAlertedMu sync.Mutex
+)
+
+// checkDiskHealth raises an alert when one of the server's disks turns to
+// SMART warning or failing. Servers in maintenance are checked again on the
+// next sample after their window ends.
+func (s *AppState) checkDiskHealth(serverID string, metrics *SystemMetrics) {
+	s.ConfigMu.RLock()
+	var name string
+	inMaintenance := false
+	for i := range s.Config.Servers {
+		if s.Config.Servers[i].ID == serverID {
+			name = s.Config.Servers[i].Name
+			inMaintenance = s.Config.Servers[i].InMaintenance(time.Now())
+			break
+		}
+	}
+	s.ConfigMu.RUnlock()
+	if inMaintenance {
+		return
+	}
+
+	var alerts []Alert
+	diskHealthAlertedMu.Lock()
+	for _, d := range metrics.Disks {
+		if d.Health == "" || d.Health == common.DiskHealthUnknown {
+			continue
+		}
+		key := serverID + "/" + d.Name
+		previous := diskHealthAlerted[key]
+		diskHealthAlerted[key] = d.Health
+		if d.Health == previous || d.Health == common.DiskHealthOK {
+			continue
+		}
+		alerts = append(alerts, Alert{
+			Kind:       AlertDiskHealth,
+			ServerID:   serverID,
+			ServerName: name,
+			Message:    fmt.Sprintf("Disk %s SMART health is %s", d.Name, d.Health),
+			Detail: map[string]interface{}{
+				"disk":                d.Name,
+				"health":              d.Health,
+				"reallocated_sectors": d.ReallocatedSectors,
+				"media_errors":        d.MediaErrors,
+			},
+		})
+	}
+	diskHealthAlertedMu.Unlock()
+
+	for _, alert := range alerts {
+		s.raiseAlert(alert)
+	}
+}
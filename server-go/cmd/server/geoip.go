@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// GeoIP
+// ============================================================================
+
+// When geoip is configured, servers without a location get one from their
+// public IP as their agent connects: from a local MaxMind database
+// (GeoLite2-City or -Country .mmdb, no network access needed) or else from
+// an HTTP lookup service.
+
+// GeoLocation is the result of a GeoIP lookup. Coordinates are unset for
+// country-level databases.
+type GeoLocation struct {
+	CountryCode string
+	Country     string
+	City        string
+	Latitude    *float64
+	Longitude   *float64
+}
+
+// locationName is the text used for RemoteServer.Location
+func (g *GeoLocation) locationName() string {
+	switch {
+	case g.City != "" && g.CountryCode != "":
+		return g.City + ", " + g.CountryCode
+	case g.Country != "":
+		return g.Country
+	}
+	return g.CountryCode
+}
+
+const geoIPLookupTimeout = 5 * time.Second
+
+var (
+	geoIPDB     *mmdbReader
+	geoIPDBPath string
+	geoIPDBMu   sync.Mutex
+)
+
+// geoIPDatabase opens the configured database once and keeps it in memory,
+// reopening it when the configured path changes
+func geoIPDatabase(path string) (*mmdbReader, error) {
+	geoIPDBMu.Lock()
+	defer geoIPDBMu.Unlock()
+	if geoIPDB != nil && geoIPDBPath == path {
+		return geoIPDB, nil
+	}
+	db, err := openMMDB(path)
+	if err != nil {
+		return nil, err
+	}
+	geoIPDB, geoIPDBPath = db, path
+	return db, nil
+}
+
+// lookupGeoIP resolves ip with the local database if configured, otherwise
+// with the lookup service. It returns nil for private addresses and
+// addresses the source doesn't know.
+func lookupGeoIP(cfg *GeoIPConfig, ip string) (*GeoLocation, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+		return nil, nil
+	}
+
+	if cfg.DatabasePath != "" {
+		db, err := geoIPDatabase(cfg.DatabasePath)
+		if err != nil {
+			return nil, err
+		}
+		record, err := db.lookup(addr)
+		if err != nil || record == nil {
+			return nil, err
+		}
+		return geoLocationFromRecord(record), nil
+	}
+	if cfg.LookupURL != "" {
+		return lookupGeoIPService(cfg.LookupURL, ip)
+	}
+	return nil, nil
+}
+
+// geoLocationFromRecord reads a GeoLite2 City/Country record
+func geoLocationFromRecord(record map[string]interface{}) *GeoLocation {
+	child := func(m map[string]interface{}, key string) map[string]interface{} {
+		v, _ := m[key].(map[string]interface{})
+		return v
+	}
+	englishName := func(m map[string]interface{}) string {
+		name, _ := child(m, "names")["en"].(string)
+		return name
+	}
+
+	geo := &GeoLocation{}
+	country := child(record, "country")
+	if country == nil {
+		country = child(record, "registered_country")
+	}
+	geo.CountryCode, _ = country["iso_code"].(string)
+	geo.Country = englishName(country)
+	geo.City = englishName(child(record, "city"))
+	if location := child(record, "location"); location != nil {
+		if lat, ok := location["latitude"].(float64); ok {
+			geo.Latitude = &lat
+		}
+		if lon, ok := location["longitude"].(float64); ok {
+			geo.Longitude = &lon
+		}
+	}
+	return geo
+}
+
+// lookupGeoIPService queries an HTTP lookup service. lookupURL contains {ip}
+// and must return JSON in the ipapi.co format (city, country_code,
+// country_name, latitude, longitude).
+func lookupGeoIPService(lookupURL, ip string) (*GeoLocation, error) {
+	client := newOutboundClient(geoIPLookupTimeout)
+	resp, err := client.Get(strings.ReplaceAll(lookupURL, "{ip}", ip))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lookup service returned %s", resp.Status)
+	}
+
+	var body struct {
+		City        string   `json:"city"`
+		CountryCode string   `json:"country_code"`
+		CountryName string   `json:"country_name"`
+		Latitude    *float64 `json:"latitude"`
+		Longitude   *float64 `json:"longitude"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid lookup service response: %w", err)
+	}
+	if body.CountryCode == "" && body.CountryName == "" {
+		return nil, nil
+	}
+	return &GeoLocation{
+		CountryCode: body.CountryCode,
+		Country:     body.CountryName,
+		City:        body.City,
+		Latitude:    body.Latitude,
+		Longitude:   body.Longitude,
+	}, nil
+}
+
+// enrichServerLocation fills in the location of a server that has none from
+// its public IP. It runs in the background when an agent connects.
+func (s *AppState) enrichServerLocation(serverID, ip string) {
+	s.ConfigMu.RLock()
+	cfg := s.Config.GeoIP
+	s.ConfigMu.RUnlock()
+	if cfg == nil {
+		return
+	}
+
+	geo, err := lookupGeoIP(cfg, ip)
+	if err != nil {
+		slog.Warn("GeoIP lookup failed", "ip", ip, "error", err)
+		return
+	}
+	if geo == nil {
+		return
+	}
+
+	s.ConfigMu.Lock()
+	defer s.ConfigMu.Unlock()
+	for i := range s.Config.Servers {
+		server := &s.Config.Servers[i]
+		if server.ID != serverID {
+			continue
+		}
+		if !server.needsGeoIP() {
+			return // Set meanwhile
+		}
+		if server.Location == "" {
+			server.Location = geo.locationName()
+		}
+		server.CountryCode = geo.CountryCode
+		server.Latitude = geo.Latitude
+		server.Longitude = geo.Longitude
+		SaveConfig(s.Config)
+		return
+	}
+}
+
+// needsGeoIP reports whether a server is missing what a GeoIP lookup provides
+func (server *RemoteServer) needsGeoIP() bool {
+	return server.Location == "" || (server.Latitude == nil && server.CountryCode == "")
+}
+
+// ----------------------------------------------------------------------------
+// MaxMind DB reader
+// ----------------------------------------------------------------------------
+
+// mmdbReader looks up records in a MaxMind DB file
+// (https://maxmind.github.io/MaxMind-DB/), enough to read GeoLite2 databases
+// without a dependency
+type mmdbReader struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	data       []byte // Data section
+	ipv4Start  uint   // Node of ::/96, where IPv4 lookups start in an IPv6 tree
+}
+
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	start := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if start < 0 {
+		return nil, fmt.Errorf("%s is not a MaxMind database", path)
+	}
+	metaSection := buf[start+len(mmdbMetadataMarker):]
+	meta, _, err := (&mmdbDecoder{buf: metaSection}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata in %s: %w", path, err)
+	}
+	metadata, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid metadata in %s", path)
+	}
+
+	r := &mmdbReader{buf: buf}
+	r.nodeCount = mmdbUint(metadata["node_count"])
+	r.recordSize = mmdbUint(metadata["record_size"])
+	r.ipVersion = mmdbUint(metadata["ip_version"])
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d in %s", r.recordSize, path)
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+16 > uint(start) {
+		return nil, fmt.Errorf("%s is truncated", path)
+	}
+	r.data = buf[treeSize+16 : start]
+
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.readNode(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+func mmdbUint(v interface{}) uint {
+	n, _ := v.(uint64)
+	return uint(n)
+}
+
+func (r *mmdbReader) readNode(node uint, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		b := r.buf[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.buf[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(r.buf[node*8+bit*4:]))
+	}
+}
+
+// lookup returns the record for ip, or nil if the database has none
+func (r *mmdbReader) lookup(ip net.IP) (map[string]interface{}, error) {
+	addr := ip.To4()
+	node := uint(0)
+	if addr != nil {
+		node = r.ipv4Start
+	} else {
+		if r.ipVersion == 4 {
+			return nil, nil
+		}
+		addr = ip.To16()
+	}
+
+	for i := 0; i < len(addr)*8 && node < r.nodeCount; i++ {
+		bit := uint(addr[i/8]>>(7-uint(i%8))) & 1
+		node = r.readNode(node, bit)
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, errors.New("invalid search tree")
+	}
+
+	value, _, err := (&mmdbDecoder{buf: r.data}).decode(node - r.nodeCount - 16)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+// mmdbDecoder decodes values of a MaxMind DB data section
+type mmdbDecoder struct {
+	buf []byte
+}
+
+const (
+	mmdbPointer = 1
+	mmdbString  = 2
+	mmdbDouble  = 3
+	mmdbBytes   = 4
+	mmdbUint16  = 5
+	mmdbUint32  = 6
+	mmdbMap     = 7
+	mmdbInt32   = 8
+	mmdbUint64  = 9
+	mmdbUint128 = 10
+	mmdbArray   = 11
+	mmdbBool    = 14
+	mmdbFloat   = 15
+)
+
+var errMMDBTruncated = errors.New("unexpected end of data")
+
+// decode returns the value at offset and the offset after it
+func (d *mmdbDecoder) decode(offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, errMMDBTruncated
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == mmdbPointer {
+		target, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target)
+		return value, next, err
+	}
+
+	if typ == 0 {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errMMDBTruncated
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return nil, 0, errMMDBTruncated
+		}
+		extra := uint(0)
+		for _, b := range d.buf[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, _ := key.(string)
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errMMDBTruncated
+	}
+	raw := d.buf[offset : offset+size]
+	offset += size
+
+	switch typ {
+	case mmdbString:
+		return string(raw), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		n := uint64(0)
+		for _, b := range raw {
+			n = n<<8 | uint64(b)
+		}
+		return n, offset, nil
+	case mmdbInt32:
+		n := uint32(0)
+		for _, b := range raw {
+			n = n<<8 | uint32(b)
+		}
+		return int64(int32(n)), offset, nil
+	case mmdbBytes, mmdbUint128:
+		return raw, offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+// pointer resolves a pointer's target offset in the data section
+func (d *mmdbDecoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint(ctrl>>3&0x3) + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errMMDBTruncated
+	}
+	b := d.buf[offset : offset+n]
+	v := uint(ctrl & 0x7)
+	switch n {
+	case 1:
+		return v<<8 | uint(b[0]), offset + n, nil
+	case 2:
+		return (v<<16 | uint(b[0])<<8 | uint(b[1])) + 2048, offset + n, nil
+	case 3:
+		return (v<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336, offset + n, nil
+	default:
+		return uint(binary.BigEndian.Uint32(b)), offset + n, nil
+	}
+}
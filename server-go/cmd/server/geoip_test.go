@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+// testdata/geoip-ipv4.mmdb is a hand-built IPv4 database (record size 24)
+// with two networks: 1.2.3.0/24 is a city record whose country is reached
+// through a pointer, 5.0.0.0/8 has only a country. Everything else is empty.
+func TestMMDBLookup(t *testing.T) {
+	db, err := openMMDB("testdata/geoip-ipv4.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip          string
+		countryCode string
+		country     string
+		city        string
+		lat, lon    float64
+		hasLocation bool
+	}{
+		{"1.2.3.4", "US", "United States", "Testville", 37.5, -122.25, true},
+		{"1.2.3.255", "US", "United States", "Testville", 37.5, -122.25, true},
+		{"5.6.7.8", "DE", "Germany", "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		record, err := db.lookup(net.ParseIP(tt.ip))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.ip, err)
+		}
+		if record == nil {
+			t.Fatalf("%s: no record", tt.ip)
+		}
+		geo := geoLocationFromRecord(record)
+		if geo.CountryCode != tt.countryCode || geo.Country != tt.country || geo.City != tt.city {
+			t.Errorf("%s: got %q %q %q, want %q %q %q",
+				tt.ip, geo.CountryCode, geo.Country, geo.City, tt.countryCode, tt.country, tt.city)
+		}
+		if (geo.Latitude != nil) != tt.hasLocation {
+			t.Errorf("%s: location present = %v, want %v", tt.ip, geo.Latitude != nil, tt.hasLocation)
+		} else if tt.hasLocation && (*geo.Latitude != tt.lat || *geo.Longitude != tt.lon) {
+			t.Errorf("%s: got %v,%v, want %v,%v", tt.ip, *geo.Latitude, *geo.Longitude, tt.lat, tt.lon)
+		}
+	}
+
+	for _, ip := range []string{"1.2.4.1", "4.255.255.255", "6.0.0.1", "2001:db8::1"} {
+		record, err := db.lookup(net.ParseIP(ip))
+		if err != nil || record != nil {
+			t.Errorf("%s: got %v, %v, want no record", ip, record, err)
+		}
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -24,15 +29,20 @@ func (s *AppState) RegisterAgent(c *gin.Context) {
 	serverID := uuid.New().String()
 	agentToken := uuid.New().String()
 
+	// The install script registers from the agent's host, so the caller's
+	// address is where the agent will connect from
 	server := RemoteServer{
 		ID:       serverID,
 		Name:     req.Name,
 		Location: req.Location,
 		Provider: req.Provider,
 		Token:    agentToken,
+		IP:       c.ClientIP(),
+		PublicIP: c.ClientIP(),
 	}
 
 	s.ConfigMu.Lock()
+	server.SortOrder = s.Config.nextServerSortOrder()
 	s.Config.Servers = append(s.Config.Servers, server)
 	SaveConfig(s.Config)
 	s.ConfigMu.Unlock()
@@ -43,6 +53,79 @@ func (s *AppState) RegisterAgent(c *gin.Context) {
 	})
 }
 
+// RotateAgentToken issues a new agent token for a server. The new token is
+// only returned to the admin, never pushed to the connected agent, which may
+// be the one holding a leaked token. The old token stays valid until the agent
+// first connects with the new one, or for at most AgentTokenGracePeriod.
+func (s *AppState) RotateAgentToken(c *gin.Context) {
+	serverID := c.Param("id")
+	newToken := uuid.New().String()
+
+	s.ConfigMu.Lock()
+	found := false
+	for i := range s.Config.Servers {
+		if s.Config.Servers[i].ID == serverID {
+			server := &s.Config.Servers[i]
+			server.PreviousToken = server.Token
+			server.PreviousTokenExpires = time.Now().Add(AgentTokenGracePeriod).Unix()
+			server.Token = newToken
+			found = true
+			break
+		}
+	}
+	if found {
+		SaveConfig(s.Config)
+	}
+	s.ConfigMu.Unlock()
+
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return
+	}
+	s.audit(c, "server.rotate_token", serverID, nil)
+
+	c.JSON(http.StatusOK, RotateTokenResponse{
+		Success: true,
+		Message: "Token rotated; configure the agent with the new token. The old one stops working once the new one is used.",
+		Token:   newToken,
+	})
+}
+
+// RefreshInterval is the minimum time between on-demand collections on an
+// agent, however often refreshes are requested
+const RefreshInterval = time.Second
+
+// lastRefresh holds when collect_now was last sent to each agent
+var lastRefresh sync.Map
+
+// RefreshServer asks an agent to collect and send a sample right away,
+// e.g. when a server's detail view is opened. Requests within
+// RefreshInterval of the previous one are coalesced into it.
+func (s *AppState) RefreshServer(c *gin.Context) {
+	serverID := c.Param("id")
+
+	s.AgentConnsMu.RLock()
+	conn := s.AgentConns[serverID]
+	s.AgentConnsMu.RUnlock()
+	if conn == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent is not connected"})
+		return
+	}
+
+	now := time.Now()
+	if last, ok := lastRefresh.Load(serverID); ok && now.Sub(last.(time.Time)) < RefreshInterval {
+		c.JSON(http.StatusAccepted, gin.H{"status": "debounced"})
+		return
+	}
+	lastRefresh.Store(serverID, now)
+
+	if err := sendAgentCommand(conn, AgentCommand{Type: "command", Command: "collect_now"}); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Agent send queue is full"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"status": "requested"})
+}
+
 // ============================================================================
 // Installation Script Handlers
 // ============================================================================
@@ -123,37 +206,86 @@ func (s *AppState) servePowerShellScript(c *gin.Context, filename string) {
 	c.JSON(http.StatusNotFound, gin.H{"error": "PowerShell script not found: " + filename})
 }
 
-func (s *AppState) GetInstallCommand(c *gin.Context) {
-	host := c.Request.Host
-	protocol := "https"
+// Install command platforms. macOS uses the same shell installer as Linux.
+const (
+	PlatformLinux   = "linux"
+	PlatformMacOS   = "macos"
+	PlatformWindows = "windows"
+)
+
+// EnrollmentTokenTTL is how long the token in an install command can register
+// agents
+const EnrollmentTokenTTL = 24 * time.Hour
+
+// enrollmentTokenType is the typ claim of enrollment tokens, which
+// AuthMiddleware accepts for agent registration and nothing else
+const enrollmentTokenType = "enroll"
+
+// generateEnrollmentToken issues the token embedded in install commands. It
+// can only register agents, so a leaked command doesn't hand out the admin's
+// session.
+func generateEnrollmentToken() (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":      "enrollment",
+		"provider": enrollmentTokenType,
+		"exp":      time.Now().Add(EnrollmentTokenTTL).Unix(),
+		"jti":      uuid.New().String(),
+		"typ":      enrollmentTokenType,
+	})
+	return token.SignedString([]byte(GetJWTSecret()))
+}
 
-	// Priority: X-Forwarded-Proto header > TLS detection > localhost fallback
-	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
-		// Trust the X-Forwarded-Proto header from nginx
-		protocol = proto
-	} else if c.Request.TLS != nil {
-		// Direct TLS connection
-		protocol = "https"
-	} else if host == "localhost" || (len(host) >= 4 && host[:4] == "127.") || (len(host) >= 10 && host[:10] == "localhost:") {
-		protocol = "http"
+// installCommands builds the one-line agent install command for every platform
+func installCommands(baseURL, token string) map[string]string {
+	shell := fmt.Sprintf(
+		`curl -fsSL %s/agent.sh | sudo bash -s -- --server %s --token "%s" --name "$(hostname)"`,
+		baseURL, baseURL, token,
+	)
+	powershell := fmt.Sprintf(
+		`[Net.ServicePointManager]::SecurityProtocol = [Net.SecurityProtocolType]::Tls12; irm %s/agent.ps1 | iex; Install-VStatsAgent -Server "%s" -Token "%s"`,
+		baseURL, baseURL, token,
+	)
+	return map[string]string{
+		PlatformLinux:   shell,
+		PlatformMacOS:   shell,
+		PlatformWindows: powershell,
 	}
+}
 
-	baseURL := fmt.Sprintf("%s://%s", protocol, host)
+// GetInstallCommand returns the agent install command for every platform.
+// ?platform=linux|macos|windows selects which one is returned as command
+// (default linux). The commands carry a new enrollment token, not the
+// caller's session token.
+func (s *AppState) GetInstallCommand(c *gin.Context) {
+	platform := c.DefaultQuery("platform", PlatformLinux)
+	if platform != PlatformLinux && platform != PlatformMacOS && platform != PlatformWindows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "platform must be linux, macos or windows"})
+		return
+	}
 
-	authHeader := c.GetHeader("Authorization")
-	token := ""
-	if len(authHeader) > 7 {
-		token = authHeader[7:]
+	baseURL := requestBaseURL(c)
+	if baseURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot determine the server address from the Host or X-Forwarded-Host header"})
+		return
 	}
 
-	command := fmt.Sprintf(
-		`curl -fsSL %s/agent.sh | sudo bash -s -- --server %s --token "%s" --name "$(hostname)"`,
-		baseURL, baseURL, token,
-	)
+	token, err := generateEnrollmentToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue an enrollment token"})
+		return
+	}
+
+	commands := installCommands(baseURL, token)
+	scriptURL := fmt.Sprintf("%s/agent.sh", baseURL)
+	if platform == PlatformWindows {
+		scriptURL = fmt.Sprintf("%s/agent.ps1", baseURL)
+	}
 
 	c.JSON(http.StatusOK, InstallCommand{
-		Command:   command,
-		ScriptURL: fmt.Sprintf("%s/agent.sh", baseURL),
+		Command:   commands[platform],
+		ScriptURL: scriptURL,
+		Platform:  platform,
+		Commands:  commands,
 	})
 }
 
@@ -167,6 +299,11 @@ func (s *AppState) UpdateAgent(c *gin.Context) {
 	var req UpdateAgentRequest
 	c.ShouldBindJSON(&req)
 
+	if err := normalizeUpdateSHA256(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	s.AgentConnsMu.RLock()
 	conn := s.AgentConns[serverID]
 	s.AgentConnsMu.RUnlock()
@@ -179,24 +316,297 @@ func (s *AppState) UpdateAgent(c *gin.Context) {
 		return
 	}
 
-	cmd := AgentCommand{
-		Type:        "command",
-		Command:     "update",
-		DownloadURL: req.DownloadURL,
-		Force:       req.Force,
-	}
-
-	data, _ := json.Marshal(cmd)
-	select {
-	case conn.SendChan <- data:
+	if s.sendUpdateCommand(serverID, conn, req) {
+		s.audit(c, "server.agent_update", serverID, gin.H{"force": req.Force, "download_url": req.DownloadURL, "sha256": req.SHA256})
 		c.JSON(http.StatusOK, UpdateAgentResponse{
 			Success: true,
 			Message: "Update command sent to agent",
 		})
-	default:
+	} else {
 		c.JSON(http.StatusOK, UpdateAgentResponse{
 			Success: false,
 			Message: "Failed to send update command",
 		})
 	}
 }
+
+// normalizeUpdateSHA256 lowercases the optional digest and checks its format
+func normalizeUpdateSHA256(req *UpdateAgentRequest) error {
+	req.SHA256 = strings.ToLower(strings.TrimSpace(req.SHA256))
+	if req.SHA256 != "" {
+		if decoded, err := hex.DecodeString(req.SHA256); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("sha256 must be a 64-character hex digest")
+		}
+	}
+	return nil
+}
+
+// sendUpdateCommand queues an update command on the agent's connection and
+// marks the update as pending. Returns false if the send buffer is full.
+func (s *AppState) sendUpdateCommand(serverID string, conn *AgentConnection, req UpdateAgentRequest) bool {
+	err := sendAgentCommand(conn, AgentCommand{
+		Type:        "command",
+		Command:     "update",
+		DownloadURL: req.DownloadURL,
+		Force:       req.Force,
+		SHA256:      req.SHA256,
+	})
+	if err != nil {
+		return false
+	}
+	s.setUpdatePending(serverID)
+	return true
+}
+
+// ============================================================================
+// Bulk Agent Update
+// ============================================================================
+
+const (
+	defaultUpdateConcurrency = 5
+	// How long a rollout slot is held waiting for an agent to report back
+	updateRolloutTimeout = 10 * time.Minute
+)
+
+// updateLimiter counts the agents updating at once across all bulk updates,
+// so overlapping requests share one limit instead of each getting their own
+type updateLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	inFlight int
+}
+
+var agentUpdates = newUpdateLimiter()
+
+func newUpdateLimiter() *updateLimiter {
+	l := &updateLimiter{}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// tryAcquire takes a slot if fewer than limit agents are updating
+func (l *updateLimiter) tryAcquire(limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight >= limit {
+		return false
+	}
+	l.inFlight++
+	return true
+}
+
+// acquire waits until fewer than limit agents are updating and takes a slot
+func (l *updateLimiter) acquire(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inFlight >= limit {
+		l.cond.Wait()
+	}
+	l.inFlight++
+}
+
+func (l *updateLimiter) release() {
+	l.mu.Lock()
+	l.inFlight--
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// updateTarget is a connected agent selected for a bulk update
+type updateTarget struct {
+	id   string
+	name string
+	conn *AgentConnection
+}
+
+// UpdateAllAgents sends the update command to every connected agent that
+// matches the optional group/dimension filter. At most `concurrency` agents
+// update at once, counting those of other bulk updates still in progress;
+// the rest are queued and dispatched in the background as earlier agents
+// report a result, disconnect to restart, or time out.
+func (s *AppState) UpdateAllAgents(c *gin.Context) {
+	var req UpdateAllAgentsRequest
+	c.ShouldBindJSON(&req)
+
+	if err := normalizeUpdateSHA256(&req.UpdateAgentRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Concurrency < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "concurrency must not be negative"})
+		return
+	}
+	if req.Concurrency == 0 {
+		req.Concurrency = defaultUpdateConcurrency
+	}
+
+	filter := serverFilter{groupID: req.GroupID, dimensions: req.Dimensions}
+
+	s.ConfigMu.RLock()
+	servers := s.Config.Servers
+	s.ConfigMu.RUnlock()
+
+	var targets []updateTarget
+	s.AgentConnsMu.RLock()
+	for i := range servers {
+		conn := s.AgentConns[servers[i].ID]
+		if conn != nil && filter.matches(&servers[i], true) {
+			targets = append(targets, updateTarget{id: servers[i].ID, name: servers[i].Name, conn: conn})
+		}
+	}
+	s.AgentConnsMu.RUnlock()
+
+	resp := UpdateAllAgentsResponse{Results: make([]UpdateAllAgentsResult, 0, len(targets))}
+	var queued []updateTarget
+
+	for _, target := range targets {
+		result := UpdateAllAgentsResult{ServerID: target.id, Name: target.name}
+		switch {
+		case len(queued) > 0 || !agentUpdates.tryAcquire(req.Concurrency):
+			queued = append(queued, target)
+			result.Status = "queued"
+			resp.Queued++
+		case s.sendUpdateCommand(target.id, target.conn, req.UpdateAgentRequest):
+			result.Status = "dispatched"
+			resp.Dispatched++
+			go s.releaseWhenUpdated(target)
+		default:
+			agentUpdates.release()
+			result.Status = "failed"
+			result.Message = "Failed to send update command"
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	if len(queued) > 0 {
+		go s.rolloutUpdates(queued, req.UpdateAgentRequest, req.Concurrency)
+	}
+
+	s.audit(c, "server.agent_update_all", "", gin.H{
+		"group_id":     req.GroupID,
+		"dimensions":   req.Dimensions,
+		"concurrency":  req.Concurrency,
+		"targets":      len(targets),
+		"download_url": req.DownloadURL,
+		"sha256":       req.SHA256,
+	})
+	c.JSON(http.StatusOK, resp)
+}
+
+// rolloutUpdates dispatches queued updates as rollout slots free up
+func (s *AppState) rolloutUpdates(targets []updateTarget, req UpdateAgentRequest, concurrency int) {
+	for _, target := range targets {
+		agentUpdates.acquire(concurrency)
+
+		// The agent may have disconnected while it was queued
+		s.AgentConnsMu.RLock()
+		conn := s.AgentConns[target.id]
+		s.AgentConnsMu.RUnlock()
+		if conn == nil {
+			agentUpdates.release()
+			fmt.Printf("⚠️  Skipping agent update for %s (%s): no longer connected\n", target.name, target.id)
+			continue
+		}
+
+		target.conn = conn
+		if !s.sendUpdateCommand(target.id, conn, req) {
+			agentUpdates.release()
+			fmt.Printf("⚠️  Failed to send update command to %s (%s)\n", target.name, target.id)
+			continue
+		}
+		go s.releaseWhenUpdated(target)
+	}
+}
+
+// releaseWhenUpdated frees a rollout slot once the agent has reported a
+// result, dropped the connection the command was sent on, or timed out.
+// Agents that predate update_result only signal completion by restarting.
+func (s *AppState) releaseWhenUpdated(target updateTarget) {
+	defer agentUpdates.release()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	deadline := time.Now().Add(updateRolloutTimeout)
+
+	for range ticker.C {
+		if status := s.getUpdateStatus(target.id); status == nil || status.Status != UpdateStatusPending {
+			return
+		}
+
+		s.AgentConnsMu.RLock()
+		current := s.AgentConns[target.id]
+		s.AgentConnsMu.RUnlock()
+		if current != target.conn {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			fmt.Printf("⚠️  Agent update for %s (%s) did not report back within %v\n", target.name, target.id, updateRolloutTimeout)
+			return
+		}
+	}
+}
+
+// ============================================================================
+// Agent Update Status
+// ============================================================================
+
+// setUpdatePending records that an update command was just sent to an agent
+func (s *AppState) setUpdatePending(serverID string) {
+	var fromVersion string
+	s.AgentMetricsMu.RLock()
+	if data := s.AgentMetrics[serverID]; data != nil {
+		fromVersion = data.Metrics.Version
+	}
+	s.AgentMetricsMu.RUnlock()
+
+	s.UpdateStatusMu.Lock()
+	s.UpdateStatus[serverID] = &AgentUpdateStatus{
+		Status:      UpdateStatusPending,
+		RequestedAt: time.Now().UTC().Format(time.RFC3339),
+		FromVersion: fromVersion,
+	}
+	s.UpdateStatusMu.Unlock()
+}
+
+// recordUpdateResult stores an "update_result" message from an agent
+func (s *AppState) recordUpdateResult(serverID string, msg *AgentMessage) {
+	s.UpdateStatusMu.Lock()
+	defer s.UpdateStatusMu.Unlock()
+
+	status := s.UpdateStatus[serverID]
+	if status == nil {
+		// Update triggered elsewhere (or the server restarted since)
+		status = &AgentUpdateStatus{}
+		s.UpdateStatus[serverID] = status
+	}
+	status.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	status.Version = msg.Version
+	status.Error = msg.Error
+	if msg.Success {
+		status.Status = UpdateStatusSucceeded
+	} else {
+		status.Status = UpdateStatusFailed
+	}
+}
+
+// getUpdateStatus returns a copy of the last update status for a server
+func (s *AppState) getUpdateStatus(serverID string) *AgentUpdateStatus {
+	s.UpdateStatusMu.RLock()
+	defer s.UpdateStatusMu.RUnlock()
+	if status := s.UpdateStatus[serverID]; status != nil {
+		copied := *status
+		return &copied
+	}
+	return nil
+}
+
+func (s *AppState) GetUpdateStatus(c *gin.Context) {
+	status := s.getUpdateStatus(c.Param("id"))
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No update has been requested for this server"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
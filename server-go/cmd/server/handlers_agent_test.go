@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Bulk updates share one count of agents updating, so a second request
+// can't start more updates while the first one's are still running
+func TestUpdateLimiterShared(t *testing.T) {
+	l := newUpdateLimiter()
+	if !l.tryAcquire(2) || !l.tryAcquire(2) {
+		t.Fatal("first two slots refused")
+	}
+	if l.tryAcquire(2) {
+		t.Fatal("a second rollout with the same limit got a third slot")
+	}
+	if !l.tryAcquire(3) {
+		t.Fatal("a rollout allowing 3 at once was refused with 2 running")
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		l.acquire(3)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquire didn't wait for a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("acquire still waiting after a release")
+	}
+}
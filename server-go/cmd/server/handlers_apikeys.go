@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// API Keys
+// ============================================================================
+
+// APIKeyPrefix marks a bearer credential as an API key rather than a JWT
+const APIKeyPrefix = "vsk_"
+
+var (
+	apiKeys   = make(map[string]APIKey) // key hash -> key
+	apiKeysMu sync.RWMutex
+)
+
+// InitAPIKeys loads API keys from the database
+func InitAPIKeys(db *sql.DB) {
+	keys, err := LoadAPIKeys(db)
+	if err != nil {
+		fmt.Printf("Failed to load API keys: %v\n", err)
+		return
+	}
+
+	apiKeysMu.Lock()
+	apiKeys = keys
+	apiKeysMu.Unlock()
+}
+
+// lookupAPIKey returns the key matching a plaintext API key. Keys are hashed
+// the same way as refresh tokens.
+func lookupAPIKey(plaintext string) (APIKey, bool) {
+	apiKeysMu.RLock()
+	defer apiKeysMu.RUnlock()
+	key, ok := apiKeys[hashRefreshToken(plaintext)]
+	return key, ok
+}
+
+func (s *AppState) ListAPIKeys(c *gin.Context) {
+	apiKeysMu.RLock()
+	keys := make([]APIKey, 0, len(apiKeys))
+	for _, key := range apiKeys {
+		keys = append(keys, key)
+	}
+	apiKeysMu.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt < keys[j].CreatedAt })
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey issues a new key. The plaintext is returned once and never stored.
+func (s *AppState) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	req.Label = strings.TrimSpace(req.Label)
+	if req.Label == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "label is required"})
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = APIKeyScopeRead
+	}
+	if req.Scope != APIKeyScopeRead && req.Scope != APIKeyScopeAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be \"read\" or \"admin\""})
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate key"})
+		return
+	}
+	plaintext := APIKeyPrefix + hex.EncodeToString(buf)
+	keyHash := hashRefreshToken(plaintext)
+
+	key := APIKey{
+		ID:        uuid.New().String(),
+		Label:     req.Label,
+		Scope:     req.Scope,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := StoreAPIKey(key, keyHash); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save key"})
+		return
+	}
+
+	apiKeysMu.Lock()
+	apiKeys[keyHash] = key
+	apiKeysMu.Unlock()
+
+	s.audit(c, "apikey.create", key.ID, gin.H{"label": key.Label, "scope": key.Scope})
+	c.JSON(http.StatusOK, CreateAPIKeyResponse{APIKey: key, Key: plaintext})
+}
+
+func (s *AppState) DeleteAPIKey(c *gin.Context) {
+	id := c.Param("id")
+
+	apiKeysMu.Lock()
+	var keyHash string
+	for hash, key := range apiKeys {
+		if key.ID == id {
+			keyHash = hash
+			break
+		}
+	}
+	if keyHash == "" {
+		apiKeysMu.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	delete(apiKeys, keyHash)
+	apiKeysMu.Unlock()
+
+	if err := DeleteAPIKey(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete key"})
+		return
+	}
+
+	s.audit(c, "apikey.delete", id, nil)
+	c.Status(http.StatusOK)
+}
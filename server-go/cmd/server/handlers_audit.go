@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// Audit Log
+// ============================================================================
+
+// AuditLogRetention is how long audit entries are kept before the daily
+// cleanup removes them
+const AuditLogRetention = 365 * 24 * time.Hour
+
+// audit records an administrative action taken by the authenticated user.
+// detail is stored as JSON and may be nil.
+func (s *AppState) audit(c *gin.Context, action, target string, detail interface{}) {
+	entry := AuditEntry{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Actor:     GetSub(c),
+		IP:        c.ClientIP(),
+		Action:    action,
+		Target:    target,
+	}
+	if entry.Actor == "" {
+		entry.Actor = "unknown"
+	}
+	if detail != nil {
+		if data, err := json.Marshal(detail); err == nil {
+			entry.Detail = data
+		}
+	}
+	StoreAuditEntry(entry)
+}
+
+func (s *AppState) GetAuditLog(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return
+	}
+
+	entries, total, err := GetAuditLog(s.DB, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, AuditLogResponse{
+		Entries: entries,
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+	})
+}
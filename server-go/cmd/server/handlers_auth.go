@@ -1,11 +1,20 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -20,55 +29,168 @@ func (s *AppState) Login(c *gin.Context) {
 		return
 	}
 
-	s.ConfigMu.RLock()
-	passwordHash := s.Config.AdminPasswordHash
-	s.ConfigMu.RUnlock()
+	clientIP := c.ClientIP()
+	if allowed, wait := s.reserveLoginAttempt(clientIP); !allowed {
+		retryAfter := int(wait.Seconds() + 0.5)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many login attempts, try again later"})
+		return
+	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
-		// If password verification fails, try reloading config from disk
-		// This handles the case where password was reset while server is running
-		if newConfig, _ := LoadConfig(); newConfig != nil {
-			s.ConfigMu.Lock()
-			oldHash := s.Config.AdminPasswordHash
-			s.Config.AdminPasswordHash = newConfig.AdminPasswordHash
-			s.ConfigMu.Unlock()
-
-			// Try again with reloaded password hash
-			if err := bcrypt.CompareHashAndPassword([]byte(newConfig.AdminPasswordHash), []byte(req.Password)); err != nil {
-				// Still failed, restore old hash and return error
-				s.ConfigMu.Lock()
-				s.Config.AdminPasswordHash = oldHash
-				s.ConfigMu.Unlock()
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
-				return
-			}
-			// Success after reload, continue with login
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+	// Named users log in with their username; the built-in admin password
+	// is used without one (or with "admin")
+	sub, role := AdminUsername, UserRoleAdmin
+	if req.Username != "" && !strings.EqualFold(req.Username, AdminUsername) {
+		user, ok := lookupUser(req.Username)
+		hash := dummyPasswordHash
+		if ok && user.PasswordHash != "" {
+			hash = user.PasswordHash
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil || hash == dummyPasswordHash {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 			return
 		}
+		sub, role = user.Username, user.Role
+	} else if !s.checkAdminPassword(req.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
 	}
 
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": "admin",
-		"exp": expiresAt.Unix(),
+	resetLoginAttempts(clientIP)
+
+	tokenString, expiresAt, err := generateJWTToken(sub, "password", role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	refreshToken, refreshExpiresAt, err := issueRefreshToken(sub, "password")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, LoginResponse{
+		Token:            tokenString,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
 	})
+}
+
+// checkAdminPassword verifies the built-in admin password. On a mismatch the
+// hash is reloaded from disk once, in case the password was reset while the
+// server is running.
+func (s *AppState) checkAdminPassword(password string) bool {
+	s.ConfigMu.RLock()
+	passwordHash := s.Config.AdminPasswordHash
+	s.ConfigMu.RUnlock()
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil {
+		return true
+	}
 
-	tokenString, err := token.SignedString([]byte(GetJWTSecret()))
+	newConfig, _ := LoadConfig()
+	if newConfig == nil || newConfig.AdminPasswordHash == passwordHash {
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(newConfig.AdminPasswordHash), []byte(password)) != nil {
+		return false
+	}
+	s.ConfigMu.Lock()
+	s.Config.AdminPasswordHash = newConfig.AdminPasswordHash
+	s.ConfigMu.Unlock()
+	return true
+}
+
+// RefreshToken exchanges a valid refresh token for a new access token and a
+// new refresh token. Each refresh token works once; the replacement keeps
+// the session's original expiry.
+func (s *AppState) RefreshToken(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	oldHash := hashRefreshToken(req.RefreshToken)
+	sub, provider, refreshExpiresAt, err := GetRefreshToken(s.DB, oldHash)
+	if err != nil {
+		abortUnauthorized(c, ErrCodeTokenInvalid, "Invalid refresh token")
+		return
+	}
+	if time.Now().After(refreshExpiresAt) {
+		abortUnauthorized(c, ErrCodeTokenExpired, "Refresh token has expired")
+		return
+	}
+
+	// The role is re-evaluated so role and allowlist changes apply here
+	role := s.tokenRole(sub, provider)
+	if role == "" {
+		DeleteRefreshToken(oldHash)
+		abortForbidden(c, ErrCodeUserNotAllowed, "Account is no longer authorized")
+		return
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	err = RotateRefreshToken(oldHash, hashRefreshToken(refreshToken), sub, provider, refreshExpiresAt)
+	if errors.Is(err, errRefreshTokenUsed) {
+		// A concurrent request exchanged it first
+		abortUnauthorized(c, ErrCodeTokenInvalid, "Invalid refresh token")
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	tokenString, expiresAt, err := generateJWTToken(sub, provider, role)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, LoginResponse{
-		Token:     tokenString,
-		ExpiresAt: expiresAt,
+		Token:            tokenString,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
 	})
 }
 
 func (s *AppState) VerifyToken(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "valid"})
+	c.JSON(http.StatusOK, gin.H{"status": "valid", "sub": GetSub(c), "provider": GetProvider(c), "role": GetRole(c)})
+}
+
+// Logout revokes the token used for this request
+func (s *AppState) Logout(c *gin.Context) {
+	// Drop the refresh token too, if the client sent it
+	var req RefreshTokenRequest
+	if c.ShouldBindJSON(&req) == nil && req.RefreshToken != "" {
+		DeleteRefreshToken(hashRefreshToken(req.RefreshToken))
+	}
+
+	jti := c.GetString(ContextJTI)
+	if jti == "" {
+		// Tokens issued before revocation support have no jti and simply expire
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+		return
+	}
+
+	expiresAt := time.Now().Add(accessTokenTTL())
+	if exp, ok := c.Get(ContextExp); ok {
+		if t, ok := exp.(time.Time); ok {
+			expiresAt = t
+		}
+	}
+
+	revokeToken(jti, expiresAt)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
 }
 
 func (s *AppState) ChangePassword(c *gin.Context) {
@@ -78,11 +200,25 @@ func (s *AppState) ChangePassword(c *gin.Context) {
 		return
 	}
 
+	// Named users change their own password
+	if user, ok := lookupUser(GetSub(c)); ok && GetProvider(c) == "password" {
+		s.changeUserPassword(c, user, req)
+		return
+	}
+	if !isAdmin(c) {
+		abortForbidden(c, ErrCodeAdminRequired, "Admin role required")
+		return
+	}
+
 	s.ConfigMu.Lock()
 	defer s.ConfigMu.Unlock()
 
 	if err := bcrypt.CompareHashAndPassword([]byte(s.Config.AdminPasswordHash), []byte(req.CurrentPassword)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid current password"})
+		abortForbidden(c, ErrCodeInvalidPassword, "Invalid current password")
+		return
+	}
+	if len(req.NewPassword) < MinPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("password must be at least %d characters", MinPasswordLength)})
 		return
 	}
 
@@ -94,5 +230,204 @@ func (s *AppState) ChangePassword(c *gin.Context) {
 
 	s.Config.AdminPasswordHash = string(hash)
 	SaveConfig(s.Config)
-	c.Status(http.StatusOK)
+	s.audit(c, "auth.password_change", "", nil)
+	// An OAuth subject may also be called admin, so only password sessions end
+	s.endSessions(c, AdminUsername, "password")
+}
+
+// endSessions drops every refresh token of sub after a password change, so
+// a leaked token stops working. If the caller is that subject, their own
+// session continues with a new refresh token sent in the response.
+func (s *AppState) endSessions(c *gin.Context, sub, provider string) {
+	if err := DeleteRefreshTokensForSub(sub, provider); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password changed, but failed to end other sessions"})
+		return
+	}
+	if GetSub(c) != sub || GetProvider(c) != "password" {
+		c.Status(http.StatusOK)
+		return
+	}
+	refreshToken, refreshExpiresAt, err := issueRefreshToken(sub, "password")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"refresh_token": refreshToken, "refresh_expires_at": refreshExpiresAt})
+}
+
+// changeUserPassword sets a named user's password after checking the current one
+func (s *AppState) changeUserPassword(c *gin.Context, user User, req ChangePasswordRequest) {
+	if user.PasswordHash == "" || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)) != nil {
+		abortForbidden(c, ErrCodeInvalidPassword, "Invalid current password")
+		return
+	}
+	if len(req.NewPassword) < MinPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("password must be at least %d characters", MinPasswordLength)})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+	user.PasswordHash = string(hash)
+	if err := StoreUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save password"})
+		return
+	}
+	usersMu.Lock()
+	users[strings.ToLower(user.Username)] = user
+	usersMu.Unlock()
+
+	s.audit(c, "auth.password_change", user.Username, nil)
+	s.endSessions(c, user.Username, "")
+}
+
+// ============================================================================
+// Refresh Tokens
+// ============================================================================
+
+const (
+	AccessTokenTTL = 15 * time.Minute
+	// Refresh tokens set the session length; token_ttl overrides the default
+	DefaultTokenTTL = 7 * 24 * time.Hour
+	MinTokenTTL     = 15 * time.Minute
+	MaxTokenTTL     = 90 * 24 * time.Hour
+)
+
+// tokenTTL holds the configured session length in nanoseconds
+var tokenTTL atomic.Int64
+
+// parseTokenTTL parses a token_ttl value. Besides Go durations ("8h",
+// "90m") it accepts whole days ("30d"); empty means DefaultTokenTTL.
+func parseTokenTTL(value string) (time.Duration, error) {
+	if value == "" {
+		return DefaultTokenTTL, nil
+	}
+	var ttl time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration")
+		}
+		ttl = time.Duration(n) * 24 * time.Hour
+	} else {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration")
+		}
+		ttl = parsed
+	}
+	if ttl < MinTokenTTL || ttl > MaxTokenTTL {
+		return 0, fmt.Errorf("must be between %v and %v", MinTokenTTL, MaxTokenTTL)
+	}
+	return ttl, nil
+}
+
+// InitTokenTTL applies token_ttl. An invalid value falls back to
+// DefaultTokenTTL and is reported as an error.
+func InitTokenTTL(value string) error {
+	ttl, err := parseTokenTTL(value)
+	if err != nil {
+		tokenTTL.Store(int64(DefaultTokenTTL))
+		return err
+	}
+	tokenTTL.Store(int64(ttl))
+	return nil
+}
+
+// sessionTTL returns the lifetime of refresh tokens
+func sessionTTL() time.Duration {
+	if ttl := tokenTTL.Load(); ttl > 0 {
+		return time.Duration(ttl)
+	}
+	return DefaultTokenTTL
+}
+
+// accessTokenTTL returns the lifetime of access tokens, never longer than
+// the session itself
+func accessTokenTTL() time.Duration {
+	return min(AccessTokenTTL, sessionTTL())
+}
+
+// newRefreshToken returns a random refresh token
+func newRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// issueRefreshToken creates a random refresh token and stores its hash
+func issueRefreshToken(sub, provider string) (string, time.Time, error) {
+	token, err := newRefreshToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(sessionTTL())
+
+	if err := StoreRefreshToken(hashRefreshToken(token), sub, provider, expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// hashRefreshToken returns the form refresh tokens are stored in
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ============================================================================
+// Token Revocation
+// ============================================================================
+
+var (
+	revokedTokens   = make(map[string]time.Time) // jti -> token expiry
+	revokedTokensMu sync.RWMutex
+)
+
+// InitRevokedTokens loads revoked token IDs from the database
+func InitRevokedTokens(db *sql.DB) {
+	tokens, err := LoadRevokedTokens(db)
+	if err != nil {
+		fmt.Printf("Failed to load revoked tokens: %v\n", err)
+		return
+	}
+
+	revokedTokensMu.Lock()
+	revokedTokens = tokens
+	revokedTokensMu.Unlock()
+}
+
+// revokeToken blacklists a token ID until it expires
+func revokeToken(jti string, expiresAt time.Time) {
+	revokedTokensMu.Lock()
+	revokedTokens[jti] = expiresAt
+	revokedTokensMu.Unlock()
+
+	StoreRevokedToken(jti, expiresAt)
+}
+
+// isTokenRevoked reports whether a token ID has been revoked
+func isTokenRevoked(jti string) bool {
+	revokedTokensMu.RLock()
+	defer revokedTokensMu.RUnlock()
+	_, ok := revokedTokens[jti]
+	return ok
+}
+
+// cleanupRevokedTokens drops in-memory entries for tokens that have expired
+func cleanupRevokedTokens() {
+	revokedTokensMu.Lock()
+	defer revokedTokensMu.Unlock()
+
+	now := time.Now()
+	for jti, expiresAt := range revokedTokens {
+		if now.After(expiresAt) {
+			delete(revokedTokens, jti)
+		}
+	}
 }
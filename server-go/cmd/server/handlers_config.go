@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// Config Import / Export
+// ============================================================================
+
+// ConfigExportVersion is bumped if the export envelope changes incompatibly
+const ConfigExportVersion = 1
+
+// ConfigExport is the backup file produced by ExportConfig and accepted by
+// ImportConfig. Secrets (password hash, JWT secret, OAuth client secrets) are
+// never exported. Agent tokens are left out unless explicitly requested; an
+// install restored from a token-less export keeps the tokens it already has
+// for known servers, but agents of any other server must be re-keyed.
+type ConfigExport struct {
+	Version    int       `json:"version"`
+	ExportedAt string    `json:"exported_at"`
+	Config     AppConfig `json:"config"`
+}
+
+// copyConfig deep-copies a config through JSON so the copy can be edited
+// without holding ConfigMu
+func copyConfig(config *AppConfig) (*AppConfig, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var copied AppConfig
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return nil, err
+	}
+	return &copied, nil
+}
+
+// stripSecrets clears credentials that must not leave the server
+func stripSecrets(config *AppConfig) {
+	config.AdminPasswordHash = ""
+	config.JWTSecret = ""
+	if config.OAuth != nil {
+		if config.OAuth.GitHub != nil {
+			config.OAuth.GitHub.ClientSecret = ""
+		}
+		if config.OAuth.Google != nil {
+			config.OAuth.Google.ClientSecret = ""
+		}
+	}
+}
+
+// stripAgentTokens removes every server's agent tokens from an export
+func stripAgentTokens(config *AppConfig) {
+	for i := range config.Servers {
+		config.Servers[i].Token = ""
+		config.Servers[i].clearPreviousToken()
+	}
+}
+
+// keepSecrets copies the credentials stripped on export from current into an
+// imported config. Imported OAuth secrets and agent tokens win when they are
+// set; servers exported without a token keep the one they already have here.
+func keepSecrets(imported, current *AppConfig) {
+	imported.AdminPasswordHash = current.AdminPasswordHash
+	imported.JWTSecret = current.JWTSecret
+	for i := range imported.Servers {
+		server := &imported.Servers[i]
+		if server.Token != "" {
+			continue
+		}
+		for _, existing := range current.Servers {
+			if existing.ID == server.ID {
+				server.Token = existing.Token
+				server.PreviousToken = existing.PreviousToken
+				server.PreviousTokenExpires = existing.PreviousTokenExpires
+				break
+			}
+		}
+	}
+	if imported.OAuth == nil || current.OAuth == nil {
+		return
+	}
+	if imported.OAuth.GitHub != nil && imported.OAuth.GitHub.ClientSecret == "" && current.OAuth.GitHub != nil {
+		imported.OAuth.GitHub.ClientSecret = current.OAuth.GitHub.ClientSecret
+	}
+	if imported.OAuth.Google != nil && imported.OAuth.Google.ClientSecret == "" && current.OAuth.Google != nil {
+		imported.OAuth.Google.ClientSecret = current.OAuth.Google.ClientSecret
+	}
+}
+
+// validateImportedConfig checks the structure of an imported config before it
+// is applied
+func validateImportedConfig(config *AppConfig) error {
+	if config.Port != "" {
+		if port, err := strconv.Atoi(config.Port); err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %q", config.Port)
+		}
+	}
+
+	serverIDs := make(map[string]bool)
+	for i, server := range config.Servers {
+		if server.ID == "" || server.Name == "" {
+			return fmt.Errorf("servers[%d]: id and name are required", i)
+		}
+		if serverIDs[server.ID] || server.ID == "local" {
+			return fmt.Errorf("servers[%d]: duplicate server id %q", i, server.ID)
+		}
+		serverIDs[server.ID] = true
+		serverURL, err := normalizeServerURL(server.URL)
+		if err != nil {
+			return fmt.Errorf("servers[%d]: %v", i, err)
+		}
+		config.Servers[i].URL = serverURL
+		if server.MonthlyQuotaBytes < 0 {
+			return fmt.Errorf("servers[%d]: monthly_quota_bytes must not be negative", i)
+		}
+	}
+
+	groupIDs := make(map[string]bool)
+	for i, group := range config.Groups {
+		if group.ID == "" || groupIDs[group.ID] {
+			return fmt.Errorf("groups[%d]: missing or duplicate id", i)
+		}
+		groupIDs[group.ID] = true
+	}
+
+	dimensionIDs := make(map[string]bool)
+	dimensionKeys := make(map[string]bool)
+	for i, dim := range config.GroupDimensions {
+		if dim.ID == "" || dim.Name == "" || dim.Key == "" {
+			return fmt.Errorf("group_dimensions[%d]: id, name and key are required", i)
+		}
+		if dimensionIDs[dim.ID] || dimensionKeys[dim.Key] {
+			return fmt.Errorf("group_dimensions[%d]: duplicate id or key", i)
+		}
+		dimensionIDs[dim.ID] = true
+		dimensionKeys[dim.Key] = true
+
+		optionIDs := make(map[string]bool)
+		for j, opt := range dim.Options {
+			if opt.ID == "" || optionIDs[opt.ID] {
+				return fmt.Errorf("group_dimensions[%d].options[%d]: missing or duplicate id", i, j)
+			}
+			optionIDs[opt.ID] = true
+		}
+	}
+
+	if _, err := parseTokenTTL(config.TokenTTL); err != nil {
+		return fmt.Errorf("token_ttl: %v", err)
+	}
+	if config.ProbeSettings.OfflineThresholdSecs < 0 {
+		return fmt.Errorf("probe_settings.offline_threshold_secs must not be negative")
+	}
+	for i := range config.ProbeSettings.PingTargets {
+		if err := normalizePingTarget(&config.ProbeSettings.PingTargets[i]); err != nil {
+			return fmt.Errorf("probe_settings.ping_targets[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// mergeConfig upserts the imported servers, groups and dimensions into current
+// by ID. Other settings are left as they are.
+func mergeConfig(current, imported *AppConfig) *AppConfig {
+	merged := *current
+
+	merged.Servers = append([]RemoteServer(nil), current.Servers...)
+	for _, server := range imported.Servers {
+		replaced := false
+		for i := range merged.Servers {
+			if merged.Servers[i].ID == server.ID {
+				merged.Servers[i] = server
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			server.SortOrder = merged.nextServerSortOrder()
+			merged.Servers = append(merged.Servers, server)
+		}
+	}
+
+	merged.Groups = append([]ServerGroup(nil), current.Groups...)
+	for _, group := range imported.Groups {
+		replaced := false
+		for i := range merged.Groups {
+			if merged.Groups[i].ID == group.ID {
+				merged.Groups[i] = group
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged.Groups = append(merged.Groups, group)
+		}
+	}
+
+	merged.GroupDimensions = append([]GroupDimension(nil), current.GroupDimensions...)
+	for _, dim := range imported.GroupDimensions {
+		replaced := false
+		for i := range merged.GroupDimensions {
+			if merged.GroupDimensions[i].ID == dim.ID {
+				merged.GroupDimensions[i] = dim
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged.GroupDimensions = append(merged.GroupDimensions, dim)
+		}
+	}
+
+	return &merged
+}
+
+// ExportConfig returns the full configuration without secrets. Agent tokens
+// are left out unless ?include_tokens=true is passed by an admin.
+func (s *AppState) ExportConfig(c *gin.Context) {
+	includeTokens := c.Query("include_tokens") == "true"
+	if includeTokens && !isAdmin(c) {
+		abortForbidden(c, ErrCodeAdminRequired, "Exporting agent tokens requires admin access")
+		return
+	}
+
+	s.ConfigMu.RLock()
+	exported, err := copyConfig(s.Config)
+	s.ConfigMu.RUnlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export config"})
+		return
+	}
+	stripSecrets(exported)
+	if !includeTokens {
+		stripAgentTokens(exported)
+	}
+
+	now := time.Now().UTC()
+	s.audit(c, "config.export", "", gin.H{"include_tokens": includeTokens})
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="vstats-config-%s.json"`, now.Format("20060102-150405")))
+	c.JSON(http.StatusOK, ConfigExport{
+		Version:    ConfigExportVersion,
+		ExportedAt: now.Format(time.RFC3339),
+		Config:     *exported,
+	})
+}
+
+// ImportConfig restores a file produced by ExportConfig. ?mode=merge (default)
+// upserts servers, groups and dimensions by ID; ?mode=replace swaps in the
+// whole config. ?regenerate_tokens=true issues new agent tokens for every
+// imported server; otherwise servers exported without tokens keep their
+// current ones. The previous config file is backed up before saving.
+func (s *AppState) ImportConfig(c *gin.Context) {
+	mode := c.DefaultQuery("mode", "merge")
+	if mode != "merge" && mode != "replace" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be merge or replace"})
+		return
+	}
+	regenerateTokens := c.Query("regenerate_tokens") == "true"
+
+	var req ConfigExport
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid config file"})
+		return
+	}
+	if req.Version != ConfigExportVersion {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unsupported export version %d", req.Version)})
+		return
+	}
+	imported := &req.Config
+	if err := validateImportedConfig(imported); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if regenerateTokens {
+		for i := range imported.Servers {
+			imported.Servers[i].Token = uuid.New().String()
+			imported.Servers[i].clearPreviousToken()
+		}
+	}
+
+	s.ConfigMu.Lock()
+	keepSecrets(imported, s.Config)
+	for i, server := range imported.Servers {
+		if server.Token == "" {
+			s.ConfigMu.Unlock()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("servers[%d]: missing token for a new server (set regenerate_tokens to issue new ones)", i)})
+			return
+		}
+	}
+	next := imported
+	if mode == "merge" {
+		next = mergeConfig(s.Config, imported)
+	} else {
+		sortServers(next.Servers)
+	}
+
+	backup, err := backupConfigFile()
+	if err != nil {
+		s.ConfigMu.Unlock()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to back up current config: " + err.Error()})
+		return
+	}
+	s.Config = next
+	SaveConfig(s.Config)
+	pingTargets := s.Config.ProbeSettings.PingTargets
+	kept := make(map[string]bool, len(s.Config.Servers))
+	for _, server := range s.Config.Servers {
+		kept[server.ID] = true
+	}
+	perCoreHistoryEnabled.Store(s.Config.PerCoreHistory)
+	setCustomHistoryKeys(s.Config.CustomHistoryKeys)
+	setMaxHistoryPoints(s.Config.MaxHistoryPoints)
+	setMaxClockSkew(s.Config.MaxClockSkewSecs)
+	InitTokenTTL(s.Config.TokenTTL)
+	s.ConfigMu.Unlock()
+
+	// Forget live metrics of servers a replace removed
+	s.AgentMetricsMu.Lock()
+	for id := range s.AgentMetrics {
+		if !kept[id] {
+			delete(s.AgentMetrics, id)
+		}
+	}
+	s.AgentMetricsMu.Unlock()
+
+	s.audit(c, "config.import", "", gin.H{
+		"mode":              mode,
+		"servers":           len(imported.Servers),
+		"regenerate_tokens": regenerateTokens,
+		"backup":            backup,
+	})
+
+	if mode == "replace" {
+		GetLocalCollector().SetPingTargets(pingTargets)
+		s.BroadcastPingTargets(pingTargets)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"mode":    mode,
+		"servers": len(imported.Servers),
+		"backup":  backup,
+	})
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+// A token-less export imports back onto the same servers without breaking
+// their agents
+func TestImportKeepsAgentTokens(t *testing.T) {
+	current := &AppConfig{Servers: []RemoteServer{
+		{ID: "a", Name: "A", Token: "token-a", PreviousToken: "old-a", PreviousTokenExpires: 42},
+	}}
+	exported, err := copyConfig(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripAgentTokens(exported)
+	if exported.Servers[0].Token != "" || exported.Servers[0].PreviousToken != "" {
+		t.Fatal("export still carries agent tokens")
+	}
+	if current.Servers[0].Token != "token-a" {
+		t.Fatal("stripping the export changed the live config")
+	}
+
+	exported.Servers = append(exported.Servers, RemoteServer{ID: "b", Name: "B"})
+	keepSecrets(exported, current)
+	if s := exported.Servers[0]; s.Token != "token-a" || s.PreviousToken != "old-a" || s.PreviousTokenExpires != 42 {
+		t.Fatalf("known server got %q/%q/%d, want its current tokens", s.Token, s.PreviousToken, s.PreviousTokenExpires)
+	}
+	if exported.Servers[1].Token != "" {
+		t.Fatal("new server was given a token")
+	}
+}
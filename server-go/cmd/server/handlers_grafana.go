@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// Grafana (SimpleJSON datasource)
+// ============================================================================
+
+// grafanaRanges are the history ranges a Grafana time range is served from,
+// finest first, with how far back each reaches
+var grafanaRanges = []struct {
+	name string
+	span time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"1y", 365 * 24 * time.Hour},
+}
+
+// grafanaServers returns server ID -> display name of the servers with
+// history. The local node's samples are only broadcast, never stored, so it
+// has no history to chart and is left out.
+func (s *AppState) grafanaServers() map[string]string {
+	s.ConfigMu.RLock()
+	defer s.ConfigMu.RUnlock()
+
+	servers := make(map[string]string, len(s.Config.Servers))
+	for _, server := range s.Config.Servers {
+		servers[server.ID] = server.Name
+	}
+	return servers
+}
+
+// resolveGrafanaTarget splits a "<server>.<metric>" target. The server part
+// may be a server ID or, if unambiguous, its name.
+func resolveGrafanaTarget(target string, servers map[string]string) (serverID, metric string, ok bool) {
+	dot := strings.LastIndex(target, ".")
+	if dot <= 0 {
+		return "", "", false
+	}
+	server, metric := target[:dot], target[dot+1:]
+	if _, known := aggregateMetrics[metric]; !known {
+		return "", "", false
+	}
+	if _, known := servers[server]; known {
+		return server, metric, true
+	}
+	for id, name := range servers {
+		if name != server {
+			continue
+		}
+		if serverID != "" {
+			return "", "", false // Ambiguous name
+		}
+		serverID = id
+	}
+	return serverID, metric, serverID != ""
+}
+
+// GrafanaTestConnection answers the datasource's "Save & test" probe
+func (s *AppState) GrafanaTestConnection(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// GrafanaSearch lists the available targets, one per server and metric.
+// The value is "<server_id>.<metric>"; the text uses the server name.
+func (s *AppState) GrafanaSearch(c *gin.Context) {
+	var req GrafanaSearchRequest
+	_ = c.ShouldBindJSON(&req) // GET and an empty body list everything
+
+	metrics := make([]string, 0, len(aggregateMetrics))
+	for metric := range aggregateMetrics {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	filter := strings.ToLower(req.Target)
+	options := []GrafanaSearchOption{}
+	for id, name := range s.grafanaServers() {
+		for _, metric := range metrics {
+			option := GrafanaSearchOption{Text: name + "." + metric, Value: id + "." + metric}
+			if filter != "" && !strings.Contains(strings.ToLower(option.Text), filter) && !strings.Contains(strings.ToLower(option.Value), filter) {
+				continue
+			}
+			options = append(options, option)
+		}
+	}
+	sort.Slice(options, func(i, j int) bool { return options[i].Text < options[j].Text })
+
+	c.JSON(http.StatusOK, options)
+}
+
+// GrafanaQuery returns one time series per target in Grafana's
+// [value, unix_ms] datapoint format. Points come from the finest history
+// range that covers the requested window and are averaged into intervalMs
+// buckets when that is coarser than the stored resolution.
+func (s *AppState) GrafanaQuery(c *gin.Context) {
+	var req GrafanaQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	to := req.Range.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	from := req.Range.From
+	if from.IsZero() {
+		from = to.Add(-time.Hour)
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "range.from must be before range.to"})
+		return
+	}
+
+	rangeStr := grafanaRanges[len(grafanaRanges)-1].name
+	for _, r := range grafanaRanges {
+		if time.Since(from) <= r.span {
+			rangeStr = r.name
+			break
+		}
+	}
+
+	servers := s.grafanaServers()
+	series := []GrafanaTimeSeries{}
+	for _, target := range req.Targets {
+		if target.Hide || target.Target == "" {
+			continue
+		}
+		serverID, metric, ok := resolveGrafanaTarget(target.Target, servers)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown target " + target.Target + ", expected <server>.<metric> as listed by /api/grafana/search"})
+			return
+		}
+
+		points, err := GetHistorySince(s.ReadDB, serverID, rangeStr, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch history"})
+			return
+		}
+		series = append(series, GrafanaTimeSeries{
+			Target:     target.Target,
+			Datapoints: grafanaDatapoints(points, aggregateMetrics[metric], from, to, req.IntervalMs),
+		})
+	}
+
+	c.JSON(http.StatusOK, series)
+}
+
+// grafanaDatapoints converts history points within [from, to] to
+// [value, unix_ms] pairs, averaging them per intervalMs when set
+func grafanaDatapoints(points []HistoryPoint, value func(p *HistoryPoint) (float64, bool), from, to time.Time, intervalMs int64) [][2]float64 {
+	type bucket struct {
+		sum   float64
+		count int
+	}
+	var order []int64
+	buckets := make(map[int64]*bucket)
+	for i := range points {
+		ts, err := time.Parse(time.RFC3339, points[i].Timestamp)
+		if err != nil || ts.Before(from) || ts.After(to) {
+			continue
+		}
+		v, ok := value(&points[i])
+		if !ok {
+			continue
+		}
+
+		key := ts.UnixMilli()
+		if intervalMs > 0 {
+			key -= key % intervalMs
+		}
+		b := buckets[key]
+		if b == nil {
+			b = &bucket{}
+			buckets[key] = b
+			order = append(order, key)
+		}
+		b.sum += v
+		b.count++
+	}
+
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+	datapoints := make([][2]float64, 0, len(order))
+	for _, key := range order {
+		b := buckets[key]
+		datapoints = append(datapoints, [2]float64{b.sum / float64(b.count), float64(key)})
+	}
+	return datapoints
+}
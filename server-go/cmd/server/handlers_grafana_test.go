@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The local node has no stored history, so search must not offer it
+func TestGrafanaSearchSkipsLocalNode(t *testing.T) {
+	s := &AppState{Config: &AppConfig{
+		LocalNode: LocalNodeConfig{Name: "dashboard-host"},
+		Servers:   []RemoteServer{{ID: "s1", Name: "web"}},
+	}}
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/grafana/search", nil)
+	s.GrafanaSearch(c)
+
+	var options []GrafanaSearchOption
+	if err := json.Unmarshal(rec.Body.Bytes(), &options); err != nil {
+		t.Fatal(err)
+	}
+	if len(options) != len(aggregateMetrics) {
+		t.Fatalf("got %d targets, want %d for the one server", len(options), len(aggregateMetrics))
+	}
+	for _, option := range options {
+		if !strings.HasPrefix(option.Value, "s1.") {
+			t.Errorf("unexpected target %s (%s)", option.Value, option.Text)
+		}
+	}
+}
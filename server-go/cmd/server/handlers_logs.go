@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// Agent Journal Logs
+// ============================================================================
+
+const (
+	DefaultLogLines  = 100
+	MaxLogLines      = 1000
+	LogsReplyTimeout = 20 * time.Second // Agent kills journalctl after 10s
+)
+
+// validUnit matches systemd unit names. The agent only reads units listed in
+// its log_units config; this just rejects obvious garbage early.
+var validUnit = regexp.MustCompile(`^[A-Za-z0-9@._:\\-]+$`)
+
+// GetServerLogs asks a connected agent for the last lines of a systemd
+// unit's journal: GET /api/servers/:id/logs?unit=nginx&lines=100
+func (s *AppState) GetServerLogs(c *gin.Context) {
+	serverID := c.Param("id")
+	unit := c.Query("unit")
+	if !validUnit.MatchString(unit) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unit is required and must be a systemd unit name"})
+		return
+	}
+	lines := DefaultLogLines
+	if raw := c.Query("lines"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > MaxLogLines {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "lines must be between 1 and 1000"})
+			return
+		}
+		lines = n
+	}
+
+	s.AgentConnsMu.RLock()
+	conn := s.AgentConns[serverID]
+	s.AgentConnsMu.RUnlock()
+	if conn == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent is not connected"})
+		return
+	}
+
+	s.audit(c, "server.logs", serverID, gin.H{"unit": unit, "lines": lines})
+	msg, err := requestAgent(c.Request.Context(), serverID, conn, AgentCommand{
+		Type:    "command",
+		Command: "tail_logs",
+		Unit:    unit,
+		Lines:   lines,
+	}, LogsReplyTimeout)
+	switch {
+	case errors.Is(err, errAgentQueueFull):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Agent send queue is full"})
+	case errors.Is(err, errAgentTimeout):
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Agent did not answer in time (agents older than this server don't support logs)"})
+	case errors.Is(err, errAgentDisconnected):
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Agent disconnected before answering"})
+	case err != nil:
+		// The client went away
+	case msg.Error != "":
+		c.JSON(http.StatusBadGateway, gin.H{"error": msg.Error})
+	default:
+		c.JSON(http.StatusOK, ServerLogsResponse{
+			ServerID:  serverID,
+			Unit:      unit,
+			Lines:     lines,
+			Output:    msg.Output,
+			Truncated: msg.Truncated,
+		})
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,85 +22,306 @@ import (
 type LocalMetricsResponse struct {
 	SystemMetrics
 	LocalNode LocalNodeConfig `json:"local_node"`
+	Human     *MetricsHuman   `json:"human,omitempty"` // Only with ?units=human
 }
 
 func (s *AppState) GetMetrics(c *gin.Context) {
-	metrics := CollectMetrics()
+	units, err := parseUnits(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	collected := CollectMetrics()
+	metrics, human := metricsWithUnits(&collected, units)
 
 	s.ConfigMu.RLock()
 	localNode := s.Config.LocalNode
 	s.ConfigMu.RUnlock()
 
 	c.JSON(http.StatusOK, LocalMetricsResponse{
-		SystemMetrics: metrics,
+		SystemMetrics: *metrics,
 		LocalNode:     localNode,
+		Human:         human,
 	})
 }
 
+// serverFilter holds the optional GetAllMetrics query filters
+type serverFilter struct {
+	groupID    string
+	dimensions map[string]string // dimension_id -> option_id, all must match
+	online     *bool
+	search     string // Lowercased
+}
+
+func parseServerFilter(c *gin.Context) (serverFilter, error) {
+	f := serverFilter{
+		groupID: c.Query("group_id"),
+		search:  strings.ToLower(strings.TrimSpace(c.Query("search"))),
+	}
+
+	for _, pair := range c.QueryArray("dimension") {
+		dimensionID, optionID, ok := strings.Cut(pair, ":")
+		if !ok || dimensionID == "" || optionID == "" {
+			return f, fmt.Errorf("dimension must be dimension_id:option_id")
+		}
+		if f.dimensions == nil {
+			f.dimensions = make(map[string]string)
+		}
+		f.dimensions[dimensionID] = optionID
+	}
+
+	if onlineStr := c.Query("online"); onlineStr != "" {
+		online, err := strconv.ParseBool(onlineStr)
+		if err != nil {
+			return f, fmt.Errorf("online must be true or false")
+		}
+		f.online = &online
+	}
+
+	return f, nil
+}
+
+func (f *serverFilter) matches(server *RemoteServer, online bool) bool {
+	if f.groupID != "" && server.GroupID != f.groupID {
+		return false
+	}
+	for dimensionID, optionID := range f.dimensions {
+		if server.GroupValues[dimensionID] != optionID {
+			return false
+		}
+	}
+	if f.online != nil && *f.online != online {
+		return false
+	}
+	if f.search != "" {
+		fields := []string{server.Name, server.Location, server.Provider, server.Tag, server.IP, server.PublicIP}
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), f.search) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// GetAllMetrics returns the current state of every agent. Optional query
+// params narrow the result: group_id, dimension=dimension_id:option_id
+// (repeatable), online=true|false, search (name, location, provider, tag,
+// IP or public IP) and limit/offset for paging. X-Total-Count carries the number of
+// matches before paging. units=bits|human changes how byte values are
+// reported, see UnitsBytes.
 func (s *AppState) GetAllMetrics(c *gin.Context) {
+	filter, err := parseServerFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	units, err := parseUnits(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil || limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must not be negative"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return
+	}
+
 	s.ConfigMu.RLock()
 	servers := s.Config.Servers
+	probe := s.Config.ProbeSettings
 	s.ConfigMu.RUnlock()
 
 	s.AgentMetricsMu.RLock()
 	defer s.AgentMetricsMu.RUnlock()
 
-	var updates []ServerMetricsUpdate
-	for _, server := range servers {
+	updates := []ServerMetricsUpdate{}
+	for i := range servers {
+		server := &servers[i]
 		metricsData := s.AgentMetrics[server.ID]
-		online := false
-		if metricsData != nil {
-			online = time.Since(metricsData.LastUpdated).Seconds() < 30
-		}
-
-		version := server.Version
-		if metricsData != nil && metricsData.Metrics.Version != "" {
-			version = metricsData.Metrics.Version
-		}
-
-		var metrics *SystemMetrics
-		if metricsData != nil {
-			metrics = &metricsData.Metrics
-		}
-
-		updates = append(updates, ServerMetricsUpdate{
-			ServerID:     server.ID,
-			ServerName:   server.Name,
-			Location:     server.Location,
-			Provider:     server.Provider,
-			Tag:          server.Tag,
-			GroupID:      server.GroupID,
-			Version:      version,
-			IP:           server.IP,
-			Online:       online,
-			Metrics:      metrics,
-			PriceAmount:  server.PriceAmount,
-			PricePeriod:  server.PricePeriod,
-			PurchaseDate: server.PurchaseDate,
-			TipBadge:     server.TipBadge,
-		})
+		online := metricsData.IsOnline(&probe)
+		if !filter.matches(server, online) {
+			continue
+		}
+
+		updates = append(updates, s.serverMetricsUpdate(server, metricsData, online, units))
+	}
+
+	c.Header("X-Total-Count", strconv.Itoa(len(updates)))
+	if offset >= len(updates) {
+		updates = updates[:0]
+	} else {
+		updates = updates[offset:]
+	}
+	if limit > 0 && limit < len(updates) {
+		updates = updates[:limit]
 	}
 
 	c.JSON(http.StatusOK, updates)
 }
 
+// serverMetricsUpdate builds the REST view of one server in the given
+// units; metricsData is nil if the agent hasn't reported since the server
+// started
+func (s *AppState) serverMetricsUpdate(server *RemoteServer, metricsData *AgentMetricsData, online bool, units string) ServerMetricsUpdate {
+	version := server.Version
+	if metricsData != nil && metricsData.Metrics.Version != "" {
+		version = metricsData.Metrics.Version
+	}
+
+	var metrics *SystemMetrics
+	if metricsData != nil {
+		metrics = &metricsData.Metrics
+	}
+	metrics, human := metricsWithUnits(metrics, units)
+
+	return ServerMetricsUpdate{
+		ServerID:     server.ID,
+		ServerName:   server.Name,
+		Location:     server.Location,
+		Provider:     server.Provider,
+		Tag:          server.Tag,
+		GroupID:      server.GroupID,
+		GroupValues:  server.GroupValues,
+		Version:      version,
+		IP:           server.IP,
+		PublicIP:     server.PublicIP,
+		Online:       online,
+		Metrics:      metrics,
+		PriceAmount:  server.PriceAmount,
+		PricePeriod:  server.PricePeriod,
+		PurchaseDate: server.PurchaseDate,
+		TipBadge:     server.TipBadge,
+		SortOrder:    server.SortOrder,
+		UpdateStatus: s.getUpdateStatus(server.ID),
+
+		MaintenanceUntil: server.activeMaintenance(time.Now()),
+		Human:            human,
+		RenewalDate:      server.renewalDay(time.Now()),
+		CountryCode:      server.CountryCode,
+		Latitude:         server.Latitude,
+		Longitude:        server.Longitude,
+	}
+}
+
+// GetServerMetrics returns the latest state of a single agent, the same
+// shape as one GET /api/metrics/all entry plus when it last reported
+func (s *AppState) GetServerMetrics(c *gin.Context) {
+	id := c.Param("id")
+	units, err := parseUnits(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.ConfigMu.RLock()
+	var server *RemoteServer
+	for i := range s.Config.Servers {
+		if s.Config.Servers[i].ID == id {
+			found := s.Config.Servers[i]
+			server = &found
+			break
+		}
+	}
+	probe := s.Config.ProbeSettings
+	s.ConfigMu.RUnlock()
+
+	if server == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return
+	}
+
+	s.AgentMetricsMu.RLock()
+	defer s.AgentMetricsMu.RUnlock()
+
+	metricsData := s.AgentMetrics[id]
+	response := ServerMetricsResponse{
+		ServerMetricsUpdate: s.serverMetricsUpdate(server, metricsData, metricsData.IsOnline(&probe), units),
+	}
+	if metricsData != nil {
+		lastUpdated := metricsData.LastUpdated.UTC().Format(time.RFC3339)
+		response.LastUpdated = &lastUpdated
+		if metricsData.LastSeen.After(metricsData.LastUpdated) {
+			lastSeen := metricsData.LastSeen.UTC().Format(time.RFC3339)
+			response.LastSeen = &lastSeen
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 // ============================================================================
 // History Handler
 // ============================================================================
 
+// GetHistory serves GET /api/history/:server_id.
+//
+// Incremental updates: each response carries last_bucket, the bucket index of
+// "now" for the requested range. Buckets are unix seconds divided by the
+// range resolution:
+//
+//	1h   bucket = unix / 5    (5-second raw samples)
+//	24h  bucket = unix / 120  (2-minute buckets)
+//
+// Clients pass the last_bucket they saw back as ?since= and receive only the
+// buckets at or after it, with incremental=true. Longer ranges (7d/30d/1y)
+// change slowly enough that they always return the full window and ignore
+// since.
+//
+// ?points= (10 to max_history_points, default 720) asks for about that many
+// points: coarser ranges group the aggregation buckets, and finer ones read
+// from a finer table where one still holds the whole range. It can't be
+// combined with since.
+//
+// ?units=bits reports net_rx/net_tx in bits, ?units=human adds formatted
+// net_rx_human/net_tx_human strings.
 func (s *AppState) GetHistory(c *gin.Context, db *sql.DB) {
 	serverID := c.Param("server_id")
 	rangeStr := c.DefaultQuery("range", "24h")
 	dataType := c.DefaultQuery("type", "all") // "ping", "metrics", or "all"
 	sinceStr := c.Query("since")              // Bucket number for incremental updates
 
+	units, err := parseUnits(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var sinceBucket int64
 	if sinceStr != "" {
-		fmt.Sscanf(sinceStr, "%d", &sinceBucket)
+		parsed, err := strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be a non-negative bucket number"})
+			return
+		}
+		sinceBucket = parsed
+	}
+
+	// ?points= trades resolution for size; incremental updates and the cache
+	// only cover the default resolution
+	points := DefaultHistoryPoints
+	if pointsStr := c.Query("points"); pointsStr != "" {
+		parsed, err := strconv.Atoi(pointsStr)
+		if limit := historyPointsLimit(); err != nil || parsed < MinHistoryPoints || parsed > limit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("points must be between %d and %d", MinHistoryPoints, limit)})
+			return
+		}
+		points = parsed
+	}
+	if points != DefaultHistoryPoints && sinceBucket > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since can't be combined with points"})
+		return
 	}
 
 	// Only use cache for 1h and 24h ranges with type=all
-	useCache := (rangeStr == "1h" || rangeStr == "24h" || rangeStr == "") && dataType == "all" && historyCache != nil
+	useCache := (rangeStr == "1h" || rangeStr == "24h" || rangeStr == "") && dataType == "all" && historyCache != nil && points == DefaultHistoryPoints
 
 	// Check cache first (for full queries only, not incremental)
 	if useCache && sinceBucket == 0 {
@@ -103,9 +329,10 @@ func (s *AppState) GetHistory(c *gin.Context, db *sql.DB) {
 			c.JSON(http.StatusOK, HistoryResponse{
 				ServerID:    serverID,
 				Range:       rangeStr,
-				Data:        cached.Data,
+				Data:        historyWithUnits(cached.Data, units),
 				PingTargets: cached.PingTargets,
 				LastBucket:  cached.LastBucket,
+				Units:       units,
 			})
 			return
 		}
@@ -123,7 +350,7 @@ func (s *AppState) GetHistory(c *gin.Context, db *sql.DB) {
 
 		go func() {
 			defer wg.Done()
-			data, metricsErr = GetHistorySince(db, serverID, rangeStr, sinceBucket)
+			data, metricsErr = GetHistoryPoints(db, serverID, rangeStr, sinceBucket, points)
 		}()
 
 		go func() {
@@ -140,7 +367,7 @@ func (s *AppState) GetHistory(c *gin.Context, db *sql.DB) {
 		// Ignore ping errors, just return empty if failed
 		_ = pingErr
 	} else if dataType == "metrics" {
-		data, metricsErr = GetHistorySince(db, serverID, rangeStr, sinceBucket)
+		data, metricsErr = GetHistoryPoints(db, serverID, rangeStr, sinceBucket, points)
 		if metricsErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch history"})
 			return
@@ -151,10 +378,12 @@ func (s *AppState) GetHistory(c *gin.Context, db *sql.DB) {
 
 	// Calculate last bucket from the data
 	now := time.Now().UTC()
-	switch rangeStr {
-	case "1h":
+	switch {
+	case points != DefaultHistoryPoints:
+		// Not resumable with ?since=
+	case rangeStr == "1h":
 		lastBucket = now.Unix() / 5
-	case "24h", "":
+	case rangeStr == "24h", rangeStr == "":
 		lastBucket = now.Unix() / 120
 	}
 
@@ -169,10 +398,442 @@ func (s *AppState) GetHistory(c *gin.Context, db *sql.DB) {
 	c.JSON(http.StatusOK, HistoryResponse{
 		ServerID:    serverID,
 		Range:       rangeStr,
-		Data:        data,
+		Data:        historyWithUnits(data, units),
 		PingTargets: pingTargets,
 		LastBucket:  lastBucket,
 		Incremental: sinceBucket > 0,
+		Units:       units,
+	})
+}
+
+// aggregateMetrics maps the ?metric= names of GetAggregateMetrics to history
+// point fields. ok is false when the point has no value for the metric.
+var aggregateMetrics = map[string]func(p *HistoryPoint) (value float64, ok bool){
+	"cpu":    func(p *HistoryPoint) (float64, bool) { return float64(p.CPU), true },
+	"memory": func(p *HistoryPoint) (float64, bool) { return float64(p.Memory), true },
+	"disk":   func(p *HistoryPoint) (float64, bool) { return float64(p.Disk), true },
+	"net_rx": func(p *HistoryPoint) (float64, bool) { return float64(p.NetRx), true },
+	"net_tx": func(p *HistoryPoint) (float64, bool) { return float64(p.NetTx), true },
+	"ping":   optionalMetric(func(p *HistoryPoint) *float64 { return p.PingMs }),
+	"load_1": optionalMetric(func(p *HistoryPoint) *float64 { return p.Load1 }),
+	"iowait": optionalMetric(func(p *HistoryPoint) *float64 { return p.IOWait }),
+	"steal":  optionalMetric(func(p *HistoryPoint) *float64 { return p.Steal }),
+}
+
+func optionalMetric(field func(p *HistoryPoint) *float64) func(p *HistoryPoint) (float64, bool) {
+	return func(p *HistoryPoint) (float64, bool) {
+		if v := field(p); v != nil {
+			return *v, true
+		}
+		return 0, false
+	}
+}
+
+// GetAggregateMetrics returns min/avg/max of one metric across all servers
+// whose dimension is set to the given option, per history bucket. Ranges and
+// buckets are the same as GetHistory's; buckets are aligned to the epoch, so
+// the same timestamp refers to the same window on every server.
+func (s *AppState) GetAggregateMetrics(c *gin.Context) {
+	dimensionID := c.Query("dimension")
+	optionID := c.Query("option")
+	metric := c.DefaultQuery("metric", "cpu")
+	rangeStr := c.DefaultQuery("range", "24h")
+
+	value, ok := aggregateMetrics[metric]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid metric, expected one of cpu, memory, disk, net_rx, net_tx, ping, load_1, iowait, steal"})
+		return
+	}
+	switch rangeStr {
+	case "1h", "24h", "7d", "30d", "1y":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range, expected 1h, 24h, 7d, 30d or 1y"})
+		return
+	}
+
+	s.ConfigMu.RLock()
+	var dimension *GroupDimension
+	for i := range s.Config.GroupDimensions {
+		if s.Config.GroupDimensions[i].ID == dimensionID {
+			dimension = &s.Config.GroupDimensions[i]
+			break
+		}
+	}
+	optionExists := false
+	if dimension != nil {
+		for _, opt := range dimension.Options {
+			if opt.ID == optionID {
+				optionExists = true
+				break
+			}
+		}
+	}
+	serverIDs := []string{}
+	for _, server := range s.Config.Servers {
+		if server.GroupValues[dimensionID] == optionID {
+			serverIDs = append(serverIDs, server.ID)
+		}
+	}
+	s.ConfigMu.RUnlock()
+
+	if dimension == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dimension not found"})
+		return
+	}
+	if !optionExists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Option not found"})
+		return
+	}
+
+	type bucket struct {
+		min, max, sum float64
+		count         int
+	}
+	buckets := make(map[string]*bucket)
+	for _, serverID := range serverIDs {
+		points, err := GetHistory(s.ReadDB, serverID, rangeStr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch history"})
+			return
+		}
+		for i := range points {
+			v, ok := value(&points[i])
+			if !ok {
+				continue
+			}
+			b := buckets[points[i].Timestamp]
+			if b == nil {
+				buckets[points[i].Timestamp] = &bucket{min: v, max: v, sum: v, count: 1}
+				continue
+			}
+			b.min = min(b.min, v)
+			b.max = max(b.max, v)
+			b.sum += v
+			b.count++
+		}
+	}
+
+	data := make([]AggregatePoint, 0, len(buckets))
+	for timestamp, b := range buckets {
+		data = append(data, AggregatePoint{
+			Timestamp: timestamp,
+			Min:       b.min,
+			Avg:       b.sum / float64(b.count),
+			Max:       b.max,
+			Servers:   b.count,
+		})
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Timestamp < data[j].Timestamp })
+
+	c.JSON(http.StatusOK, AggregateMetricsResponse{
+		Dimension: dimensionID,
+		Option:    optionID,
+		Metric:    metric,
+		Range:     rangeStr,
+		ServerIDs: serverIDs,
+		Data:      data,
+	})
+}
+
+// ============================================================================
+// Outages & Uptime Handlers
+// ============================================================================
+
+// GetCoreHistory returns per-core CPU history (range 1h or 24h). Samples are
+// only recorded while per_core_history is enabled in the config.
+func (s *AppState) GetCoreHistory(c *gin.Context) {
+	serverID := c.Param("server_id")
+	rangeStr := c.DefaultQuery("range", "1h")
+	if rangeStr != "1h" && rangeStr != "24h" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range, expected 1h or 24h"})
+		return
+	}
+
+	s.ConfigMu.RLock()
+	enabled := s.Config.PerCoreHistory
+	s.ConfigMu.RUnlock()
+
+	cores, err := GetCoreHistory(s.DB, serverID, rangeStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch core history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, CoreHistoryResponse{
+		ServerID: serverID,
+		Range:    rangeStr,
+		Enabled:  enabled,
+		Cores:    cores,
+	})
+}
+
+// GetCustomHistory returns the history of custom agent metrics (range 1h or
+// 24h, optionally one ?key=). Only keys listed in custom_history_keys are
+// recorded.
+func (s *AppState) GetCustomHistory(c *gin.Context) {
+	serverID := c.Param("server_id")
+	rangeStr := c.DefaultQuery("range", "1h")
+	if rangeStr != "1h" && rangeStr != "24h" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range, expected 1h or 24h"})
+		return
+	}
+
+	s.ConfigMu.RLock()
+	keys := append([]string{}, s.Config.CustomHistoryKeys...)
+	s.ConfigMu.RUnlock()
+
+	metrics, err := GetCustomHistory(s.DB, serverID, rangeStr, c.Query("key"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch custom metric history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, CustomHistoryResponse{
+		ServerID: serverID,
+		Range:    rangeStr,
+		Keys:     keys,
+		Metrics:  metrics,
+	})
+}
+
+func (s *AppState) GetServerOutages(c *gin.Context) {
+	serverID := c.Param("id")
+	rangeStr := c.DefaultQuery("range", "30d")
+
+	outages, total, err := GetOutages(s.DB, serverID, rangeStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch outages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, OutagesResponse{
+		ServerID:             serverID,
+		Range:                rangeStr,
+		Outages:              outages,
+		TotalDowntimeSeconds: total,
+	})
+}
+
+func (s *AppState) GetServerUptime(c *gin.Context) {
+	serverID := c.Param("id")
+	rangeStr := c.DefaultQuery("range", "30d")
+
+	var days int
+	if _, err := fmt.Sscanf(rangeStr, "%dd", &days); err != nil || days < 1 || days > 400 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range, expected e.g. 7d, 30d or 90d"})
+		return
+	}
+
+	uptime, breakdown, err := GetUptime(s.DB, serverID, days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute uptime"})
+		return
+	}
+
+	c.JSON(http.StatusOK, UptimeResponse{
+		ServerID:      serverID,
+		Range:         rangeStr,
+		UptimePercent: uptime,
+		Days:          breakdown,
+	})
+}
+
+// GetServerTraffic returns monthly traffic totals for a server, newest first
+func (s *AppState) GetServerTraffic(c *gin.Context) {
+	serverID := c.Param("id")
+
+	months, err := strconv.Atoi(c.DefaultQuery("months", "6"))
+	if err != nil || months < 1 || months > 24 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "months must be between 1 and 24"})
+		return
+	}
+
+	var quota int64
+	s.ConfigMu.RLock()
+	for _, server := range s.Config.Servers {
+		if server.ID == serverID {
+			quota = server.MonthlyQuotaBytes
+			break
+		}
+	}
+	s.ConfigMu.RUnlock()
+
+	traffic, err := GetTrafficMonthly(s.DB, serverID, months)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch traffic"})
+		return
+	}
+	exceeded, _ := trafficQuotaExceeded(s.DB, serverID, quota)
+
+	c.JSON(http.StatusOK, TrafficResponse{
+		ServerID:          serverID,
+		MonthlyQuotaBytes: quota,
+		QuotaExceeded:     exceeded,
+		Months:            traffic,
+	})
+}
+
+// GetServerRecords returns a server's peak metrics: all-time and for the
+// current month by default, or for ?month=YYYY-MM
+func (s *AppState) GetServerRecords(c *gin.Context) {
+	serverID := c.Param("id")
+	month := c.DefaultQuery("month", time.Now().UTC().Format("2006-01"))
+	if _, err := time.Parse("2006-01", month); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month must be YYYY-MM"})
+		return
+	}
+
+	records, err := GetMetricRecords(s.DB, serverID, []string{MetricRecordPeriodAll, month})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch records"})
+		return
+	}
+
+	c.JSON(http.StatusOK, MetricRecordsResponse{
+		ServerID: serverID,
+		Records:  records,
+	})
+}
+
+// GetServerConnections returns an agent's connect/disconnect history.
+// range is one of 1h, 24h (default), 7d or 30d.
+func (s *AppState) GetServerConnections(c *gin.Context) {
+	serverID := c.Param("id")
+	rangeStr := c.DefaultQuery("range", "24h")
+
+	var window time.Duration
+	switch rangeStr {
+	case "1h":
+		window = time.Hour
+	case "24h":
+		window = 24 * time.Hour
+	case "7d":
+		window = 7 * 24 * time.Hour
+	case "30d":
+		window = 30 * 24 * time.Hour
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "range must be 1h, 24h, 7d or 30d"})
+		return
+	}
+
+	events, err := GetConnectionEvents(s.DB, serverID, time.Now().Add(-window))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch connection events"})
+		return
+	}
+
+	resp := ConnectionEventsResponse{ServerID: serverID, Range: rangeStr, Events: events}
+	for _, e := range events {
+		if e.Event == ConnectionEventDisconnect {
+			resp.Disconnects++
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Bounds for GET /api/servers/:id/raw
+const (
+	DefaultRawWindow = time.Minute
+	MaxRawWindow     = 10 * time.Minute
+	MaxRawSamples    = 1000
+)
+
+// GetServerRaw returns the raw samples within ?window= (default 60s, at most
+// 10m) either side of ?at=, for looking at the exact values behind an alert.
+// Raw samples are only kept for 24 hours.
+func (s *AppState) GetServerRaw(c *gin.Context) {
+	serverID := c.Param("id")
+
+	at, err := time.Parse(time.RFC3339, c.Query("at"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or missing 'at', expected RFC3339"})
+		return
+	}
+	if time.Since(at) > 24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "'at' is older than the 24h raw retention"})
+		return
+	}
+	window := DefaultRawWindow
+	if w := c.Query("window"); w != "" {
+		if window, err = time.ParseDuration(w); err != nil || window <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'window', expected a duration like 60s"})
+			return
+		}
+		if window > MaxRawWindow {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "'window' must not exceed 10m"})
+			return
+		}
+	}
+
+	samples, err := GetRawMetrics(s.DB, serverID, at.Add(-window), at.Add(window), MaxRawSamples)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch raw metrics"})
+		return
+	}
+
+	resp := RawMetricsResponse{
+		ServerID: serverID,
+		At:       at.UTC().Format(time.RFC3339),
+		Window:   window.String(),
+		Samples:  samples,
+	}
+	var nearest time.Duration
+	for i := range samples {
+		ts, err := time.Parse(time.RFC3339, samples[i].Timestamp)
+		if err != nil {
+			continue
+		}
+		if d := ts.Sub(at).Abs(); resp.Nearest == nil || d < nearest {
+			resp.Nearest, nearest = &samples[i], d
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ============================================================================
+// Admin Handlers
+// ============================================================================
+
+// MaxReaggregateRange is the longest window one re-aggregation request may
+// rebuild; the request waits for it to finish
+const MaxReaggregateRange = 31 * 24 * time.Hour
+
+// Reaggregate rebuilds the 15min/hourly/daily rollups for a time window, for
+// repairing history after imports. from/to are RFC3339 timestamps.
+func (s *AppState) Reaggregate(c *gin.Context) {
+	from, err := time.Parse(time.RFC3339, c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or missing 'from', expected RFC3339"})
+		return
+	}
+	to := time.Now().UTC()
+	if toStr := c.Query("to"); toStr != "" {
+		if to, err = time.Parse(time.RFC3339, toStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'to', expected RFC3339"})
+			return
+		}
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "'from' must be before 'to'"})
+		return
+	}
+	if to.Sub(from) > MaxReaggregateRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Range must not exceed 31 days; split longer ranges into several requests"})
+		return
+	}
+
+	started := time.Now()
+	if err := AggregateRange(s.DB, from, to); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to re-aggregate metrics"})
+		return
+	}
+
+	s.audit(c, "admin.reaggregate", "", gin.H{"from": from.UTC().Format(time.RFC3339), "to": to.UTC().Format(time.RFC3339)})
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"from":        from.UTC().Format(time.RFC3339),
+		"to":          to.UTC().Format(time.RFC3339),
+		"duration_ms": time.Since(started).Milliseconds(),
 	})
 }
 
@@ -184,6 +845,68 @@ func HealthCheck(c *gin.Context) {
 	c.String(http.StatusOK, "OK")
 }
 
+// writeQueueSaturation is the fraction of the DBWriter queue that may be in
+// use before the instance reports itself as not ready
+const writeQueueSaturation = 0.9
+
+// ReadinessCheck reports whether the instance can serve traffic: the database
+// must answer a ping and the write queue must not be close to full. /health
+// stays a plain liveness check.
+func ReadinessCheck(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+
+	status := gin.H{
+		"status": "ready",
+		"time":   time.Now().UTC().Format(time.RFC3339),
+	}
+	failing := []string{}
+
+	if dbWriter == nil {
+		failing = append(failing, "database")
+		status["database"] = gin.H{"status": "down", "error": "database not initialized"}
+	} else if err := dbWriter.GetDB().PingContext(ctx); err != nil {
+		// The endpoint is public; the error may name paths, so it is only logged
+		slog.Error("Readiness check: database ping failed", "error", err)
+		failing = append(failing, "database")
+		status["database"] = gin.H{"status": "down", "error": "database unavailable"}
+	} else {
+		status["database"] = gin.H{"status": "up"}
+	}
+
+	if dbWriter != nil {
+		stats := dbWriter.Stats()
+		queue := gin.H{
+			"status":    "ok",
+			"depth":     stats.QueueDepth,
+			"capacity":  stats.QueueCapacity,
+			"queued":    stats.Queued,
+			"processed": stats.Processed,
+			"failed":    stats.Failed,
+			"dropped":   stats.Dropped,
+		}
+		if stats.QueueCapacity > 0 && float64(stats.QueueDepth) >= float64(stats.QueueCapacity)*writeQueueSaturation {
+			failing = append(failing, "write_queue")
+			queue["status"] = "saturated"
+		}
+		status["write_queue"] = queue
+	}
+
+	status["agent_connections"] = gin.H{
+		"open":          agentConnsOpen.Load(),
+		"rejected":      agentConnsRejected.Load(),
+		"auth_timeouts": agentAuthTimeouts.Load(),
+	}
+
+	status["failing"] = failing
+	if len(failing) > 0 {
+		status["status"] = "not_ready"
+		c.JSON(http.StatusServiceUnavailable, status)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
 // ============================================================================
 // Online Users Handler
 // ============================================================================
@@ -1,14 +1,20 @@
 package main
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"vstats/internal/oauthclient"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
@@ -22,6 +28,9 @@ var (
 
 const CentralizedOAuthURL = "https://vstats-oauth-proxy.zsai001.workers.dev"
 
+// oauthStateCookie binds a pending OAuth flow to the browser that started it
+const oauthStateCookie = "vstats_oauth_state"
+
 // ============================================================================
 // OAuth 2.0 Handlers
 // ============================================================================
@@ -71,6 +80,7 @@ func (s *AppState) GetOAuthSettings(c *gin.Context) {
 	if s.Config.OAuth != nil {
 		response["use_centralized"] = s.Config.OAuth.UseCentralized
 		response["allowed_users"] = s.Config.OAuth.AllowedUsers
+		response["viewer_users"] = s.Config.OAuth.ViewerUsers
 
 		if s.Config.OAuth.GitHub != nil {
 			response["github"] = gin.H{
@@ -78,6 +88,7 @@ func (s *AppState) GetOAuthSettings(c *gin.Context) {
 				"client_id":     s.Config.OAuth.GitHub.ClientID,
 				"has_secret":    s.Config.OAuth.GitHub.ClientSecret != "",
 				"allowed_users": s.Config.OAuth.GitHub.AllowedUsers,
+				"viewer_users":  s.Config.OAuth.GitHub.ViewerUsers,
 			}
 		}
 		if s.Config.OAuth.Google != nil {
@@ -86,6 +97,7 @@ func (s *AppState) GetOAuthSettings(c *gin.Context) {
 				"client_id":     s.Config.OAuth.Google.ClientID,
 				"has_secret":    s.Config.OAuth.Google.ClientSecret != "",
 				"allowed_users": s.Config.OAuth.Google.AllowedUsers,
+				"viewer_users":  s.Config.OAuth.Google.ViewerUsers,
 			}
 		}
 	}
@@ -98,17 +110,20 @@ func (s *AppState) UpdateOAuthSettings(c *gin.Context) {
 	var req struct {
 		UseCentralized *bool    `json:"use_centralized,omitempty"`
 		AllowedUsers   []string `json:"allowed_users,omitempty"`
+		ViewerUsers    []string `json:"viewer_users,omitempty"`
 		GitHub         *struct {
 			Enabled      bool     `json:"enabled"`
 			ClientID     string   `json:"client_id"`
 			ClientSecret string   `json:"client_secret,omitempty"`
 			AllowedUsers []string `json:"allowed_users"`
+			ViewerUsers  []string `json:"viewer_users,omitempty"`
 		} `json:"github,omitempty"`
 		Google *struct {
 			Enabled      bool     `json:"enabled"`
 			ClientID     string   `json:"client_id"`
 			ClientSecret string   `json:"client_secret,omitempty"`
 			AllowedUsers []string `json:"allowed_users"`
+			ViewerUsers  []string `json:"viewer_users,omitempty"`
 		} `json:"google,omitempty"`
 	}
 
@@ -131,6 +146,9 @@ func (s *AppState) UpdateOAuthSettings(c *gin.Context) {
 	if req.AllowedUsers != nil {
 		s.Config.OAuth.AllowedUsers = req.AllowedUsers
 	}
+	if req.ViewerUsers != nil {
+		s.Config.OAuth.ViewerUsers = req.ViewerUsers
+	}
 
 	// Update self-hosted OAuth settings
 	if req.GitHub != nil {
@@ -143,6 +161,9 @@ func (s *AppState) UpdateOAuthSettings(c *gin.Context) {
 			s.Config.OAuth.GitHub.ClientSecret = req.GitHub.ClientSecret
 		}
 		s.Config.OAuth.GitHub.AllowedUsers = req.GitHub.AllowedUsers
+		if req.GitHub.ViewerUsers != nil {
+			s.Config.OAuth.GitHub.ViewerUsers = req.GitHub.ViewerUsers
+		}
 	}
 
 	if req.Google != nil {
@@ -155,9 +176,32 @@ func (s *AppState) UpdateOAuthSettings(c *gin.Context) {
 			s.Config.OAuth.Google.ClientSecret = req.Google.ClientSecret
 		}
 		s.Config.OAuth.Google.AllowedUsers = req.Google.AllowedUsers
+		if req.Google.ViewerUsers != nil {
+			s.Config.OAuth.Google.ViewerUsers = req.Google.ViewerUsers
+		}
 	}
 
 	SaveConfig(s.Config)
+
+	// Log which providers changed, never the client secrets
+	detail := gin.H{}
+	if req.UseCentralized != nil {
+		detail["use_centralized"] = *req.UseCentralized
+	}
+	if req.AllowedUsers != nil {
+		detail["allowed_users"] = req.AllowedUsers
+	}
+	if req.ViewerUsers != nil {
+		detail["viewer_users"] = req.ViewerUsers
+	}
+	if req.GitHub != nil {
+		detail["github"] = gin.H{"enabled": req.GitHub.Enabled, "client_id": req.GitHub.ClientID, "allowed_users": req.GitHub.AllowedUsers, "viewer_users": req.GitHub.ViewerUsers, "secret_changed": req.GitHub.ClientSecret != ""}
+	}
+	if req.Google != nil {
+		detail["google"] = gin.H{"enabled": req.Google.Enabled, "client_id": req.Google.ClientID, "allowed_users": req.Google.AllowedUsers, "viewer_users": req.Google.ViewerUsers, "secret_changed": req.Google.ClientSecret != ""}
+	}
+	s.audit(c, "settings.oauth_update", "", detail)
+
 	c.JSON(http.StatusOK, gin.H{"status": "updated"})
 }
 
@@ -172,15 +216,11 @@ func (s *AppState) GitHubOAuthStart(c *gin.Context) {
 		return
 	}
 
-	state := uuid.New().String()
-
-	oauthStatesMu.Lock()
-	oauthStates[state] = &OAuthStateData{
-		Provider:  "github",
-		State:     state,
-		CreatedAt: time.Now().Unix(),
+	state, verifier, err := newOAuthState(c, "github")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start OAuth login"})
+		return
 	}
-	oauthStatesMu.Unlock()
 
 	// Clean up old states (older than 10 minutes)
 	go cleanupOAuthStates()
@@ -202,12 +242,7 @@ func (s *AppState) GitHubOAuthStart(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "GitHub OAuth not configured"})
 			return
 		}
-		authURL = fmt.Sprintf(
-			"https://github.com/login/oauth/authorize?client_id=%s&redirect_uri=%s&scope=read:user user:email&state=%s",
-			oauth.GitHub.ClientID,
-			url.QueryEscape(getCallbackURL(c, "github")),
-			state,
-		)
+		authURL = oauthclient.GitHubAuthURL(oauth.GitHub.ClientID, getCallbackURL(c, "github"), state, oauthclient.PKCEChallenge(verifier))
 	}
 
 	c.JSON(http.StatusOK, gin.H{"url": authURL})
@@ -222,14 +257,8 @@ func (s *AppState) GitHubOAuthCallback(c *gin.Context) {
 		return
 	}
 
-	// Verify state
-	oauthStatesMu.Lock()
-	stateData, exists := oauthStates[state]
-	if exists {
-		delete(oauthStates, state)
-	}
-	oauthStatesMu.Unlock()
-
+	// Verify state and that it belongs to this browser
+	stateData, exists := consumeOAuthState(c, state)
 	if !exists || stateData.Provider != "github" {
 		redirectWithError(c, "Invalid state parameter")
 		return
@@ -245,34 +274,40 @@ func (s *AppState) GitHubOAuthCallback(c *gin.Context) {
 	}
 
 	// Exchange code for token
-	tokenResp, err := exchangeGitHubCode(code, oauth.GitHub.ClientID, oauth.GitHub.ClientSecret, getCallbackURL(c, "github"))
+	tokenResp, err := oauthclient.ExchangeGitHubCode(c.Request.Context(), oauth.GitHub.ClientID, oauth.GitHub.ClientSecret, code, getCallbackURL(c, "github"), stateData.CodeVerifier)
 	if err != nil {
 		redirectWithError(c, "Failed to exchange code: "+err.Error())
 		return
 	}
 
 	// Get user info
-	user, err := getGitHubUser(tokenResp.AccessToken)
+	user, err := oauthclient.GetGitHubUser(c.Request.Context(), tokenResp.AccessToken)
 	if err != nil {
 		redirectWithError(c, "Failed to get user info: "+err.Error())
 		return
 	}
 
-	// Check if user is allowed
-	if !isUserAllowed(oauth.GitHub.AllowedUsers, user.Login) {
-		redirectWithError(c, "User not authorized: "+user.Login)
+	// Check if user is allowed (or mapped to a named user)
+	sub, role, ok := oauthSubject("github", user.Login, oauth.GitHub.AllowedUsers, oauth.GitHub.ViewerUsers)
+	if !ok {
+		redirectNotAllowed(c, user.Login)
 		return
 	}
 
 	// Generate JWT token
-	token, expiresAt, err := generateJWTToken(user.Login, "github")
+	token, expiresAt, err := generateJWTToken(sub, "github", role)
+	if err != nil {
+		redirectWithError(c, "Failed to generate token")
+		return
+	}
+	refreshToken, _, err := issueRefreshToken(sub, "github")
 	if err != nil {
 		redirectWithError(c, "Failed to generate token")
 		return
 	}
 
 	// Redirect to frontend with token
-	redirectWithToken(c, token, expiresAt, "github", user.Login)
+	redirectWithToken(c, token, refreshToken, expiresAt, "github", sub)
 }
 
 // Google OAuth handlers
@@ -286,15 +321,11 @@ func (s *AppState) GoogleOAuthStart(c *gin.Context) {
 		return
 	}
 
-	state := uuid.New().String()
-
-	oauthStatesMu.Lock()
-	oauthStates[state] = &OAuthStateData{
-		Provider:  "google",
-		State:     state,
-		CreatedAt: time.Now().Unix(),
+	state, verifier, err := newOAuthState(c, "google")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start OAuth login"})
+		return
 	}
-	oauthStatesMu.Unlock()
 
 	go cleanupOAuthStates()
 
@@ -315,12 +346,7 @@ func (s *AppState) GoogleOAuthStart(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Google OAuth not configured"})
 			return
 		}
-		authURL = fmt.Sprintf(
-			"https://accounts.google.com/o/oauth2/v2/auth?client_id=%s&redirect_uri=%s&response_type=code&scope=openid email profile&state=%s&access_type=offline",
-			oauth.Google.ClientID,
-			url.QueryEscape(getCallbackURL(c, "google")),
-			state,
-		)
+		authURL = oauthclient.GoogleAuthURL(oauth.Google.ClientID, getCallbackURL(c, "google"), state, oauthclient.PKCEChallenge(verifier))
 	}
 
 	c.JSON(http.StatusOK, gin.H{"url": authURL})
@@ -335,14 +361,8 @@ func (s *AppState) GoogleOAuthCallback(c *gin.Context) {
 		return
 	}
 
-	// Verify state
-	oauthStatesMu.Lock()
-	stateData, exists := oauthStates[state]
-	if exists {
-		delete(oauthStates, state)
-	}
-	oauthStatesMu.Unlock()
-
+	// Verify state and that it belongs to this browser
+	stateData, exists := consumeOAuthState(c, state)
 	if !exists || stateData.Provider != "google" {
 		redirectWithError(c, "Invalid state parameter")
 		return
@@ -358,34 +378,47 @@ func (s *AppState) GoogleOAuthCallback(c *gin.Context) {
 	}
 
 	// Exchange code for token
-	tokenResp, err := exchangeGoogleCode(code, oauth.Google.ClientID, oauth.Google.ClientSecret, getCallbackURL(c, "google"))
+	tokenResp, err := oauthclient.ExchangeGoogleCode(c.Request.Context(), oauth.Google.ClientID, oauth.Google.ClientSecret, code, getCallbackURL(c, "google"), stateData.CodeVerifier)
 	if err != nil {
 		redirectWithError(c, "Failed to exchange code: "+err.Error())
 		return
 	}
 
 	// Get user info
-	user, err := getGoogleUser(tokenResp.AccessToken)
+	user, err := oauthclient.GetGoogleUser(c.Request.Context(), tokenResp.AccessToken)
 	if err != nil {
 		redirectWithError(c, "Failed to get user info: "+err.Error())
 		return
 	}
 
-	// Check if user is allowed
-	if !isUserAllowed(oauth.Google.AllowedUsers, user.Email) {
-		redirectWithError(c, "User not authorized: "+user.Email)
+	// Anyone can put an arbitrary address on a Google account; only a
+	// verified one may be matched against the allowlist
+	if !user.VerifiedEmail {
+		redirectWithError(c, "Google account email is not verified")
+		return
+	}
+
+	// Check if user is allowed (or mapped to a named user)
+	sub, role, ok := oauthSubject("google", user.Email, oauth.Google.AllowedUsers, oauth.Google.ViewerUsers)
+	if !ok {
+		redirectNotAllowed(c, user.Email)
 		return
 	}
 
 	// Generate JWT token
-	token, expiresAt, err := generateJWTToken(user.Email, "google")
+	token, expiresAt, err := generateJWTToken(sub, "google", role)
+	if err != nil {
+		redirectWithError(c, "Failed to generate token")
+		return
+	}
+	refreshToken, _, err := issueRefreshToken(sub, "google")
 	if err != nil {
 		redirectWithError(c, "Failed to generate token")
 		return
 	}
 
 	// Redirect to frontend with token
-	redirectWithToken(c, token, expiresAt, "google", user.Email)
+	redirectWithToken(c, token, refreshToken, expiresAt, "google", sub)
 }
 
 // ProxyOAuthCallback handles OAuth callback from centralized OAuth proxy (vstats.zsoft.cc)
@@ -406,14 +439,8 @@ func (s *AppState) ProxyOAuthCallback(c *gin.Context) {
 		return
 	}
 
-	// Verify state
-	oauthStatesMu.Lock()
-	stateData, exists := oauthStates[state]
-	if exists {
-		delete(oauthStates, state)
-	}
-	oauthStatesMu.Unlock()
-
+	// Verify state and that it belongs to this browser
+	stateData, exists := consumeOAuthState(c, state)
 	if !exists {
 		redirectWithError(c, "Invalid or expired state parameter")
 		return
@@ -436,164 +463,171 @@ func (s *AppState) ProxyOAuthCallback(c *gin.Context) {
 		return
 	}
 
-	// Check allowed users (from centralized config)
-	if !isUserAllowed(oauth.AllowedUsers, user) {
-		redirectWithError(c, "User not authorized: "+user)
+	// Check allowed users (from centralized config, or mapped to a named user)
+	sub, role, ok := oauthSubject(provider, user, oauth.AllowedUsers, oauth.ViewerUsers)
+	if !ok {
+		redirectNotAllowed(c, user)
 		return
 	}
 
 	// Generate JWT token
-	token, expiresAt, err := generateJWTToken(user, provider)
+	token, expiresAt, err := generateJWTToken(sub, provider, role)
+	if err != nil {
+		redirectWithError(c, "Failed to generate token")
+		return
+	}
+	refreshToken, _, err := issueRefreshToken(sub, provider)
 	if err != nil {
 		redirectWithError(c, "Failed to generate token")
 		return
 	}
 
 	// Redirect to frontend with token
-	redirectWithToken(c, token, expiresAt, provider, user)
+	redirectWithToken(c, token, refreshToken, expiresAt, provider, sub)
 }
 
 // ============================================================================
 // OAuth Helper Functions
 // ============================================================================
 
-func getCallbackURL(c *gin.Context, provider string) string {
-	protocol := "https"
+// validHost matches a host[:port] that is safe to embed in URLs and shell
+// commands
+var validHost = regexp.MustCompile(`^[A-Za-z0-9.\-]+(:[0-9]+)?$|^\[[0-9A-Fa-f:.]+\](:[0-9]+)?$`)
 
-	// Priority: X-Forwarded-Proto header > TLS detection > localhost fallback
-	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
-		// Trust the X-Forwarded-Proto header from nginx
-		protocol = proto
-	} else if c.Request.TLS != nil {
-		// Direct TLS connection
-		protocol = "https"
-	} else if strings.Contains(c.Request.Host, "localhost") || strings.HasPrefix(c.Request.Host, "127.") {
-		// Localhost fallback
-		protocol = "http"
+// trustedProxies lists the networks whose forwarding headers are believed:
+// local reverse proxies, or anyone if VSTATS_TRUST_ALL_PROXIES=true
+func trustedProxies() []string {
+	if os.Getenv("VSTATS_TRUST_ALL_PROXIES") == "true" {
+		return []string{"0.0.0.0/0", "::/0"}
 	}
-
-	return fmt.Sprintf("%s://%s/api/auth/oauth/%s/callback", protocol, c.Request.Host, provider)
+	return []string{"127.0.0.1/32", "::1/128"}
 }
 
-func exchangeGitHubCode(code, clientID, clientSecret, redirectURI string) (*GitHubTokenResponse, error) {
-	data := url.Values{}
-	data.Set("client_id", clientID)
-	data.Set("client_secret", clientSecret)
-	data.Set("code", code)
-	data.Set("redirect_uri", redirectURI)
-
-	req, _ := http.NewRequest("POST", "https://github.com/login/oauth/access_token", strings.NewReader(data.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Accept", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+// fromTrustedProxy reports whether the request comes straight from a trusted
+// proxy, so its X-Forwarded-* headers can be used
+func fromTrustedProxy(c *gin.Context) bool {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
 	if err != nil {
-		return nil, err
+		host = c.Request.RemoteAddr
 	}
-	defer resp.Body.Close()
-
-	var tokenResp GitHubTokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		return nil, err
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
 	}
-
-	if tokenResp.AccessToken == "" {
-		return nil, fmt.Errorf("no access token in response")
+	for _, proxy := range trustedProxies() {
+		if _, network, err := net.ParseCIDR(proxy); err == nil && network.Contains(ip) {
+			return true
+		}
 	}
-
-	return &tokenResp, nil
+	return false
 }
 
-func getGitHubUser(accessToken string) (*GitHubUser, error) {
-	req, _ := http.NewRequest("GET", "https://api.github.com/user", nil)
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Accept", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// requestHost returns the host the client used: the first X-Forwarded-Host
+// value when behind a trusted reverse proxy, else the Host header. Empty if
+// neither is a valid host.
+func requestHost(c *gin.Context) string {
+	host := c.Request.Host
+	if forwarded := c.GetHeader("X-Forwarded-Host"); forwarded != "" && fromTrustedProxy(c) {
+		host = strings.TrimSpace(strings.Split(forwarded, ",")[0])
 	}
-	defer resp.Body.Close()
-
-	var user GitHubUser
-	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-		return nil, err
+	if !validHost.MatchString(host) {
+		return ""
 	}
-
-	return &user, nil
+	return host
 }
 
-func exchangeGoogleCode(code, clientID, clientSecret, redirectURI string) (*GoogleTokenResponse, error) {
-	data := url.Values{}
-	data.Set("client_id", clientID)
-	data.Set("client_secret", clientSecret)
-	data.Set("code", code)
-	data.Set("redirect_uri", redirectURI)
-	data.Set("grant_type", "authorization_code")
-
-	req, _ := http.NewRequest("POST", "https://oauth2.googleapis.com/token", strings.NewReader(data.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var tokenResp GoogleTokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		return nil, err
+// requestBaseURL returns the externally visible scheme://host of the request,
+// or "" if the host can't be determined. Shared by the OAuth callback URL and
+// the agent install command so both handle reverse proxies the same way.
+func requestBaseURL(c *gin.Context) string {
+	host := requestHost(c)
+	if host == "" {
+		return ""
 	}
+	protocol := "https"
 
-	if tokenResp.AccessToken == "" {
-		return nil, fmt.Errorf("no access token in response")
+	// Priority: X-Forwarded-Proto header > TLS detection > localhost fallback
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" && fromTrustedProxy(c) {
+		// Trust the X-Forwarded-Proto header from nginx; with chained proxies
+		// the first value is the one the client used
+		proto = strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+		if proto == "http" || proto == "https" {
+			protocol = proto
+		}
+	} else if c.Request.TLS != nil {
+		// Direct TLS connection
+		protocol = "https"
+	} else if strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.") || strings.HasPrefix(host, "[::1]") {
+		// Localhost fallback
+		protocol = "http"
 	}
 
-	return &tokenResp, nil
+	return fmt.Sprintf("%s://%s", protocol, host)
 }
 
-func getGoogleUser(accessToken string) (*GoogleUserInfo, error) {
-	req, _ := http.NewRequest("GET", "https://www.googleapis.com/oauth2/v2/userinfo", nil)
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var user GoogleUserInfo
-	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-		return nil, err
-	}
-
-	return &user, nil
+func getCallbackURL(c *gin.Context, provider string) string {
+	return fmt.Sprintf("%s/api/auth/oauth/%s/callback", requestBaseURL(c), provider)
 }
 
+// isUserAllowed checks an identifier against an allowlist. Entries match
+// case-insensitively and may contain '*' wildcards, e.g. "*@mycompany.com"
+// or "*" for anyone. Entries prefixed with '!' deny; a matching deny entry
+// always wins over any allow entry, regardless of order.
 func isUserAllowed(allowedUsers []string, identifier string) bool {
 	// If no allowed users specified, deny all users
 	if len(allowedUsers) == 0 {
 		return false
 	}
 
+	allowed := false
 	for _, u := range allowedUsers {
-		if strings.EqualFold(u, identifier) {
-			return true
+		u = strings.TrimSpace(u)
+		if pattern, deny := strings.CutPrefix(u, "!"); deny {
+			if matchUserPattern(pattern, identifier) {
+				return false
+			}
+		} else if !allowed && matchUserPattern(u, identifier) {
+			allowed = true
 		}
 	}
-	return false
+	return allowed
 }
 
-func generateJWTToken(sub, provider string) (string, time.Time, error) {
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
+// matchUserPattern reports whether identifier matches pattern, where '*'
+// matches any run of characters
+func matchUserPattern(pattern, identifier string) bool {
+	pattern = strings.ToLower(pattern)
+	identifier = strings.ToLower(identifier)
+	if !strings.Contains(pattern, "*") {
+		return pattern == identifier
+	}
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(identifier, parts[0]) {
+		return false
+	}
+	identifier = identifier[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(identifier, part)
+		if idx < 0 {
+			return false
+		}
+		identifier = identifier[idx+len(part):]
+	}
+	return strings.HasSuffix(identifier, parts[len(parts)-1])
+}
+
+// generateJWTToken mints a short-lived access token; clients renew it with
+// the refresh token from issueRefreshToken via POST /api/auth/refresh
+func generateJWTToken(sub, provider, role string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(accessTokenTTL())
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"sub":      sub,
 		"provider": provider,
+		"role":     role,
 		"exp":      expiresAt.Unix(),
+		"jti":      uuid.New().String(),
+		"typ":      "access",
 	})
 
 	tokenString, err := token.SignedString([]byte(GetJWTSecret()))
@@ -604,10 +638,11 @@ func generateJWTToken(sub, provider string) (string, time.Time, error) {
 	return tokenString, expiresAt, nil
 }
 
-func redirectWithToken(c *gin.Context, token string, expiresAt time.Time, provider, username string) {
+func redirectWithToken(c *gin.Context, token, refreshToken string, expiresAt time.Time, provider, username string) {
 	// Redirect to frontend OAuth callback page
-	redirectURL := fmt.Sprintf("/oauth-callback?token=%s&expires=%d&provider=%s&user=%s",
+	redirectURL := fmt.Sprintf("/oauth-callback?token=%s&refresh_token=%s&expires=%d&provider=%s&user=%s",
 		url.QueryEscape(token),
+		url.QueryEscape(refreshToken),
 		expiresAt.Unix(),
 		provider,
 		url.QueryEscape(username),
@@ -620,6 +655,62 @@ func redirectWithError(c *gin.Context, message string) {
 	c.Redirect(http.StatusTemporaryRedirect, redirectURL)
 }
 
+// redirectNotAllowed reports an account that isn't on the allowlist with an
+// error_code, so the callback page can explain it instead of showing the raw
+// message
+func redirectNotAllowed(c *gin.Context, user string) {
+	redirectURL := fmt.Sprintf("/oauth-callback?error=%s&error_code=%s&user=%s",
+		url.QueryEscape("User not authorized: "+user),
+		ErrCodeUserNotAllowed,
+		url.QueryEscape(user),
+	)
+	c.Redirect(http.StatusTemporaryRedirect, redirectURL)
+}
+
+// newOAuthState registers a pending OAuth flow and sets the cookie that ties
+// it to the current browser. The returned PKCE verifier is kept server-side.
+func newOAuthState(c *gin.Context, provider string) (state, verifier string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	state = uuid.New().String()
+	verifier = base64.RawURLEncoding.EncodeToString(buf)
+
+	oauthStatesMu.Lock()
+	oauthStates[state] = &OAuthStateData{
+		Provider:     provider,
+		State:        state,
+		CreatedAt:    time.Now().Unix(),
+		CodeVerifier: verifier,
+	}
+	oauthStatesMu.Unlock()
+
+	// Lax so the cookie survives the top-level redirect back from the provider
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, state, 600, "/api/auth/oauth", "", strings.HasPrefix(requestBaseURL(c), "https://"), true)
+	return state, verifier, nil
+}
+
+// consumeOAuthState removes and returns a pending OAuth flow. It only succeeds
+// when the callback comes from the browser that started the flow.
+func consumeOAuthState(c *gin.Context, state string) (*OAuthStateData, bool) {
+	cookie, _ := c.Cookie(oauthStateCookie)
+	c.SetCookie(oauthStateCookie, "", -1, "/api/auth/oauth", "", false, true)
+
+	oauthStatesMu.Lock()
+	stateData, exists := oauthStates[state]
+	if exists {
+		delete(oauthStates, state)
+	}
+	oauthStatesMu.Unlock()
+
+	if !exists || cookie != state {
+		return nil, false
+	}
+	return stateData, true
+}
+
 func cleanupOAuthStates() {
 	oauthStatesMu.Lock()
 	defer oauthStatesMu.Unlock()
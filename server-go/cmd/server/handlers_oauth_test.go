@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// X-Forwarded-Host and X-Forwarded-Proto only count when a trusted proxy
+// sends them; a client talking to the server directly can't pick the host of
+// OAuth callbacks and install commands
+func TestRequestBaseURLTrustsOnlyProxies(t *testing.T) {
+	t.Setenv("VSTATS_TRUST_ALL_PROXIES", "")
+	for _, tc := range []struct {
+		remoteAddr, want string
+	}{
+		{"127.0.0.1:51000", "https://vstats.example.com"},
+		{"[::1]:51000", "https://vstats.example.com"},
+		{"203.0.113.7:51000", "https://10.0.0.5:3001"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://10.0.0.5:3001/api/install-command", nil)
+		req.RemoteAddr = tc.remoteAddr
+		req.Header.Set("X-Forwarded-Host", "vstats.example.com")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+
+		if got := requestBaseURL(c); got != tc.want {
+			t.Errorf("from %s: got %q, want %q", tc.remoteAddr, got, tc.want)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -11,10 +15,51 @@ import (
 // Server Management Handlers
 // ============================================================================
 
+// GetServers lists the servers for anyone; agent tokens are left out
 func (s *AppState) GetServers(c *gin.Context) {
 	s.ConfigMu.RLock()
-	defer s.ConfigMu.RUnlock()
-	c.JSON(http.StatusOK, s.Config.Servers)
+	servers := append([]RemoteServer(nil), s.Config.Servers...)
+	s.ConfigMu.RUnlock()
+
+	for i := range servers {
+		servers[i].Token = ""
+		servers[i].clearPreviousToken()
+	}
+	c.JSON(http.StatusOK, servers)
+}
+
+// GetAdminServers lists the servers with their current agent tokens, for the
+// settings page. Rotated-out tokens are never returned.
+func (s *AppState) GetAdminServers(c *gin.Context) {
+	s.ConfigMu.RLock()
+	servers := append([]RemoteServer(nil), s.Config.Servers...)
+	s.ConfigMu.RUnlock()
+
+	for i := range servers {
+		servers[i].clearPreviousToken()
+	}
+	c.JSON(http.StatusOK, servers)
+}
+
+// normalizeServerURL validates an optional server URL and strips trailing
+// slashes. An empty URL is allowed; anything else must be an absolute
+// http(s) URL with a host.
+func normalizeServerURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid url %q: %v", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid url %q: scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid url %q: missing host", raw)
+	}
+	return strings.TrimRight(raw, "/"), nil
 }
 
 func (s *AppState) AddServer(c *gin.Context) {
@@ -23,11 +68,20 @@ func (s *AppState) AddServer(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
+	serverURL, err := normalizeServerURL(req.URL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateServerDate("expiry_date", req.ExpiryDate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	server := RemoteServer{
 		ID:           uuid.New().String(),
 		Name:         req.Name,
-		URL:          req.URL,
+		URL:          serverURL,
 		Location:     req.Location,
 		Provider:     req.Provider,
 		Tag:          req.Tag,
@@ -37,14 +91,19 @@ func (s *AppState) AddServer(c *gin.Context) {
 		PriceAmount:  req.PriceAmount,
 		PricePeriod:  req.PricePeriod,
 		PurchaseDate: req.PurchaseDate,
+		ExpiryDate:   req.ExpiryDate,
 		TipBadge:     req.TipBadge,
+
+		MonthlyQuotaBytes: req.MonthlyQuotaBytes,
 	}
 
 	s.ConfigMu.Lock()
+	server.SortOrder = s.Config.nextServerSortOrder()
 	s.Config.Servers = append(s.Config.Servers, server)
 	SaveConfig(s.Config)
 	s.ConfigMu.Unlock()
 
+	s.audit(c, "server.add", server.ID, gin.H{"name": server.Name})
 	c.JSON(http.StatusOK, server)
 }
 
@@ -52,12 +111,21 @@ func (s *AppState) DeleteServer(c *gin.Context) {
 	id := c.Param("id")
 
 	s.ConfigMu.Lock()
-	servers := make([]RemoteServer, 0)
+	servers := make([]RemoteServer, 0, len(s.Config.Servers))
+	var name string
+	found := false
 	for _, srv := range s.Config.Servers {
 		if srv.ID != id {
 			servers = append(servers, srv)
+		} else {
+			name, found = srv.Name, true
 		}
 	}
+	if !found {
+		s.ConfigMu.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return
+	}
 	s.Config.Servers = servers
 	SaveConfig(s.Config)
 	s.ConfigMu.Unlock()
@@ -66,9 +134,43 @@ func (s *AppState) DeleteServer(c *gin.Context) {
 	delete(s.AgentMetrics, id)
 	s.AgentMetricsMu.Unlock()
 
+	// History would otherwise stay until time-based cleanup, and daily
+	// rollups are kept forever
+	go func() {
+		if _, err := DeleteServerHistory(id); err != nil {
+			slog.Error("Failed to delete server history", "server_id", id, "error", err)
+		}
+		if historyCache != nil {
+			historyCache.InvalidateServer(id)
+		}
+	}()
+
+	s.audit(c, "server.delete", id, gin.H{"name": name})
 	c.Status(http.StatusOK)
 }
 
+// DeleteServerHistory purges a server's stored history but keeps the
+// server, e.g. after it was rebuilt
+func (s *AppState) DeleteServerHistory(c *gin.Context) {
+	id := c.Param("id")
+	if !s.serverKnown(id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return
+	}
+
+	deleted, err := DeleteServerHistory(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete history"})
+		return
+	}
+	if historyCache != nil {
+		historyCache.InvalidateServer(id)
+	}
+
+	s.audit(c, "server.delete_history", id, gin.H{"rows": deleted})
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
 func (s *AppState) UpdateServer(c *gin.Context) {
 	id := c.Param("id")
 
@@ -77,6 +179,20 @@ func (s *AppState) UpdateServer(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
+	if req.URL != nil {
+		serverURL, err := normalizeServerURL(*req.URL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.URL = &serverURL
+	}
+	if req.ExpiryDate != nil {
+		if err := validateServerDate("expiry_date", *req.ExpiryDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	s.ConfigMu.Lock()
 	defer s.ConfigMu.Unlock()
@@ -87,6 +203,9 @@ func (s *AppState) UpdateServer(c *gin.Context) {
 			if req.Name != nil {
 				s.Config.Servers[i].Name = *req.Name
 			}
+			if req.URL != nil {
+				s.Config.Servers[i].URL = *req.URL
+			}
 			if req.Location != nil {
 				s.Config.Servers[i].Location = *req.Location
 			}
@@ -111,9 +230,15 @@ func (s *AppState) UpdateServer(c *gin.Context) {
 			if req.PurchaseDate != nil {
 				s.Config.Servers[i].PurchaseDate = *req.PurchaseDate
 			}
+			if req.ExpiryDate != nil {
+				s.Config.Servers[i].ExpiryDate = *req.ExpiryDate
+			}
 			if req.TipBadge != nil {
 				s.Config.Servers[i].TipBadge = *req.TipBadge
 			}
+			if req.MonthlyQuotaBytes != nil {
+				s.Config.Servers[i].MonthlyQuotaBytes = *req.MonthlyQuotaBytes
+			}
 			updated = &s.Config.Servers[i]
 			break
 		}
@@ -125,9 +250,114 @@ func (s *AppState) UpdateServer(c *gin.Context) {
 	}
 
 	SaveConfig(s.Config)
+	s.audit(c, "server.update", id, req)
 	c.JSON(http.StatusOK, updated)
 }
 
+// ReorderServers assigns SortOrder from the position of each ID in the request
+func (s *AppState) ReorderServers(c *gin.Context) {
+	var req ReorderServersRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	s.ConfigMu.Lock()
+	defer s.ConfigMu.Unlock()
+
+	known := map[string]bool{"local": true}
+	for _, server := range s.Config.Servers {
+		known[server.ID] = true
+	}
+	positions := make(map[string]int, len(req.IDs))
+	for i, id := range req.IDs {
+		if !known[id] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown server: " + id})
+			return
+		}
+		if _, dup := positions[id]; dup {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Duplicate server: " + id})
+			return
+		}
+		positions[id] = i
+	}
+
+	// Unlisted servers follow the listed ones in their current order. The
+	// servers are reordered in a new slice, since readers may still be
+	// ranging over the one they copied from the config.
+	next := len(req.IDs)
+	if pos, ok := positions["local"]; ok {
+		s.Config.LocalNode.SortOrder = pos
+	} else {
+		s.Config.LocalNode.SortOrder = next
+		next++
+	}
+	servers := make([]RemoteServer, len(s.Config.Servers))
+	copy(servers, s.Config.Servers)
+	for i := range servers {
+		if pos, ok := positions[servers[i].ID]; ok {
+			servers[i].SortOrder = pos
+		} else {
+			servers[i].SortOrder = next
+			next++
+		}
+	}
+	sortServers(servers)
+	s.Config.Servers = servers
+
+	SaveConfig(s.Config)
+	s.audit(c, "server.reorder", "", req)
+	c.JSON(http.StatusOK, servers)
+}
+
+// serverKnown reports whether id is a configured server or the local node
+func (s *AppState) serverKnown(id string) bool {
+	if id == "local" {
+		return true
+	}
+	s.ConfigMu.RLock()
+	defer s.ConfigMu.RUnlock()
+	for _, server := range s.Config.Servers {
+		if server.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// RenamePingTarget moves a server's ping history to a new target name, to
+// be used together with renaming the target in the probe settings
+func (s *AppState) RenamePingTarget(c *gin.Context) {
+	id := c.Param("id")
+
+	var req RenamePingTargetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	req.From, req.To = strings.TrimSpace(req.From), strings.TrimSpace(req.To)
+	if req.From == "" || req.To == "" || req.From == req.To {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be two different target names"})
+		return
+	}
+	if !s.serverKnown(id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return
+	}
+
+	renamed, err := RenamePingTarget(id, req.From, req.To)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename ping target"})
+		return
+	}
+	if historyCache != nil {
+		historyCache.InvalidateServer(id)
+	}
+
+	s.audit(c, "server.rename_ping_target", id, req)
+	c.JSON(http.StatusOK, gin.H{"renamed": renamed})
+}
+
 // ============================================================================
 // Group Management Handlers
 // ============================================================================
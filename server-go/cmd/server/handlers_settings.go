@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"vstats/internal/common"
 
@@ -31,6 +34,7 @@ func (s *AppState) UpdateSiteSettings(c *gin.Context) {
 	s.Config.SiteSettings = settings
 	SaveConfig(s.Config)
 	s.ConfigMu.Unlock()
+	s.audit(c, "settings.site_update", "", nil)
 
 	// Broadcast the updated settings to all connected dashboard clients
 	s.BroadcastSiteSettings(&settings)
@@ -81,6 +85,7 @@ func (s *AppState) UpdateLocalNodeConfig(c *gin.Context) {
 	s.Config.LocalNode = config
 	SaveConfig(s.Config)
 	s.ConfigMu.Unlock()
+	s.audit(c, "settings.local_node_update", "local", config)
 
 	c.JSON(http.StatusOK, config)
 }
@@ -101,11 +106,22 @@ func (s *AppState) UpdateProbeSettings(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
+	if settings.OfflineThresholdSecs < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offline_threshold_secs must not be negative"})
+		return
+	}
+	for i := range settings.PingTargets {
+		if err := normalizePingTarget(&settings.PingTargets[i]); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("ping_targets[%d]: %v", i, err)})
+			return
+		}
+	}
 
 	s.ConfigMu.Lock()
 	s.Config.ProbeSettings = settings
 	SaveConfig(s.Config)
 	s.ConfigMu.Unlock()
+	s.audit(c, "settings.probe_update", "", settings)
 
 	// Update local collector's ping targets
 	localCollector := GetLocalCollector()
@@ -117,6 +133,43 @@ func (s *AppState) UpdateProbeSettings(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+// normalizePingTarget trims a ping target and rejects hosts the agents can't
+// probe, most commonly a URL pasted into the host field
+func normalizePingTarget(target *common.PingTargetConfig) error {
+	target.Host = strings.TrimSpace(target.Host)
+	target.URL = strings.TrimSpace(target.URL)
+
+	switch target.Type {
+	case "", "icmp", "tcp", "http":
+	default:
+		return fmt.Errorf("unknown type %q (use icmp, tcp or http)", target.Type)
+	}
+	if target.Port < 0 || target.Port > 65535 {
+		return fmt.Errorf("invalid port %d", target.Port)
+	}
+
+	if target.URL != "" {
+		u, err := url.Parse(target.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid url %q: must be an absolute http or https URL", target.URL)
+		}
+	}
+
+	if target.Host == "" {
+		if target.Type == "http" && target.URL != "" {
+			return nil
+		}
+		return fmt.Errorf("host is required")
+	}
+	if strings.Contains(target.Host, "://") {
+		return fmt.Errorf("invalid host %q: use a hostname or IP without a scheme", target.Host)
+	}
+	if strings.ContainsAny(target.Host, "/ \t") {
+		return fmt.Errorf("invalid host %q", target.Host)
+	}
+	return nil
+}
+
 // BroadcastPingTargets sends updated ping targets to all connected agents
 func (s *AppState) BroadcastPingTargets(targets []common.PingTargetConfig) {
 	msg := map[string]interface{}{
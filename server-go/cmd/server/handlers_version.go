@@ -1,11 +1,15 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -41,8 +45,21 @@ func CheckLatestVersion(c *gin.Context) {
 // Server Upgrade Handler
 // ============================================================================
 
+// Self-upgrade downloads and runs the installer as root, so it is disabled
+// unless VSTATS_ALLOW_SELF_UPGRADE=true. VSTATS_UPGRADE_URL overrides the
+// installer location. The installer's SHA-256 digest must be pinned out of
+// band, in the request or in VSTATS_UPGRADE_SHA256: a checksum served next to
+// the installer would be replaced along with it.
+const (
+	DefaultUpgradeURL   = "https://vstats.zsoft.cc/install.sh"
+	maxInstallerSize    = 1 << 20
+	upgradeFetchTimeout = 30 * time.Second
+)
+
 type UpgradeServerRequest struct {
-	Force bool `json:"force"`
+	Force    bool   `json:"force"`
+	Password string `json:"password"`         // Caller's login password, required to confirm
+	SHA256   string `json:"sha256,omitempty"` // Expected installer digest; defaults to VSTATS_UPGRADE_SHA256
 }
 
 type UpgradeServerResponse struct {
@@ -51,13 +68,140 @@ type UpgradeServerResponse struct {
 	Output  string `json:"output,omitempty"`
 }
 
-func UpgradeServer(c *gin.Context) {
+func selfUpgradeEnabled() bool {
+	return os.Getenv("VSTATS_ALLOW_SELF_UPGRADE") == "true"
+}
+
+func upgradeURL() string {
+	if url := os.Getenv("VSTATS_UPGRADE_URL"); url != "" {
+		return url
+	}
+	return DefaultUpgradeURL
+}
+
+// fetchUpgradeFile downloads a small file used by the upgrade
+func fetchUpgradeFile(url string) ([]byte, error) {
+	client := &http.Client{Timeout: upgradeFetchTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxInstallerSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxInstallerSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", url, maxInstallerSize)
+	}
+	return data, nil
+}
+
+// validSHA256 reports whether digest is a hex SHA-256 digest
+func validSHA256(digest string) bool {
+	decoded, err := hex.DecodeString(digest)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// pinnedUpgradeSHA256 returns the installer digest pinned with
+// VSTATS_UPGRADE_SHA256, or "" if none is
+func pinnedUpgradeSHA256() string {
+	return strings.ToLower(strings.TrimSpace(os.Getenv("VSTATS_UPGRADE_SHA256")))
+}
+
+// fetchVerifiedInstaller downloads the installer and checks it against the
+// expected SHA-256 digest
+func fetchVerifiedInstaller(url, expected string) ([]byte, error) {
+	installer, err := fetchUpgradeFile(url)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(installer)
+	if actual := hex.EncodeToString(digest[:]); actual != expected {
+		return nil, fmt.Errorf("installer checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	return installer, nil
+}
+
+// UpgradeServer reinstalls the server with the official installer. It must be
+// enabled with VSTATS_ALLOW_SELF_UPGRADE=true, requires the admin password,
+// and only runs an installer whose SHA-256 digest matches.
+func (s *AppState) UpgradeServer(c *gin.Context) {
+	if !selfUpgradeEnabled() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Self-upgrade is disabled; set VSTATS_ALLOW_SELF_UPGRADE=true to enable it"})
+		return
+	}
+
 	var req UpgradeServerRequest
-	c.ShouldBindJSON(&req)
+	if err := c.ShouldBindJSON(&req); err != nil || req.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Your password is required to confirm the upgrade"})
+		return
+	}
+	req.SHA256 = strings.ToLower(strings.TrimSpace(req.SHA256))
+	if req.SHA256 == "" {
+		req.SHA256 = pinnedUpgradeSHA256()
+	}
+	if req.SHA256 == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The installer's sha256 is required, in the request or VSTATS_UPGRADE_SHA256"})
+		return
+	}
+	if !validSHA256(req.SHA256) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sha256 must be a 64-character hex digest"})
+		return
+	}
+
+	clientIP := c.ClientIP()
+	if allowed, _ := s.reserveLoginAttempt(clientIP); !allowed {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, try again later"})
+		return
+	}
+	if !s.checkCallerPassword(c, req.Password) {
+		s.audit(c, "server.upgrade_denied", "", gin.H{"reason": "invalid password"})
+		abortForbidden(c, ErrCodeInvalidPassword, "Invalid password")
+		return
+	}
+	releaseLoginAttempt(clientIP)
+
+	url := upgradeURL()
+	installer, err := fetchVerifiedInstaller(url, req.SHA256)
+	if err != nil {
+		s.audit(c, "server.upgrade_denied", "", gin.H{"url": url, "reason": err.Error()})
+		c.JSON(http.StatusOK, UpgradeServerResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to verify installer: %v", err),
+		})
+		return
+	}
+
+	// Run the verified copy, never a second download
+	script, err := os.CreateTemp("", "vstats-install-*.sh")
+	if err != nil {
+		c.JSON(http.StatusOK, UpgradeServerResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to save installer: %v", err),
+		})
+		return
+	}
+	_, err = script.Write(installer)
+	if closeErr := script.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(script.Name())
+		c.JSON(http.StatusOK, UpgradeServerResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to save installer: %v", err),
+		})
+		return
+	}
 
 	// Always use --force flag to ensure reinstall even if version matches
 	// This ensures users can reinstall/repair if needed
-	upgradeCmd := "curl -fsSL https://vstats.zsoft.cc/install.sh | sudo bash -s -- --upgrade --force"
+	upgradeCmd := fmt.Sprintf("sudo bash %s --upgrade --force; rm -f %s", script.Name(), script.Name())
 
 	// Use nohup and setsid to run in a completely detached process
 	// that survives the server shutdown during upgrade:
@@ -69,9 +213,10 @@ func UpgradeServer(c *gin.Context) {
 
 	// Execute the detached command - use Start() not Run() so we don't wait
 	cmd := exec.Command("bash", "-c", detachedCmd)
-	err := cmd.Start()
+	err = cmd.Start()
 
 	if err != nil {
+		os.Remove(script.Name())
 		c.JSON(http.StatusOK, UpgradeServerResponse{
 			Success: false,
 			Message: fmt.Sprintf("Failed to start upgrade: %v", err),
@@ -79,6 +224,9 @@ func UpgradeServer(c *gin.Context) {
 		return
 	}
 
+	digest := sha256.Sum256(installer)
+	s.audit(c, "server.upgrade", "", gin.H{"url": url, "sha256": hex.EncodeToString(digest[:])})
+
 	c.JSON(http.StatusOK, UpgradeServerResponse{
 		Success: true,
 		Message: "Upgrade started in background (force mode). The server will restart shortly. Check /tmp/vstats-upgrade.log for details.",
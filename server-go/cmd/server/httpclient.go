@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// newOutboundClient returns an HTTP client for requests the server makes to
+// third parties (alert webhooks, GeoIP lookups). It goes through
+// HTTPS_PROXY/HTTP_PROXY, falling back to ALL_PROXY like the agent does, and
+// honours NO_PROXY.
+func newOutboundClient(timeout time.Duration) *http.Client {
+	cfg := httpproxy.FromEnvironment()
+	all := os.Getenv("ALL_PROXY")
+	if all == "" {
+		all = os.Getenv("all_proxy")
+	}
+	if cfg.HTTPProxy == "" {
+		cfg.HTTPProxy = all
+	}
+	if cfg.HTTPSProxy == "" {
+		cfg.HTTPSProxy = all
+	}
+	selectProxy := cfg.ProxyFunc()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return selectProxy(req.URL)
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
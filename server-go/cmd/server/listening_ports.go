@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// Listening Ports
+// ============================================================================
+
+// listeningPortsSeen remembers the last port set reported per server, so the
+// database is only written and new listeners only announced on a change
+var (
+	listeningPortsSeen   = make(map[string]map[string]bool) // server_id -> proto/address/port
+	listeningPortsSeenMu sync.Mutex
+)
+
+func portKey(p PortInfo) string {
+	return fmt.Sprintf("%s/%s/%d", p.Proto, p.Address, p.Port)
+}
+
+// recordListeningPorts stores the listening ports from a metrics sample and
+// warns about ports that weren't open in the previous report. The first
+// report after a restart is only stored. Agents without
+// report_listening_ports send none and are skipped.
+func (s *AppState) recordListeningPorts(serverID string, ports []PortInfo) {
+	if len(ports) == 0 {
+		return
+	}
+
+	current := make(map[string]bool, len(ports))
+	for _, p := range ports {
+		current[portKey(p)] = true
+	}
+
+	listeningPortsSeenMu.Lock()
+	previous, known := listeningPortsSeen[serverID]
+	changed := !known || len(previous) != len(current)
+	for key := range current {
+		if !previous[key] {
+			changed = true
+			break
+		}
+	}
+	listeningPortsSeen[serverID] = current
+	listeningPortsSeenMu.Unlock()
+	if !changed {
+		return
+	}
+
+	SyncListeningPorts(serverID, ports)
+	if !known {
+		return
+	}
+
+	s.ConfigMu.RLock()
+	name := serverID
+	for i := range s.Config.Servers {
+		if s.Config.Servers[i].ID == serverID {
+			name = s.Config.Servers[i].Name
+			break
+		}
+	}
+	s.ConfigMu.RUnlock()
+	for _, p := range ports {
+		if !previous[portKey(p)] {
+			slog.Warn("Server is listening on a new port", "server_id", serverID, "server", name,
+				"proto", p.Proto, "address", p.Address, "port", p.Port, "process", p.Process)
+		}
+	}
+}
+
+// GetServerPorts returns the ports a server listens on, with when each was
+// first seen, and the ones closed in the last 30 days
+func (s *AppState) GetServerPorts(c *gin.Context) {
+	serverID := c.Param("id")
+
+	ports, err := GetListeningPorts(s.DB, serverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch listening ports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ListeningPortsResponse{
+		ServerID: serverID,
+		Ports:    ports,
+	})
+}
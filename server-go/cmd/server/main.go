@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"vstats/internal/common"
+
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
@@ -19,6 +24,7 @@ var ServerVersion = "dev"
 func main() {
 	// Check for command line arguments
 	args := os.Args[1:]
+	common.SetupLogging(common.LogFormatFromArgs(args))
 
 	if len(args) > 0 {
 		switch args[0] {
@@ -75,48 +81,66 @@ func main() {
 		}
 	}
 
+	// Load config
+	config, initialPassword := LoadConfig()
+	if initialPassword != nil {
+		fmt.Println("\n╔════════════════════════════════════════════════════════════════╗")
+		fmt.Println("║              🎉 FIRST RUN - SAVE YOUR PASSWORD!               ║")
+		fmt.Println("╠════════════════════════════════════════════════════════════════╣")
+		fmt.Printf("║  Admin password: %-44s ║\n", *initialPassword)
+		fmt.Println("║                                                                ║")
+		fmt.Println("║  ⚠️  Save this password! It won't be shown again.              ║")
+		fmt.Println("║  To reset: sudo /opt/vstats/vstats-server --reset-password     ║")
+		fmt.Println("╚════════════════════════════════════════════════════════════════╝")
+	}
+
+	perCoreHistoryEnabled.Store(config.PerCoreHistory)
+	setCustomHistoryKeys(config.CustomHistoryKeys)
+	setMaxHistoryPoints(config.MaxHistoryPoints)
+	setMaxClockSkew(config.MaxClockSkewSecs)
+
 	// Initialize database
 	db, err := InitDatabase()
 	if err != nil {
 		fmt.Printf("Failed to initialize database: %v\n", err)
 		os.Exit(1)
 	}
-	defer db.Close()
 
 	// Initialize the database writer for serialized writes
-	// With batch buffers, only a few write jobs per second, so 100 is plenty
-	dbWriter = NewDBWriter(db, 100)
-	defer dbWriter.Close()
+	// Real-time metrics are batched, but replayed offline batches queue one job
+	// per metric; when the queue is full WriteAsync drops or blocks depending
+	// on db_write_queue.mode
+	dbWriter = NewDBWriter(db, config.DBWriteQueue.bufferSize())
+	dbWriter.SetBlocking(config.DBWriteQueue.blocking(), config.DBWriteQueue.blockTimeout())
+
+	// History queries read through their own read-only pool
+	readDB, err := OpenReadDB()
+	if err != nil {
+		slog.Warn("Failed to open read-only database pool, history reads share the main pool", "error", err)
+		readDB = db
+	}
 
 	// Initialize metrics buffer for batched real-time metrics writes
 	// Flush every 1 second or when buffer reaches 1000 items
 	metricsBuffer = NewMetricsBuffer(1*time.Second, 1000)
-	defer metricsBuffer.Close()
 
 	// Initialize aggregation buffer for batched writes (flush every 1 second)
 	aggBuffer = NewAggBuffer(1 * time.Second)
-	defer aggBuffer.Close()
 	fmt.Println("📊 Batch write buffers initialized (flush every 1s, supports 3000+ agents)")
 
 	// Initialize history cache with 10 second TTL
 	InitHistoryCache(10 * time.Second)
 
+	// Load revoked JWT IDs so logged-out tokens stay rejected across restarts
+	InitRevokedTokens(db)
+
+	// Load API keys for programmatic access
+	InitAPIKeys(db)
+	InitUsers(db)
+
 	fmt.Printf("📦 Database initialized: %s\n", GetDBPath())
 	fmt.Printf("⚙️  Config file: %s\n", GetConfigPath())
 
-	// Load config
-	config, initialPassword := LoadConfig()
-	if initialPassword != nil {
-		fmt.Println("\n╔════════════════════════════════════════════════════════════════╗")
-		fmt.Println("║              🎉 FIRST RUN - SAVE YOUR PASSWORD!               ║")
-		fmt.Println("╠════════════════════════════════════════════════════════════════╣")
-		fmt.Printf("║  Admin password: %-44s ║\n", *initialPassword)
-		fmt.Println("║                                                                ║")
-		fmt.Println("║  ⚠️  Save this password! It won't be shown again.              ║")
-		fmt.Println("║  To reset: sudo /opt/vstats/vstats-server --reset-password     ║")
-		fmt.Println("╚════════════════════════════════════════════════════════════════╝")
-	}
-
 	// Create app state
 	state := &AppState{
 		Config:           config,
@@ -131,6 +155,8 @@ func main() {
 		},
 		DashboardClients: make(map[*websocket.Conn]*DashboardClient),
 		DB:               db,
+		ReadDB:           readDB,
+		UpdateStatus:     make(map[string]*AgentUpdateStatus),
 	}
 
 	// Initialize local metrics collector with ping targets
@@ -140,14 +166,20 @@ func main() {
 		fmt.Printf("📡 Ping targets configured: %d targets\n", len(config.ProbeSettings.PingTargets))
 	}
 
-	// Setup signal handler for config reload (SIGHUP)
-	SetupSignalHandler(state)
+	// Setup signal handler for config reload (SIGHUP) and shutdown (SIGINT/SIGTERM)
+	shutdownSignals := SetupSignalHandler(state)
 
 	// Start background tasks
 	go snapshotRefreshLoop(state)  // Refresh dashboard snapshot every 5 seconds
 	go metricsBroadcastLoop(state) // Broadcast delta updates to connected dashboards
-	// NOTE: aggregation15MinLoop and aggregationLoop removed - aggregation now done on agent side
+	go aggregationLoop(db)         // Roll up raw metrics for agents that don't send their own
 	go cleanupLoop(db)
+	go state.trafficLoop(db)
+	go state.loginAttemptsSweepLoop()
+	go state.maintenanceLoop()
+	go state.openStartupOutages(time.Now())
+	go state.renewalLoop()
+	go state.vacuumLoop()
 
 	// Setup routes
 	gin.SetMode(gin.ReleaseMode)
@@ -155,33 +187,29 @@ func main() {
 
 	// Trust proxy headers (for X-Forwarded-Proto, X-Forwarded-For, etc.)
 	// This allows the app to correctly detect HTTPS when behind nginx
-	r.SetTrustedProxies([]string{"127.0.0.1", "::1"}) // Trust localhost proxies
-	// Also trust all proxies if VSTATS_TRUST_ALL_PROXIES is set
-	if os.Getenv("VSTATS_TRUST_ALL_PROXIES") == "true" {
-		r.SetTrustedProxies(nil) // nil means trust all proxies
-	}
+	r.SetTrustedProxies(trustedProxies())
 
 	// CORS middleware
-	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "*")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		c.Next()
-	})
+	r.Use(state.CORSMiddleware())
 
 	// Public routes
 	r.GET("/health", HealthCheck)
+	r.GET("/health/ready", ReadinessCheck)
 	r.GET("/api/metrics", state.GetMetrics)
 	r.GET("/api/metrics/all", state.GetAllMetrics)
+	r.GET("/api/metrics/aggregate", state.GetAggregateMetrics)
 	r.GET("/api/online-users", state.GetOnlineUsers)
 	r.GET("/api/history/:server_id", func(c *gin.Context) {
-		state.GetHistory(c, db)
+		state.GetHistory(c, readDB)
 	})
+	r.GET("/api/history/:server_id/cores", state.GetCoreHistory)
+	r.GET("/api/history/:server_id/custom", state.GetCustomHistory)
 	r.GET("/api/servers", state.GetServers)
+	r.GET("/api/servers/:id/metrics", state.GetServerMetrics)
+	r.GET("/api/servers/:id/outages", state.GetServerOutages)
+	r.GET("/api/servers/:id/uptime", state.GetServerUptime)
+	r.GET("/api/servers/:id/traffic", state.GetServerTraffic)
+	r.GET("/api/servers/:id/records", state.GetServerRecords)
 	r.GET("/api/groups", state.GetGroups)
 	r.GET("/api/dimensions", state.GetDimensions) // Public: get all dimensions for grouping
 	r.GET("/api/settings/site", state.GetSiteSettings)
@@ -190,6 +218,7 @@ func main() {
 	r.GET("/api/wallpaper/proxy", GetCustomWallpaper)
 	r.GET("/api/wallpaper/proxy/image", GetCustomWallpaperImage)
 	r.POST("/api/auth/login", state.Login)
+	r.POST("/api/auth/refresh", state.RefreshToken)
 	r.GET("/api/auth/verify", AuthMiddleware(), state.VerifyToken)
 
 	// OAuth 2.0 routes (public)
@@ -199,7 +228,7 @@ func main() {
 	r.GET("/api/auth/oauth/google", state.GoogleOAuthStart)
 	r.GET("/api/auth/oauth/google/callback", state.GoogleOAuthCallback)
 	r.GET("/api/auth/oauth/proxy/callback", state.ProxyOAuthCallback) // Centralized OAuth callback
-	r.GET("/api/install-command", AuthMiddleware(), state.GetInstallCommand)
+	r.GET("/api/install-command", AuthMiddleware(), RequireAdmin(), state.GetInstallCommand)
 	r.GET("/api/version", GetServerVersion)
 	r.GET("/version", GetServerVersion)
 	r.GET("/api/version/check", CheckLatestVersion)
@@ -216,18 +245,53 @@ func main() {
 	{
 		protected.POST("/api/servers", state.AddServer)
 		protected.DELETE("/api/servers/:id", state.DeleteServer)
+		protected.DELETE("/api/servers/:id/history", state.DeleteServerHistory)
+		protected.GET("/api/costs", state.GetCosts)
 		protected.PUT("/api/servers/:id", state.UpdateServer)
+		protected.POST("/api/servers/reorder", state.ReorderServers)
+		protected.POST("/api/servers/update-all", state.UpdateAllAgents)
+		protected.GET("/api/servers/expiring", state.GetExpiringServers)
 		protected.POST("/api/servers/:id/update", state.UpdateAgent)
+		protected.GET("/api/servers/:id/update-status", state.GetUpdateStatus)
+		protected.GET("/api/servers/:id/connections", state.GetServerConnections)
+		protected.GET("/api/servers/:id/raw", state.GetServerRaw)
+		protected.GET("/api/servers/:id/logs", RequireAdmin(), state.GetServerLogs)
+		protected.GET("/api/servers/:id/ports", state.GetServerPorts)
+		protected.POST("/api/servers/:id/refresh", state.RefreshServer)
+		// Grafana SimpleJSON datasource (URL: <server>/api/grafana)
+		protected.GET("/api/grafana", state.GrafanaTestConnection)
+		protected.GET("/api/grafana/", state.GrafanaTestConnection)
+		protected.GET("/api/grafana/search", state.GrafanaSearch)
+		protected.POST("/api/grafana/search", state.GrafanaSearch)
+		protected.POST("/api/grafana/query", state.GrafanaQuery)
+		protected.POST("/api/servers/:id/rotate-token", state.RotateAgentToken)
+		protected.POST("/api/servers/:id/maintenance", state.SetMaintenance)
+		protected.POST("/api/servers/:id/ping-targets/rename", state.RenamePingTarget)
 		protected.POST("/api/auth/password", state.ChangePassword)
+		protected.POST("/api/auth/logout", state.Logout)
 		protected.POST("/api/agent/register", state.RegisterAgent)
 		protected.PUT("/api/settings/site", state.UpdateSiteSettings)
-		protected.GET("/api/settings/local-node", state.GetLocalNodeConfig)
+		protected.GET("/api/settings/local-node", RequireAdmin(), state.GetLocalNodeConfig)
 		protected.PUT("/api/settings/local-node", state.UpdateLocalNodeConfig)
 		protected.GET("/api/settings/probe", state.GetProbeSettings)
 		protected.PUT("/api/settings/probe", state.UpdateProbeSettings)
-		protected.POST("/api/server/upgrade", UpgradeServer)
+		protected.POST("/api/server/upgrade", state.UpgradeServer)
+		protected.POST("/api/admin/reaggregate", state.Reaggregate)
+		protected.GET("/api/admin/aggregation-status", RequireAdmin(), state.GetAggregationStatus)
+		protected.GET("/api/admin/db-stats", RequireAdmin(), state.GetDBStats)
+		protected.GET("/api/admin/servers", RequireAdmin(), state.GetAdminServers)
+		protected.GET("/api/admin/audit", RequireAdmin(), state.GetAuditLog)
+		protected.GET("/api/admin/config/export", RequireAdmin(), state.ExportConfig)
+		protected.POST("/api/admin/config/import", state.ImportConfig)
+		protected.GET("/api/admin/apikeys", RequireAdmin(), state.ListAPIKeys)
+		protected.POST("/api/admin/apikeys", state.CreateAPIKey)
+		protected.DELETE("/api/admin/apikeys/:id", state.DeleteAPIKey)
+		protected.GET("/api/admin/users", RequireAdmin(), state.ListUsers)
+		protected.POST("/api/admin/users", state.CreateUser)
+		protected.PUT("/api/admin/users/:id", state.UpdateUser)
+		protected.DELETE("/api/admin/users/:id", state.DeleteUser)
 		// OAuth settings (admin only)
-		protected.GET("/api/settings/oauth", state.GetOAuthSettings)
+		protected.GET("/api/settings/oauth", RequireAdmin(), state.GetOAuthSettings)
 		protected.PUT("/api/settings/oauth", state.UpdateOAuthSettings)
 		// Group management (GET is public, mutations are protected)
 		protected.POST("/api/groups", state.AddGroup)
@@ -287,18 +351,61 @@ func main() {
 	if port == "" {
 		port = os.Getenv("VSTATS_PORT")
 	}
+	if port == "" && config.AutocertDomain != "" {
+		// ACME TLS-ALPN challenges are only made on 443
+		port = "443"
+	}
 	if port == "" {
 		port = "3001"
 	}
 
-	fmt.Printf("🚀 Server running on http://0.0.0.0:%s\n", port)
-	fmt.Printf("📡 Agent WebSocket: ws://0.0.0.0:%s/ws/agent\n", port)
+	scheme, wsScheme := "http", "ws"
+	if tlsEnabled(config) {
+		scheme, wsScheme = "https", "wss"
+	}
+	fmt.Printf("🚀 Server running on %s://0.0.0.0:%s\n", scheme, port)
+	fmt.Printf("📡 Agent WebSocket: %s://0.0.0.0:%s/ws/agent\n", wsScheme, port)
 	fmt.Printf("🔑 Reset password: sudo /opt/vstats/vstats-server --reset-password\n")
 
-	if err := r.Run("0.0.0.0:" + port); err != nil {
-		fmt.Printf("Failed to start server: %v\n", err)
-		os.Exit(1)
+	srv := &http.Server{
+		Addr:    "0.0.0.0:" + port,
+		Handler: r,
 	}
+	go func() {
+		if err := listenAndServe(srv, config); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Failed to start server: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	sig := <-shutdownSignals
+	fmt.Printf("\n🛑 Received %v, shutting down...\n", sig)
+	shutdownServer(srv, db, readDB)
+}
+
+// shutdownServer stops accepting requests, flushes buffered metrics through
+// the DBWriter and checkpoints the WAL before closing the database
+func shutdownServer(srv *http.Server, db, readDB *sql.DB) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Printf("⚠️  HTTP shutdown: %v\n", err)
+	}
+
+	// Buffers queue their final flush on the DBWriter, which then drains
+	metricsBuffer.Close()
+	aggBuffer.Close()
+	dbWriter.Close()
+
+	// Readers would keep the checkpoint from truncating the WAL
+	if readDB != db {
+		readDB.Close()
+	}
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		fmt.Printf("⚠️  WAL checkpoint failed: %v\n", err)
+	}
+	db.Close()
+	fmt.Println("👋 Shutdown complete")
 }
 
 func showDiagnostics() {
@@ -347,14 +454,29 @@ func boolToStr(b bool) string {
 }
 
 func metricsBroadcastLoop(state *AppState) {
-	ticker := time.NewTicker(5 * time.Second)
+	state.ConfigMu.RLock()
+	interval := state.Config.broadcastInterval()
+	state.ConfigMu.RUnlock()
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	// Maintenance state last sent to dashboards, per server
+	maintenanceSent := make(map[string]bool)
+	maintenanceWindows := make(maintenanceTracker)
+
 	for range ticker.C {
 		state.ConfigMu.RLock()
 		config := state.Config
+		thresholds := config.DeltaThresholds.effective()
+		newInterval := config.broadcastInterval()
 		state.ConfigMu.RUnlock()
 
+		if newInterval != interval {
+			interval = newInterval
+			ticker.Reset(interval)
+		}
+
 		state.AgentMetricsMu.RLock()
 		agentMetrics := make(map[string]*AgentMetricsData)
 		for k, v := range state.AgentMetrics {
@@ -362,6 +484,17 @@ func metricsBroadcastLoop(state *AppState) {
 		}
 		state.AgentMetricsMu.RUnlock()
 
+		state.DashboardMu.RLock()
+		clients := len(state.DashboardClients)
+		state.DashboardMu.RUnlock()
+
+		// Nobody would receive a delta; skip collecting and diffing but keep
+		// following online transitions so outage windows stay accurate
+		if clients == 0 {
+			state.trackOnlineStates(config, agentMetrics, maintenanceWindows)
+			continue
+		}
+
 		// Collect local metrics
 		localMetrics := CollectMetrics()
 
@@ -374,13 +507,17 @@ func metricsBroadcastLoop(state *AppState) {
 		localPrev := state.LastSent.Servers["local"]
 		state.LastSentMu.Unlock()
 
-		localChanged := localPrev == nil || localCompact.HasChanged(localPrev.Metrics)
+		localChanged := localPrev == nil || localCompact.HasChanged(localPrev.Metrics, thresholds)
 		if localChanged {
-			var diffMetrics *CompactMetrics
+			// Remember what dashboards now hold rather than the raw sample, so
+			// small moves below the threshold can't accumulate unnoticed
+			var diffMetrics, sentMetrics *CompactMetrics
 			if localPrev != nil {
-				diffMetrics = localCompact.Diff(localPrev.Metrics)
+				diffMetrics = localCompact.Diff(localPrev.Metrics, thresholds)
+				sentMetrics = localPrev.Metrics.Apply(diffMetrics)
 			} else {
 				diffMetrics = localCompact
+				sentMetrics = localCompact
 			}
 
 			if !diffMetrics.IsEmpty() {
@@ -397,18 +534,19 @@ func metricsBroadcastLoop(state *AppState) {
 				Metrics *CompactMetrics
 			}{
 				Online:  true,
-				Metrics: localCompact,
+				Metrics: sentMetrics,
 			}
 			state.LastSentMu.Unlock()
+		} else {
+			state.trackUptime("local", localCompact)
 		}
 
 		// Check remote servers
+		now := time.Now()
 		for _, server := range config.Servers {
 			metricsData := agentMetrics[server.ID]
-			online := false
-			if metricsData != nil {
-				online = time.Since(metricsData.LastUpdated).Seconds() < 30
-			}
+			online := metricsData.IsOnline(&config.ProbeSettings)
+			inMaintenance := server.InMaintenance(now)
 
 			currentMetrics := &CompactMetrics{}
 			if metricsData != nil {
@@ -429,9 +567,18 @@ func metricsBroadcastLoop(state *AppState) {
 			}
 
 			onlineChanged := online != prevOnline
-			metricsChanged := online && currentMetrics.HasChanged(prevMetrics)
+			metricsChanged := online && currentMetrics.HasChanged(prevMetrics, thresholds)
+			maintenanceChanged := inMaintenance != maintenanceSent[server.ID]
 
-			if onlineChanged || metricsChanged {
+			// Record outage windows on online/offline transitions
+			if end, ended := maintenanceWindows.ended(&server, now); ended && !online {
+				recordMaintenanceEnd(server.ID, end, metricsData)
+			}
+			if onlineChanged {
+				recordOnlineTransition(server.ID, online, inMaintenance, metricsData)
+			}
+
+			if onlineChanged || metricsChanged || maintenanceChanged {
 				update := CompactServerUpdate{
 					ID: server.ID,
 				}
@@ -439,12 +586,18 @@ func metricsBroadcastLoop(state *AppState) {
 				if onlineChanged {
 					update.On = &online
 				}
+				if maintenanceChanged {
+					update.Mt = &inMaintenance
+					maintenanceSent[server.ID] = inMaintenance
+				}
 
+				sentMetrics := prevMetrics
 				if metricsChanged && online {
-					update.M = currentMetrics.Diff(prevMetrics)
+					update.M = currentMetrics.Diff(prevMetrics, thresholds)
+					sentMetrics = prevMetrics.Apply(update.M)
 				}
 
-				if update.On != nil || (update.M != nil && !update.M.IsEmpty()) {
+				if update.On != nil || update.Mt != nil || (update.M != nil && !update.M.IsEmpty()) {
 					deltaUpdates = append(deltaUpdates, update)
 				}
 
@@ -454,9 +607,11 @@ func metricsBroadcastLoop(state *AppState) {
 					Metrics *CompactMetrics
 				}{
 					Online:  online,
-					Metrics: currentMetrics,
+					Metrics: sentMetrics,
 				}
 				state.LastSentMu.Unlock()
+			} else if online {
+				state.trackUptime(server.ID, currentMetrics)
 			}
 		}
 
@@ -475,9 +630,107 @@ func metricsBroadcastLoop(state *AppState) {
 	}
 }
 
-// NOTE: aggregation15MinLoop and aggregationLoop removed
-// Aggregation is now performed on the agent side and sent to server
-// This reduces server CPU load and allows agents to maintain their own historical data
+// recordOnlineTransition opens or closes an outage window for a server whose
+// online state just changed. Going offline during maintenance is expected and
+// doesn't open one; recordMaintenanceEnd does if the server is still down
+// when the window ends.
+func recordOnlineTransition(serverID string, online, inMaintenance bool, metricsData *AgentMetricsData) {
+	if online {
+		RecordOutageEnd(serverID, time.Now())
+	} else if inMaintenance {
+		return
+	} else if metricsData != nil {
+		RecordOutageStart(serverID, metricsData.LastAlive())
+	} else {
+		RecordOutageStart(serverID, time.Now())
+	}
+}
+
+// recordMaintenanceEnd opens an outage for a server that is offline when its
+// maintenance window ends. It starts at the end of the window, or when the
+// server was last seen if that is later.
+func recordMaintenanceEnd(serverID string, end time.Time, metricsData *AgentMetricsData) {
+	if metricsData != nil && metricsData.LastAlive().After(end) {
+		end = metricsData.LastAlive()
+	}
+	RecordOutageStart(serverID, end)
+}
+
+// openStartupOutages opens an outage for each configured server whose agent
+// has not connected within the offline threshold of startup. Such servers
+// never go through an online to offline transition, so their downtime would
+// otherwise go unrecorded. The outage starts when the server process did.
+func (s *AppState) openStartupOutages(started time.Time) {
+	s.ConfigMu.RLock()
+	wait := s.Config.ProbeSettings.OfflineThreshold(0)
+	s.ConfigMu.RUnlock()
+	time.Sleep(wait)
+
+	s.ConfigMu.RLock()
+	probe := s.Config.ProbeSettings
+	servers := append([]RemoteServer(nil), s.Config.Servers...)
+	s.ConfigMu.RUnlock()
+
+	now := time.Now()
+	for _, server := range servers {
+		s.AgentMetricsMu.RLock()
+		online := s.AgentMetrics[server.ID].IsOnline(&probe)
+		s.AgentMetricsMu.RUnlock()
+		if !online && !server.InMaintenance(now) {
+			RecordOutageStart(server.ID, started)
+		}
+	}
+}
+
+// trackOnlineStates updates only the online flags in LastSent, for ticks with
+// no dashboard connected. Metrics are left as last sent, so the first delta
+// after a client connects still carries everything that changed meanwhile.
+func (s *AppState) trackOnlineStates(config *AppConfig, agentMetrics map[string]*AgentMetricsData, windows maintenanceTracker) {
+	now := time.Now()
+	for _, server := range config.Servers {
+		metricsData := agentMetrics[server.ID]
+		online := metricsData.IsOnline(&config.ProbeSettings)
+		if end, ended := windows.ended(&server, now); ended && !online {
+			recordMaintenanceEnd(server.ID, end, metricsData)
+		}
+
+		s.LastSentMu.Lock()
+		prev := s.LastSent.Servers[server.ID]
+		prevOnline := prev != nil && prev.Online
+		if online != prevOnline {
+			prevMetrics := &CompactMetrics{}
+			if prev != nil {
+				prevMetrics = prev.Metrics
+			}
+			s.LastSent.Servers[server.ID] = &struct {
+				Online  bool
+				Metrics *CompactMetrics
+			}{
+				Online:  online,
+				Metrics: prevMetrics,
+			}
+		}
+		s.LastSentMu.Unlock()
+
+		if online != prevOnline {
+			recordOnlineTransition(server.ID, online, server.InMaintenance(now), metricsData)
+		}
+	}
+}
+
+// trackUptime keeps the last observed uptime current between sends. Without
+// it a reboot could go unnoticed whenever the new uptime is still above the
+// uptime that was last sent.
+func (s *AppState) trackUptime(serverID string, current *CompactMetrics) {
+	if current.Up == nil {
+		return
+	}
+	s.LastSentMu.Lock()
+	if prev := s.LastSent.Servers[serverID]; prev != nil && prev.Metrics != nil {
+		prev.Metrics.Up = current.Up
+	}
+	s.LastSentMu.Unlock()
+}
 
 // snapshotRefreshLoop periodically refreshes the dashboard snapshot
 func snapshotRefreshLoop(state *AppState) {
@@ -500,6 +753,7 @@ func cleanupLoop(db *sql.DB) {
 		if err := CleanupOldData(db); err != nil {
 			fmt.Printf("Failed to cleanup old data: %v\n", err)
 		}
+		cleanupRevokedTokens()
 	}
 }
 
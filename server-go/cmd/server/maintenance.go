@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// Maintenance Windows
+// ============================================================================
+
+// InMaintenance reports whether the server is inside a maintenance window.
+// Outages and alerts are not recorded while it is.
+func (r *RemoteServer) InMaintenance(now time.Time) bool {
+	return r.MaintenanceUntil != nil && now.Before(*r.MaintenanceUntil)
+}
+
+// activeMaintenance returns the end of the current window, nil if none
+func (r *RemoteServer) activeMaintenance(now time.Time) *time.Time {
+	if r.InMaintenance(now) {
+		return r.MaintenanceUntil
+	}
+	return nil
+}
+
+// maintenanceTracker remembers each server's maintenance window as of the
+// previous broadcast tick, to notice when a window ends
+type maintenanceTracker map[string]time.Time
+
+// ended records the server's current window and, if the window seen on the
+// previous call is over, returns when it ended. A window cleared early ends
+// now.
+func (m maintenanceTracker) ended(server *RemoteServer, now time.Time) (time.Time, bool) {
+	if until := server.activeMaintenance(now); until != nil {
+		m[server.ID] = *until
+		return time.Time{}, false
+	}
+	end, ok := m[server.ID]
+	if !ok {
+		return time.Time{}, false
+	}
+	delete(m, server.ID)
+	if end.After(now) {
+		end = now
+	}
+	return end, true
+}
+
+// SetMaintenance starts, extends or ends a server's maintenance window. A
+// future until or a positive duration_minutes sets the window; an empty body
+// ends it.
+func (s *AppState) SetMaintenance(c *gin.Context) {
+	id := c.Param("id")
+
+	var req MaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.Until != nil && req.DurationMinutes != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set either until or duration_minutes, not both"})
+		return
+	}
+	if req.DurationMinutes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duration_minutes must not be negative"})
+		return
+	}
+
+	var until *time.Time
+	now := time.Now()
+	if req.DurationMinutes > 0 {
+		end := now.Add(time.Duration(req.DurationMinutes) * time.Minute).UTC()
+		until = &end
+	} else if req.Until != nil && req.Until.After(now) {
+		end := req.Until.UTC()
+		until = &end
+	}
+
+	s.ConfigMu.Lock()
+	defer s.ConfigMu.Unlock()
+
+	var updated *RemoteServer
+	for i := range s.Config.Servers {
+		if s.Config.Servers[i].ID == id {
+			s.Config.Servers[i].MaintenanceUntil = until
+			updated = &s.Config.Servers[i]
+			break
+		}
+	}
+	if updated == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return
+	}
+
+	SaveConfig(s.Config)
+	s.audit(c, "server.maintenance", id, gin.H{"until": until})
+	c.JSON(http.StatusOK, gin.H{"server_id": id, "maintenance_until": until})
+}
+
+// maintenanceLoop clears maintenance windows once they have passed
+func (s *AppState) maintenanceLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		s.ConfigMu.Lock()
+		changed := false
+		for i := range s.Config.Servers {
+			server := &s.Config.Servers[i]
+			if server.MaintenanceUntil != nil && !server.InMaintenance(now) {
+				server.MaintenanceUntil = nil
+				changed = true
+				slog.Info("Maintenance window ended", "server_id", server.ID)
+			}
+		}
+		if changed {
+			SaveConfig(s.Config)
+		}
+		s.ConfigMu.Unlock()
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceTrackerEnded(t *testing.T) {
+	now := time.Now()
+	until := now.Add(time.Hour)
+	server := &RemoteServer{ID: "srv", MaintenanceUntil: &until}
+	windows := make(maintenanceTracker)
+
+	if _, ended := windows.ended(server, now); ended {
+		t.Fatal("window reported ended while active")
+	}
+
+	// The window runs out
+	later := until.Add(30 * time.Second)
+	end, ended := windows.ended(server, later)
+	if !ended || !end.Equal(until) {
+		t.Fatalf("ended() = %v, %v; want %v, true", end, ended, until)
+	}
+	if _, ended := windows.ended(server, later); ended {
+		t.Fatal("window reported ended twice")
+	}
+
+	// A window cleared early ends at the time it was cleared
+	next := later.Add(time.Hour)
+	server.MaintenanceUntil = &next
+	windows.ended(server, later)
+	server.MaintenanceUntil = nil
+	cleared := later.Add(time.Minute)
+	if end, ended := windows.ended(server, cleared); !ended || !end.Equal(cleared) {
+		t.Fatalf("ended() after clearing = %v, %v; want %v, true", end, ended, cleared)
+	}
+}
@@ -1,24 +1,79 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Context keys set by AuthMiddleware
+const (
+	ContextSub      = "sub"
+	ContextProvider = "provider"
+	ContextJTI      = "jti"
+	ContextExp      = "exp"
+	ContextScope    = "scope" // Only set for API keys
+	ContextRole     = "role"  // Only set for logins (JWT)
+)
+
+// Error codes sent as error_code with 401 and 403 responses. A 401 means the
+// credentials are missing or no longer valid and the client should log in
+// again; a 403 means they are valid but don't allow the request.
+const (
+	ErrCodeTokenMissing    = "token_missing"
+	ErrCodeTokenInvalid    = "token_invalid"
+	ErrCodeTokenExpired    = "token_expired"
+	ErrCodeTokenRevoked    = "token_revoked"
+	ErrCodeReadOnly        = "read_only"
+	ErrCodeAdminRequired   = "admin_required"
+	ErrCodeUserNotAllowed  = "user_not_allowed"
+	ErrCodeInvalidPassword = "invalid_password"
+	ErrCodeEnrollmentOnly  = "enrollment_only"
+)
+
+// abortUnauthorized rejects a request for missing or invalid credentials.
+// The WWW-Authenticate challenge follows RFC 6750, which leaves out the error
+// when no token was sent.
+func abortUnauthorized(c *gin.Context, code, message string) {
+	challenge := `Bearer realm="vstats"`
+	if code != ErrCodeTokenMissing {
+		challenge += `, error="invalid_token"`
+	}
+	c.Header("WWW-Authenticate", challenge)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": message, "error_code": code})
+}
+
+// abortForbidden rejects a request the authenticated caller may not make
+func abortForbidden(c *gin.Context, code, message string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": message, "error_code": code})
+}
+
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+
+		// API keys are accepted as X-API-Key or as a bearer token with the key prefix
+		apiKey := c.GetHeader("X-API-Key")
+		if apiKey == "" && strings.HasPrefix(authHeader, "Bearer "+APIKeyPrefix) {
+			apiKey = strings.TrimPrefix(authHeader, "Bearer ")
+		}
+		if apiKey != "" {
+			authenticateAPIKey(c, apiKey)
+			return
+		}
+
 		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing authorization header"})
+			abortUnauthorized(c, ErrCodeTokenMissing, "Missing authorization header")
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == authHeader {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
+			abortUnauthorized(c, ErrCodeTokenInvalid, "Invalid authorization header format")
 			return
 		}
 
@@ -26,8 +81,61 @@ func AuthMiddleware() gin.HandlerFunc {
 			return []byte(GetJWTSecret()), nil
 		})
 
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			abortUnauthorized(c, ErrCodeTokenExpired, "Token has expired")
+			return
+		}
 		if err != nil || !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			abortUnauthorized(c, ErrCodeTokenInvalid, "Invalid token")
+			return
+		}
+
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			// Enrollment tokens from install commands only register agents
+			if typ, _ := claims["typ"].(string); typ == enrollmentTokenType {
+				if c.Request.Method != http.MethodPost || c.FullPath() != "/api/agent/register" {
+					abortForbidden(c, ErrCodeEnrollmentOnly, "Enrollment tokens can only register agents")
+					return
+				}
+			}
+			if jti, ok := claims["jti"].(string); ok && jti != "" {
+				if isTokenRevoked(jti) {
+					abortUnauthorized(c, ErrCodeTokenRevoked, "Token has been revoked")
+					return
+				}
+				c.Set(ContextJTI, jti)
+			}
+			if sub, ok := claims["sub"].(string); ok {
+				c.Set(ContextSub, sub)
+			}
+			if provider, ok := claims["provider"].(string); ok {
+				c.Set(ContextProvider, provider)
+			}
+			if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+				c.Set(ContextExp, exp.Time)
+			}
+			// Tokens issued before roles existed all had full access
+			role := UserRoleAdmin
+			if r, ok := claims["role"].(string); ok && r != "" {
+				role = r
+			}
+			c.Set(ContextRole, role)
+		}
+
+		// Password logins other than the built-in admin belong to named
+		// users; tokens of a deleted user stop working immediately, and a
+		// role change applies without waiting for the token to expire
+		if sub := GetSub(c); GetProvider(c) == "password" && sub != AdminUsername {
+			user, ok := lookupUser(sub)
+			if !ok {
+				abortUnauthorized(c, ErrCodeTokenRevoked, "User no longer exists")
+				return
+			}
+			c.Set(ContextRole, user.Role)
+		}
+
+		if GetRole(c) == UserRoleViewer && !viewerMayRequest(c) {
+			abortForbidden(c, ErrCodeReadOnly, "Viewers have read-only access")
 			return
 		}
 
@@ -35,3 +143,188 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// viewerMayRequest reports whether the viewer role may make this request:
+// any read-only request, plus logging out and changing their own password
+func viewerMayRequest(c *gin.Context) bool {
+	if isReadOnlyRequest(c) {
+		return true
+	}
+	switch c.FullPath() {
+	case "/api/auth/logout", "/api/auth/password":
+		return true
+	}
+	return false
+}
+
+// isReadOnlyRequest reports whether the request cannot change anything: any
+// GET/HEAD, plus the Grafana datasource queries, which are POSTs
+func isReadOnlyRequest(c *gin.Context) bool {
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		return true
+	}
+	switch c.FullPath() {
+	case "/api/grafana/search", "/api/grafana/query":
+		return true
+	}
+	return false
+}
+
+// RequireAdmin rejects viewers and read-scoped API keys on GET endpoints
+// that expose secrets or administrative data (agent tokens, audit log,
+// users, OAuth settings). It runs after AuthMiddleware.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isAdmin(c) {
+			abortForbidden(c, ErrCodeAdminRequired, "Admin role required")
+			return
+		}
+		c.Next()
+	}
+}
+
+// isAdmin reports whether the caller has full access: an admin login or an
+// API key with admin scope
+func isAdmin(c *gin.Context) bool {
+	if GetProvider(c) == "apikey" {
+		return GetScope(c) == APIKeyScopeAdmin
+	}
+	return GetRole(c) != UserRoleViewer
+}
+
+// CORSMiddleware answers preflight requests and sets the CORS headers. With
+// the default allowed_origins ["*"] any origin is allowed; otherwise the
+// request origin is echoed back only if it matches the list.
+func (s *AppState) CORSMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.ConfigMu.RLock()
+		allowed := s.Config.AllowedOrigins
+		s.ConfigMu.RUnlock()
+
+		if len(allowed) == 0 || containsString(allowed, "*") {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Vary", "Origin")
+			if origin := c.GetHeader("Origin"); origin != "" && originAllowed(origin, allowed) {
+				c.Header("Access-Control-Allow-Origin", origin)
+			}
+		}
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "*")
+		// Cross-origin clients can't read the paging total otherwise
+		c.Header("Access-Control-Expose-Headers", "X-Total-Count")
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+		c.Next()
+	}
+}
+
+// originAllowed matches an Origin header against allowed_origins entries:
+// exact origins ("https://status.example.com") or wildcard subdomains
+// ("https://*.example.com", or "*.example.com" for any scheme). A wildcard
+// doesn't match the bare domain itself.
+func originAllowed(origin string, allowed []string) bool {
+	origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+	originScheme, originHost, ok := strings.Cut(origin, "://")
+	if !ok {
+		return false
+	}
+
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(pattern), "/"))
+		if pattern == origin {
+			return true
+		}
+
+		scheme, host, hasScheme := strings.Cut(pattern, "://")
+		if !hasScheme {
+			scheme, host = "", pattern
+		}
+		if scheme != "" && scheme != originScheme {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(host, "*."); ok && strings.HasSuffix(originHost, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// dashboardOriginAllowed reports whether a browser on the request's Origin
+// may open the dashboard WebSocket: same-origin pages always can, others
+// only if listed in ws_allowed_origins (["*"] allows any). Requests without
+// an Origin header don't come from a browser and are allowed.
+func (s *AppState) dashboardOriginAllowed(c *gin.Context) bool {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if u, err := url.Parse(origin); err == nil && u.Host != "" {
+		if strings.EqualFold(u.Host, c.Request.Host) || strings.EqualFold(u.Host, requestHost(c)) {
+			return true
+		}
+	}
+
+	s.ConfigMu.RLock()
+	allowed := s.Config.WSAllowedOrigins
+	s.ConfigMu.RUnlock()
+	return containsString(allowed, "*") || originAllowed(origin, allowed)
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateAPIKey validates an API key and enforces its scope. Read-only
+// keys may only make read-only requests (see isReadOnlyRequest).
+func authenticateAPIKey(c *gin.Context, plaintext string) {
+	key, ok := lookupAPIKey(plaintext)
+	if !ok {
+		abortUnauthorized(c, ErrCodeTokenInvalid, "Invalid API key")
+		return
+	}
+
+	if key.Scope != APIKeyScopeAdmin && !isReadOnlyRequest(c) {
+		abortForbidden(c, ErrCodeReadOnly, "API key is read-only")
+		return
+	}
+
+	c.Set(ContextSub, "apikey:"+key.Label)
+	c.Set(ContextProvider, "apikey")
+	c.Set(ContextScope, key.Scope)
+	c.Next()
+}
+
+// GetSub returns the authenticated subject (admin username or OAuth identity)
+func GetSub(c *gin.Context) string {
+	if sub, exists := c.Get(ContextSub); exists {
+		return sub.(string)
+	}
+	return ""
+}
+
+// GetRole returns the caller's role ("admin" or "viewer"); empty for API keys
+func GetRole(c *gin.Context) string {
+	return c.GetString(ContextRole)
+}
+
+// GetScope returns the API key scope ("read" or "admin"); empty for logins
+func GetScope(c *gin.Context) string {
+	return c.GetString(ContextScope)
+}
+
+// GetProvider returns how the caller logged in ("password", "github", "google", "apikey")
+func GetProvider(c *gin.Context) string {
+	if provider, exists := c.Get(ContextProvider); exists {
+		return provider.(string)
+	}
+	return ""
+}
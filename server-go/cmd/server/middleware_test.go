@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A read-scope key is enough for the Grafana datasource, whose queries are
+// POSTs, but not for other writes
+func TestReadKeyMayQueryGrafana(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const plaintext = APIKeyPrefix + "test-read-key"
+	apiKeysMu.Lock()
+	apiKeys[hashRefreshToken(plaintext)] = APIKey{ID: "k", Label: "grafana", Scope: APIKeyScopeRead}
+	apiKeysMu.Unlock()
+	defer func() {
+		apiKeysMu.Lock()
+		delete(apiKeys, hashRefreshToken(plaintext))
+		apiKeysMu.Unlock()
+	}()
+
+	r := gin.New()
+	protected := r.Group("/", AuthMiddleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	protected.POST("/api/grafana/query", ok)
+	protected.POST("/api/grafana/search", ok)
+	protected.POST("/api/servers", ok)
+
+	for path, want := range map[string]int{
+		"/api/grafana/query":  http.StatusOK,
+		"/api/grafana/search": http.StatusOK,
+		"/api/servers":        http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("X-API-Key", plaintext)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("POST %s: got %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+// The enrollment token in install commands registers agents and can't be used
+// as an admin session
+func TestEnrollmentTokenOnlyRegisters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	token, err := generateEnrollmentToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	protected := r.Group("/", AuthMiddleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	protected.POST("/api/agent/register", ok)
+	protected.POST("/api/servers", ok)
+	protected.GET("/api/admin/servers", ok)
+
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPost, "/api/agent/register", http.StatusOK},
+		{http.MethodPost, "/api/servers", http.StatusForbidden},
+		{http.MethodGet, "/api/admin/servers", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// Login Rate Limiting
+// ============================================================================
+
+const (
+	defaultLoginMaxAttempts    = 5
+	defaultLoginWindowSecs     = 60
+	defaultLoginMaxBackoffSecs = 3600
+)
+
+// loginAttempt tracks failed logins for a single client IP
+type loginAttempt struct {
+	mu           sync.Mutex
+	failures     int       // Attempts in the current window, including ones still being checked
+	windowStart  time.Time // Start of the current window
+	lockouts     int       // Consecutive lockouts, drives the exponential backoff
+	blockedUntil time.Time
+	lastSeen     time.Time
+}
+
+// loginAttempts maps client IP -> *loginAttempt
+var loginAttempts sync.Map
+
+// loginLimits returns the effective rate limit settings
+func (s *AppState) loginLimits() (maxAttempts int, window, maxBackoff time.Duration) {
+	maxAttempts = defaultLoginMaxAttempts
+	windowSecs := defaultLoginWindowSecs
+	maxBackoffSecs := defaultLoginMaxBackoffSecs
+
+	s.ConfigMu.RLock()
+	if cfg := s.Config.LoginRateLimit; cfg != nil {
+		if cfg.MaxAttempts > 0 {
+			maxAttempts = cfg.MaxAttempts
+		}
+		if cfg.WindowSecs > 0 {
+			windowSecs = cfg.WindowSecs
+		}
+		if cfg.MaxBackoffSecs > 0 {
+			maxBackoffSecs = cfg.MaxBackoffSecs
+		}
+	}
+	s.ConfigMu.RUnlock()
+
+	return maxAttempts, time.Duration(windowSecs) * time.Second, time.Duration(maxBackoffSecs) * time.Second
+}
+
+// reserveLoginAttempt counts an attempt against the IP before the password is
+// checked, so concurrent requests can't all slip past the limit while bcrypt
+// runs. It reports whether the attempt may go ahead, and if not, how long to
+// wait. The attempt after the limit starts a lockout; each consecutive
+// lockout doubles in length. A successful attempt is handed back with
+// releaseLoginAttempt (or resetLoginAttempts).
+func (s *AppState) reserveLoginAttempt(ip string) (bool, time.Duration) {
+	maxAttempts, window, maxBackoff := s.loginLimits()
+	now := time.Now()
+
+	v, _ := loginAttempts.LoadOrStore(ip, &loginAttempt{windowStart: now})
+	attempt := v.(*loginAttempt)
+	attempt.mu.Lock()
+	defer attempt.mu.Unlock()
+
+	attempt.lastSeen = now
+	if wait := attempt.blockedUntil.Sub(now); wait > 0 {
+		return false, wait
+	}
+	if now.Sub(attempt.windowStart) > window {
+		attempt.windowStart = now
+		attempt.failures = 0
+	}
+
+	if attempt.failures < maxAttempts {
+		attempt.failures++
+		return true, 0
+	}
+
+	backoff := window
+	for i := 0; i < attempt.lockouts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	attempt.lockouts++
+	attempt.failures = 0
+	attempt.windowStart = now
+	attempt.blockedUntil = now.Add(backoff)
+	return false, backoff
+}
+
+// releaseLoginAttempt hands back an attempt reserved by reserveLoginAttempt
+// that turned out to be successful
+func releaseLoginAttempt(ip string) {
+	v, ok := loginAttempts.Load(ip)
+	if !ok {
+		return
+	}
+	attempt := v.(*loginAttempt)
+	attempt.mu.Lock()
+	defer attempt.mu.Unlock()
+	if attempt.failures > 0 {
+		attempt.failures--
+	}
+}
+
+// resetLoginAttempts clears the counter after a successful login
+func resetLoginAttempts(ip string) {
+	loginAttempts.Delete(ip)
+}
+
+// loginAttemptsSweepLoop periodically drops entries that are no longer blocked
+// and have been idle for longer than the maximum backoff
+func (s *AppState) loginAttemptsSweepLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, window, maxBackoff := s.loginLimits()
+		idle := maxBackoff
+		if window > idle {
+			idle = window
+		}
+
+		now := time.Now()
+		loginAttempts.Range(func(key, value interface{}) bool {
+			attempt := value.(*loginAttempt)
+			attempt.mu.Lock()
+			expired := now.After(attempt.blockedUntil) && now.Sub(attempt.lastSeen) > idle
+			attempt.mu.Unlock()
+			if expired {
+				loginAttempts.Delete(key)
+			}
+			return true
+		})
+	}
+}
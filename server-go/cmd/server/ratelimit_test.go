@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// A burst of concurrent logins gets no more attempts than the limit, and a
+// successful one hands its attempt back
+func TestReserveLoginAttemptBurst(t *testing.T) {
+	s := &AppState{Config: &AppConfig{LoginRateLimit: &LoginRateLimitConfig{MaxAttempts: 5, WindowSecs: 60}}}
+	const ip = "192.0.2.1"
+	defer resetLoginAttempts(ip)
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := s.reserveLoginAttempt(ip); ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := allowed.Load(); got != 5 {
+		t.Fatalf("%d concurrent attempts allowed, want 5", got)
+	}
+
+	const other = "192.0.2.2"
+	defer resetLoginAttempts(other)
+	for i := 0; i < 10; i++ {
+		if ok, _ := s.reserveLoginAttempt(other); !ok {
+			t.Fatalf("successful attempt %d was rate limited", i+1)
+		}
+		releaseLoginAttempt(other)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// Renewals
+// ============================================================================
+
+// A server renews on its expiry_date if set, otherwise on the next
+// anniversary of its purchase_date per price_period. Renewals within
+// renewal_reminder_days raise an alert once per renewal date.
+
+// DefaultRenewalReminderDays is how far ahead renewals are reported unless
+// renewal_reminder_days is set
+const DefaultRenewalReminderDays = 7
+
+// renewalReminded remembers the renewal date last reported per server,
+// mirrored in the renewal_reminders table
+var (
+	renewalReminded   = make(map[string]string)
+	renewalRemindedMu sync.Mutex
+)
+
+// parseServerDate reads a purchase or expiry date, either YYYY-MM-DD or
+// RFC3339, as a UTC calendar day
+func parseServerDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < len("2006-01-02") {
+		return time.Time{}, false
+	}
+	day, err := time.Parse("2006-01-02", value[:len("2006-01-02")])
+	return day, err == nil
+}
+
+// pricePeriodStep returns the length of a billing period in months or days;
+// an empty period is monthly, like the dashboard's default
+func pricePeriodStep(period string) (months, days int, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(period)) {
+	case "day":
+		return 0, 1, true
+	case "week":
+		return 0, 7, true
+	case "", "month":
+		return 1, 0, true
+	case "quarter":
+		return 3, 0, true
+	case "year":
+		return 12, 0, true
+	}
+	return 0, 0, false
+}
+
+// renewalDate returns the day the server next renews or expires, counting
+// from today, and whether it comes from expiry_date (which may have passed)
+func (server *RemoteServer) renewalDate(now time.Time) (day time.Time, explicit bool, ok bool) {
+	if expiry, ok := parseServerDate(server.ExpiryDate); ok {
+		return expiry, true, true
+	}
+	purchase, ok := parseServerDate(server.PurchaseDate)
+	if !ok {
+		return time.Time{}, false, false
+	}
+	months, days, ok := pricePeriodStep(server.PricePeriod)
+	if !ok {
+		return time.Time{}, false, false
+	}
+
+	// Estimate how many periods have passed, then correct for month lengths.
+	// Always count from the purchase date so month-end dates don't drift.
+	today := now.UTC().Truncate(24 * time.Hour)
+	n := 1
+	if today.After(purchase) {
+		if months > 0 {
+			n = ((today.Year()-purchase.Year())*12 + int(today.Month()-purchase.Month())) / months
+		} else {
+			n = int(today.Sub(purchase).Hours()/24) / days
+		}
+		n = max(n, 1)
+	}
+	for n > 1 && !purchase.AddDate(0, months*(n-1), days*(n-1)).Before(today) {
+		n--
+	}
+	for purchase.AddDate(0, months*n, days*n).Before(today) {
+		n++
+	}
+	return purchase.AddDate(0, months*n, days*n), false, true
+}
+
+// renewalDay is renewalDate as YYYY-MM-DD, empty if unknown
+func (server *RemoteServer) renewalDay(now time.Time) string {
+	day, _, ok := server.renewalDate(now)
+	if !ok {
+		return ""
+	}
+	return day.Format("2006-01-02")
+}
+
+// validateServerDate checks an expiry or purchase date from a request; empty
+// clears it
+func validateServerDate(field, value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, ok := parseServerDate(value); !ok {
+		return fmt.Errorf("%s must be a date (YYYY-MM-DD)", field)
+	}
+	return nil
+}
+
+// parseWithinDays parses ?within= as days: "30d", "30" or a Go duration
+func parseWithinDays(value string) (int, error) {
+	if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil && days >= 0 {
+		return days, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return int(d / (24 * time.Hour)), nil
+	}
+	return 0, fmt.Errorf("within must be a number of days, e.g. 30d")
+}
+
+// expiringServers lists the servers renewing or expiring within the given
+// number of days, soonest first. Servers whose expiry_date has passed are
+// included with negative days_left.
+func (s *AppState) expiringServers(now time.Time, withinDays int) []ExpiringServer {
+	today := now.UTC().Truncate(24 * time.Hour)
+
+	s.ConfigMu.RLock()
+	defer s.ConfigMu.RUnlock()
+
+	expiring := []ExpiringServer{}
+	for i := range s.Config.Servers {
+		server := &s.Config.Servers[i]
+		day, explicit, ok := server.renewalDate(now)
+		if !ok {
+			continue
+		}
+		daysLeft := int(day.Sub(today).Hours() / 24)
+		if daysLeft > withinDays {
+			continue
+		}
+		entry := ExpiringServer{
+			ServerID:    server.ID,
+			ServerName:  server.Name,
+			RenewalDate: day.Format("2006-01-02"),
+			DaysLeft:    daysLeft,
+			Source:      "purchase_date",
+			PriceAmount: server.PriceAmount,
+			PricePeriod: server.PricePeriod,
+		}
+		if explicit {
+			entry.Source = "expiry_date"
+		}
+		expiring = append(expiring, entry)
+	}
+	sort.SliceStable(expiring, func(i, j int) bool { return expiring[i].DaysLeft < expiring[j].DaysLeft })
+	return expiring
+}
+
+// GetExpiringServers serves GET /api/servers/expiring?within=30d
+func (s *AppState) GetExpiringServers(c *gin.Context) {
+	withinDays, err := parseWithinDays(c.DefaultQuery("within", "30d"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, s.expiringServers(time.Now(), withinDays))
+}
+
+// renewalLoop checks for upcoming renewals every hour
+func (s *AppState) renewalLoop() {
+	// Renewals already reported before a restart aren't reported again
+	if reminded, err := LoadRenewalReminders(s.DB); err == nil {
+		renewalRemindedMu.Lock()
+		renewalReminded = reminded
+		renewalRemindedMu.Unlock()
+	} else {
+		slog.Error("Failed to load renewal reminders", "error", err)
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		s.checkRenewals(time.Now())
+		<-ticker.C
+	}
+}
+
+// checkRenewals raises an alert once per renewal date for servers renewing
+// within renewal_reminder_days
+func (s *AppState) checkRenewals(now time.Time) {
+	s.ConfigMu.RLock()
+	days := s.Config.RenewalReminderDays
+	s.ConfigMu.RUnlock()
+	if days < 0 {
+		return
+	}
+	if days == 0 {
+		days = DefaultRenewalReminderDays
+	}
+
+	var alerts []Alert
+	renewalRemindedMu.Lock()
+	for _, server := range s.expiringServers(now, days) {
+		if server.DaysLeft < 0 || renewalReminded[server.ServerID] == server.RenewalDate {
+			continue // Already past, it was reported beforehand
+		}
+		renewalReminded[server.ServerID] = server.RenewalDate
+		StoreRenewalReminder(server.ServerID, server.RenewalDate)
+
+		verb := "renews"
+		if server.Source == "expiry_date" {
+			verb = "expires"
+		}
+		detail := map[string]interface{}{
+			"renewal_date": server.RenewalDate,
+			"days_left":    server.DaysLeft,
+			"source":       server.Source,
+		}
+		if server.PriceAmount != "" {
+			period := server.PricePeriod
+			if period == "" {
+				period = "month"
+			}
+			detail["price_amount"] = server.PriceAmount
+			detail["price_period"] = period
+		}
+		alerts = append(alerts, Alert{
+			Kind:       AlertRenewal,
+			ServerID:   server.ServerID,
+			ServerName: server.ServerName,
+			Message:    fmt.Sprintf("Server %s %s on %s, in %d days", server.ServerName, verb, server.RenewalDate, server.DaysLeft),
+			Detail:     detail,
+		})
+	}
+	renewalRemindedMu.Unlock()
+
+	for _, alert := range alerts {
+		s.raiseAlert(alert)
+	}
+}
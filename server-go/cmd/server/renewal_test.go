@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// renewalDate jumps straight to the current period; it must agree with
+// stepping through every period from the purchase date
+func TestRenewalDateMatchesStepping(t *testing.T) {
+	stepped := func(purchase time.Time, period string, today time.Time) time.Time {
+		months, days, _ := pricePeriodStep(period)
+		for n := 1; ; n++ {
+			if next := purchase.AddDate(0, months*n, days*n); !next.Before(today) {
+				return next
+			}
+		}
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, purchase := range []string{"2020-01-31", "2023-02-28", "2024-02-29", "2024-06-15"} {
+		for _, period := range []string{"day", "week", "month", "quarter", "year"} {
+			server := RemoteServer{PurchaseDate: purchase, PricePeriod: period}
+			bought, _ := parseServerDate(purchase)
+			for d := 0; d < 800; d += 3 {
+				today := start.AddDate(0, 0, d)
+				got, _, ok := server.renewalDate(today.Add(13 * time.Hour))
+				if want := stepped(bought, period, today); !ok || !got.Equal(want) {
+					t.Fatalf("purchase %s, %s, today %s: got %s, want %s",
+						purchase, period, today.Format("2006-01-02"), got.Format("2006-01-02"), want.Format("2006-01-02"))
+				}
+			}
+		}
+	}
+}
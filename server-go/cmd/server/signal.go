@@ -14,7 +14,8 @@ import (
 
 // SetupSignalHandler sets up signal handlers for graceful operations
 // SIGHUP: Reload password from config file
-func SetupSignalHandler(state *AppState) {
+// SIGINT/SIGTERM: Delivered on the returned channel so main can shut down cleanly
+func SetupSignalHandler(state *AppState) <-chan os.Signal {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGHUP)
 
@@ -27,6 +28,10 @@ func SetupSignalHandler(state *AppState) {
 			}
 		}
 	}()
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+	return shutdown
 }
 
 // reloadConfig reloads the configuration from disk
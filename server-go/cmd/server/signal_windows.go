@@ -3,6 +3,12 @@
 
 package main
 
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
 // SignalError represents different types of signal errors
 type SignalError struct {
 	Type    string // "not_found", "permission_denied", "other"
@@ -14,10 +20,12 @@ func (e *SignalError) Error() string {
 	return e.Message
 }
 
-// SetupSignalHandler is a no-op on Windows
-// Windows doesn't support SIGHUP
-func SetupSignalHandler(state *AppState) {
-	// No-op on Windows
+// SetupSignalHandler only handles shutdown on Windows
+// Windows doesn't support SIGHUP, so config reload is unavailable
+func SetupSignalHandler(state *AppState) <-chan os.Signal {
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	return shutdown
 }
 
 // findAndSignalServer is not supported on Windows
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ============================================================================
+// Built-in TLS
+// ============================================================================
+
+// tlsCertPaths returns the certificate and key files to serve, with
+// VSTATS_TLS_CERT / VSTATS_TLS_KEY taking precedence over the config
+func tlsCertPaths(config *AppConfig) (certFile, keyFile string) {
+	certFile, keyFile = config.TLSCertFile, config.TLSKeyFile
+	if env := os.Getenv("VSTATS_TLS_CERT"); env != "" {
+		certFile = env
+	}
+	if env := os.Getenv("VSTATS_TLS_KEY"); env != "" {
+		keyFile = env
+	}
+	return certFile, keyFile
+}
+
+// tlsEnabled reports whether the server will serve HTTPS
+func tlsEnabled(config *AppConfig) bool {
+	certFile, keyFile := tlsCertPaths(config)
+	return config.AutocertDomain != "" || (certFile != "" && keyFile != "")
+}
+
+// DefaultAutocertHTTPAddr is where ACME HTTP-01 challenges are answered
+const DefaultAutocertHTTPAddr = ":80"
+
+// listenAndServe starts srv using, in order of preference, an ACME
+// (Let's Encrypt) certificate for AutocertDomain, the configured certificate
+// files, or plain HTTP. A certificate without a key or the other way round
+// is an error rather than a silent fallback to plain HTTP.
+func listenAndServe(srv *http.Server, config *AppConfig) error {
+	if config.AutocertDomain != "" {
+		cacheDir := config.AutocertCacheDir
+		if cacheDir == "" {
+			cacheDir = filepath.Join(filepath.Dir(GetDBPath()), "autocert")
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.AutocertDomain),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      config.AutocertEmail,
+		}
+		srv.TLSConfig = manager.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+
+		// Port 80 answers HTTP-01 challenges and redirects everything else to
+		// HTTPS. Without it, TLS-ALPN-01 on port 443 still works.
+		httpAddr := config.AutocertHTTPAddr
+		if httpAddr == "" {
+			httpAddr = DefaultAutocertHTTPAddr
+		}
+		if httpAddr != "off" {
+			go func() {
+				if err := http.ListenAndServe(httpAddr, manager.HTTPHandler(nil)); err != nil {
+					fmt.Printf("⚠️  ACME HTTP listener on %s failed: %v\n", httpAddr, err)
+				}
+			}()
+		}
+
+		fmt.Printf("🔒 TLS: automatic certificate for %s (cache: %s)\n", config.AutocertDomain, cacheDir)
+		return srv.ListenAndServeTLS("", "")
+	}
+
+	certFile, keyFile := tlsCertPaths(config)
+	if certFile != "" && keyFile != "" {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		fmt.Printf("🔒 TLS: %s\n", certFile)
+		return srv.ListenAndServeTLS(certFile, keyFile)
+	}
+	if certFile != "" || keyFile != "" {
+		return fmt.Errorf("only one of the TLS certificate (%q) and key (%q) is set; set both or neither", certFile, keyFile)
+	}
+
+	return srv.ListenAndServe()
+}
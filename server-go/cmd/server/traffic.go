@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// Traffic Accounting
+// ============================================================================
+
+// trafficQuotaAlerted remembers the month a quota alert was last raised per
+// server, so each overage is reported once
+var (
+	trafficQuotaAlerted   = make(map[string]string)
+	trafficQuotaAlertedMu sync.Mutex
+)
+
+// trafficLoop refreshes the monthly traffic totals every hour and checks them
+// against the configured quotas
+func (s *AppState) trafficLoop(db *sql.DB) {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if err := AggregateTrafficMonthly(db); err != nil {
+			slog.Error("Monthly traffic aggregation failed", "error", err)
+		} else {
+			s.checkTrafficQuotas(db)
+		}
+		<-ticker.C
+	}
+}
+
+// checkTrafficQuotas raises an alert for every server whose egress this month
+// exceeds its MonthlyQuotaBytes. Servers in maintenance are checked again once
+// their window ends.
+func (s *AppState) checkTrafficQuotas(db *sql.DB) {
+	s.ConfigMu.RLock()
+	quotas := make(map[string]int64)
+	names := make(map[string]string)
+	now := time.Now()
+	for _, server := range s.Config.Servers {
+		if server.MonthlyQuotaBytes > 0 && !server.InMaintenance(now) {
+			quotas[server.ID] = server.MonthlyQuotaBytes
+			names[server.ID] = server.Name
+		}
+	}
+	s.ConfigMu.RUnlock()
+
+	month := time.Now().UTC().Format("2006-01")
+	for serverID, quota := range quotas {
+		exceeded, used := trafficQuotaExceeded(db, serverID, quota)
+		if !exceeded {
+			continue
+		}
+
+		trafficQuotaAlertedMu.Lock()
+		alreadyAlerted := trafficQuotaAlerted[serverID] == month
+		trafficQuotaAlerted[serverID] = month
+		trafficQuotaAlertedMu.Unlock()
+
+		if !alreadyAlerted {
+			s.raiseAlert(Alert{
+				Kind:       AlertTrafficQuota,
+				ServerID:   serverID,
+				ServerName: names[serverID],
+				Message:    fmt.Sprintf("Server %s exceeded its monthly traffic quota", names[serverID]),
+				Detail:     map[string]interface{}{"month": month, "used_bytes": used, "quota_bytes": quota},
+			})
+		}
+	}
+}
+
+// trafficQuotaExceeded reports whether this month's egress is over quota, and
+// the egress so far
+func trafficQuotaExceeded(db *sql.DB, serverID string, quota int64) (bool, int64) {
+	if quota <= 0 {
+		return false, 0
+	}
+	var tx int64
+	db.QueryRow("SELECT tx_bytes FROM traffic_monthly WHERE server_id = ? AND month = ?",
+		serverID, time.Now().UTC().Format("2006-01")).Scan(&tx)
+	return tx > quota, tx
+}
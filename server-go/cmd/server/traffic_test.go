@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// A month that ended while the server was down is finalized on the next run,
+// and hours purged since don't shrink the total already recorded
+func TestAggregateTrafficMonthlyFinalizesPastMonth(t *testing.T) {
+	db, err := openDatabase("file:traffic?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	prev := month.AddDate(0, -1, 0)
+	hour := prev.Unix() / 3600
+	for i, tx := range []int64{1000, 3000, 6000} {
+		if _, err := db.Exec("INSERT INTO metrics_hourly_agg (server_id, bucket, net_rx, net_tx) VALUES ('srv', ?, 0, ?)",
+			hour+int64(i), tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Last written mid-month, before the final hours were counted
+	if _, err := db.Exec("INSERT INTO traffic_monthly (server_id, month, rx_bytes, tx_bytes, updated_at) VALUES ('srv', ?, 0, 2000, ?)",
+		prev.Format("2006-01"), prev.Add(time.Hour).Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+
+	txBytes := func() int64 {
+		var tx int64
+		db.QueryRow("SELECT tx_bytes FROM traffic_monthly WHERE server_id = 'srv' AND month = ?", prev.Format("2006-01")).Scan(&tx)
+		return tx
+	}
+
+	if err := AggregateTrafficMonthly(db); err != nil {
+		t.Fatal(err)
+	}
+	if got := txBytes(); got != 5000 {
+		t.Fatalf("finalized tx = %d, want 5000", got)
+	}
+
+	// Once finalized the month is left alone, even if its data is purged
+	if _, err := db.Exec("DELETE FROM metrics_hourly_agg WHERE bucket = ?", hour+2); err != nil {
+		t.Fatal(err)
+	}
+	if err := AggregateTrafficMonthly(db); err != nil {
+		t.Fatal(err)
+	}
+	if got := txBytes(); got != 5000 {
+		t.Fatalf("tx after rerun = %d, want 5000", got)
+	}
+
+	// A partially purged month being finalized late keeps the larger total
+	if _, err := db.Exec("UPDATE traffic_monthly SET tx_bytes = 4500, updated_at = ? WHERE server_id = 'srv'",
+		prev.Add(time.Hour).Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	if err := finalizeTrafficMonth(db, prev); err != nil {
+		t.Fatal(err)
+	}
+	if got := txBytes(); got != 4500 {
+		t.Fatalf("tx after late finalize = %d, want the earlier 4500", got)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"sync"
 	"time"
 
@@ -21,6 +22,7 @@ type NetworkInterface = common.NetworkInterface
 type LoadAverage = common.LoadAverage
 type PingMetrics = common.PingMetrics
 type PingTarget = common.PingTarget
+type PortInfo = common.PortInfo
 
 // ============================================================================
 // Auth Types
@@ -32,12 +34,19 @@ type Claims struct {
 }
 
 type LoginRequest struct {
+	Username string `json:"username,omitempty"` // Empty or "admin" for the built-in admin password
 	Password string `json:"password"`
 }
 
 type LoginResponse struct {
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token,omitempty"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at,omitempty"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 type ChangePasswordRequest struct {
@@ -50,40 +59,10 @@ type ChangePasswordRequest struct {
 // ============================================================================
 
 type OAuthStateData struct {
-	Provider  string `json:"provider"`
-	State     string `json:"state"`
-	CreatedAt int64  `json:"created_at"`
-}
-
-type GitHubUser struct {
-	ID        int    `json:"id"`
-	Login     string `json:"login"`
-	Name      string `json:"name"`
-	Email     string `json:"email"`
-	AvatarURL string `json:"avatar_url"`
-}
-
-type GitHubTokenResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	Scope       string `json:"scope"`
-}
-
-type GoogleTokenResponse struct {
-	AccessToken  string `json:"access_token"`
-	ExpiresIn    int    `json:"expires_in"`
-	TokenType    string `json:"token_type"`
-	Scope        string `json:"scope"`
-	RefreshToken string `json:"refresh_token,omitempty"`
-	IDToken      string `json:"id_token,omitempty"`
-}
-
-type GoogleUserInfo struct {
-	ID            string `json:"id"`
-	Email         string `json:"email"`
-	VerifiedEmail bool   `json:"verified_email"`
-	Name          string `json:"name"`
-	Picture       string `json:"picture"`
+	Provider     string `json:"provider"`
+	State        string `json:"state"`
+	CreatedAt    int64  `json:"created_at"`
+	CodeVerifier string `json:"-"` // PKCE verifier for self-hosted flows
 }
 
 type OAuthLoginResponse struct {
@@ -109,10 +88,16 @@ type AddServerRequest struct {
 	PricePeriod  string            `json:"price_period,omitempty"`
 	PurchaseDate string            `json:"purchase_date,omitempty"`
 	TipBadge     string            `json:"tip_badge,omitempty"`
+	// Monthly egress quota in bytes (0 = none)
+	MonthlyQuotaBytes int64 `json:"monthly_quota_bytes,omitempty"`
+	// Explicit expiry/renewal date (YYYY-MM-DD), for terms that aren't a
+	// simple period from the purchase date
+	ExpiryDate string `json:"expiry_date,omitempty"`
 }
 
 type UpdateServerRequest struct {
 	Name         *string            `json:"name,omitempty"`
+	URL          *string            `json:"url,omitempty"`
 	Location     *string            `json:"location,omitempty"`
 	Provider     *string            `json:"provider,omitempty"`
 	Tag          *string            `json:"tag,omitempty"`
@@ -122,6 +107,82 @@ type UpdateServerRequest struct {
 	PricePeriod  *string            `json:"price_period,omitempty"`
 	PurchaseDate *string            `json:"purchase_date,omitempty"`
 	TipBadge     *string            `json:"tip_badge,omitempty"`
+	// Monthly egress quota in bytes (0 = none)
+	MonthlyQuotaBytes *int64 `json:"monthly_quota_bytes,omitempty"`
+	// Explicit expiry/renewal date (YYYY-MM-DD), for terms that aren't a
+	// simple period from the purchase date
+	ExpiryDate *string `json:"expiry_date,omitempty"`
+}
+
+// MaintenanceRequest sets a maintenance window, either ending at Until or
+// DurationMinutes from now. Neither set ends the current window.
+type MaintenanceRequest struct {
+	Until           *time.Time `json:"until,omitempty"`
+	DurationMinutes int        `json:"duration_minutes,omitempty"`
+}
+
+// ReorderServersRequest lists server IDs in display order ("local" for the
+// dashboard's own node). Servers not listed keep their relative order after
+// the listed ones.
+type ReorderServersRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// CostsResponse is returned by GET /api/costs. Amounts are normalized to
+// months and summed per currency.
+type CostsResponse struct {
+	Totals     []CostTotal      `json:"totals"`
+	Dimensions []DimensionCosts `json:"dimensions,omitempty"`
+	Servers    []ServerCost     `json:"servers"`  // Most expensive first
+	Unpriced   int              `json:"unpriced"` // Servers without a parseable price
+}
+
+type CostTotal struct {
+	Currency string  `json:"currency"`
+	Monthly  float64 `json:"monthly"`
+	Yearly   float64 `json:"yearly"`
+	Servers  int     `json:"servers"`
+}
+
+type DimensionCosts struct {
+	DimensionID   string        `json:"dimension_id"`
+	DimensionName string        `json:"dimension_name"`
+	Options       []OptionCosts `json:"options"`
+}
+
+// OptionCosts sums the servers of one dimension option; OptionID is empty
+// for servers without an option in the dimension
+type OptionCosts struct {
+	OptionID   string      `json:"option_id,omitempty"`
+	OptionName string      `json:"option_name,omitempty"`
+	Totals     []CostTotal `json:"totals"`
+}
+
+type ServerCost struct {
+	ServerID    string            `json:"server_id"`
+	ServerName  string            `json:"server_name"`
+	PriceAmount string            `json:"price_amount"`
+	PricePeriod string            `json:"price_period,omitempty"`
+	Currency    string            `json:"currency"`
+	Monthly     float64           `json:"monthly"`
+	GroupValues map[string]string `json:"group_values,omitempty"`
+}
+
+// ExpiringServer is an entry of GET /api/servers/expiring
+type ExpiringServer struct {
+	ServerID    string `json:"server_id"`
+	ServerName  string `json:"server_name"`
+	RenewalDate string `json:"renewal_date"` // YYYY-MM-DD
+	DaysLeft    int    `json:"days_left"`    // Negative once an expiry_date has passed
+	Source      string `json:"source"`       // "expiry_date" or "purchase_date"
+	PriceAmount string `json:"price_amount,omitempty"`
+	PricePeriod string `json:"price_period,omitempty"`
+}
+
+// RenamePingTargetRequest renames a ping target in a server's history
+type RenamePingTargetRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
 }
 
 // ============================================================================
@@ -181,6 +242,14 @@ type HistoryPoint struct {
 	NetRx     int64    `json:"net_rx"`
 	NetTx     int64    `json:"net_tx"`
 	PingMs    *float64 `json:"ping_ms,omitempty"`
+	Load1     *float64 `json:"load_1,omitempty"` // Load averages, absent for 7d/30d/1y ranges served from the agent rollup tables
+	Load5     *float64 `json:"load_5,omitempty"`
+	Load15    *float64 `json:"load_15,omitempty"`
+	IOWait    *float64 `json:"iowait,omitempty"` // CPU iowait/steal %, absent for older agents
+	Steal     *float64 `json:"steal,omitempty"`
+	// Formatted net_rx/net_tx, only with ?units=human
+	NetRxHuman string `json:"net_rx_human,omitempty"`
+	NetTxHuman string `json:"net_tx_human,omitempty"`
 }
 
 type HistoryResponse struct {
@@ -188,8 +257,334 @@ type HistoryResponse struct {
 	Range       string              `json:"range"`
 	Data        []HistoryPoint      `json:"data"`
 	PingTargets []PingHistoryTarget `json:"ping_targets,omitempty"`
-	LastBucket  int64               `json:"last_bucket,omitempty"`  // For incremental updates
+	LastBucket  int64               `json:"last_bucket,omitempty"` // Current bucket (unix/5 for 1h, unix/120 for 24h); pass back as ?since=
 	Incremental bool                `json:"incremental,omitempty"` // True if this is an incremental response
+	Units       string              `json:"units"`                 // "bytes", "bits" or "human", see ?units=
+}
+
+// AggregatePoint summarizes one metric across servers for one history bucket
+type AggregatePoint struct {
+	Timestamp string  `json:"timestamp"`
+	Min       float64 `json:"min"`
+	Avg       float64 `json:"avg"`
+	Max       float64 `json:"max"`
+	Servers   int     `json:"servers"` // Servers with a value in this bucket
+}
+
+type AggregateMetricsResponse struct {
+	Dimension string           `json:"dimension"`
+	Option    string           `json:"option"`
+	Metric    string           `json:"metric"`
+	Range     string           `json:"range"`
+	ServerIDs []string         `json:"server_ids"`
+	Data      []AggregatePoint `json:"data"`
+}
+
+// GrafanaQueryRequest is the body Grafana's SimpleJSON datasource posts to
+// /api/grafana/query
+type GrafanaQueryRequest struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs int64           `json:"intervalMs"`
+	Targets    []GrafanaTarget `json:"targets"`
+}
+
+type GrafanaTarget struct {
+	Target string `json:"target"` // "<server_id>.<metric>" (or "<server name>.<metric>")
+	RefID  string `json:"refId"`
+	Hide   bool   `json:"hide,omitempty"`
+}
+
+// GrafanaTimeSeries holds datapoints as [value, unix_ms] pairs
+type GrafanaTimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type GrafanaSearchRequest struct {
+	Target string `json:"target"` // Optional filter
+}
+
+type GrafanaSearchOption struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// AggregationRun is the outcome of the server's last rollup into a table
+type AggregationRun struct {
+	LastRun    string `json:"last_run"`
+	DurationMs int64  `json:"duration_ms"`
+	LastError  string `json:"last_error,omitempty"`
+}
+
+type AggregationTableStatus struct {
+	Table           string `json:"table"`
+	Source          string `json:"source"` // "server" (rolled up from metrics_raw) or "agent" (sent by agents)
+	Rows            int64  `json:"rows"`
+	NewestBucket    string `json:"newest_bucket,omitempty"`
+	*AggregationRun        // Only for server tables, once aggregated since startup
+}
+
+type AggregationStatusResponse struct {
+	Tables []AggregationTableStatus `json:"tables"`
+}
+
+// DBStatsResponse describes the database file for GET /api/admin/db-stats
+type DBStatsResponse struct {
+	Path       string         `json:"path"`
+	FileSize   int64          `json:"file_size"` // Bytes, excluding the WAL
+	WALSize    int64          `json:"wal_size"`
+	PageSize   int64          `json:"page_size"`
+	PageCount  int64          `json:"page_count"`
+	FreePages  int64          `json:"free_pages"`  // Unused pages a vacuum would release
+	AutoVacuum string         `json:"auto_vacuum"` // "none", "full" or "incremental"
+	VacuumTime string         `json:"vacuum_time"` // Daily vacuum time, or "off"
+	LastVacuum *VacuumRun     `json:"last_vacuum,omitempty"`
+	Tables     []DBTableStats `json:"tables"`
+}
+
+type DBTableStats struct {
+	Table string `json:"table"`
+	Rows  int64  `json:"rows"`
+}
+
+// VacuumRun is the outcome of a database vacuum
+type VacuumRun struct {
+	Time       string `json:"time"`
+	Mode       string `json:"mode"` // "incremental", or "full" when converting the database
+	FreedPages int64  `json:"freed_pages"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// OutageWindow is a period during which a server was offline
+type OutageWindow struct {
+	Start           string  `json:"start"`
+	End             *string `json:"end"` // nil while the outage is ongoing
+	DurationSeconds int64   `json:"duration_seconds"`
+}
+
+type OutagesResponse struct {
+	ServerID             string         `json:"server_id"`
+	Range                string         `json:"range"`
+	Outages              []OutageWindow `json:"outages"`
+	TotalDowntimeSeconds int64          `json:"total_downtime_seconds"`
+}
+
+// UptimeDay is the uptime of a server for a single UTC day; UptimePercent is
+// null when nothing was recorded that day
+type UptimeDay struct {
+	Date            string   `json:"date"`
+	UptimePercent   *float64 `json:"uptime_percent"`
+	DowntimeSeconds int64    `json:"downtime_seconds"`
+}
+
+type UptimeResponse struct {
+	ServerID      string      `json:"server_id"`
+	Range         string      `json:"range"`
+	UptimePercent *float64    `json:"uptime_percent"`
+	Days          []UptimeDay `json:"days"`
+}
+
+type CoreHistoryPoint struct {
+	Timestamp string  `json:"timestamp"`
+	Usage     float64 `json:"usage"`
+}
+
+// CoreHistory is the usage history of a single CPU core
+type CoreHistory struct {
+	Core int                `json:"core"`
+	Data []CoreHistoryPoint `json:"data"`
+}
+
+type CoreHistoryResponse struct {
+	ServerID string        `json:"server_id"`
+	Range    string        `json:"range"`
+	Enabled  bool          `json:"enabled"` // False when per_core_history is off in the config
+	Cores    []CoreHistory `json:"cores"`
+}
+
+// CustomHistory is the history of one custom agent metric
+type CustomHistory struct {
+	Key  string               `json:"key"`
+	Data []CustomHistoryPoint `json:"data"`
+}
+
+type CustomHistoryPoint struct {
+	Timestamp string  `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type CustomHistoryResponse struct {
+	ServerID string          `json:"server_id"`
+	Range    string          `json:"range"`
+	Keys     []string        `json:"keys"` // custom_history_keys from the config
+	Metrics  []CustomHistory `json:"metrics"`
+}
+
+// TrafficMonth is the network traffic of a server for one calendar month (UTC)
+type TrafficMonth struct {
+	Month      string `json:"month"` // YYYY-MM
+	RxBytes    int64  `json:"rx_bytes"`
+	TxBytes    int64  `json:"tx_bytes"`
+	TotalBytes int64  `json:"total_bytes"`
+}
+
+type TrafficResponse struct {
+	ServerID          string         `json:"server_id"`
+	MonthlyQuotaBytes int64          `json:"monthly_quota_bytes,omitempty"`
+	QuotaExceeded     bool           `json:"quota_exceeded"`
+	Months            []TrafficMonth `json:"months"`
+}
+
+// MetricRecord is the highest value a metric reached in a period
+type MetricRecord struct {
+	Metric    string  `json:"metric"` // cpu, memory, disk (percent), rx_speed, tx_speed (bytes/s), load_1
+	Value     float64 `json:"value"`
+	Timestamp string  `json:"timestamp"` // When the record was set
+}
+
+type MetricRecordsResponse struct {
+	ServerID string                    `json:"server_id"`
+	Records  map[string][]MetricRecord `json:"records"` // "all" and/or YYYY-MM
+}
+
+// ListeningPortRecord is a port a server listens on, or listened on until
+// ClosedAt
+type ListeningPortRecord struct {
+	Proto     string  `json:"proto"`
+	Address   string  `json:"address"`
+	Port      uint32  `json:"port"`
+	Process   string  `json:"process,omitempty"`
+	FirstSeen string  `json:"first_seen"`
+	ClosedAt  *string `json:"closed_at,omitempty"`
+}
+
+type ListeningPortsResponse struct {
+	ServerID string                `json:"server_id"`
+	Ports    []ListeningPortRecord `json:"ports"` // Open ports first
+}
+
+// Agent connection event types
+const (
+	ConnectionEventConnect    = "connect"
+	ConnectionEventDisconnect = "disconnect"
+)
+
+// ConnectionEvent is one agent connect or disconnect
+type ConnectionEvent struct {
+	Event     string `json:"event"`
+	Timestamp string `json:"timestamp"`
+	RemoteIP  string `json:"remote_ip"`
+}
+
+// RawMetricsSample is one row of metrics_raw, as stored
+type RawMetricsSample struct {
+	Timestamp string   `json:"timestamp"`
+	CPU       float64  `json:"cpu"`
+	Memory    float64  `json:"memory"`
+	Disk      float64  `json:"disk"`
+	NetRx     int64    `json:"net_rx"` // Cumulative bytes, as reported by the agent
+	NetTx     int64    `json:"net_tx"`
+	Load1     float64  `json:"load_1"`
+	Load5     float64  `json:"load_5"`
+	Load15    float64  `json:"load_15"`
+	PingMs    *float64 `json:"ping_ms,omitempty"`
+	IOWait    *float64 `json:"iowait,omitempty"`
+	Steal     *float64 `json:"steal,omitempty"`
+}
+
+type RawMetricsResponse struct {
+	ServerID string             `json:"server_id"`
+	At       string             `json:"at"`
+	Window   string             `json:"window"`
+	Nearest  *RawMetricsSample  `json:"nearest"` // Closest sample to at, nil if none
+	Samples  []RawMetricsSample `json:"samples"` // Within window either side of at, oldest first
+}
+
+type ConnectionEventsResponse struct {
+	ServerID    string            `json:"server_id"`
+	Range       string            `json:"range"`
+	Disconnects int               `json:"disconnects"`
+	Events      []ConnectionEvent `json:"events"`
+}
+
+// AuditEntry records one administrative action
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Timestamp string          `json:"timestamp"`
+	Actor     string          `json:"actor"` // JWT sub of the admin who made the change
+	IP        string          `json:"ip"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target,omitempty"`
+	Detail    json.RawMessage `json:"detail,omitempty"`
+}
+
+type AuditLogResponse struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int          `json:"total"`
+	Limit   int          `json:"limit"`
+	Offset  int          `json:"offset"`
+}
+
+// API key scopes
+const (
+	APIKeyScopeRead  = "read"  // Read-only requests (GET and Grafana queries)
+	APIKeyScopeAdmin = "admin" // Full access, same as a logged-in admin
+)
+
+// APIKey is a long-lived credential for scripts. Only its hash is stored.
+type APIKey struct {
+	ID        string `json:"id"`
+	Label     string `json:"label"`
+	Scope     string `json:"scope"`
+	CreatedAt string `json:"created_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Label string `json:"label"`
+	Scope string `json:"scope"`
+}
+
+// User roles
+const (
+	UserRoleAdmin  = "admin"  // Full access, same as the built-in admin password
+	UserRoleViewer = "viewer" // Read-only: GET requests, minus admin-only pages
+)
+
+// User is a named login on the self-hosted server, next to the built-in
+// "admin" password login. GitHub and Google map an OAuth identity to the
+// user, so OAuth logins act as that user.
+type User struct {
+	ID           string `json:"id"`
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	GitHub       string `json:"github,omitempty"` // GitHub login
+	Google       string `json:"google,omitempty"` // Google account email
+	HasPassword  bool   `json:"has_password"`
+	CreatedAt    string `json:"created_at"`
+	PasswordHash string `json:"-"`
+}
+
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"` // Optional for OAuth-only users
+	Role     string `json:"role,omitempty"`     // Default admin
+	GitHub   string `json:"github,omitempty"`
+	Google   string `json:"google,omitempty"`
+}
+
+type UpdateUserRequest struct {
+	Password *string `json:"password,omitempty"`
+	Role     *string `json:"role,omitempty"`
+	GitHub   *string `json:"github,omitempty"`
+	Google   *string `json:"google,omitempty"`
+}
+
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"` // Plaintext key, only returned once
 }
 
 type PingHistoryTarget struct {
@@ -209,9 +604,28 @@ type PingHistoryPoint struct {
 // ============================================================================
 
 type AgentMetricsData struct {
-	ServerID    string
-	Metrics     SystemMetrics
-	LastUpdated time.Time
+	ServerID     string
+	Metrics      SystemMetrics
+	LastUpdated  time.Time // Last metrics report
+	LastSeen     time.Time // Last heartbeat, set when the agent is alive but metrics are late
+	IntervalSecs uint64    // Reporting interval announced by the agent, 0 if unknown
+}
+
+// LastAlive returns the latest sign of life from the agent, metrics or heartbeat
+func (d *AgentMetricsData) LastAlive() time.Time {
+	if d.LastSeen.After(d.LastUpdated) {
+		return d.LastSeen
+	}
+	return d.LastUpdated
+}
+
+// IsOnline reports whether the agent has reported or sent a heartbeat within
+// the offline threshold
+func (d *AgentMetricsData) IsOnline(probe *ProbeSettings) bool {
+	if d == nil {
+		return false
+	}
+	return time.Since(d.LastAlive()) < probe.OfflineThreshold(d.IntervalSecs)
 }
 
 type DashboardMessage struct {
@@ -231,13 +645,56 @@ type ServerMetricsUpdate struct {
 	GroupID      string            `json:"group_id,omitempty"`     // Deprecated
 	GroupValues  map[string]string `json:"group_values,omitempty"` // dimension_id -> option_id
 	Version      string            `json:"version"`
-	IP           string            `json:"ip"`
+	IP           string            `json:"ip"`                  // Agent's own (possibly internal) address, see metrics.ip_addresses for all
+	PublicIP     string            `json:"public_ip,omitempty"` // Address the agent connects from
 	Online       bool              `json:"online"`
 	Metrics      *SystemMetrics    `json:"metrics"`
 	PriceAmount  string            `json:"price_amount,omitempty"`
 	PricePeriod  string            `json:"price_period,omitempty"`
 	PurchaseDate string            `json:"purchase_date,omitempty"`
 	TipBadge     string            `json:"tip_badge,omitempty"`
+	SortOrder    int               `json:"sort_order"`
+	// Last agent update, only included by GET /api/metrics/all
+	UpdateStatus *AgentUpdateStatus `json:"update_status,omitempty"`
+	// End of the current maintenance window, unset when not in maintenance
+	MaintenanceUntil *time.Time `json:"maintenance_until,omitempty"`
+	// Formatted byte values, only with ?units=human
+	Human *MetricsHuman `json:"human,omitempty"`
+	// Next renewal or expiry (YYYY-MM-DD), see GET /api/servers/expiring
+	RenewalDate string `json:"renewal_date,omitempty"`
+	// From GeoIP, for plotting servers on a map
+	CountryCode string   `json:"country_code,omitempty"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+}
+
+// MetricsHuman holds the byte values of a sample formatted for display,
+// e.g. "1.5 GiB" and "12.3 MiB/s"
+type MetricsHuman struct {
+	MemoryTotal string      `json:"memory_total"`
+	MemoryUsed  string      `json:"memory_used"`
+	SwapTotal   string      `json:"swap_total"`
+	SwapUsed    string      `json:"swap_used"`
+	Disks       []DiskHuman `json:"disks,omitempty"` // Same order as metrics.disks
+	NetTotalRx  string      `json:"net_total_rx"`
+	NetTotalTx  string      `json:"net_total_tx"`
+	NetRxSpeed  string      `json:"net_rx_speed"`
+	NetTxSpeed  string      `json:"net_tx_speed"`
+	NetDailyRx  string      `json:"net_daily_rx"`
+	NetDailyTx  string      `json:"net_daily_tx"`
+}
+
+type DiskHuman struct {
+	Name  string `json:"name"`
+	Total string `json:"total"`
+	Used  string `json:"used"`
+}
+
+// ServerMetricsResponse is returned by GET /api/servers/:id/metrics
+type ServerMetricsResponse struct {
+	ServerMetricsUpdate
+	LastUpdated *string `json:"last_updated,omitempty"` // RFC3339, unset if the agent hasn't reported yet
+	LastSeen    *string `json:"last_seen,omitempty"`    // RFC3339 heartbeat, set when newer than last_updated
 }
 
 type DeltaMessage struct {
@@ -250,6 +707,7 @@ type CompactServerUpdate struct {
 	ID string          `json:"id"`
 	On *bool           `json:"on,omitempty"`
 	M  *CompactMetrics `json:"m,omitempty"`
+	Mt *bool           `json:"mt,omitempty"` // Maintenance started (true) or ended (false)
 }
 
 type CompactMetrics struct {
@@ -265,30 +723,74 @@ func (cm *CompactMetrics) IsEmpty() bool {
 	return cm.C == nil && cm.M == nil && cm.D == nil && cm.Rx == nil && cm.Tx == nil && cm.Up == nil
 }
 
-func (cm *CompactMetrics) HasChanged(other *CompactMetrics) bool {
-	return cm.C != other.C || cm.M != other.M || cm.D != other.D || cm.Rx != other.Rx || cm.Tx != other.Tx
+// HasChanged reports whether any metric moved by at least its threshold
+// since other (the values last sent to dashboards), or uptime went backwards
+func (cm *CompactMetrics) HasChanged(other *CompactMetrics, th DeltaThresholdsConfig) bool {
+	return !cm.Diff(other, th).IsEmpty()
 }
 
-func (cm *CompactMetrics) Diff(prev *CompactMetrics) *CompactMetrics {
+// Diff returns the metrics that moved by at least their threshold since prev.
+// A metric that appears always counts as changed. One that disappears (say,
+// the last disk is unmounted) is not reported: a delta can't clear a value,
+// so dashboards keep showing the last one until they reconnect and receive
+// the full state.
+func (cm *CompactMetrics) Diff(prev *CompactMetrics, th DeltaThresholdsConfig) *CompactMetrics {
 	diff := &CompactMetrics{}
-	if cm.C != nil && (prev.C == nil || *cm.C != *prev.C) {
+	if cm.C != nil && (prev.C == nil || absDiff(uint64(*cm.C), uint64(*prev.C)) >= uint64(th.CPUPercent)) {
 		diff.C = cm.C
 	}
-	if cm.M != nil && (prev.M == nil || *cm.M != *prev.M) {
+	if cm.M != nil && (prev.M == nil || absDiff(uint64(*cm.M), uint64(*prev.M)) >= uint64(th.MemoryPercent)) {
 		diff.M = cm.M
 	}
-	if cm.D != nil && (prev.D == nil || *cm.D != *prev.D) {
+	if cm.D != nil && (prev.D == nil || absDiff(uint64(*cm.D), uint64(*prev.D)) >= uint64(th.DiskPercent)) {
 		diff.D = cm.D
 	}
-	if cm.Rx != nil && (prev.Rx == nil || *cm.Rx != *prev.Rx) {
+	if cm.Rx != nil && (prev.Rx == nil || absDiff(*cm.Rx, *prev.Rx) >= th.NetworkBytesPerSec) {
 		diff.Rx = cm.Rx
 	}
-	if cm.Tx != nil && (prev.Tx == nil || *cm.Tx != *prev.Tx) {
+	if cm.Tx != nil && (prev.Tx == nil || absDiff(*cm.Tx, *prev.Tx) >= th.NetworkBytesPerSec) {
 		diff.Tx = cm.Tx
 	}
+	// Uptime grows every tick, so it rides along with other changes and is
+	// only sent on its own when it goes backwards (the server rebooted)
+	if cm.Up != nil && (prev.Up == nil || *cm.Up < *prev.Up || !diff.IsEmpty()) {
+		diff.Up = cm.Up
+	}
 	return diff
 }
 
+// Apply returns a copy of cm with the fields set in diff overwritten, i.e.
+// what a dashboard holds after receiving the delta
+func (cm *CompactMetrics) Apply(diff *CompactMetrics) *CompactMetrics {
+	merged := *cm
+	if diff.C != nil {
+		merged.C = diff.C
+	}
+	if diff.M != nil {
+		merged.M = diff.M
+	}
+	if diff.D != nil {
+		merged.D = diff.D
+	}
+	if diff.Rx != nil {
+		merged.Rx = diff.Rx
+	}
+	if diff.Tx != nil {
+		merged.Tx = diff.Tx
+	}
+	if diff.Up != nil {
+		merged.Up = diff.Up
+	}
+	return &merged
+}
+
+func absDiff(a, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
 func CompactMetricsFromSystem(m *SystemMetrics) *CompactMetrics {
 	cpu := uint8(m.CPU.Usage)
 	mem := uint8(m.Memory.UsagePercent)
@@ -311,11 +813,12 @@ func CompactMetricsFromSystem(m *SystemMetrics) *CompactMetrics {
 }
 
 type AgentMessage struct {
-	Type     string         `json:"type"`
-	ServerID string         `json:"server_id,omitempty"`
-	Token    string         `json:"token,omitempty"`
-	Version  string         `json:"version,omitempty"`
-	Metrics  *SystemMetrics `json:"metrics,omitempty"`
+	Type         string         `json:"type"`
+	ServerID     string         `json:"server_id,omitempty"`
+	Token        string         `json:"token,omitempty"`
+	Version      string         `json:"version,omitempty"`
+	IntervalSecs uint64         `json:"interval_secs,omitempty"` // Agent reporting interval, sent with auth
+	Metrics      *SystemMetrics `json:"metrics,omitempty"`
 	// Batch metrics fields
 	BatchID    string                       `json:"batch_id,omitempty"`
 	BatchItems []common.TimestampedMetrics  `json:"metrics_batch,omitempty"` // For batch raw metrics
@@ -323,6 +826,16 @@ type AgentMessage struct {
 	// Multi-granularity aggregated metrics (new)
 	Granularities []common.GranularityData `json:"granularities,omitempty"` // For multi-granularity data
 	LastMetrics   *SystemMetrics           `json:"last_metrics,omitempty"`  // Latest metrics snapshot
+	// Update result fields ("update_result")
+	Success bool   `json:"success,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Replies to commands ("logs_result", "update_result", "command_ack")
+	RequestID string `json:"request_id,omitempty"`
+	Command   string `json:"command,omitempty"` // Command acknowledged by "command_ack"
+	// Logs result fields ("logs_result")
+	Unit      string `json:"unit,omitempty"`
+	Output    string `json:"output,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
 }
 
 type AgentCommand struct {
@@ -330,11 +843,26 @@ type AgentCommand struct {
 	Command     string `json:"command"`
 	DownloadURL string `json:"download_url,omitempty"`
 	Force       bool   `json:"force,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	Token       string `json:"token,omitempty"`
+	RequestID   string `json:"request_id,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	Lines       int    `json:"lines,omitempty"`
 }
 
 type UpdateAgentRequest struct {
 	DownloadURL string `json:"download_url,omitempty"`
 	Force       bool   `json:"force,omitempty"`
+	SHA256      string `json:"sha256,omitempty"` // Hex SHA-256 the agent verifies the download against
+}
+
+// ServerLogsResponse is the journal tail returned by an agent
+type ServerLogsResponse struct {
+	ServerID  string `json:"server_id"`
+	Unit      string `json:"unit"`
+	Lines     int    `json:"lines"`
+	Output    string `json:"output"`
+	Truncated bool   `json:"truncated,omitempty"`
 }
 
 type UpdateAgentResponse struct {
@@ -342,9 +870,57 @@ type UpdateAgentResponse struct {
 	Message string `json:"message"`
 }
 
+// UpdateAllAgentsRequest updates every connected agent matching the optional
+// filter, with at most Concurrency agents downloading at once
+type UpdateAllAgentsRequest struct {
+	UpdateAgentRequest
+	GroupID     string            `json:"group_id,omitempty"`
+	Dimensions  map[string]string `json:"dimensions,omitempty"`  // dimension_id -> option_id, all must match
+	Concurrency int               `json:"concurrency,omitempty"` // Default 5
+}
+
+type UpdateAllAgentsResult struct {
+	ServerID string `json:"server_id"`
+	Name     string `json:"name"`
+	Status   string `json:"status"` // "dispatched", "queued" or "failed"
+	Message  string `json:"message,omitempty"`
+}
+
+type UpdateAllAgentsResponse struct {
+	Dispatched int                     `json:"dispatched"`
+	Queued     int                     `json:"queued"`
+	Failed     int                     `json:"failed"`
+	Results    []UpdateAllAgentsResult `json:"results"`
+}
+
+// Agent update states
+const (
+	UpdateStatusPending   = "pending"   // Command sent, no result yet
+	UpdateStatusSucceeded = "succeeded" // Agent installed the update
+	UpdateStatusFailed    = "failed"    // Agent reported an error
+)
+
+// AgentUpdateStatus tracks the most recent update command sent to an agent
+type AgentUpdateStatus struct {
+	Status      string `json:"status"`
+	RequestedAt string `json:"requested_at"`
+	FinishedAt  string `json:"finished_at,omitempty"`
+	FromVersion string `json:"from_version,omitempty"`
+	Version     string `json:"version,omitempty"` // Version reported after the update
+	Error       string `json:"error,omitempty"`
+}
+
+type RotateTokenResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Token   string `json:"token"`
+}
+
 type InstallCommand struct {
-	Command   string `json:"command"`
-	ScriptURL string `json:"script_url"`
+	Command   string            `json:"command"`
+	ScriptURL string            `json:"script_url"`
+	Platform  string            `json:"platform"`
+	Commands  map[string]string `json:"commands"` // Keyed by platform
 }
 
 type VersionInfo struct {
@@ -379,6 +955,9 @@ type LastSentState struct {
 type AgentConnection struct {
 	Conn     *websocket.Conn
 	SendChan chan []byte
+	Encoding string // Encoding negotiated for agent -> server messages ("json" or "msgpack")
+	RemoteIP string
+	Done     <-chan struct{} // Closed when the connection ends
 }
 
 // DashboardClient represents a connected dashboard client with its IP
@@ -401,9 +980,13 @@ type AppState struct {
 	DashboardClients map[*websocket.Conn]*DashboardClient
 	DashboardMu      sync.RWMutex
 	DB               *sql.DB
+	ReadDB           *sql.DB // Read-only pool for history queries, DB if it couldn't be opened
 	// Pre-built snapshot for fast dashboard delivery
 	Snapshot         *DashboardSnapshot
 	SnapshotMu       sync.RWMutex
+	// Outcome of the last agent update per server (in memory only)
+	UpdateStatus   map[string]*AgentUpdateStatus
+	UpdateStatusMu sync.RWMutex
 }
 
 // GetOnlineUsersCount returns the number of unique IPs connected to the dashboard
@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func u8(v uint8) *uint8    { return &v }
+func u64(v uint64) *uint64 { return &v }
+
+func TestCompactMetricsDiff(t *testing.T) {
+	th := (*DeltaThresholdsConfig)(nil).effective()
+
+	tests := []struct {
+		name   string
+		prev   CompactMetrics
+		cur    CompactMetrics
+		wantUp *uint64
+		empty  bool
+	}{
+		{
+			name:   "reboot resets uptime",
+			prev:   CompactMetrics{C: u8(10), Up: u64(100000)},
+			cur:    CompactMetrics{C: u8(10), Up: u64(5)},
+			wantUp: u64(5),
+		},
+		{
+			name:  "uptime growing alone is not a change",
+			prev:  CompactMetrics{C: u8(10), Up: u64(100000)},
+			cur:   CompactMetrics{C: u8(10), Up: u64(100001)},
+			empty: true,
+		},
+		{
+			name:   "uptime rides along with other changes",
+			prev:   CompactMetrics{C: u8(10), Up: u64(100000)},
+			cur:    CompactMetrics{C: u8(50), Up: u64(100001)},
+			wantUp: u64(100001),
+		},
+		{
+			name:   "first uptime is sent",
+			prev:   CompactMetrics{C: u8(10)},
+			cur:    CompactMetrics{C: u8(10), Up: u64(42)},
+			wantUp: u64(42),
+		},
+		{
+			name:   "a metric that appears is sent",
+			prev:   CompactMetrics{C: u8(10), Up: u64(100000)},
+			cur:    CompactMetrics{C: u8(10), D: u8(40), Up: u64(100001)},
+			wantUp: u64(100001),
+		},
+		{
+			name:  "a metric that disappears is not sent",
+			prev:  CompactMetrics{C: u8(10), D: u8(40), Up: u64(100000)},
+			cur:   CompactMetrics{C: u8(10), Up: u64(100001)},
+			empty: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := tt.cur.Diff(&tt.prev, th)
+			if diff.IsEmpty() != tt.empty {
+				t.Fatalf("IsEmpty() = %v, want %v", diff.IsEmpty(), tt.empty)
+			}
+			if (diff.Up == nil) != (tt.wantUp == nil) || (diff.Up != nil && *diff.Up != *tt.wantUp) {
+				t.Fatalf("Up = %v, want %v", ptrString(diff.Up), ptrString(tt.wantUp))
+			}
+
+			merged := tt.prev.Apply(diff)
+			if tt.wantUp != nil && *merged.Up != *tt.wantUp {
+				t.Fatalf("Apply: Up = %d, want %d", *merged.Up, *tt.wantUp)
+			}
+			if tt.empty && *merged.Up != *tt.prev.Up {
+				t.Fatalf("Apply of empty delta changed Up to %d", *merged.Up)
+			}
+		})
+	}
+}
+
+func ptrString(v *uint64) any {
+	if v == nil {
+		return "nil"
+	}
+	return *v
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// Units
+// ============================================================================
+
+// Values of the ?units= query param on the metrics and history endpoints.
+// Byte values are returned raw by default. "bits" reports network rates and
+// totals in bits (the ISP convention) and leaves memory and disk sizes in
+// bytes; "human" keeps the raw bytes and adds formatted strings next to them.
+const (
+	UnitsBytes = "bytes"
+	UnitsBits  = "bits"
+	UnitsHuman = "human"
+)
+
+func parseUnits(c *gin.Context) (string, error) {
+	switch units := c.DefaultQuery("units", UnitsBytes); units {
+	case UnitsBytes, UnitsBits, UnitsHuman:
+		return units, nil
+	default:
+		return "", fmt.Errorf("units must be bytes, bits or human")
+	}
+}
+
+// networkValue converts a network byte count or byte rate to the requested units
+func networkValue(bytes uint64, units string) uint64 {
+	if units == UnitsBits {
+		return bytes * 8
+	}
+	return bytes
+}
+
+// formatBytes formats a byte count with binary prefixes, e.g. "1.5 GiB"
+func formatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// formatRate formats a byte rate, e.g. "12.3 MiB/s"
+func formatRate(bytesPerSec uint64) string {
+	return formatBytes(bytesPerSec) + "/s"
+}
+
+// metricsWithUnits returns the metrics in the requested units, plus the
+// formatted values for "human". The input is never modified, as it is the
+// shared latest sample.
+func metricsWithUnits(m *SystemMetrics, units string) (*SystemMetrics, *MetricsHuman) {
+	if m == nil || units == UnitsBytes {
+		return m, nil
+	}
+
+	if units == UnitsHuman {
+		human := &MetricsHuman{
+			MemoryTotal: formatBytes(m.Memory.Total),
+			MemoryUsed:  formatBytes(m.Memory.Used),
+			SwapTotal:   formatBytes(m.Memory.SwapTotal),
+			SwapUsed:    formatBytes(m.Memory.SwapUsed),
+			NetTotalRx:  formatBytes(m.Network.TotalRx),
+			NetTotalTx:  formatBytes(m.Network.TotalTx),
+			NetRxSpeed:  formatRate(m.Network.RxSpeed),
+			NetTxSpeed:  formatRate(m.Network.TxSpeed),
+			NetDailyRx:  formatBytes(m.Network.DailyRx),
+			NetDailyTx:  formatBytes(m.Network.DailyTx),
+		}
+		for _, disk := range m.Disks {
+			human.Disks = append(human.Disks, DiskHuman{
+				Name:  disk.Name,
+				Total: formatBytes(disk.Total),
+				Used:  formatBytes(disk.Used),
+			})
+		}
+		return m, human
+	}
+
+	converted := *m
+	converted.Network.TotalRx = networkValue(m.Network.TotalRx, units)
+	converted.Network.TotalTx = networkValue(m.Network.TotalTx, units)
+	converted.Network.RxSpeed = networkValue(m.Network.RxSpeed, units)
+	converted.Network.TxSpeed = networkValue(m.Network.TxSpeed, units)
+	converted.Network.DailyRx = networkValue(m.Network.DailyRx, units)
+	converted.Network.DailyTx = networkValue(m.Network.DailyTx, units)
+	return &converted, nil
+}
+
+// historyWithUnits returns history points in the requested units. The
+// points may come from the history cache, so they are copied, not modified.
+func historyWithUnits(points []HistoryPoint, units string) []HistoryPoint {
+	if units == UnitsBytes || len(points) == 0 {
+		return points
+	}
+
+	converted := make([]HistoryPoint, len(points))
+	copy(converted, points)
+	for i := range converted {
+		p := &converted[i]
+		if units == UnitsHuman {
+			p.NetRxHuman = formatBytes(uint64(max(p.NetRx, 0)))
+			p.NetTxHuman = formatBytes(uint64(max(p.NetTx, 0)))
+			continue
+		}
+		p.NetRx *= 8
+		p.NetTx *= 8
+	}
+	return converted
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Days the server reported nothing are unknown, not 100% up
+func TestGetUptimeNoDataDays(t *testing.T) {
+	db, err := openDatabase("file:uptime?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+	if _, err := db.Exec("INSERT INTO metrics_2min (server_id, bucket) VALUES ('srv', ?)", now.Unix()/120); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO outages (server_id, start_time, end_time) VALUES ('srv', ?, ?)",
+		now.Add(-49*time.Hour).Format(time.RFC3339), now.Add(-48*time.Hour).Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+
+	overall, days, err := GetUptime(db, "srv", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 7 {
+		t.Fatalf("got %d days, want 7", len(days))
+	}
+	known := 0
+	for _, d := range days {
+		if d.UptimePercent != nil {
+			known++
+		}
+	}
+	if known < 2 || known > 3 {
+		t.Fatalf("%d days have data, want today and the outage's day(s)", known)
+	}
+	if days[6].UptimePercent == nil || *days[6].UptimePercent != 100 {
+		t.Fatalf("today = %v, want 100", days[6].UptimePercent)
+	}
+	if overall == nil || *overall >= 100 {
+		t.Fatalf("overall = %v, want below 100 from the outage only", overall)
+	}
+
+	if overall, _, _ := GetUptime(db, "other", 7); overall != nil {
+		t.Fatalf("overall for a server without data = %v, want nil", *overall)
+	}
+}
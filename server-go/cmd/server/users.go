@@ -0,0 +1,379 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ============================================================================
+// Users
+// ============================================================================
+
+// AdminUsername is the subject of the built-in admin password login. It is
+// reserved and can't be used by a named user.
+const AdminUsername = "admin"
+
+// MinPasswordLength applies to every password set through the API
+const MinPasswordLength = 8
+
+// dummyPasswordHash is compared against when a login names an unknown user
+// or one without a password, so the response takes as long as a wrong
+// password and doesn't reveal which usernames exist
+const dummyPasswordHash = "$2a$10$IJTE31KnStcO.EJBRvGaS.CtdUZ9XeNAr8v56AzwBQPoLdpvWS2Gi"
+
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+var (
+	users   = make(map[string]User) // lowercase username -> user
+	usersMu sync.RWMutex
+)
+
+// InitUsers loads users from the database
+func InitUsers(db *sql.DB) {
+	loaded, err := LoadUsers(db)
+	if err != nil {
+		slog.Error("Failed to load users", "error", err)
+		return
+	}
+
+	usersMu.Lock()
+	users = make(map[string]User, len(loaded))
+	for _, user := range loaded {
+		user.HasPassword = user.PasswordHash != ""
+		users[strings.ToLower(user.Username)] = user
+	}
+	usersMu.Unlock()
+}
+
+// lookupUser returns the named user, case-insensitively
+func lookupUser(username string) (User, bool) {
+	usersMu.RLock()
+	defer usersMu.RUnlock()
+	user, ok := users[strings.ToLower(username)]
+	return user, ok
+}
+
+// userForOAuth returns the user a GitHub login or Google email is mapped to
+func userForOAuth(provider, identity string) (User, bool) {
+	usersMu.RLock()
+	defer usersMu.RUnlock()
+	for _, user := range users {
+		mapped := user.GitHub
+		if provider == "google" {
+			mapped = user.Google
+		}
+		if mapped != "" && strings.EqualFold(mapped, identity) {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// oauthSubject decides the subject and role of an OAuth login. The subject is
+// always the identity itself, so it can never be mistaken for a named user's
+// username; the role is that of the user the identity is mapped to, or else
+// what allowed_users (admin) or viewer_users (viewer) permits.
+func oauthSubject(provider, identity string, allowedUsers, viewerUsers []string) (string, string, bool) {
+	if user, ok := userForOAuth(provider, identity); ok {
+		return identity, user.Role, true
+	}
+	if role := allowlistRole(identity, allowedUsers, viewerUsers); role != "" {
+		return identity, role, true
+	}
+	return "", "", false
+}
+
+// callerUser returns the named user behind the request: the user a password
+// login names, or the user an OAuth identity is mapped to
+func callerUser(c *gin.Context) (User, bool) {
+	switch provider := GetProvider(c); provider {
+	case "password":
+		return lookupUser(GetSub(c))
+	case "github", "google":
+		return userForOAuth(provider, GetSub(c))
+	}
+	return User{}, false
+}
+
+// allowlistRole returns the role an OAuth identity gets from the allowlists,
+// admin winning over viewer, or "" if neither allows it
+func allowlistRole(identity string, allowedUsers, viewerUsers []string) string {
+	switch {
+	case isUserAllowed(allowedUsers, identity):
+		return UserRoleAdmin
+	case isUserAllowed(viewerUsers, identity):
+		return UserRoleViewer
+	}
+	return ""
+}
+
+// tokenRole re-evaluates the role of a refresh token's subject, or returns
+// "" if it may no longer log in
+func (s *AppState) tokenRole(sub, provider string) string {
+	if provider == "password" {
+		if sub == AdminUsername {
+			return UserRoleAdmin
+		}
+		if user, ok := lookupUser(sub); ok {
+			return user.Role
+		}
+		return ""
+	}
+
+	// OAuth subjects are identities; only a user mapped to this very
+	// identity lends it a role
+	if user, ok := userForOAuth(provider, sub); ok {
+		return user.Role
+	}
+
+	s.ConfigMu.RLock()
+	defer s.ConfigMu.RUnlock()
+	oauth := s.Config.OAuth
+	switch {
+	case oauth == nil:
+		return ""
+	case oauth.UseCentralized:
+		return allowlistRole(sub, oauth.AllowedUsers, oauth.ViewerUsers)
+	case provider == "github" && oauth.GitHub != nil:
+		return allowlistRole(sub, oauth.GitHub.AllowedUsers, oauth.GitHub.ViewerUsers)
+	case provider == "google" && oauth.Google != nil:
+		return allowlistRole(sub, oauth.Google.AllowedUsers, oauth.Google.ViewerUsers)
+	}
+	return ""
+}
+
+func validRole(role string) bool {
+	return role == UserRoleAdmin || role == UserRoleViewer
+}
+
+// checkCallerPassword verifies a password re-entered by the logged-in caller:
+// a named user's own password, otherwise the admin password
+func (s *AppState) checkCallerPassword(c *gin.Context, password string) bool {
+	if user, ok := callerUser(c); ok && user.PasswordHash != "" {
+		return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+	}
+	s.ConfigMu.RLock()
+	passwordHash := s.Config.AdminPasswordHash
+	s.ConfigMu.RUnlock()
+	return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil
+}
+
+// normalizeOAuthMapping trims a GitHub login or Google email and makes sure
+// no other user has it
+func normalizeOAuthMapping(provider, identity, userID string) (string, error) {
+	identity = strings.TrimSpace(identity)
+	if identity == "" {
+		return "", nil
+	}
+	if existing, ok := userForOAuth(provider, identity); ok && existing.ID != userID {
+		return "", fmt.Errorf("%s identity %q is already mapped to %s", provider, identity, existing.Username)
+	}
+	return identity, nil
+}
+
+func (s *AppState) ListUsers(c *gin.Context) {
+	usersMu.RLock()
+	list := make([]User, 0, len(users))
+	for _, user := range users {
+		list = append(list, user)
+	}
+	usersMu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt < list[j].CreatedAt })
+	c.JSON(http.StatusOK, list)
+}
+
+func (s *AppState) CreateUser(c *gin.Context) {
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if !validUsername.MatchString(req.Username) || strings.EqualFold(req.Username, AdminUsername) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username must be 1-64 letters, digits, '.', '_' or '-' and not \"admin\""})
+		return
+	}
+	if _, exists := lookupUser(req.Username); exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+		return
+	}
+	if req.Role == "" {
+		req.Role = UserRoleAdmin
+	}
+	if !validRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be \"admin\" or \"viewer\""})
+		return
+	}
+
+	user := User{
+		ID:        uuid.New().String(),
+		Username:  req.Username,
+		Role:      req.Role,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	var err error
+	if user.GitHub, err = normalizeOAuthMapping("github", req.GitHub, ""); err == nil {
+		user.Google, err = normalizeOAuthMapping("google", req.Google, "")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Password == "" && user.GitHub == "" && user.Google == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password or an OAuth identity is required"})
+		return
+	}
+	if req.Password != "" {
+		if len(req.Password) < MinPasswordLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("password must be at least %d characters", MinPasswordLength)})
+			return
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		user.PasswordHash = string(hash)
+		user.HasPassword = true
+	}
+
+	if err := StoreUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save user"})
+		return
+	}
+	usersMu.Lock()
+	users[strings.ToLower(user.Username)] = user
+	usersMu.Unlock()
+
+	s.audit(c, "user.create", user.Username, gin.H{"role": user.Role, "github": user.GitHub, "google": user.Google})
+	c.JSON(http.StatusOK, user)
+}
+
+func (s *AppState) UpdateUser(c *gin.Context) {
+	id := c.Param("id")
+
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	var user User
+	found := false
+	usersMu.RLock()
+	for _, u := range users {
+		if u.ID == id {
+			user, found = u, true
+			break
+		}
+	}
+	usersMu.RUnlock()
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	detail := gin.H{}
+	if req.Role != nil {
+		if !validRole(*req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role must be \"admin\" or \"viewer\""})
+			return
+		}
+		user.Role = *req.Role
+		detail["role"] = user.Role
+	}
+	var err error
+	if req.GitHub != nil {
+		if user.GitHub, err = normalizeOAuthMapping("github", *req.GitHub, user.ID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		detail["github"] = user.GitHub
+	}
+	if req.Google != nil {
+		if user.Google, err = normalizeOAuthMapping("google", *req.Google, user.ID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		detail["google"] = user.Google
+	}
+	if req.Password != nil {
+		if *req.Password == "" {
+			user.PasswordHash = ""
+		} else {
+			if len(*req.Password) < MinPasswordLength {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("password must be at least %d characters", MinPasswordLength)})
+				return
+			}
+			hash, err := bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+				return
+			}
+			user.PasswordHash = string(hash)
+		}
+		user.HasPassword = user.PasswordHash != ""
+		detail["password_changed"] = true
+	}
+	if user.PasswordHash == "" && user.GitHub == "" && user.Google == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user would have no way to log in"})
+		return
+	}
+
+	if err := StoreUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save user"})
+		return
+	}
+	usersMu.Lock()
+	users[strings.ToLower(user.Username)] = user
+	usersMu.Unlock()
+
+	s.audit(c, "user.update", user.Username, detail)
+	// A reset password ends the user's sessions, like changing it themselves
+	if req.Password != nil {
+		if err := DeleteRefreshTokensForSub(user.Username, "password"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Password changed, but failed to end the user's sessions"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func (s *AppState) DeleteUser(c *gin.Context) {
+	id := c.Param("id")
+
+	usersMu.Lock()
+	var user User
+	for key, u := range users {
+		if u.ID == id {
+			user = u
+			delete(users, key)
+			break
+		}
+	}
+	usersMu.Unlock()
+	if user.ID == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := DeleteUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+
+	s.audit(c, "user.delete", user.Username, nil)
+	c.Status(http.StatusOK)
+}
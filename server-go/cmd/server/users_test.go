@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A refresh token whose OAuth subject happens to equal a username must not
+// inherit that user's role; only the identity the user is mapped to does
+func TestTokenRoleMatchesMappedIdentity(t *testing.T) {
+	usersMu.Lock()
+	saved := users
+	users = map[string]User{
+		"alice": {ID: "u1", Username: "alice", Role: UserRoleAdmin, GitHub: "alice-gh"},
+	}
+	usersMu.Unlock()
+	defer func() {
+		usersMu.Lock()
+		users = saved
+		usersMu.Unlock()
+	}()
+
+	s := &AppState{Config: &AppConfig{}}
+	for _, tc := range []struct {
+		sub, provider, want string
+	}{
+		{"alice", "github", ""},
+		{"alice-gh", "github", UserRoleAdmin},
+		{"Alice-GH", "github", UserRoleAdmin},
+		{"alice-gh", "google", ""},
+		{"alice", "password", UserRoleAdmin},
+	} {
+		if got := s.tokenRole(tc.sub, tc.provider); got != tc.want {
+			t.Errorf("tokenRole(%q, %q) = %q, want %q", tc.sub, tc.provider, got, tc.want)
+		}
+	}
+}
+
+// Logins naming an unknown user compare against dummyPasswordHash; it only
+// takes as long as a real check if it has the cost real hashes are made with
+func TestDummyPasswordHashCost(t *testing.T) {
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	if err != nil || cost != bcrypt.DefaultCost {
+		t.Fatalf("dummy hash cost %d (%v), want %d", cost, err, bcrypt.DefaultCost)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// Database Size
+// ============================================================================
+
+// SQLite keeps pages freed by the retention cleanup in its freelist instead
+// of shrinking the file. Once a day at vacuum_time the freelist is returned
+// to the filesystem: with auto_vacuum=INCREMENTAL (the default for new
+// databases) by a cheap incremental_vacuum, otherwise by a full VACUUM, which
+// also converts the database to incremental so later runs are cheap.
+
+// DefaultVacuumTime is the local time of day the database is vacuumed unless
+// vacuum_time is set
+const DefaultVacuumTime = "04:00"
+
+// lastVacuum is the most recent vacuum since startup, nil if none ran
+var (
+	lastVacuum   *VacuumRun
+	lastVacuumMu sync.Mutex
+)
+
+// parseVacuumTime checks a vacuum_time value; "off" disables vacuuming
+func parseVacuumTime(value string) (string, error) {
+	if value == "" {
+		return DefaultVacuumTime, nil
+	}
+	if value == "off" {
+		return value, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return "", fmt.Errorf("vacuum_time must be HH:MM or off")
+	}
+	return t.Format("15:04"), nil
+}
+
+// vacuumLoop vacuums the database once a day, at the first tick at or after
+// vacuum_time, so a tick delayed past the exact minute doesn't skip the day
+func (s *AppState) vacuumLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	lastDay, warned := "", ""
+	for range ticker.C {
+		s.ConfigMu.RLock()
+		value := s.Config.VacuumTime
+		s.ConfigMu.RUnlock()
+		at, err := parseVacuumTime(value)
+		if err != nil {
+			if warned != value {
+				warned = value
+				slog.Warn("Not vacuuming the database", "error", err)
+			}
+			continue
+		}
+
+		now := time.Now()
+		today := now.Format("2006-01-02")
+		if at == "off" || now.Format("15:04") < at || lastDay == today {
+			continue
+		}
+		lastDay = today
+
+		run, err := VacuumDatabase(s.DB)
+		if err != nil {
+			slog.Error("Database vacuum failed", "error", err)
+			continue
+		}
+		slog.Info("Database vacuumed", "mode", run.Mode, "freed_pages", run.FreedPages, "duration_ms", run.DurationMs)
+	}
+}
+
+// VacuumDatabase returns free pages to the filesystem. It runs through the
+// writer so it doesn't compete with metric writes for the lock.
+func VacuumDatabase(db *sql.DB) (*VacuumRun, error) {
+	var run *VacuumRun
+	vacuum := func(db *sql.DB) error {
+		var err error
+		run, err = vacuumDatabaseInternal(db)
+		return err
+	}
+	var err error
+	if dbWriter != nil {
+		err = dbWriter.WriteSync(vacuum)
+	} else {
+		err = vacuum(db)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lastVacuumMu.Lock()
+	lastVacuum = run
+	lastVacuumMu.Unlock()
+	return run, nil
+}
+
+func vacuumDatabaseInternal(db *sql.DB) (*VacuumRun, error) {
+	ctx := context.Background()
+	// auto_vacuum is per connection until VACUUM persists it, so both must
+	// use the same one
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var autoVacuum, before, after int64
+	if err := conn.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&autoVacuum); err != nil {
+		return nil, err
+	}
+	if err := conn.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&before); err != nil {
+		return nil, err
+	}
+
+	run := &VacuumRun{Time: time.Now().UTC().Format(time.RFC3339)}
+	started := time.Now()
+	if autoVacuum == 2 {
+		run.Mode = "incremental"
+		err = incrementalVacuum(ctx, conn)
+	} else {
+		run.Mode = "full"
+		if _, err = conn.ExecContext(ctx, "PRAGMA auto_vacuum=INCREMENTAL"); err == nil {
+			_, err = conn.ExecContext(ctx, "VACUUM")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&after); err != nil {
+		return nil, err
+	}
+	// Move the WAL's copy of the pages back into the file and truncate it
+	conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)")
+
+	run.DurationMs = time.Since(started).Milliseconds()
+	run.FreedPages = max(before-after, 0)
+	return run, nil
+}
+
+// incrementalVacuum frees the whole freelist. The pragma returns a row per
+// freed page and only frees pages as they are stepped through, so the rows
+// must be read to the end rather than run as an Exec.
+func incrementalVacuum(ctx context.Context, conn *sql.Conn) error {
+	rows, err := conn.QueryContext(ctx, "PRAGMA incremental_vacuum")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// GetDatabaseStats reports the size of the database file, its page usage
+// and the row count of every table
+func GetDatabaseStats(db *sql.DB) (*DBStatsResponse, error) {
+	stats := &DBStatsResponse{Path: GetDBPath(), Tables: []DBTableStats{}}
+	if info, err := os.Stat(stats.Path); err == nil {
+		stats.FileSize = info.Size()
+	}
+	if info, err := os.Stat(stats.Path + "-wal"); err == nil {
+		stats.WALSize = info.Size()
+	}
+
+	var autoVacuum int64
+	for _, pragma := range []struct {
+		name string
+		dest *int64
+	}{
+		{"page_size", &stats.PageSize},
+		{"page_count", &stats.PageCount},
+		{"freelist_count", &stats.FreePages},
+		{"auto_vacuum", &autoVacuum},
+	} {
+		if err := db.QueryRow("PRAGMA " + pragma.name).Scan(pragma.dest); err != nil {
+			return nil, err
+		}
+	}
+	stats.AutoVacuum = map[int64]string{0: "none", 1: "full", 2: "incremental"}[autoVacuum]
+
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, table := range tables {
+		var count int64
+		query := `SELECT COUNT(*) FROM "` + strings.ReplaceAll(table, `"`, `""`) + `"`
+		if err := db.QueryRow(query).Scan(&count); err != nil {
+			return nil, err
+		}
+		stats.Tables = append(stats.Tables, DBTableStats{Table: table, Rows: count})
+	}
+
+	lastVacuumMu.Lock()
+	stats.LastVacuum = lastVacuum
+	lastVacuumMu.Unlock()
+	return stats, nil
+}
+
+// GetDBStats serves GET /api/admin/db-stats
+func (s *AppState) GetDBStats(c *gin.Context) {
+	stats, err := GetDatabaseStats(s.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read database stats"})
+		return
+	}
+	s.ConfigMu.RLock()
+	stats.VacuumTime, _ = parseVacuumTime(s.Config.VacuumTime)
+	s.ConfigMu.RUnlock()
+	c.JSON(http.StatusOK, stats)
+}
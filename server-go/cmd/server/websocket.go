@@ -1,33 +1,69 @@
 package main
 
 import (
+	"compress/flate"
 	"encoding/json"
+	"errors"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"vstats/internal/common"
+
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
+// upgrader is used for agents, which aren't browsers, so any origin is
+// accepted. Dashboard connections set CheckOrigin per request.
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
 }
 
+// dashboardUpgrader negotiates permessage-deflate with dashboard clients.
+// Single-server deltas (~100 bytes) don't shrink, but a 10-server delta goes
+// from ~830 to ~220 bytes and a 100-server one from ~8.3KB to ~1.3KB. At
+// BestSpeed that costs roughly 15-40µs of CPU per message; BroadcastMetrics
+// compresses once per broadcast via PreparedMessage, not once per client.
+// Disable with "disable_ws_compression" for proxies that mangle compressed frames.
+var dashboardUpgrader = websocket.Upgrader{
+	EnableCompression: true,
+}
+
 // ============================================================================
 // Dashboard WebSocket Handler
 // ============================================================================
 
 func (s *AppState) HandleDashboardWS(c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	s.ConfigMu.RLock()
+	compress := !s.Config.DisableWSCompression
+	s.ConfigMu.RUnlock()
+
+	wsUpgrader := upgrader
+	if compress {
+		wsUpgrader = dashboardUpgrader
+	}
+	// Reject cross-site pages opening a socket with the user's session
+	wsUpgrader.CheckOrigin = func(r *http.Request) bool {
+		return s.dashboardOriginAllowed(c)
+	}
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
 	defer conn.Close()
 
+	if compress {
+		conn.SetCompressionLevel(flate.BestSpeed)
+	}
+
 	// Get client IP
 	clientIP := c.ClientIP()
 
@@ -183,6 +219,7 @@ func (s *AppState) sendInitialStateFresh(client *DashboardClient) {
 			PricePeriod:  localNode.PricePeriod,
 			PurchaseDate: localNode.PurchaseDate,
 			TipBadge:     localNode.TipBadge,
+			SortOrder:    localNode.SortOrder,
 		},
 	}
 	localData, _ := json.Marshal(localServer)
@@ -194,10 +231,7 @@ func (s *AppState) sendInitialStateFresh(client *DashboardClient) {
 	// Remote servers
 	for _, server := range config.Servers {
 		metricsData := agentMetrics[server.ID]
-		online := false
-		if metricsData != nil {
-			online = time.Since(metricsData.LastUpdated).Seconds() < 30
-		}
+		online := metricsData.IsOnline(&config.ProbeSettings)
 
 		version := server.Version
 		if metricsData != nil && metricsData.Metrics.Version != "" {
@@ -223,12 +257,20 @@ func (s *AppState) sendInitialStateFresh(client *DashboardClient) {
 				GroupValues:  server.GroupValues,
 				Version:      version,
 				IP:           server.IP,
+				PublicIP:     server.PublicIP,
 				Online:       online,
 				Metrics:      metrics,
 				PriceAmount:  server.PriceAmount,
 				PricePeriod:  server.PricePeriod,
 				PurchaseDate: server.PurchaseDate,
 				TipBadge:     server.TipBadge,
+				SortOrder:    server.SortOrder,
+
+				MaintenanceUntil: server.activeMaintenance(time.Now()),
+				RenewalDate:      server.renewalDay(time.Now()),
+				CountryCode:      server.CountryCode,
+				Latitude:         server.Latitude,
+				Longitude:        server.Longitude,
 			},
 		}
 		serverData, _ := json.Marshal(serverMsg)
@@ -305,6 +347,7 @@ func (s *AppState) RefreshSnapshot() {
 			PricePeriod:  localNode.PricePeriod,
 			PurchaseDate: localNode.PurchaseDate,
 			TipBadge:     localNode.TipBadge,
+			SortOrder:    localNode.SortOrder,
 		},
 	}
 	localData, _ := json.Marshal(localServer)
@@ -314,10 +357,7 @@ func (s *AppState) RefreshSnapshot() {
 	index := 1
 	for _, server := range config.Servers {
 		metricsData := agentMetrics[server.ID]
-		online := false
-		if metricsData != nil {
-			online = time.Since(metricsData.LastUpdated).Seconds() < 30
-		}
+		online := metricsData.IsOnline(&config.ProbeSettings)
 
 		version := server.Version
 		if metricsData != nil && metricsData.Metrics.Version != "" {
@@ -343,12 +383,20 @@ func (s *AppState) RefreshSnapshot() {
 				GroupValues:  server.GroupValues,
 				Version:      version,
 				IP:           server.IP,
+				PublicIP:     server.PublicIP,
 				Online:       online,
 				Metrics:      metrics,
 				PriceAmount:  server.PriceAmount,
 				PricePeriod:  server.PricePeriod,
 				PurchaseDate: server.PurchaseDate,
 				TipBadge:     server.TipBadge,
+				SortOrder:    server.SortOrder,
+
+				MaintenanceUntil: server.activeMaintenance(time.Now()),
+				RenewalDate:      server.renewalDay(time.Now()),
+				CountryCode:      server.CountryCode,
+				Latitude:         server.Latitude,
+				Longitude:        server.Longitude,
 			},
 		}
 		serverData, _ := json.Marshal(serverMsg)
@@ -376,10 +424,16 @@ func (s *AppState) BroadcastMetrics(msg string) {
 	}
 	s.DashboardMu.RUnlock()
 
-	msgBytes := []byte(msg)
+	// Prepare once so the frame (and its compressed form) is shared by all clients
+	prepared, err := websocket.NewPreparedMessage(websocket.TextMessage, []byte(msg))
+	if err != nil {
+		log.Printf("Failed to prepare broadcast message: %v", err)
+		return
+	}
+
 	for _, client := range clients {
 		client.WriteMu.Lock()
-		err := client.Conn.WriteMessage(websocket.TextMessage, msgBytes)
+		err := client.Conn.WritePreparedMessage(prepared)
 		client.WriteMu.Unlock()
 
 		if err != nil {
@@ -395,16 +449,72 @@ func (s *AppState) BroadcastMetrics(msg string) {
 // Agent WebSocket Handler
 // ============================================================================
 
+// Defaults for max_agent_connections and agent_auth_timeout_secs
+const (
+	DefaultMaxAgentConnections  = 1000
+	DefaultAgentAuthTimeoutSecs = 10
+)
+
+// AgentRevalidateInterval is how often a connected agent's token is checked
+// again, so revoking it or deleting the server ends the session
+const AgentRevalidateInterval = time.Minute
+
+// Agent connection counters, reported by /health/ready
+var (
+	agentConnsOpen     atomic.Int64 // Open agent connections, authenticated or not
+	agentConnsRejected atomic.Int64 // Refused because of max_agent_connections
+	agentAuthTimeouts  atomic.Int64 // Dropped for not authenticating in time
+)
+
+// agentConnLimits returns the connection cap (0 for none) and auth deadline
+func (s *AppState) agentConnLimits() (int64, time.Duration) {
+	s.ConfigMu.RLock()
+	defer s.ConfigMu.RUnlock()
+	limit := int64(s.Config.MaxAgentConnections)
+	if limit == 0 {
+		limit = DefaultMaxAgentConnections
+	} else if limit < 0 {
+		limit = 0
+	}
+	timeout := s.Config.AgentAuthTimeoutSecs
+	if timeout <= 0 {
+		timeout = DefaultAgentAuthTimeoutSecs
+	}
+	return limit, time.Duration(timeout) * time.Second
+}
+
 func (s *AppState) HandleAgentWS(c *gin.Context) {
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		slog.Warn("Agent WebSocket upgrade failed", "remote_ip", c.ClientIP(), "error", err)
 		return
 	}
 	defer conn.Close()
 
 	clientIP := c.ClientIP()
+
+	limit, authTimeout := s.agentConnLimits()
+	defer agentConnsOpen.Add(-1)
+	if open := agentConnsOpen.Add(1); limit > 0 && open > limit {
+		agentConnsRejected.Add(1)
+		slog.Warn("Agent connection refused: too many connections", "remote_ip", clientIP, "limit", limit)
+		msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too many agent connections")
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		return
+	}
+
+	// Until it authenticates, the agent has authTimeout to send a valid auth
+	// message; a failed attempt doesn't extend the deadline
+	conn.SetReadDeadline(time.Now().Add(authTimeout))
+
 	var authenticatedServerID string
+	var authenticatedToken string
+	var lastValidated time.Time
+	var agentIntervalSecs uint64
+
+	// Agents opt into MessagePack with ?encoding=msgpack and then send binary
+	// frames; text frames are always JSON. Replies to the agent stay JSON.
+	encoding := common.NormalizeEncoding(c.Query("encoding"))
 
 	// Create channel for sending commands
 	sendChan := make(chan []byte, 16)
@@ -416,7 +526,7 @@ func (s *AppState) HandleAgentWS(c *gin.Context) {
 			select {
 			case msg := <-sendChan:
 				if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-					log.Printf("Failed to send message to agent: %v", err)
+					slog.Warn("Failed to send message to agent", "server_id", authenticatedServerID, "error", err)
 					return
 				}
 			case <-done:
@@ -427,13 +537,34 @@ func (s *AppState) HandleAgentWS(c *gin.Context) {
 
 	// Handle incoming messages
 	for {
-		_, message, err := conn.ReadMessage()
+		messageType, message, err := conn.ReadMessage()
 		if err != nil {
+			var netErr net.Error
+			if authenticatedServerID == "" && errors.As(err, &netErr) && netErr.Timeout() {
+				agentAuthTimeouts.Add(1)
+				slog.Warn("Agent connection dropped: no authentication in time", "remote_ip", clientIP, "timeout", authTimeout)
+				msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "authentication timeout")
+				conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			}
 			break
 		}
 
+		if authenticatedServerID != "" && time.Since(lastValidated) >= AgentRevalidateInterval {
+			if reason := s.agentSessionInvalid(authenticatedServerID, authenticatedToken); reason != "" {
+				slog.Warn("Agent disconnected: "+reason, "server_id", authenticatedServerID, "remote_ip", clientIP)
+				msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+				conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				break
+			}
+			lastValidated = time.Now()
+		}
+
 		var agentMsg AgentMessage
-		if err := json.Unmarshal(message, &agentMsg); err != nil {
+		msgEncoding := common.EncodingJSON
+		if messageType == websocket.BinaryMessage {
+			msgEncoding = common.EncodingMsgpack
+		}
+		if err := common.DecodeMessage(msgEncoding, message, &agentMsg); err != nil {
 			continue
 		}
 
@@ -444,23 +575,62 @@ func (s *AppState) HandleAgentWS(c *gin.Context) {
 				var server *RemoteServer
 				for i := range s.Config.Servers {
 					if s.Config.Servers[i].ID == agentMsg.ServerID {
-						if s.Config.Servers[i].Token == agentMsg.Token {
+						if ok, previous := s.Config.Servers[i].CheckToken(agentMsg.Token); ok {
 							server = &s.Config.Servers[i]
 							authenticatedServerID = agentMsg.ServerID
+							authenticatedToken = agentMsg.Token
+							lastValidated = time.Now()
+							agentIntervalSecs = agentMsg.IntervalSecs
+							if agentIntervalSecs > common.MaxIntervalSecs {
+								slog.Warn("Ignoring out-of-range agent interval", "server_id", agentMsg.ServerID, "interval_secs", agentIntervalSecs)
+								agentIntervalSecs = 0
+							}
 
-							// Update version
+							// Update version and the address the agent connects from.
+							// IP is refined by the agent's own addresses with its metrics.
+							changed := false
 							if agentMsg.Version != "" && server.Version != agentMsg.Version {
 								server.Version = agentMsg.Version
+								changed = true
+							}
+							if server.PublicIP != clientIP {
+								server.PublicIP = clientIP
+								changed = true
+							}
+							if server.IP == "" {
+								server.IP = clientIP
+								changed = true
+							}
+							// The agent has the rotated token, so the old one is no
+							// longer needed; sessions still using it end on their
+							// next token check
+							if !previous && server.PreviousToken != "" {
+								server.clearPreviousToken()
+								changed = true
+							}
+							if changed {
 								SaveConfig(s.Config)
 							}
+							if s.Config.GeoIP != nil && server.needsGeoIP() {
+								go s.enrichServerLocation(server.ID, clientIP)
+							}
 
 							// Register connection
-							s.AgentConnsMu.Lock()
-							s.AgentConns[agentMsg.ServerID] = &AgentConnection{
+							agentConn := &AgentConnection{
 								Conn:     conn,
 								SendChan: sendChan,
+								Encoding: encoding,
+								RemoteIP: clientIP,
+								Done:     done,
+							}
+							if !s.registerAgentConn(agentMsg.ServerID, agentConn, s.Config.DuplicateAgentPolicy) {
+								authenticatedServerID = ""
+								conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"auth","status":"error","message":"Another agent is already connected for this server"}`))
+								conn.Close()
+								break
 							}
-							s.AgentConnsMu.Unlock()
+							RecordConnectionEvent(agentMsg.ServerID, ConnectionEventConnect, clientIP)
+							conn.SetReadDeadline(time.Time{})
 
 							// Send auth success with probe config and last data time
 							response := map[string]interface{}{
@@ -483,8 +653,15 @@ func (s *AppState) HandleAgentWS(c *gin.Context) {
 							
 							data, _ := json.Marshal(response)
 							conn.WriteMessage(websocket.TextMessage, data)
-							log.Printf("Agent %s authenticated", agentMsg.ServerID)
+							slog.Info("Agent authenticated", "server_id", agentMsg.ServerID, "encoding", encoding, "remote_ip", clientIP)
+
+							// Never hand the new token to a connection using the old
+							// one: whoever holds a leaked token would get the new one
+							if previous {
+								slog.Warn("Agent authenticated with its previous token; update it with the rotated token", "server_id", agentMsg.ServerID, "remote_ip", clientIP)
+							}
 						} else {
+							slog.Warn("Agent authentication failed: invalid token", "server_id", agentMsg.ServerID, "remote_ip", clientIP)
 							conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"auth","status":"error","message":"Invalid token"}`))
 						}
 						break
@@ -498,8 +675,15 @@ func (s *AppState) HandleAgentWS(c *gin.Context) {
 
 		case "metrics":
 			if authenticatedServerID != "" && agentMsg.Metrics != nil {
+				// Listening ports are a security inventory: keep them out of the
+				// public metrics and serve them from the authenticated ports endpoint
+				s.recordListeningPorts(authenticatedServerID, agentMsg.Metrics.ListeningPorts)
+				agentMsg.Metrics.ListeningPorts = nil
+				correctLiveTimestamp(authenticatedServerID, agentMsg.Metrics)
+
 				// Store to database asynchronously via channel queue with deduplication
 				StoreMetricsWithDedup(authenticatedServerID, agentMsg.Metrics)
+				s.checkDiskHealth(authenticatedServerID, agentMsg.Metrics)
 
 				// Determine IP address
 				agentIP := clientIP
@@ -531,16 +715,19 @@ func (s *AppState) HandleAgentWS(c *gin.Context) {
 				// Update in-memory state
 				s.AgentMetricsMu.Lock()
 				s.AgentMetrics[authenticatedServerID] = &AgentMetricsData{
-					ServerID:    authenticatedServerID,
-					Metrics:     *agentMsg.Metrics,
-					LastUpdated: time.Now(),
+					ServerID:     authenticatedServerID,
+					Metrics:      *agentMsg.Metrics,
+					LastUpdated:  time.Now(),
+					IntervalSecs: agentIntervalSecs,
 				}
 				s.AgentMetricsMu.Unlock()
 			} else {
 				conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"error","message":"Not authenticated"}`))
 			}
 
-		case "batch_metrics":
+		case "batch_metrics", "metrics_batch":
+			// Metrics buffered by the agent while offline, replayed with their
+			// original timestamps
 			if authenticatedServerID == "" {
 				conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"error","message":"Not authenticated"}`))
 				continue
@@ -558,8 +745,8 @@ func (s *AppState) HandleAgentWS(c *gin.Context) {
 			ackData, _ := json.Marshal(ackResponse)
 			conn.WriteMessage(websocket.TextMessage, ackData)
 			
-			log.Printf("Batch %s from %s: accepted=%d, rejected=%d", 
-				agentMsg.BatchID, authenticatedServerID, accepted, rejected)
+			slog.Info("Agent batch processed", "server_id", authenticatedServerID, "batch_id", agentMsg.BatchID,
+				"accepted", accepted, "rejected", rejected)
 
 		case "aggregated_metrics":
 			if authenticatedServerID == "" {
@@ -574,29 +761,130 @@ func (s *AppState) HandleAgentWS(c *gin.Context) {
 
 			// Update in-memory state with last metrics if provided
 			if agentMsg.LastMetrics != nil {
+				agentMsg.LastMetrics.ListeningPorts = nil
 				s.AgentMetricsMu.Lock()
 				s.AgentMetrics[authenticatedServerID] = &AgentMetricsData{
-					ServerID:    authenticatedServerID,
-					Metrics:     *agentMsg.LastMetrics,
-					LastUpdated: time.Now(),
+					ServerID:     authenticatedServerID,
+					Metrics:      *agentMsg.LastMetrics,
+					LastUpdated:  time.Now(),
+					IntervalSecs: agentIntervalSecs,
 				}
 				s.AgentMetricsMu.Unlock()
 			}
+
+		case "ping":
+			// Heartbeat sent independently of metrics collection: keeps the
+			// server online while a slow collection is in progress, but
+			// leaves the last metrics untouched
+			if authenticatedServerID == "" {
+				continue
+			}
+			s.AgentMetricsMu.Lock()
+			if existing := s.AgentMetrics[authenticatedServerID]; existing != nil {
+				updated := *existing
+				updated.LastSeen = time.Now()
+				s.AgentMetrics[authenticatedServerID] = &updated
+			}
+			s.AgentMetricsMu.Unlock()
+
+		case "update_result":
+			if authenticatedServerID == "" {
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"error","message":"Not authenticated"}`))
+				continue
+			}
+
+			s.recordUpdateResult(authenticatedServerID, &agentMsg)
+			deliverAgentReply(authenticatedServerID, &agentMsg)
+			if agentMsg.Success {
+				slog.Info("Agent updated", "server_id", authenticatedServerID, "version", agentMsg.Version)
+			} else {
+				slog.Warn("Agent update failed", "server_id", authenticatedServerID, "error", agentMsg.Error)
+			}
+
+		case "logs_result":
+			if authenticatedServerID == "" {
+				continue
+			}
+			deliverAgentReply(authenticatedServerID, &agentMsg)
+
+		case "command_ack":
+			if authenticatedServerID == "" {
+				continue
+			}
+			if !deliverAgentReply(authenticatedServerID, &agentMsg) && !agentMsg.Success {
+				slog.Warn("Agent command failed", "server_id", authenticatedServerID, "command", agentMsg.Command, "error", agentMsg.Error)
+			}
 		}
 	}
 
 	// Cleanup on disconnect
 	close(done) // Stop the send goroutine
 	if authenticatedServerID != "" {
-		log.Printf("Agent %s disconnected", authenticatedServerID)
+		slog.Info("Agent disconnected", "server_id", authenticatedServerID)
 		s.AgentConnsMu.Lock()
-		delete(s.AgentConns, authenticatedServerID)
+		// A newer connection may have replaced this one (last-wins)
+		if current := s.AgentConns[authenticatedServerID]; current != nil && current.Conn == conn {
+			delete(s.AgentConns, authenticatedServerID)
+		}
+		s.AgentConnsMu.Unlock()
+		RecordConnectionEvent(authenticatedServerID, ConnectionEventDisconnect, clientIP)
+	}
+}
+
+// agentSessionInvalid re-checks the token an agent authenticated with and
+// returns why the session must end, or "" if it is still valid
+func (s *AppState) agentSessionInvalid(serverID, token string) string {
+	s.ConfigMu.RLock()
+	defer s.ConfigMu.RUnlock()
+	for i := range s.Config.Servers {
+		if s.Config.Servers[i].ID == serverID {
+			if ok, _ := s.Config.Servers[i].CheckToken(token); !ok {
+				return "agent token revoked"
+			}
+			return ""
+		}
+	}
+	return "server deleted"
+}
+
+// Policies for a second agent authenticating as an already connected server
+// (duplicate_agent_policy)
+const (
+	DuplicateAgentLastWins  = "last-wins"
+	DuplicateAgentFirstWins = "first-wins"
+)
+
+// registerAgentConn records the live connection for serverID. If another agent
+// is already connected under the same ID, typically a VM cloned with the agent
+// config, the policy decides: last-wins (default) closes the old connection,
+// first-wins rejects the new one and returns false.
+func (s *AppState) registerAgentConn(serverID string, agentConn *AgentConnection, policy string) bool {
+	s.AgentConnsMu.Lock()
+	existing := s.AgentConns[serverID]
+	if existing != nil && existing.Conn != agentConn.Conn && policy == DuplicateAgentFirstWins {
 		s.AgentConnsMu.Unlock()
+		slog.Warn("Duplicate agent connection rejected", "server_id", serverID,
+			"existing_ip", existing.RemoteIP, "remote_ip", agentConn.RemoteIP, "policy", policy)
+		return false
 	}
+	s.AgentConns[serverID] = agentConn
+	s.AgentConnsMu.Unlock()
+
+	if existing != nil && existing.Conn != agentConn.Conn {
+		slog.Warn("Duplicate agent connection, closing the previous one", "server_id", serverID,
+			"existing_ip", existing.RemoteIP, "remote_ip", agentConn.RemoteIP, "policy", DuplicateAgentLastWins)
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "replaced by a newer connection")
+		existing.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		existing.Conn.Close()
+	}
+	return true
 }
 
 // handleBatchMetrics processes batch metrics from an agent
 func (s *AppState) handleBatchMetrics(serverID string, msg *AgentMessage) (accepted, rejected int) {
+	// Time span covered by the batch, used to backfill the rollups
+	var earliest, latest time.Time
+
 	// Process raw metrics
 	for _, tm := range msg.BatchItems {
 		if tm.Metrics == nil {
@@ -614,8 +902,19 @@ func (s *AppState) handleBatchMetrics(serverID string, msg *AgentMessage) (accep
 			}
 		}
 
+		if !batchTimestampValid(serverID, ts) {
+			rejected++
+			continue
+		}
+
 		// Update metrics timestamp
 		tm.Metrics.Timestamp = ts
+		if earliest.IsZero() || ts.Before(earliest) {
+			earliest = ts
+		}
+		if ts.After(latest) {
+			latest = ts
+		}
 
 		// Store with deduplication
 		if StoreBatchMetrics(serverID, tm.Metrics) {
@@ -632,6 +931,13 @@ func (s *AppState) handleBatchMetrics(serverID string, msg *AgentMessage) (accep
 			continue
 		}
 
+		// Aggregates are subject to the same clock checks as raw samples
+		start, err := time.Parse(time.RFC3339Nano, agg.StartTime)
+		if err != nil || !batchTimestampValid(serverID, start) {
+			rejected++
+			continue
+		}
+
 		// Store aggregated metrics
 		if StoreAggregatedMetrics(serverID, agg) {
 			accepted++
@@ -640,25 +946,40 @@ func (s *AppState) handleBatchMetrics(serverID string, msg *AgentMessage) (accep
 		}
 	}
 
+	// Replayed metrics land in windows the periodic aggregation has already
+	// processed, so recompute the 15min/hourly/daily buckets they fall into
+	if !earliest.IsZero() && s.DB != nil {
+		go func() {
+			if err := AggregateRange(s.DB, earliest, latest.Add(time.Second)); err != nil {
+				slog.Error("Batch re-aggregation failed", "server_id", serverID, "error", err)
+			}
+		}()
+	}
+
 	// Update in-memory state with the latest metrics if available
 	if len(msg.BatchItems) > 0 {
 		lastItem := msg.BatchItems[len(msg.BatchItems)-1]
 		if lastItem.Metrics != nil {
+			latest := *lastItem.Metrics
+			latest.ListeningPorts = nil
 			s.AgentMetricsMu.Lock()
 			s.AgentMetrics[serverID] = &AgentMetricsData{
-				ServerID:    serverID,
-				Metrics:     *lastItem.Metrics,
-				LastUpdated: time.Now(),
+				ServerID:     serverID,
+				Metrics:      latest,
+				LastUpdated:  time.Now(),
+				IntervalSecs: s.agentIntervalLocked(serverID),
 			}
 			s.AgentMetricsMu.Unlock()
 		}
 	} else if len(msg.Aggregated) > 0 && msg.Aggregated[len(msg.Aggregated)-1].LastMetrics != nil {
-		lastAgg := msg.Aggregated[len(msg.Aggregated)-1]
+		latest := *msg.Aggregated[len(msg.Aggregated)-1].LastMetrics
+		latest.ListeningPorts = nil
 		s.AgentMetricsMu.Lock()
 		s.AgentMetrics[serverID] = &AgentMetricsData{
-			ServerID:    serverID,
-			Metrics:     *lastAgg.LastMetrics,
-			LastUpdated: time.Now(),
+			ServerID:     serverID,
+			Metrics:      latest,
+			LastUpdated:  time.Now(),
+			IntervalSecs: s.agentIntervalLocked(serverID),
 		}
 		s.AgentMetricsMu.Unlock()
 	}
@@ -666,4 +987,11 @@ func (s *AppState) handleBatchMetrics(serverID string, msg *AgentMessage) (accep
 	return accepted, rejected
 }
 
-
+// agentIntervalLocked returns the last known reporting interval of an agent.
+// Caller must hold AgentMetricsMu.
+func (s *AppState) agentIntervalLocked(serverID string) uint64 {
+	if prev := s.AgentMetrics[serverID]; prev != nil {
+		return prev.IntervalSecs
+	}
+	return 0
+}
@@ -1,238 +0,0 @@
-package auth
-
-import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"strings"
-	"time"
-
-	"vstats/internal/cloud/config"
-)
-
-// ============================================================================
-// GitHub OAuth
-// ============================================================================
-
-type GitHubTokenResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	Scope       string `json:"scope"`
-}
-
-type GitHubUser struct {
-	ID        int64  `json:"id"`
-	Login     string `json:"login"`
-	Name      string `json:"name"`
-	Email     string `json:"email"`
-	AvatarURL string `json:"avatar_url"`
-}
-
-// GetGitHubAuthURL returns the GitHub OAuth authorization URL
-func GetGitHubAuthURL(state, redirectURI string) string {
-	cfg := config.Get()
-	params := url.Values{}
-	params.Set("client_id", cfg.GitHubClientID)
-	params.Set("redirect_uri", redirectURI)
-	params.Set("scope", "read:user user:email")
-	params.Set("state", state)
-	return "https://github.com/login/oauth/authorize?" + params.Encode()
-}
-
-// ExchangeGitHubCode exchanges authorization code for access token
-func ExchangeGitHubCode(ctx context.Context, code, redirectURI string) (*GitHubTokenResponse, error) {
-	cfg := config.Get()
-
-	data := url.Values{}
-	data.Set("client_id", cfg.GitHubClientID)
-	data.Set("client_secret", cfg.GitHubClientSecret)
-	data.Set("code", code)
-	data.Set("redirect_uri", redirectURI)
-
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://github.com/login/oauth/access_token", strings.NewReader(data.Encode()))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Accept", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var tokenResp GitHubTokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		return nil, err
-	}
-
-	if tokenResp.AccessToken == "" {
-		return nil, fmt.Errorf("no access token in response")
-	}
-
-	return &tokenResp, nil
-}
-
-// GetGitHubUser retrieves GitHub user info
-func GetGitHubUser(ctx context.Context, accessToken string) (*GitHubUser, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.github.com/user", nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Accept", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var user GitHubUser
-	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-		return nil, err
-	}
-
-	// If no public email, try to get private email
-	if user.Email == "" {
-		email, _ := getGitHubPrimaryEmail(ctx, accessToken)
-		user.Email = email
-	}
-
-	return &user, nil
-}
-
-func getGitHubPrimaryEmail(ctx context.Context, accessToken string) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.github.com/user/emails", nil)
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Accept", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	var emails []struct {
-		Email    string `json:"email"`
-		Primary  bool   `json:"primary"`
-		Verified bool   `json:"verified"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&emails); err != nil {
-		return "", err
-	}
-
-	for _, e := range emails {
-		if e.Primary && e.Verified {
-			return e.Email, nil
-		}
-	}
-
-	return "", nil
-}
-
-// ============================================================================
-// Google OAuth
-// ============================================================================
-
-type GoogleTokenResponse struct {
-	AccessToken  string `json:"access_token"`
-	TokenType    string `json:"token_type"`
-	ExpiresIn    int    `json:"expires_in"`
-	RefreshToken string `json:"refresh_token,omitempty"`
-	IDToken      string `json:"id_token,omitempty"`
-}
-
-type GoogleUser struct {
-	ID            string `json:"id"`
-	Email         string `json:"email"`
-	VerifiedEmail bool   `json:"verified_email"`
-	Name          string `json:"name"`
-	GivenName     string `json:"given_name"`
-	FamilyName    string `json:"family_name"`
-	Picture       string `json:"picture"`
-}
-
-// GetGoogleAuthURL returns the Google OAuth authorization URL
-func GetGoogleAuthURL(state, redirectURI string) string {
-	cfg := config.Get()
-	params := url.Values{}
-	params.Set("client_id", cfg.GoogleClientID)
-	params.Set("redirect_uri", redirectURI)
-	params.Set("response_type", "code")
-	params.Set("scope", "openid email profile")
-	params.Set("state", state)
-	params.Set("access_type", "offline")
-	return "https://accounts.google.com/o/oauth2/v2/auth?" + params.Encode()
-}
-
-// ExchangeGoogleCode exchanges authorization code for access token
-func ExchangeGoogleCode(ctx context.Context, code, redirectURI string) (*GoogleTokenResponse, error) {
-	cfg := config.Get()
-
-	data := url.Values{}
-	data.Set("client_id", cfg.GoogleClientID)
-	data.Set("client_secret", cfg.GoogleClientSecret)
-	data.Set("code", code)
-	data.Set("redirect_uri", redirectURI)
-	data.Set("grant_type", "authorization_code")
-
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://oauth2.googleapis.com/token", strings.NewReader(data.Encode()))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-
-	var tokenResp GoogleTokenResponse
-	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return nil, err
-	}
-
-	if tokenResp.AccessToken == "" {
-		return nil, fmt.Errorf("no access token in response")
-	}
-
-	return &tokenResp, nil
-}
-
-// GetGoogleUser retrieves Google user info
-func GetGoogleUser(ctx context.Context, accessToken string) (*GoogleUser, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://www.googleapis.com/oauth2/v2/userinfo", nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var user GoogleUser
-	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-		return nil, err
-	}
-
-	return &user, nil
-}
@@ -44,6 +44,9 @@ type Config struct {
 
 	// Metrics
 	MetricsRetentionDays int
+
+	// Agents: "last-wins" or "first-wins" when two agents use the same key
+	DuplicateAgentPolicy string
 }
 
 var cfg *Config
@@ -87,6 +90,9 @@ func Load() *Config {
 
 		// Metrics
 		MetricsRetentionDays: getIntEnv("METRICS_RETENTION_DAYS", 30),
+
+		// Agents
+		DuplicateAgentPolicy: getEnv("DUPLICATE_AGENT_POLICY", "last-wins"),
 	}
 	return cfg
 }
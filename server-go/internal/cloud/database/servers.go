@@ -239,3 +239,44 @@ func CleanupOldMetrics(ctx context.Context, retentionDays int) (int64, error) {
 	}
 	return result.RowsAffected(), nil
 }
+
+// ============================================================================
+// Connection Events
+// ============================================================================
+
+// InsertConnectionEvent records an agent connecting or disconnecting
+func InsertConnectionEvent(ctx context.Context, serverID, event, ipAddress string) error {
+	var ip *string
+	if ipAddress != "" {
+		ip = &ipAddress
+	}
+	_, err := pool.Exec(ctx, `
+		INSERT INTO connection_events (server_id, event, ip_address) VALUES ($1, $2, $3)
+	`, serverID, event, ip)
+	return err
+}
+
+// GetConnectionEvents retrieves a server's connection events since a time, oldest first
+func GetConnectionEvents(ctx context.Context, serverID string, since time.Time) ([]models.ConnectionEvent, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, server_id, event, host(ip_address), created_at
+		FROM connection_events
+		WHERE server_id = $1 AND created_at >= $2
+		ORDER BY created_at ASC
+	`, serverID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.ConnectionEvent{}
+	for rows.Next() {
+		var e models.ConnectionEvent
+		if err := rows.Scan(&e.ID, &e.ServerID, &e.Event, &e.IPAddress, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, nil
+}
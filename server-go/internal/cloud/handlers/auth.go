@@ -15,6 +15,7 @@ import (
 	"vstats/internal/cloud/database"
 	"vstats/internal/cloud/middleware"
 	"vstats/internal/cloud/redis"
+	"vstats/internal/oauthclient"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -64,7 +65,7 @@ func GitHubOAuthStart(c *gin.Context) {
 		return
 	}
 
-	authURL := auth.GetGitHubAuthURL(state, redirectURI)
+	authURL := oauthclient.GitHubAuthURL(cfg.GitHubClientID, redirectURI, state, "")
 	c.JSON(http.StatusOK, gin.H{"url": authURL})
 }
 
@@ -88,15 +89,16 @@ func GitHubOAuthCallback(c *gin.Context) {
 	}
 
 	// Exchange code for token
+	cfg := config.Get()
 	redirectURI := getOAuthCallbackURL(c, "github")
-	tokenResp, err := auth.ExchangeGitHubCode(ctx, code, redirectURI)
+	tokenResp, err := oauthclient.ExchangeGitHubCode(ctx, cfg.GitHubClientID, cfg.GitHubClientSecret, code, redirectURI, "")
 	if err != nil {
 		redirectWithError(c, "Failed to exchange code: "+err.Error())
 		return
 	}
 
 	// Get user info
-	githubUser, err := auth.GetGitHubUser(ctx, tokenResp.AccessToken)
+	githubUser, err := oauthclient.GetGitHubUser(ctx, tokenResp.AccessToken)
 	if err != nil {
 		redirectWithError(c, "Failed to get user info: "+err.Error())
 		return
@@ -156,7 +158,7 @@ func GoogleOAuthStart(c *gin.Context) {
 		return
 	}
 
-	authURL := auth.GetGoogleAuthURL(state, redirectURI)
+	authURL := oauthclient.GoogleAuthURL(cfg.GoogleClientID, redirectURI, state, "")
 	c.JSON(http.StatusOK, gin.H{"url": authURL})
 }
 
@@ -178,14 +180,15 @@ func GoogleOAuthCallback(c *gin.Context) {
 		return
 	}
 
+	cfg := config.Get()
 	redirectURI := getOAuthCallbackURL(c, "google")
-	tokenResp, err := auth.ExchangeGoogleCode(ctx, code, redirectURI)
+	tokenResp, err := oauthclient.ExchangeGoogleCode(ctx, cfg.GoogleClientID, cfg.GoogleClientSecret, code, redirectURI, "")
 	if err != nil {
 		redirectWithError(c, "Failed to exchange code: "+err.Error())
 		return
 	}
 
-	googleUser, err := auth.GetGoogleUser(ctx, tokenResp.AccessToken)
+	googleUser, err := oauthclient.GetGoogleUser(ctx, tokenResp.AccessToken)
 	if err != nil {
 		redirectWithError(c, "Failed to get user info: "+err.Error())
 		return
@@ -338,8 +338,8 @@ func GetServerHistory(c *gin.Context) {
 		since = time.Now().Add(-30 * 24 * time.Hour)
 		limit = 720
 	default:
-		since = time.Now().Add(-1 * time.Hour)
-		limit = 60
+		c.JSON(http.StatusBadRequest, gin.H{"error": "range must be 1h, 24h, 7d or 30d"})
+		return
 	}
 
 	history, err := database.GetMetricsHistory(ctx, serverID, since, limit)
@@ -354,3 +354,57 @@ func GetServerHistory(c *gin.Context) {
 		"data":      history,
 	})
 }
+
+// GetServerConnections returns an agent's connect/disconnect history
+func GetServerConnections(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	serverID := c.Param("id")
+	rangeStr := c.DefaultQuery("range", "24h")
+	ctx := context.Background()
+
+	server, err := database.GetServerByID(ctx, serverID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return
+	}
+
+	if server.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var since time.Time
+	switch rangeStr {
+	case "1h":
+		since = time.Now().Add(-1 * time.Hour)
+	case "24h":
+		since = time.Now().Add(-24 * time.Hour)
+	case "7d":
+		since = time.Now().Add(-7 * 24 * time.Hour)
+	case "30d":
+		since = time.Now().Add(-30 * 24 * time.Hour)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "range must be 1h, 24h, 7d or 30d"})
+		return
+	}
+
+	events, err := database.GetConnectionEvents(ctx, serverID, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch connection events"})
+		return
+	}
+
+	disconnects := 0
+	for _, e := range events {
+		if e.Event == "disconnect" {
+			disconnects++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"server_id":   serverID,
+		"range":       rangeStr,
+		"disconnects": disconnects,
+		"data":        events,
+	})
+}
@@ -195,6 +195,15 @@ type AuthOverallStats struct {
 	GoogleUsers  int `json:"google_users"`
 }
 
+// ConnectionEvent is one agent connect or disconnect
+type ConnectionEvent struct {
+	ID        int64     `json:"id" db:"id"`
+	ServerID  string    `json:"server_id" db:"server_id"`
+	Event     string    `json:"event" db:"event"`
+	IPAddress *string   `json:"ip_address,omitempty" db:"ip_address"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
 // ============================================================================
 // Plan Limits
 // ============================================================================
@@ -3,17 +3,20 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"sync"
 	"time"
 
+	"vstats/internal/cloud/config"
 	"vstats/internal/cloud/database"
 	"vstats/internal/cloud/models"
 	"vstats/internal/cloud/redis"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
 )
 
 var upgrader = websocket.Upgrader{
@@ -53,8 +56,15 @@ type AgentConn struct {
 	UserID    string
 	SendChan  chan []byte
 	CloseChan chan struct{}
+	RemoteIP  string
+
+	validatedAt time.Time // Last time AgentKey was found valid, read loop only
 }
 
+// AgentRevalidateInterval is how often a connected agent's key is looked up
+// again, so regenerating it or deleting the server ends the session
+const AgentRevalidateInterval = 5 * time.Minute
+
 type DashboardConn struct {
 	Conn      *websocket.Conn
 	ConnID    string
@@ -169,6 +179,19 @@ func HandleAgentWS(c *gin.Context) {
 		return
 	}
 
+	// Two agents sharing a key (e.g. a cloned VM) would interleave their
+	// metrics; with first-wins the newcomer is turned away
+	policy := config.Get().DuplicateAgentPolicy
+	hub.agentConnsMu.RLock()
+	existing := hub.agentConns[agentKey]
+	hub.agentConnsMu.RUnlock()
+	if existing != nil && policy == "first-wins" {
+		log.Printf("Duplicate agent connection rejected: %s (server: %s, connected from %s, new from %s)",
+			agentKey[:8], server.Name, existing.RemoteIP, c.ClientIP())
+		c.JSON(http.StatusConflict, gin.H{"error": "Another agent is already connected with this key"})
+		return
+	}
+
 	// Upgrade connection
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -183,14 +206,27 @@ func HandleAgentWS(c *gin.Context) {
 		UserID:    server.UserID,
 		SendChan:  make(chan []byte, 64),
 		CloseChan: make(chan struct{}),
+		RemoteIP:  c.ClientIP(),
+
+		validatedAt: time.Now(),
 	}
 
 	hub.agentConnsMu.Lock()
+	existing = hub.agentConns[agentKey]
 	hub.agentConns[agentKey] = agentConn
 	hub.agentConnsMu.Unlock()
 
+	if existing != nil {
+		log.Printf("Duplicate agent connection: %s (server: %s), closing the one from %s in favor of %s",
+			agentKey[:8], server.Name, existing.RemoteIP, agentConn.RemoteIP)
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "replaced by a newer connection")
+		existing.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		existing.Conn.Close()
+	}
+
 	// Update server status
 	database.UpdateServerStatus(ctx, server.ID, "online")
+	database.InsertConnectionEvent(ctx, server.ID, "connect", agentConn.RemoteIP)
 	redis.SetServerLive(ctx, server.ID, &redis.ServerLiveData{
 		ServerID:   server.ID,
 		Status:     "online",
@@ -213,19 +249,26 @@ func HandleAgentWS(c *gin.Context) {
 
 func (ac *AgentConn) readPump() {
 	defer func() {
+		// Skip the offline handling if a newer connection replaced this one
 		hub.agentConnsMu.Lock()
-		delete(hub.agentConns, ac.AgentKey)
+		current := hub.agentConns[ac.AgentKey] == ac
+		if current {
+			delete(hub.agentConns, ac.AgentKey)
+		}
 		hub.agentConnsMu.Unlock()
 
 		ctx := context.Background()
-		database.UpdateServerStatus(ctx, ac.ServerID, "offline")
-		redis.DeleteServerLive(ctx, ac.ServerID)
-
-		hub.BroadcastToUser(ac.UserID, &DashboardMessage{
-			Type:      "server_offline",
-			Timestamp: time.Now().Unix(),
-			Data:      gin.H{"server_id": ac.ServerID},
-		})
+		database.InsertConnectionEvent(ctx, ac.ServerID, "disconnect", ac.RemoteIP)
+		if current {
+			database.UpdateServerStatus(ctx, ac.ServerID, "offline")
+			redis.DeleteServerLive(ctx, ac.ServerID)
+
+			hub.BroadcastToUser(ac.UserID, &DashboardMessage{
+				Type:      "server_offline",
+				Timestamp: time.Now().Unix(),
+				Data:      gin.H{"server_id": ac.ServerID},
+			})
+		}
 
 		close(ac.CloseChan)
 		ac.Conn.Close()
@@ -247,6 +290,15 @@ func (ac *AgentConn) readPump() {
 			break
 		}
 
+		if time.Since(ac.validatedAt) >= AgentRevalidateInterval {
+			if reason := ac.revalidate(); reason != "" {
+				log.Printf("Agent disconnected: %s (%s)", ac.AgentKey[:8], reason)
+				msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+				ac.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				break
+			}
+		}
+
 		var msg AgentMessage
 		if err := json.Unmarshal(message, &msg); err != nil {
 			continue
@@ -256,6 +308,25 @@ func (ac *AgentConn) readPump() {
 	}
 }
 
+// revalidate looks the agent key up again and returns why the session must
+// end, or "" if it is still valid. A failed lookup keeps the connection and
+// is retried on the next message, so a database hiccup doesn't drop agents.
+func (ac *AgentConn) revalidate() string {
+	server, err := database.GetServerByAgentKey(context.Background(), ac.AgentKey)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "agent key revoked"
+	}
+	if err != nil {
+		log.Printf("Failed to revalidate agent key %s: %v", ac.AgentKey[:8], err)
+		return ""
+	}
+	if server.ID != ac.ServerID {
+		return "agent key revoked"
+	}
+	ac.validatedAt = time.Now()
+	return ""
+}
+
 func (ac *AgentConn) writePump() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer func() {
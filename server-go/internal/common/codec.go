@@ -0,0 +1,58 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/ugorji/go/codec"
+)
+
+// ============================================================================
+// Agent Protocol Encoding
+// ============================================================================
+
+// Supported agent -> server message encodings. The agent selects one with the
+// ?encoding= query parameter when it opens the WebSocket; JSON is the default
+// so older agents keep working unchanged.
+const (
+	EncodingJSON    = "json"
+	EncodingMsgpack = "msgpack"
+)
+
+// msgpackHandle reuses the existing json tags (field names and omitempty), so
+// both encodings produce the same keys. A msgpack tag takes precedence if a
+// field ever needs a different name on the wire.
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true // Encode time.Time as the standard timestamp extension
+	h.TypeInfos = codec.NewTypeInfos([]string{"msgpack", "json"})
+	return h
+}()
+
+// NormalizeEncoding maps a requested encoding to a supported one
+func NormalizeEncoding(encoding string) string {
+	if encoding == EncodingMsgpack {
+		return EncodingMsgpack
+	}
+	return EncodingJSON
+}
+
+// EncodeMessage serializes v using the given encoding
+func EncodeMessage(encoding string, v interface{}) ([]byte, error) {
+	if encoding != EncodingMsgpack {
+		return json.Marshal(v)
+	}
+	var buf bytes.Buffer
+	if err := codec.NewEncoder(&buf, msgpackHandle).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeMessage deserializes data produced by EncodeMessage into v
+func DecodeMessage(encoding string, data []byte, v interface{}) error {
+	if encoding != EncodingMsgpack {
+		return json.Unmarshal(data, v)
+	}
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+}
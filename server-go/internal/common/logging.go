@@ -0,0 +1,48 @@
+package common
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// ============================================================================
+// Logging
+// ============================================================================
+
+// Log output formats, selected with --log-format or VSTATS_LOG_FORMAT. Text
+// keeps the human-readable console output; JSON emits one object per line
+// (time, level, msg plus fields like server_id) for Loki/ELK.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogFormatFromArgs returns the --log-format value from args, accepting both
+// "--log-format=json" and "--log-format json", falling back to
+// VSTATS_LOG_FORMAT and then text
+func LogFormatFromArgs(args []string) string {
+	for i, arg := range args {
+		if value, ok := strings.CutPrefix(arg, "--log-format="); ok {
+			return value
+		}
+		if arg == "--log-format" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	if format := os.Getenv("VSTATS_LOG_FORMAT"); format != "" {
+		return format
+	}
+	return LogFormatText
+}
+
+// SetupLogging installs the default slog logger for the given format. With
+// JSON, output from the standard log package is routed through the same
+// handler at info level, so existing log.Printf calls are structured too.
+// Startup banners written with fmt are left as-is in both formats.
+func SetupLogging(format string) {
+	if format != LogFormatJSON {
+		return
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+}
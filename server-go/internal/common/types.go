@@ -19,13 +19,43 @@ type SystemMetrics struct {
 	Ping        *PingMetrics   `json:"ping,omitempty"`
 	Version     string         `json:"version,omitempty"`
 	IPAddresses []string       `json:"ip_addresses,omitempty"`
+	// Pending OS package updates, only reported by agents with check_updates
+	// enabled (checked every few hours, not every sample)
+	UpdatesPending  int  `json:"updates_pending,omitempty"`
+	SecurityUpdates int  `json:"security_updates,omitempty"` // Subset of UpdatesPending, where the package manager can tell
+	RebootRequired  bool `json:"reboot_required,omitempty"`
+
+	// Values from the agent's external_collectors, keyed by metric name
+	Custom map[string]float64 `json:"custom,omitempty"`
+
+	// Listening TCP/UDP sockets, only reported by agents with
+	// report_listening_ports enabled (refreshed every few minutes)
+	ListeningPorts []PortInfo `json:"listening_ports,omitempty"`
+}
+
+// PortInfo is a listening socket. Process is empty when the agent lacks the
+// privileges to see which process owns it.
+type PortInfo struct {
+	Proto   string `json:"proto"` // "tcp", "tcp6", "udp" or "udp6"
+	Address string `json:"address"`
+	Port    uint32 `json:"port"`
+	Process string `json:"process,omitempty"`
 }
 
 type OsInfo struct {
-	Name    string `json:"name"`
-	Version string `json:"version"`
-	Kernel  string `json:"kernel"`
-	Arch    string `json:"arch"`
+	Name           string              `json:"name"`
+	Version        string              `json:"version"`
+	Kernel         string              `json:"kernel"`
+	Arch           string              `json:"arch"`
+	Virtualization *VirtualizationInfo `json:"virtualization,omitempty"`
+}
+
+// VirtualizationInfo describes the environment the agent runs in. It is
+// detected once at agent start.
+type VirtualizationInfo struct {
+	Type   string `json:"type"`             // "bare-metal", "vm" or "container"
+	System string `json:"system,omitempty"` // Hypervisor or container runtime, e.g. "kvm", "docker", "kubernetes"
+	Role   string `json:"role,omitempty"`   // "host" or "guest", as reported by the OS
 }
 
 type CpuMetrics struct {
@@ -34,6 +64,33 @@ type CpuMetrics struct {
 	Usage     float32   `json:"usage"`
 	Frequency uint64    `json:"frequency"`
 	PerCore   []float32 `json:"per_core"`
+	// Share of CPU time since the previous sample, in percent. All zero on
+	// the first sample and from agents that don't report a breakdown.
+	User   float32 `json:"user,omitempty"`
+	System float32 `json:"system,omitempty"`
+	Idle   float32 `json:"idle,omitempty"`
+	IOWait float32 `json:"iowait,omitempty"`
+	Steal  float32 `json:"steal,omitempty"`
+	// Lowest and highest usage the agent saw sampling every second over the
+	// reporting interval, which Usage then averages. Nil when no per-second
+	// sample was taken, and from agents that only take one reading per report.
+	UsageMin *float32 `json:"usage_min,omitempty"`
+	UsageMax *float32 `json:"usage_max,omitempty"`
+}
+
+// HasTimes reports whether the sample carries a CPU time breakdown, which
+// older agents don't send
+func (c *CpuMetrics) HasTimes() bool {
+	return c.User+c.System+c.Idle+c.IOWait+c.Steal > 0
+}
+
+// PeakUsage returns the highest usage over the sample's interval, for the
+// cpu_max aggregation columns
+func (c *CpuMetrics) PeakUsage() float32 {
+	if c.UsageMax == nil {
+		return c.Usage
+	}
+	return max(*c.UsageMax, c.Usage)
 }
 
 type MemoryMetrics struct {
@@ -65,6 +122,36 @@ type DiskMetrics struct {
 	Used         uint64   `json:"used"`
 	ReadSpeed    uint64   `json:"read_speed,omitempty"`  // Bytes per second
 	WriteSpeed   uint64   `json:"write_speed,omitempty"` // Bytes per second
+	// Inode usage of the disk's fullest mounted filesystem; zero where the
+	// filesystem has no fixed inode table (Windows, btrfs, ZFS)
+	InodesUsed    uint64  `json:"inodes_used,omitempty"`
+	InodesTotal   uint64  `json:"inodes_total,omitempty"`
+	InodesPercent float32 `json:"inodes_percent,omitempty"`
+	// SMART summary (Linux with smartmontools, refreshed hourly): Health is
+	// one of the DiskHealth* values, empty on other platforms
+	Health             string `json:"health,omitempty"`
+	ReallocatedSectors uint64 `json:"reallocated_sectors,omitempty"` // SATA: reallocated + pending sectors
+	MediaErrors        uint64 `json:"media_errors,omitempty"`        // NVMe: unrecovered data integrity errors
+}
+
+// Disk SMART health states, from best to worst
+const (
+	DiskHealthUnknown = "unknown" // smartctl missing, no permission, or no SMART support
+	DiskHealthOK      = "ok"
+	DiskHealthWarning = "warning" // Self-assessment passed, but sectors were remapped or media errors logged
+	DiskHealthFailing = "failing" // Self-assessment failed
+)
+
+// MaxInodesPercent returns the highest inode usage across all disks, the
+// value to alert on since any full filesystem stops new files being created
+func (m *SystemMetrics) MaxInodesPercent() float32 {
+	var worst float32
+	for _, d := range m.Disks {
+		if d.InodesPercent > worst {
+			worst = d.InodesPercent
+		}
+	}
+	return worst
 }
 
 type NetworkMetrics struct {
@@ -100,7 +187,7 @@ type PingMetrics struct {
 type PingTarget struct {
 	Name       string   `json:"name"`
 	Host       string   `json:"host"`
-	Type       string   `json:"type,omitempty"` // "icmp" or "tcp"
+	Type       string   `json:"type,omitempty"` // "icmp", "tcp" or "http"
 	Port       int      `json:"port,omitempty"` // Port for TCP connections
 	LatencyMs  *float64 `json:"latency_ms"`
 	PacketLoss float64  `json:"packet_loss"`
@@ -110,8 +197,15 @@ type PingTarget struct {
 type PingTargetConfig struct {
 	Name string `json:"name"`
 	Host string `json:"host"`
-	Type string `json:"type,omitempty"` // "icmp" or "tcp", default "icmp"
-	Port int    `json:"port,omitempty"` // Port for TCP connections, default 80
+	Type string `json:"type,omitempty"` // "icmp", "tcp" or "http", default "icmp"
+	Port int    `json:"port,omitempty"` // Port for TCP connections, default 80 (or taken from "host:port")
+
+	// HTTP probe options (Type "http")
+	URL             string `json:"url,omitempty"`              // Full URL to GET, defaults to http://<host>
+	ExpectedStatus  int    `json:"expected_status,omitempty"`  // Expected status code, default any 2xx/3xx
+	BodyContains    string `json:"body_contains,omitempty"`    // Optional substring the body must contain
+	TimeoutSecs     int    `json:"timeout_secs,omitempty"`     // Request timeout, default 5
+	FollowRedirects *bool  `json:"follow_redirects,omitempty"` // Follow redirects, default true
 }
 
 // ============================================================================
@@ -196,6 +290,11 @@ type BucketData struct {
 	PingSum     float64 `json:"ping_sum"`     // Sum of ping latency for averaging
 	PingCount   int     `json:"ping_count"`   // Number of ping samples
 	SampleCount int     `json:"sample_count"` // Number of samples in this bucket
+	// CPU iowait/steal sums over the samples with a CPU time breakdown,
+	// which CPUTimesCount counts. Absent from older agents.
+	IOWaitSum     float64 `json:"iowait_sum,omitempty"`
+	StealSum      float64 `json:"steal_sum,omitempty"`
+	CPUTimesCount int     `json:"cpu_times_count,omitempty"`
 }
 
 // PingBucketData represents ping metrics for a specific target in a bucket
@@ -4,11 +4,17 @@ package common
 // WebSocket Message Types
 // ============================================================================
 
+// MaxIntervalSecs is the longest reporting interval an agent may use. Longer
+// ones are refused by the agent and ignored by the server, which would
+// otherwise wait for days before showing the server offline.
+const MaxIntervalSecs = 3600
+
 type AuthMessage struct {
-	Type     string `json:"type"`
-	ServerID string `json:"server_id"`
-	Token    string `json:"token"`
-	Version  string `json:"version"`
+	Type         string `json:"type"`
+	ServerID     string `json:"server_id"`
+	Token        string `json:"token"`
+	Version      string `json:"version"`
+	IntervalSecs uint64 `json:"interval_secs,omitempty"` // 1..MaxIntervalSecs, 0 if unknown
 }
 
 type MetricsMessage struct {
@@ -16,6 +22,45 @@ type MetricsMessage struct {
 	Metrics SystemMetrics `json:"metrics"`
 }
 
+// HeartbeatMessage is sent by the agent on its own timer so the server can
+// tell a live agent with slow collection from a dead one
+type HeartbeatMessage struct {
+	Type string `json:"type"` // "ping"
+}
+
+// UpdateResultMessage reports the outcome of an "update" command
+type UpdateResultMessage struct {
+	Type      string `json:"type"` // "update_result"
+	RequestID string `json:"request_id,omitempty"`
+	Success   bool   `json:"success"`
+	Version   string `json:"version,omitempty"` // Version now installed
+	Error     string `json:"error,omitempty"`
+}
+
+// CommandAckMessage acknowledges a command that has no result of its own
+// ("collect_now", "rotate_token")
+type CommandAckMessage struct {
+	Type      string `json:"type"` // "command_ack"
+	RequestID string `json:"request_id"`
+	Command   string `json:"command"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+}
+
+// LogsResultMessage answers a "tail_logs" command with the last lines of a
+// systemd unit's journal
+type LogsResultMessage struct {
+	Type      string `json:"type"` // "logs_result"
+	RequestID string `json:"request_id"`
+	Unit      string `json:"unit"`
+	Output    string `json:"output,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"` // Output was cut to MaxLogsOutput bytes
+	Error     string `json:"error,omitempty"`
+}
+
+// MaxLogsOutput caps the journal text an agent returns for "tail_logs"
+const MaxLogsOutput = 256 << 10
+
 type ServerResponse struct {
 	Type        string             `json:"type"`
 	Status      string             `json:"status,omitempty"`
@@ -23,12 +68,18 @@ type ServerResponse struct {
 	Command     string             `json:"command,omitempty"`
 	DownloadURL string             `json:"download_url,omitempty"`
 	Force       bool               `json:"force,omitempty"`
+	SHA256      string             `json:"sha256,omitempty"` // Expected hex SHA-256 of the "update" download
+	Token       string             `json:"token,omitempty"`  // New agent token for "rotate_token" commands
 	PingTargets []PingTargetConfig `json:"ping_targets,omitempty"`
+	RequestID   string             `json:"request_id,omitempty"` // Echoed back in the command's reply
+	// "tail_logs" command fields
+	Unit  string `json:"unit,omitempty"`
+	Lines int    `json:"lines,omitempty"`
 	// Batch metrics response fields
-	BatchID   string  `json:"batch_id,omitempty"`
-	Accepted  int     `json:"accepted,omitempty"`
-	Rejected  int     `json:"rejected,omitempty"`
-	LastSeen  *string `json:"last_seen,omitempty"` // Last timestamp server has seen for this server
+	BatchID  string  `json:"batch_id,omitempty"`
+	Accepted int     `json:"accepted,omitempty"`
+	Rejected int     `json:"rejected,omitempty"`
+	LastSeen *string `json:"last_seen,omitempty"` // Last timestamp server has seen for this server
 	// Resumable sync fields - last bucket for each granularity
 	LastBuckets map[string]int64 `json:"last_buckets,omitempty"` // granularity -> last bucket
 }
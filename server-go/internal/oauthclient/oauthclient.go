@@ -0,0 +1,214 @@
+// Package oauthclient implements the provider side of the GitHub and Google
+// OAuth flows shared by the self-hosted server and the cloud service: building
+// the authorization URL, exchanging the code and fetching the user.
+package oauthclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// requestTimeout bounds each call to a provider
+const requestTimeout = 10 * time.Second
+
+// ============================================================================
+// GitHub OAuth
+// ============================================================================
+
+type GitHubTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Scope       string `json:"scope"`
+}
+
+type GitHubUser struct {
+	ID        int64  `json:"id"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+// GitHubAuthURL returns the GitHub authorization URL. codeChallenge is the
+// PKCE S256 challenge; PKCE is left out when it is empty.
+func GitHubAuthURL(clientID, redirectURI, state, codeChallenge string) string {
+	params := url.Values{}
+	params.Set("client_id", clientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("scope", "read:user user:email")
+	params.Set("state", state)
+	setPKCEChallenge(params, codeChallenge)
+	return "https://github.com/login/oauth/authorize?" + params.Encode()
+}
+
+// ExchangeGitHubCode exchanges an authorization code for an access token.
+// codeVerifier is only sent when the flow used PKCE.
+func ExchangeGitHubCode(ctx context.Context, clientID, clientSecret, code, redirectURI, codeVerifier string) (*GitHubTokenResponse, error) {
+	data := url.Values{}
+	data.Set("client_id", clientID)
+	data.Set("client_secret", clientSecret)
+	data.Set("code", code)
+	data.Set("redirect_uri", redirectURI)
+	if codeVerifier != "" {
+		data.Set("code_verifier", codeVerifier)
+	}
+
+	var tokenResp GitHubTokenResponse
+	if err := postForm(ctx, "https://github.com/login/oauth/access_token", data, &tokenResp); err != nil {
+		return nil, err
+	}
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("no access token in response")
+	}
+	return &tokenResp, nil
+}
+
+// GetGitHubUser retrieves the GitHub user. A private email is filled in from
+// the primary verified address when the token may read it.
+func GetGitHubUser(ctx context.Context, accessToken string) (*GitHubUser, error) {
+	var user GitHubUser
+	if err := getJSON(ctx, "https://api.github.com/user", accessToken, &user); err != nil {
+		return nil, err
+	}
+
+	if user.Email == "" {
+		var emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+		if err := getJSON(ctx, "https://api.github.com/user/emails", accessToken, &emails); err == nil {
+			for _, e := range emails {
+				if e.Primary && e.Verified {
+					user.Email = e.Email
+					break
+				}
+			}
+		}
+	}
+
+	return &user, nil
+}
+
+// ============================================================================
+// Google OAuth
+// ============================================================================
+
+type GoogleTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+type GoogleUser struct {
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	VerifiedEmail bool   `json:"verified_email"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Picture       string `json:"picture"`
+}
+
+// GoogleAuthURL returns the Google authorization URL. codeChallenge is the
+// PKCE S256 challenge; PKCE is left out when it is empty.
+func GoogleAuthURL(clientID, redirectURI, state, codeChallenge string) string {
+	params := url.Values{}
+	params.Set("client_id", clientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("response_type", "code")
+	params.Set("scope", "openid email profile")
+	params.Set("state", state)
+	params.Set("access_type", "offline")
+	setPKCEChallenge(params, codeChallenge)
+	return "https://accounts.google.com/o/oauth2/v2/auth?" + params.Encode()
+}
+
+// ExchangeGoogleCode exchanges an authorization code for an access token.
+// codeVerifier is only sent when the flow used PKCE.
+func ExchangeGoogleCode(ctx context.Context, clientID, clientSecret, code, redirectURI, codeVerifier string) (*GoogleTokenResponse, error) {
+	data := url.Values{}
+	data.Set("client_id", clientID)
+	data.Set("client_secret", clientSecret)
+	data.Set("code", code)
+	data.Set("redirect_uri", redirectURI)
+	data.Set("grant_type", "authorization_code")
+	if codeVerifier != "" {
+		data.Set("code_verifier", codeVerifier)
+	}
+
+	var tokenResp GoogleTokenResponse
+	if err := postForm(ctx, "https://oauth2.googleapis.com/token", data, &tokenResp); err != nil {
+		return nil, err
+	}
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("no access token in response")
+	}
+	return &tokenResp, nil
+}
+
+// GetGoogleUser retrieves the Google user
+func GetGoogleUser(ctx context.Context, accessToken string) (*GoogleUser, error) {
+	var user GoogleUser
+	if err := getJSON(ctx, "https://www.googleapis.com/oauth2/v2/userinfo", accessToken, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ============================================================================
+// Helpers
+// ============================================================================
+
+// PKCEChallenge derives the S256 code_challenge for a PKCE verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func setPKCEChallenge(params url.Values, codeChallenge string) {
+	if codeChallenge != "" {
+		params.Set("code_challenge", codeChallenge)
+		params.Set("code_challenge_method", "S256")
+	}
+}
+
+func postForm(ctx context.Context, endpoint string, data url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	return doJSON(req, out)
+}
+
+func getJSON(ctx context.Context, endpoint, accessToken string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	return doJSON(req, out)
+}
+
+func doJSON(req *http.Request, out interface{}) error {
+	client := &http.Client{Timeout: requestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,33 @@
+package oauthclient
+
+import (
+	"net/url"
+	"testing"
+)
+
+// The verifier/challenge pair from RFC 7636 appendix B
+func TestPKCEChallenge(t *testing.T) {
+	got := PKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+// PKCE parameters are only added when the flow uses a challenge
+func TestAuthURLPKCE(t *testing.T) {
+	for _, build := range []func(clientID, redirectURI, state, codeChallenge string) string{GitHubAuthURL, GoogleAuthURL} {
+		u, err := url.Parse(build("id", "https://example.com/cb?x=1", "st", "ch"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		if q.Get("redirect_uri") != "https://example.com/cb?x=1" || q.Get("code_challenge") != "ch" || q.Get("code_challenge_method") != "S256" {
+			t.Fatalf("bad query %v", q)
+		}
+
+		u, _ = url.Parse(build("id", "https://example.com/cb", "st", ""))
+		if u.Query().Has("code_challenge") {
+			t.Fatalf("PKCE added without a challenge: %s", u)
+		}
+	}
+}
@@ -8,20 +8,22 @@ interface OAuthProviders {
 interface AuthContextType {
   isAuthenticated: boolean;
   token: string | null;
-  login: (password: string) => Promise<boolean>;
+  login: (password: string, username?: string) => Promise<boolean>;
   logout: () => void;
   isLoading: boolean;
   oauthProviders: OAuthProviders;
   startOAuthLogin: (provider: 'github' | 'google') => Promise<void>;
-  handleOAuthCallback: (token: string, expiresAt: number, provider: string, user: string) => void;
+  handleOAuthCallback: (token: string, expiresAt: number, provider: string, user: string, refreshToken?: string | null) => void;
   oauthUser: string | null;
   oauthProvider: string | null;
+  storeRefreshToken: (refreshToken: string) => void;
 }
 
 const AuthContext = createContext<AuthContextType | null>(null);
 
 export function AuthProvider({ children }: { children: ReactNode }) {
   const [token, setToken] = useState<string | null>(() => localStorage.getItem('vstats_token'));
+  const [refreshToken, setRefreshToken] = useState<string | null>(() => localStorage.getItem('vstats_refresh_token'));
   const [isLoading, setIsLoading] = useState(true);
   const [oauthProviders, setOauthProviders] = useState<OAuthProviders>({});
   const [oauthUser, setOauthUser] = useState<string | null>(() => localStorage.getItem('vstats_oauth_user'));
@@ -43,6 +45,53 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     fetchProviders();
   }, []);
 
+  const storeRefreshToken = (newRefreshToken: string) => {
+    setRefreshToken(newRefreshToken);
+    localStorage.setItem('vstats_refresh_token', newRefreshToken);
+  };
+
+  // Exchange the refresh token for a new short-lived access token
+  const refreshAccessToken = async (): Promise<boolean> => {
+    const stored = localStorage.getItem('vstats_refresh_token');
+    if (!stored) return false;
+    try {
+      const res = await fetch('/api/auth/refresh', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: stored })
+      });
+      if (res.ok) {
+        const data = await res.json();
+        setToken(data.token);
+        localStorage.setItem('vstats_token', data.token);
+        // Refresh tokens are single-use; keep the replacement
+        if (data.refresh_token) {
+          storeRefreshToken(data.refresh_token);
+        }
+        return true;
+      }
+      if (res.status === 401 && localStorage.getItem('vstats_refresh_token') !== stored) {
+        // Another tab exchanged the same token meanwhile; use its replacement
+        return refreshAccessToken();
+      }
+      if (res.status === 401 || res.status === 403) {
+        // Expired or revoked, or the account was removed from the allowlist
+        setRefreshToken(null);
+        localStorage.removeItem('vstats_refresh_token');
+      }
+    } catch {
+      // Server unreachable, keep tokens and retry later
+    }
+    return false;
+  };
+
+  // Renew the access token well before it expires (access tokens last 15 minutes)
+  useEffect(() => {
+    if (!refreshToken) return;
+    const interval = setInterval(refreshAccessToken, 10 * 60 * 1000);
+    return () => clearInterval(interval);
+  }, [refreshToken]);
+
   useEffect(() => {
     // Verify token on mount
     const verifyToken = async () => {
@@ -51,6 +100,11 @@ export function AuthProvider({ children }: { children: ReactNode }) {
           const res = await fetch('/api/auth/verify', {
             headers: { Authorization: `Bearer ${token}` }
           });
+          if (!res.ok && await refreshAccessToken()) {
+            // Access token expired but the refresh token was still valid
+            setIsLoading(false);
+            return;
+          }
           if (!res.ok) {
             setToken(null);
             setOauthUser(null);
@@ -68,12 +122,12 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     verifyToken();
   }, [token]);
 
-  const login = async (password: string): Promise<boolean> => {
+  const login = async (password: string, username?: string): Promise<boolean> => {
     try {
       const res = await fetch('/api/auth/login', {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ password })
+        body: JSON.stringify({ password, username: username || undefined })
       });
       
       if (res.ok) {
@@ -82,6 +136,10 @@ export function AuthProvider({ children }: { children: ReactNode }) {
         setOauthUser(null);
         setOauthProvider(null);
         localStorage.setItem('vstats_token', data.token);
+        if (data.refresh_token) {
+          setRefreshToken(data.refresh_token);
+          localStorage.setItem('vstats_refresh_token', data.refresh_token);
+        }
         localStorage.removeItem('vstats_oauth_user');
         localStorage.removeItem('vstats_oauth_provider');
         return true;
@@ -109,8 +167,12 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     }
   };
 
-  const handleOAuthCallback = (newToken: string, expiresAt: number, provider: string, user: string) => {
+  const handleOAuthCallback = (newToken: string, expiresAt: number, provider: string, user: string, newRefreshToken?: string | null) => {
     setToken(newToken);
+    if (newRefreshToken) {
+      setRefreshToken(newRefreshToken);
+      localStorage.setItem('vstats_refresh_token', newRefreshToken);
+    }
     setOauthUser(user);
     setOauthProvider(provider);
     localStorage.setItem('vstats_token', newToken);
@@ -120,7 +182,17 @@ export function AuthProvider({ children }: { children: ReactNode }) {
   };
 
   const logout = () => {
+    if (token) {
+      // Revoke the token server-side; ignore failures, the local session is cleared regardless
+      fetch('/api/auth/logout', {
+        method: 'POST',
+        headers: { 'Authorization': `Bearer ${token}`, 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: localStorage.getItem('vstats_refresh_token') || '' })
+      }).catch(() => {});
+    }
     setToken(null);
+    setRefreshToken(null);
+    localStorage.removeItem('vstats_refresh_token');
     setOauthUser(null);
     setOauthProvider(null);
     localStorage.removeItem('vstats_token');
@@ -140,7 +212,8 @@ export function AuthProvider({ children }: { children: ReactNode }) {
       startOAuthLogin,
       handleOAuthCallback,
       oauthUser,
-      oauthProvider
+      oauthProvider,
+      storeRefreshToken
     }}>
       {children}
     </AuthContext.Provider>
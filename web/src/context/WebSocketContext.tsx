@@ -32,6 +32,7 @@ export interface ServerState {
   metrics: SystemMetrics | null;
   speed: NetworkSpeed;
   isConnected: boolean;
+  maintenance: boolean; // Inside a maintenance window; shown instead of offline
   error: string | null;
 }
 
@@ -74,6 +75,7 @@ interface CompactServerUpdate {
   id: string;
   on?: boolean;
   m?: CompactMetrics;
+  mt?: boolean;
 }
 
 interface CompactMetrics {
@@ -100,6 +102,7 @@ interface ServerMetricsUpdate {
   price_period?: string;
   purchase_date?: string;
   tip_badge?: string;
+  maintenance_until?: string;
 }
 
 // Context interface
@@ -179,6 +182,10 @@ export function WebSocketProvider({ children }: { children: ReactNode }) {
     if (delta.on !== undefined) {
       updated.isConnected = delta.on;
     }
+
+    if (delta.mt !== undefined) {
+      updated.maintenance = delta.mt;
+    }
     
     if (delta.m && updated.metrics) {
       const m = delta.m;
@@ -318,6 +325,7 @@ export function WebSocketProvider({ children }: { children: ReactNode }) {
                   metrics: metricsToUse,
                   speed: newSpeed,
                   isConnected: serverUpdate.online,
+                  maintenance: !!serverUpdate.maintenance_until,
                   error: null
                 };
                 
@@ -452,6 +460,7 @@ export function WebSocketProvider({ children }: { children: ReactNode }) {
                 metrics: metricsToUse,
                 speed: newSpeed,
                 isConnected: serverUpdate.online,
+                maintenance: !!serverUpdate.maintenance_until,
                 error: null
               };
               
@@ -46,6 +46,7 @@ export default {
     groupByTag: 'Nach Tag gruppieren',
     online: 'Online',
     offline: 'Offline',
+    maintenance: 'Wartung',
     download: 'Download',
     upload: 'Upload',
     uptime: 'Betriebszeit',
@@ -133,6 +134,8 @@ export default {
     subtitle: 'Server-Überwachungspanel',
     password: 'Admin-Passwort',
     passwordPlaceholder: 'Passwort eingeben',
+    username: 'Benutzername',
+    usernamePlaceholder: 'Optional – leer lassen für den Admin',
     loginButton: 'Anmelden',
     loggingIn: 'Anmeldung...',
     loginWithGithub: 'Mit GitHub anmelden',
@@ -153,6 +156,7 @@ export default {
     authenticationFailed: 'Authentifizierung fehlgeschlagen',
     backToLogin: 'Zurück zur Anmeldung',
     invalidParams: 'Ungültige OAuth-Callback-Parameter',
+    userNotAllowed: 'Das Konto {{user}} darf sich nicht anmelden. Bitten Sie einen Administrator, es zu den erlaubten Benutzern hinzuzufügen.',
   },
 
   // Settings
@@ -259,7 +263,7 @@ export default {
     security: 'Sicherheit',
     currentPasswordIncorrect: 'Aktuelles Passwort ist falsch',
     changePasswordFailed: 'Passwortänderung fehlgeschlagen',
-    passwordTooShort: 'Passwort muss mindestens 4 Zeichen lang sein',
+    passwordTooShort: 'Passwort muss mindestens 8 Zeichen lang sein',
   },
 
   toast: {
@@ -46,6 +46,7 @@ export default {
     groupByTag: 'Group by Tag',
     online: 'Online',
     offline: 'Offline',
+    maintenance: 'Maintenance',
     download: 'Download',
     upload: 'Upload',
     uptime: 'Uptime',
@@ -143,6 +144,8 @@ export default {
     subtitle: 'Server Monitoring Panel',
     password: 'Admin Password',
     passwordPlaceholder: 'Enter password',
+    username: 'Username',
+    usernamePlaceholder: 'Optional – leave empty for the admin',
     loginButton: 'Login',
     loggingIn: 'Logging in...',
     loginWithGithub: 'Login with GitHub',
@@ -163,6 +166,7 @@ export default {
     authenticationFailed: 'Authentication Failed',
     backToLogin: 'Back to Login',
     invalidParams: 'Invalid OAuth callback parameters',
+    userNotAllowed: 'The account {{user}} is not allowed to sign in. Ask an administrator to add it to the allowed users.',
   },
 
   // Settings
@@ -283,7 +287,7 @@ export default {
     security: 'Security',
     currentPasswordIncorrect: 'Current password is incorrect',
     changePasswordFailed: 'Failed to change password',
-    passwordTooShort: 'Password must be at least 4 characters',
+    passwordTooShort: 'Password must be at least 8 characters',
   },
 
   // Toast messages
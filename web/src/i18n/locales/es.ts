@@ -46,6 +46,7 @@ export default {
     groupByTag: 'Agrupar por etiqueta',
     online: 'En línea',
     offline: 'Fuera de línea',
+    maintenance: 'Mantenimiento',
     download: 'Descarga',
     upload: 'Subida',
     uptime: 'Tiempo activo',
@@ -133,6 +134,8 @@ export default {
     subtitle: 'Panel de monitoreo de servidores',
     password: 'Contraseña de administrador',
     passwordPlaceholder: 'Ingrese contraseña',
+    username: 'Usuario',
+    usernamePlaceholder: 'Opcional: déjelo vacío para el administrador',
     loginButton: 'Iniciar sesión',
     loggingIn: 'Iniciando sesión...',
     loginWithGithub: 'Iniciar sesión con GitHub',
@@ -153,6 +156,7 @@ export default {
     authenticationFailed: 'Error de autenticación',
     backToLogin: 'Volver al inicio de sesión',
     invalidParams: 'Parámetros de callback OAuth inválidos',
+    userNotAllowed: 'La cuenta {{user}} no tiene permiso para iniciar sesión. Pide a un administrador que la añada a los usuarios permitidos.',
   },
 
   // Settings
@@ -259,7 +263,7 @@ export default {
     security: 'Seguridad',
     currentPasswordIncorrect: 'La contraseña actual es incorrecta',
     changePasswordFailed: 'No se pudo cambiar la contraseña',
-    passwordTooShort: 'La contraseña debe tener al menos 4 caracteres',
+    passwordTooShort: 'La contraseña debe tener al menos 8 caracteres',
   },
 
   toast: {
@@ -46,6 +46,7 @@ export default {
     groupByTag: 'Grouper par étiquette',
     online: 'En ligne',
     offline: 'Hors ligne',
+    maintenance: 'Maintenance',
     download: 'Téléchargement',
     upload: 'Envoi',
     uptime: 'Temps de fonctionnement',
@@ -133,6 +134,8 @@ export default {
     subtitle: 'Panneau de surveillance serveur',
     password: 'Mot de passe administrateur',
     passwordPlaceholder: 'Entrez le mot de passe',
+    username: 'Nom d\'utilisateur',
+    usernamePlaceholder: 'Facultatif – laisser vide pour l\'administrateur',
     loginButton: 'Connexion',
     loggingIn: 'Connexion...',
     loginWithGithub: 'Se connecter avec GitHub',
@@ -153,6 +156,7 @@ export default {
     authenticationFailed: 'Échec de l\'authentification',
     backToLogin: 'Retour à la connexion',
     invalidParams: 'Paramètres de rappel OAuth invalides',
+    userNotAllowed: 'Le compte {{user}} n\'est pas autorisé à se connecter. Demandez à un administrateur de l\'ajouter aux utilisateurs autorisés.',
   },
 
   // Settings
@@ -259,7 +263,7 @@ export default {
     security: 'Sécurité',
     currentPasswordIncorrect: 'Le mot de passe actuel est incorrect',
     changePasswordFailed: 'Échec du changement de mot de passe',
-    passwordTooShort: 'Le mot de passe doit contenir au moins 4 caractères',
+    passwordTooShort: 'Le mot de passe doit contenir au moins 8 caractères',
   },
 
   toast: {
@@ -46,6 +46,7 @@ export default {
     groupByTag: 'タグでグループ化',
     online: 'オンライン',
     offline: 'オフライン',
+    maintenance: 'メンテナンス中',
     download: 'ダウンロード',
     upload: 'アップロード',
     uptime: '稼働時間',
@@ -133,6 +134,8 @@ export default {
     subtitle: 'サーバー監視パネル',
     password: '管理者パスワード',
     passwordPlaceholder: 'パスワードを入力',
+    username: 'ユーザー名',
+    usernamePlaceholder: '任意 – 管理者の場合は空欄',
     loginButton: 'ログイン',
     loggingIn: 'ログイン中...',
     loginWithGithub: 'GitHubでログイン',
@@ -153,6 +156,7 @@ export default {
     authenticationFailed: '認証に失敗しました',
     backToLogin: 'ログインに戻る',
     invalidParams: '無効なOAuthコールバックパラメータ',
+    userNotAllowed: 'アカウント {{user}} はログインを許可されていません。管理者に許可ユーザーへの追加を依頼してください。',
   },
 
   // Settings
@@ -259,7 +263,7 @@ export default {
     security: 'セキュリティ',
     currentPasswordIncorrect: '現在のパスワードが正しくありません',
     changePasswordFailed: 'パスワードの変更に失敗しました',
-    passwordTooShort: 'パスワードは4文字以上で入力してください',
+    passwordTooShort: 'パスワードは8文字以上で入力してください',
   },
 
   toast: {
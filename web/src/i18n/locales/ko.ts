@@ -46,6 +46,7 @@ export default {
     groupByTag: '태그별 그룹화',
     online: '온라인',
     offline: '오프라인',
+    maintenance: '점검 중',
     download: '다운로드',
     upload: '업로드',
     uptime: '가동시간',
@@ -133,6 +134,8 @@ export default {
     subtitle: '서버 모니터링 패널',
     password: '관리자 비밀번호',
     passwordPlaceholder: '비밀번호 입력',
+    username: '사용자 이름',
+    usernamePlaceholder: '선택 사항 – 관리자는 비워 두세요',
     loginButton: '로그인',
     loggingIn: '로그인 중...',
     loginWithGithub: 'GitHub로 로그인',
@@ -153,6 +156,7 @@ export default {
     authenticationFailed: '인증 실패',
     backToLogin: '로그인으로 돌아가기',
     invalidParams: '잘못된 OAuth 콜백 매개변수',
+    userNotAllowed: '{{user}} 계정은 로그인이 허용되지 않습니다. 관리자에게 허용된 사용자에 추가해 달라고 요청하세요.',
   },
 
   // Settings
@@ -259,7 +263,7 @@ export default {
     security: '보안',
     currentPasswordIncorrect: '현재 비밀번호가 올바르지 않습니다',
     changePasswordFailed: '비밀번호 변경에 실패했습니다',
-    passwordTooShort: '비밀번호는 최소 4자 이상이어야 합니다',
+    passwordTooShort: '비밀번호는 최소 8자 이상이어야 합니다',
   },
 
   toast: {
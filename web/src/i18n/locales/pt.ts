@@ -46,6 +46,7 @@ export default {
     groupByTag: 'Agrupar por tag',
     online: 'Online',
     offline: 'Offline',
+    maintenance: 'Manutenção',
     download: 'Download',
     upload: 'Upload',
     uptime: 'Tempo ativo',
@@ -133,6 +134,8 @@ export default {
     subtitle: 'Painel de monitoramento de servidores',
     password: 'Senha do administrador',
     passwordPlaceholder: 'Digite a senha',
+    username: 'Usuário',
+    usernamePlaceholder: 'Opcional – deixe vazio para o administrador',
     loginButton: 'Entrar',
     loggingIn: 'Entrando...',
     loginWithGithub: 'Entrar com GitHub',
@@ -153,6 +156,7 @@ export default {
     authenticationFailed: 'Falha na autenticação',
     backToLogin: 'Voltar ao login',
     invalidParams: 'Parâmetros de callback OAuth inválidos',
+    userNotAllowed: 'A conta {{user}} não tem permissão para entrar. Peça a um administrador para adicioná-la aos usuários permitidos.',
   },
 
   // Settings
@@ -259,7 +263,7 @@ export default {
     security: 'Segurança',
     currentPasswordIncorrect: 'A senha atual está incorreta',
     changePasswordFailed: 'Falha ao alterar a senha',
-    passwordTooShort: 'A senha deve ter pelo menos 4 caracteres',
+    passwordTooShort: 'A senha deve ter pelo menos 8 caracteres',
   },
 
   toast: {
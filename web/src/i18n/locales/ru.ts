@@ -46,6 +46,7 @@ export default {
     groupByTag: 'Группировать по тегу',
     online: 'Онлайн',
     offline: 'Офлайн',
+    maintenance: 'Обслуживание',
     download: 'Загрузка',
     upload: 'Выгрузка',
     uptime: 'Время работы',
@@ -133,6 +134,8 @@ export default {
     subtitle: 'Панель мониторинга серверов',
     password: 'Пароль администратора',
     passwordPlaceholder: 'Введите пароль',
+    username: 'Имя пользователя',
+    usernamePlaceholder: 'Необязательно – оставьте пустым для администратора',
     loginButton: 'Войти',
     loggingIn: 'Вход...',
     loginWithGithub: 'Войти через GitHub',
@@ -153,6 +156,7 @@ export default {
     authenticationFailed: 'Ошибка аутентификации',
     backToLogin: 'Назад к входу',
     invalidParams: 'Неверные параметры OAuth обратного вызова',
+    userNotAllowed: 'Учётной записи {{user}} вход не разрешён. Попросите администратора добавить её в список разрешённых пользователей.',
   },
 
   // Settings
@@ -259,7 +263,7 @@ export default {
     security: 'Безопасность',
     currentPasswordIncorrect: 'Текущий пароль неверен',
     changePasswordFailed: 'Не удалось изменить пароль',
-    passwordTooShort: 'Пароль должен быть не короче 4 символов',
+    passwordTooShort: 'Пароль должен быть не короче 8 символов',
   },
 
   toast: {
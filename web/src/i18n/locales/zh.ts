@@ -46,6 +46,7 @@ export default {
     groupByTag: '按标签分组',
     online: '在线',
     offline: '离线',
+    maintenance: '维护中',
     download: '下载',
     upload: '上传',
     uptime: '运行时间',
@@ -143,6 +144,8 @@ export default {
     subtitle: '服务器监控管理面板',
     password: '管理员密码',
     passwordPlaceholder: '输入密码',
+    username: '用户名',
+    usernamePlaceholder: '可选，内置管理员留空',
     loginButton: '登录',
     loggingIn: '登录中...',
     loginWithGithub: '使用 GitHub 登录',
@@ -163,6 +166,7 @@ export default {
     authenticationFailed: '验证失败',
     backToLogin: '返回登录',
     invalidParams: '无效的 OAuth 回调参数',
+    userNotAllowed: '账号 {{user}} 不允许登录，请联系管理员将其加入允许的用户列表。',
   },
 
   // Settings
@@ -283,7 +287,7 @@ export default {
     security: '安全',
     currentPasswordIncorrect: '当前密码不正确',
     changePasswordFailed: '修改密码失败',
-    passwordTooShort: '密码至少需要 4 个字符',
+    passwordTooShort: '密码至少需要 8 个字符',
   },
 
   // Toast messages
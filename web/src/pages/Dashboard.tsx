@@ -204,6 +204,12 @@ const formatDiskSize = (bytes: number) => {
   return `${(bytes / kb).toFixed(0)}K`;
 };
 
+// Status shown by the chip/dot; a server in maintenance isn't shown as offline
+const getServerStatus = (server: ServerState): 'running' | 'stopped' | 'maintenance' => {
+  if (server.isConnected) return 'running';
+  return server.maintenance ? 'maintenance' : 'stopped';
+};
+
 const getResourceState = (value: number, thresholds: [number, number]): 'ok' | 'warn' | 'bad' => {
   if (value > thresholds[1]) return 'bad';
   if (value > thresholds[0]) return 'warn';
@@ -354,8 +360,11 @@ function VpsGridCard({ server, onClick, isDark }: { server: ServerState; onClick
             </div>
           </div>
         </div>
-        <span className={`vps-chip ${isConnected ? `vps-chip--running-${themeClass}` : `vps-chip--stopped-${themeClass}`}`}>
-          <span className={`vps-chip-dot ${isConnected ? 'vps-chip-dot--running' : 'vps-chip-dot--stopped'}`} />
+        <span
+          className={`vps-chip vps-chip--${getServerStatus(server)}-${themeClass}`}
+          title={server.maintenance ? t('dashboard.maintenance') : undefined}
+        >
+          <span className={`vps-chip-dot vps-chip-dot--${getServerStatus(server)}`} />
         </span>
       </div>
 
@@ -554,7 +563,10 @@ function VpsListCard({ server, onClick, isDark }: { server: ServerState; onClick
         <div className="vps-list-info">
           <div className={`vps-list-title vps-list-title--${themeClass}`}>
             {config.name}
-            <span className={`vps-chip-dot ${isConnected ? 'vps-chip-dot--running' : 'vps-chip-dot--stopped'}`} />
+            <span
+              className={`vps-chip-dot vps-chip-dot--${getServerStatus(server)}`}
+              title={server.maintenance ? t('dashboard.maintenance') : undefined}
+            />
           </div>
           <div className="vps-list-meta">
             {flag && (
@@ -768,7 +780,10 @@ function VpsCompactCard({ server, onClick, themeId }: {
     <div className={`vps-compact-row vps-compact-row--${themeId}`} onClick={onClick}>
       {/* NODE */}
       <div className="vps-compact-col vps-compact-col--node">
-        <span className={`vps-compact-status ${isConnected ? 'is-online' : 'is-offline'}`} />
+        <span
+          className={`vps-compact-status ${isConnected ? 'is-online' : server.maintenance ? 'is-maintenance' : 'is-offline'}`}
+          title={server.maintenance ? t('dashboard.maintenance') : undefined}
+        />
         {/* Country Flag as main icon */}
         <div className="w-9 h-9 rounded-xl flex items-center justify-center flex-shrink-0 bg-white/5 border border-white/10">
           {flag ? (
@@ -988,6 +1003,7 @@ export default function Dashboard() {
   };
 
   const onlineCount = servers.filter(s => s.isConnected).length;
+  const offlineCount = servers.filter(s => !s.isConnected && !s.maintenance).length;
   const totalBandwidthRx = servers.reduce((acc, s) => acc + s.speed.rx_sec, 0);
   const totalBandwidthTx = servers.reduce((acc, s) => acc + s.speed.tx_sec, 0);
 
@@ -1167,7 +1183,7 @@ export default function Dashboard() {
           </div>
           <div className={`vps-overview-card vps-overview-card--offline-${themeClass}`}>
             <div className="vps-overview-label vps-overview-label--offline">{t('dashboard.offline')}</div>
-            <div className={`vps-overview-value vps-overview-value--${themeClass}`}>{servers.length - onlineCount}</div>
+            <div className={`vps-overview-value vps-overview-value--${themeClass}`}>{offlineCount}</div>
           </div>
           <div className={`vps-overview-card vps-overview-card--download-${themeClass}`}>
             <div className="vps-overview-label vps-overview-label--download">↓ {t('dashboard.download')}</div>
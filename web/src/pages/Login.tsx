@@ -22,6 +22,7 @@ const GoogleIcon = () => (
 
 export default function Login() {
   const { t } = useTranslation();
+  const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
   const [error, setError] = useState('');
   const [loading, setLoading] = useState(false);
@@ -37,6 +38,7 @@ export default function Login() {
     
     const formData = new FormData(e.target as HTMLFormElement);
     const inputPassword = (formData.get('password') as string) || password;
+    const inputUsername = ((formData.get('username') as string) || username).trim();
     
     if (!inputPassword) {
       setError(t('login.pleaseEnterPassword'));
@@ -45,7 +47,7 @@ export default function Login() {
     
     setLoading(true);
 
-    const success = await login(inputPassword);
+    const success = await login(inputPassword, inputUsername);
     
     if (success) {
       // Use replace to avoid going back to login page
@@ -159,6 +161,20 @@ export default function Login() {
             )}
 
             <form onSubmit={handleSubmit} className="space-y-6">
+              <div className="space-y-2">
+                <label className="block text-sm font-semibold text-slate-700">
+                  {t('login.username')}
+                </label>
+                <input
+                  type="text"
+                  name="username"
+                  autoComplete="username"
+                  value={username}
+                  onChange={(e) => setUsername(e.target.value)}
+                  className="w-full px-4 py-3.5 rounded-xl bg-slate-50 border border-slate-200 text-slate-900 placeholder-slate-400 focus:outline-none focus:border-emerald-500 focus:ring-4 focus:ring-emerald-500/15 transition-all"
+                  placeholder={t('login.usernamePlaceholder')}
+                />
+              </div>
               <div className="space-y-2">
                 <label className="block text-sm font-semibold text-slate-700">
                   {t('login.password')}
@@ -12,11 +12,17 @@ export default function OAuthCallback() {
 
   useEffect(() => {
     const token = searchParams.get('token');
+    const refreshToken = searchParams.get('refresh_token');
     const expires = searchParams.get('expires');
     const provider = searchParams.get('provider');
     const user = searchParams.get('user');
     const errorMsg = searchParams.get('error');
+    const errorCode = searchParams.get('error_code');
 
+    if (errorCode === 'user_not_allowed') {
+      setError(t('oauth.userNotAllowed', { user: user ?? '' }));
+      return;
+    }
     if (errorMsg) {
       setError(decodeURIComponent(errorMsg));
       return;
@@ -24,7 +30,7 @@ export default function OAuthCallback() {
 
     if (token && expires && provider && user) {
       // Store the token and redirect to settings
-      handleOAuthCallback(token, parseInt(expires), provider, decodeURIComponent(user));
+      handleOAuthCallback(token, parseInt(expires), provider, decodeURIComponent(user), refreshToken);
       navigate('/settings', { replace: true });
     } else {
       setError(t('oauth.invalidParams'));
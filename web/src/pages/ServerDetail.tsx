@@ -5,6 +5,7 @@ import { useServerManager, formatBytes, formatSpeed, formatUptime } from '../hoo
 import { getOsIcon, getProviderIcon } from '../components/Icons';
 import { getProviderLogo, getDistributionLogo, LogoImage } from '../utils/logoUtils';
 import { useTheme } from '../context/ThemeContext';
+import { useAuth } from '../context/AuthContext';
 import type { HistoryPoint, HistoryResponse, PingHistoryTarget } from '../types';
 import {
   LineChart,
@@ -1309,10 +1310,22 @@ export default function ServerDetail() {
   const navigate = useNavigate();
   const { servers, loadingState, isInitialLoad } = useServerManager();
   const { isDark } = useTheme();
+  const { token } = useAuth();
   const [showContent, setShowContent] = useState(false);
 
   const server = servers.find(s => s.config.id === id);
 
+  // Ask the agent for a fresh sample instead of waiting for its next interval;
+  // signed-in users only, visitors wait for the regular report
+  useEffect(() => {
+    if (id && token) {
+      fetch(`/api/servers/${id}/refresh`, {
+        method: 'POST',
+        headers: { 'Authorization': `Bearer ${token}` }
+      }).catch(() => {});
+    }
+  }, [id, token]);
+
   // Delay showing content for smooth transition
   useEffect(() => {
     if (server?.metrics) {
@@ -60,6 +60,7 @@ interface RemoteServer {
   version?: string;
   token?: string;
   ip?: string;
+  public_ip?: string; // Address the agent connects from, differs from ip behind NAT
   // Extended metadata
   price_amount?: string;
   price_period?: string;
@@ -71,12 +72,18 @@ interface RemoteServer {
 interface PingTargetConfig {
   name: string;
   host: string;
-  type?: string; // "icmp" or "tcp", default "icmp"
+  type?: string; // "icmp", "tcp" or "http", default "icmp"
   port?: number; // Port for TCP connections, default 80
+  url?: string; // URL for HTTP checks, defaults to http://<host>
+  expected_status?: number; // Expected HTTP status, default any 2xx/3xx
+  body_contains?: string; // Optional substring the response body must contain
+  timeout_secs?: number; // HTTP timeout, default 5
+  follow_redirects?: boolean; // Follow HTTP redirects, default true
 }
 
 interface ProbeSettings {
   ping_targets: PingTargetConfig[];
+  offline_threshold_secs?: number; // 0/unset = automatic (30s or 3x agent interval)
 }
 
 const PLATFORM_OPTIONS = [
@@ -572,7 +579,7 @@ function ThemeSettingsSection({ isAuthenticated, token, siteSettings, onSiteSett
 
 export default function Settings() {
   const { t, i18n } = useTranslation();
-  const { isAuthenticated, token, logout, isLoading: authLoading } = useAuth();
+  const { isAuthenticated, token, logout, isLoading: authLoading, storeRefreshToken } = useAuth();
   const navigate = useNavigate();
   const isZh = i18n.language.startsWith('zh');
   
@@ -1414,7 +1421,7 @@ export default function Settings() {
     });
   };
   
-  const updatePingTarget = (index: number, field: 'name' | 'host' | 'type' | 'port', value: string | number) => {
+  const updatePingTarget = (index: number, field: 'name' | 'host' | 'type' | 'port' | 'expected_status' | 'body_contains', value: string | number) => {
     const newTargets = [...probeSettings.ping_targets];
     if (field === 'port') {
       newTargets[index] = { ...newTargets[index], [field]: typeof value === 'number' ? value : parseInt(value as string) || 80 };
@@ -1473,22 +1480,19 @@ export default function Settings() {
   };
   
   const generateInstallCommand = async () => {
-    const host = window.location.host;
-    const protocol = window.location.protocol === 'https:' ? 'https' : 'http';
-    const baseUrl = `${protocol}://${host}`;
-    const scriptUrl = 'https://vstats.zsoft.cc';
-    
-    // Linux/macOS command
-    const linuxCommand = `curl -fsSL ${scriptUrl}/agent.sh | sudo bash -s -- \\
-  --server ${baseUrl} \\
-  --token "${token}" \\
-  --name "$(hostname)"`;
-    
-    // Windows PowerShell command (with TLS 1.2 and execution policy bypass)
-    const windowsCommand = `[Net.ServicePointManager]::SecurityProtocol = [Net.SecurityProtocolType]::Tls12; iex (irm ${scriptUrl}/agent.ps1); Install-VStatsAgent -Server "${baseUrl}" -Token "${token}"`;
-    
-    setInstallCommand(linuxCommand);
-    setWindowsInstallCommand(windowsCommand);
+    // The server embeds a short-lived enrollment token, never our session token
+    try {
+      const res = await fetch('/api/install-command', {
+        headers: { 'Authorization': `Bearer ${token}` }
+      });
+      if (res.ok) {
+        const data = await res.json();
+        setInstallCommand(data.commands.linux);
+        setWindowsInstallCommand(data.commands.windows);
+      }
+    } catch (e) {
+      console.error('Failed to fetch install command', e);
+    }
   };
   
   const copyToClipboard = useCallback(async () => {
@@ -1512,7 +1516,10 @@ export default function Settings() {
 
   const fetchServers = async () => {
     try {
-      const res = await fetch('/api/servers');
+      // The admin list includes agent tokens, which /api/servers leaves out
+      const res = await fetch('/api/admin/servers', {
+        headers: { 'Authorization': `Bearer ${token}` }
+      });
       if (res.ok) {
         const data = await res.json();
         setServers(data);
@@ -1560,6 +1567,15 @@ export default function Settings() {
       return;
     }
 
+    const password = prompt('Enter the admin password to confirm the upgrade');
+    if (!password) {
+      return;
+    }
+    const sha256 = prompt("Enter the installer's SHA-256 from the release notes (leave empty to use VSTATS_UPGRADE_SHA256)");
+    if (sha256 === null) {
+      return;
+    }
+
     setUpgrading(true);
     
     try {
@@ -1569,7 +1585,7 @@ export default function Settings() {
           'Content-Type': 'application/json',
           'Authorization': `Bearer ${token}`
         },
-        body: JSON.stringify({ force })
+        body: JSON.stringify({ force, password, sha256: sha256.trim() })
       });
       
       if (res.ok) {
@@ -1589,7 +1605,8 @@ export default function Settings() {
           showToast(`Upgrade failed: ${data.message}`, 'error');
         }
       } else {
-        showToast('Failed to execute upgrade command', 'error');
+        const data = await res.json().catch(() => ({ error: '' }));
+        showToast(data.error ? `Upgrade failed: ${data.error}` : 'Failed to execute upgrade command', 'error');
       }
     } catch (e) {
       console.error('Failed to upgrade server', e);
@@ -1757,7 +1774,7 @@ export default function Settings() {
       return;
     }
     
-    if (passwords.new.length < 4) {
+    if (passwords.new.length < 8) {
       setPasswordError(t('settings.passwordTooShort'));
       return;
     }
@@ -1776,6 +1793,11 @@ export default function Settings() {
       });
       
       if (res.ok) {
+        // Other sessions were ended; this one continues with a new refresh token
+        const data = await res.json().catch(() => null);
+        if (data?.refresh_token) {
+          storeRefreshToken(data.refresh_token);
+        }
         setPasswordSuccess(true);
         setPasswords({ current: '', new: '', confirm: '' });
         setShowPasswordForm(false);
@@ -2143,7 +2165,7 @@ export default function Settings() {
                       value={target.host}
                       onChange={(e) => updatePingTarget(index, 'host', e.target.value)}
                       className="flex-1 min-w-[150px] px-3 py-2 rounded-lg bg-white/5 border border-white/10 text-white text-sm focus:outline-none focus:border-purple-500/50 font-mono"
-                      placeholder="Host/IP"
+                      placeholder={target.type === 'http' ? 'URL (https://example.com/health)' : 'Host/IP'}
                     />
                     <select
                       value={target.type || 'icmp'}
@@ -2152,6 +2174,7 @@ export default function Settings() {
                     >
                       <option value="icmp">ICMP</option>
                       <option value="tcp">TCP</option>
+                      <option value="http">HTTP</option>
                     </select>
                     {target.type === 'tcp' && (
                       <input
@@ -2164,6 +2187,26 @@ export default function Settings() {
                         max="65535"
                       />
                     )}
+                    {target.type === 'http' && (
+                      <>
+                        <input
+                          type="number"
+                          value={target.expected_status || ''}
+                          onChange={(e) => updatePingTarget(index, 'expected_status', parseInt(e.target.value) || 0)}
+                          className="w-24 px-3 py-2 rounded-lg bg-white/5 border border-white/10 text-white text-sm focus:outline-none focus:border-purple-500/50"
+                          placeholder="Status"
+                          min="100"
+                          max="599"
+                        />
+                        <input
+                          type="text"
+                          value={target.body_contains || ''}
+                          onChange={(e) => updatePingTarget(index, 'body_contains', e.target.value)}
+                          className="w-32 px-3 py-2 rounded-lg bg-white/5 border border-white/10 text-white text-sm focus:outline-none focus:border-purple-500/50"
+                          placeholder="Body contains"
+                        />
+                      </>
+                    )}
                     <button
                       type="button"
                       onClick={() => removePingTarget(index)}
@@ -2178,6 +2221,18 @@ export default function Settings() {
               </div>
             )}
             
+            <div className="pt-4 border-t border-white/5">
+              <label className="text-xs text-gray-500 uppercase tracking-wider">Offline Threshold (seconds)</label>
+              <input
+                type="number"
+                min={0}
+                value={probeSettings.offline_threshold_secs || ''}
+                onChange={(e) => setProbeSettings({ ...probeSettings, offline_threshold_secs: parseInt(e.target.value) || 0 })}
+                placeholder="Auto (30s, or 3x agent interval)"
+                className="mt-2 w-full px-3 py-2 rounded-lg bg-white/5 border border-white/10 text-white text-sm placeholder-gray-600 focus:outline-none focus:border-purple-500/50"
+              />
+            </div>
+            
             <div className="pt-4 border-t border-white/5 text-xs text-gray-500">
               <p className="mb-2">Common China carrier IPs for reference:</p>
               <div className="grid grid-cols-3 gap-2 font-mono text-gray-400">
@@ -2288,6 +2343,7 @@ export default function Settings() {
                       <li>启用后，登录页面将显示 GitHub 和 Google 登录按钮</li>
                       <li>OAuth 认证由 vstats.zsoft.cc 统一处理，无需额外配置</li>
                       <li>设置允许的用户可以限制谁能登录</li>
+                      <li>支持通配符：<code>*@mycompany.com</code> 允许整个域名，<code>*</code> 允许所有人；<code>!user</code> 拒绝指定用户，优先于通配符</li>
                     </ul>
                   </div>
                 </div>
@@ -3160,6 +3216,9 @@ export default function Settings() {
                           {server.ip && (
                             <span className="text-xs text-cyan-400 font-mono">{server.ip}</span>
                           )}
+                          {server.public_ip && server.public_ip !== server.ip && (
+                            <span className="text-xs text-cyan-400/70 font-mono">{server.public_ip}</span>
+                          )}
                           {server.version && (
                             <span className="text-xs text-gray-600 font-mono">v{server.version}</span>
                           )}
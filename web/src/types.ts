@@ -10,6 +10,9 @@ export interface SystemMetrics {
   load_average: LoadAverage;
   ping?: PingMetrics;
   version?: string;
+  updates_pending?: number;
+  security_updates?: number;
+  reboot_required?: boolean;
 }
 
 export interface OsInfo {
@@ -17,6 +20,13 @@ export interface OsInfo {
   version: string;
   kernel: string;
   arch: string;
+  virtualization?: VirtualizationInfo;
+}
+
+export interface VirtualizationInfo {
+  type: 'bare-metal' | 'vm' | 'container';
+  system?: string;
+  role?: string;
 }
 
 export interface CpuMetrics {
@@ -25,6 +35,11 @@ export interface CpuMetrics {
   usage: number;
   frequency: number;
   per_core: number[];
+  user?: number;
+  system?: number;
+  idle?: number;
+  iowait?: number;
+  steal?: number;
 }
 
 export interface MemoryMetrics {
@@ -54,6 +69,9 @@ export interface DiskMetrics {
   mount_points?: string[];
   usage_percent: number;
   used: number;
+  inodes_used?: number;
+  inodes_total?: number;
+  inodes_percent?: number;
 }
 
 export interface NetworkMetrics {
@@ -87,7 +105,7 @@ export interface PingMetrics {
 export interface PingTarget {
   name: string;
   host: string;
-  type?: string; // "icmp" or "tcp"
+  type?: string; // "icmp", "tcp" or "http"
   port?: number; // Port for TCP connections
   latency_ms: number | null;
   packet_loss: number;
@@ -156,6 +174,11 @@ export interface HistoryPoint {
   net_rx: number;
   net_tx: number;
   ping_ms?: number;
+  load_1?: number;
+  load_5?: number;
+  load_15?: number;
+  iowait?: number;
+  steal?: number;
 }
 
 export interface HistoryResponse {